@@ -11,7 +11,6 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/mandelsoft/spiff/dynaml"
-	"github.com/mandelsoft/spiff/legacy/candiedyaml"
 	"github.com/mandelsoft/spiff/yaml"
 )
 
@@ -20,7 +19,7 @@ var convertCmd = &cobra.Command{
 	Use:     "convert",
 	Aliases: []string{"c"},
 	Short:   "Convert template",
-	Long:    `A given template file is normalized and converted to json or yaml.`,
+	Long:    `A given template file is normalized and converted to yaml, json or another output format.`,
 	Args: func(cmd *cobra.Command, args []string) error {
 		if len(args) != 1 {
 			return errors.New("requires at one arg")
@@ -36,9 +35,12 @@ func init() {
 	rootCmd.AddCommand(convertCmd)
 
 	convertCmd.Flags().BoolVar(&asJSON, "json", false, "print output in json format")
+	convertCmd.Flags().StringVar(&outputFormat, "output-format", yaml.FORMAT_YAML, outputFormatUsage())
 	convertCmd.Flags().StringVar(&outputPath, "path", "", "output is taken from given path")
 	convertCmd.Flags().BoolVar(&split, "split", false, "if the output is alist it will be split into separate documents")
 	convertCmd.Flags().StringArrayVar(&selection, "select", []string{}, "filter dedicated output fields")
+	convertCmd.Flags().BoolVar(&preserveComments, "preserve-comments", false, "preserve comments in yaml output")
+	convertCmd.Flags().BoolVar(&processingOptions.KeepOrder, "keep-order", false, "keep the original key order of maps in the output")
 }
 
 func convert(stdin bool, templateFilePath string, json, split bool, subpath string, selection []string) {
@@ -56,11 +58,16 @@ func convert(stdin bool, templateFilePath string, json, split bool, subpath stri
 		log.Fatalln(fmt.Sprintf("error reading template [%s]:", path.Clean(templateFilePath)), err)
 	}
 
-	templateYAMLs, err := yaml.ParseMulti(templateFilePath, templateFile)
+	templateYAMLs, err := parseTemplate(templateFilePath, templateFile)
 	if err != nil {
 		log.Fatalln(fmt.Sprintf("error parsing template [%s]:", path.Clean(templateFilePath)), err)
 	}
 
+	formatter, err := outputFormatter(json)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
 	result := [][]byte{}
 	count := 0
 	for no, templateYAML := range templateYAMLs {
@@ -83,6 +90,7 @@ func convert(stdin bool, templateFilePath string, json, split bool, subpath stri
 
 			if len(selection) > 0 {
 				new := map[string]yaml.Node{}
+				keys := []string{}
 				for _, p := range selection {
 					comps := dynaml.PathComponents(p, false)
 					node, ok := yaml.FindR(true, flowed, nil, comps...)
@@ -90,18 +98,15 @@ func convert(stdin bool, templateFilePath string, json, split bool, subpath stri
 						log.Fatalln(fmt.Sprintf("path %q not found%s", subpath, doc))
 					}
 					new[comps[len(comps)-1]] = node
+					keys = append(keys, comps[len(comps)-1])
 
 				}
-				flowed = yaml.NewNode(new, "")
+				flowed = yaml.OrderedNode(yaml.NewNode(new, ""), keys)
 			}
 			if split {
 				if list, ok := flowed.Value().([]yaml.Node); ok {
 					for _, d := range list {
-						if json {
-							bytes, err = yaml.ToJSON(d)
-						} else {
-							bytes, err = candiedyaml.Marshal(d)
-						}
+						bytes, err = formatter.Marshal(d, marshalOptions())
 						if err != nil {
 							log.Fatalln(fmt.Sprintf("error marshalling manifest%s:", doc), err)
 						}
@@ -110,11 +115,7 @@ func convert(stdin bool, templateFilePath string, json, split bool, subpath stri
 					continue
 				}
 			}
-			if json {
-				bytes, err = yaml.ToJSON(flowed)
-			} else {
-				bytes, err = candiedyaml.Marshal(flowed)
-			}
+			bytes, err = formatter.Marshal(flowed, marshalOptions())
 			if err != nil {
 				log.Fatalln(fmt.Sprintf("error marshalling manifest%s:", doc), err)
 			}
@@ -122,15 +123,9 @@ func convert(stdin bool, templateFilePath string, json, split bool, subpath stri
 		result = append(result, bytes)
 	}
 
-	for _, bytes := range result {
-		if !json && (len(result) > 1 || len(bytes) == 0) {
-			fmt.Println("---")
-		}
-		if bytes != nil {
-			fmt.Print(string(bytes))
-			if json {
-				fmt.Println()
-			}
-		}
+	out, err := formatter.Join(result)
+	if err != nil {
+		log.Fatalln("error marshalling manifest:", err)
 	}
+	fmt.Print(string(out))
 }
@@ -1,9 +1,11 @@
 package cmd
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"os"
 	"path"
 	"strings"
 
@@ -15,6 +17,48 @@ import (
 )
 
 var separator string
+var diffFormat string
+var exitCode bool
+var diffOptions compareFlags
+
+// compareFlags are the command line options controlling
+// the structural comparison.
+type compareFlags struct {
+	keys         []string
+	ignore       []string
+	numbers      bool
+	strings      bool
+	nullAsAbsent bool
+	unordered    bool
+}
+
+func (f *compareFlags) addFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.StringArrayVar(&f.keys, "key", nil, "identity fields for list entries (<path>=<field>{,<field>})")
+	flags.StringArrayVar(&f.ignore, "ignore", nil, "ignore nodes matching a path pattern (* matches a path step, ** any steps)")
+	flags.BoolVar(&f.numbers, "equivalent-numbers", false, "compare numbers by their value (1 equals 1.0)")
+	flags.BoolVar(&f.strings, "equivalent-strings", false, "compare scalar values by their string representation (\"1\" equals 1)")
+	flags.BoolVar(&f.nullAsAbsent, "null-as-absent", false, "handle fields with null values like missing fields")
+	flags.BoolVar(&f.unordered, "unordered-lists", false, "compare lists without entry identities as unordered sets")
+}
+
+func (f *compareFlags) options() compare.Options {
+	opts := compare.Options{
+		Ignore:             f.ignore,
+		NumericEquivalence: f.numbers,
+		StringEquivalence:  f.strings,
+		NullAsAbsent:       f.nullAsAbsent,
+		UnorderedLists:     f.unordered,
+	}
+	for _, k := range f.keys {
+		rule, err := compare.ParseKeyRule(k)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		opts.Keys = append(opts.Keys, rule)
+	}
+	return opts
+}
 
 // diffCmd represents the diff command
 var diffCmd = &cobra.Command{
@@ -26,7 +70,19 @@ Here streams with multiple documents are supported, also. To indicate
 no difference the number of documents in both streams must be identical
 and each document in the first stream must have no difference compared
 to the document with the same index in the second stream. Found differences
-are shown for each document separately.`,
+are shown for each document separately.
+
+The output format can be selected with --format:
+  text       colored listing of the differences (default)
+  unified    unified diff of the normalized yaml documents
+  json       list of differences with path and both values
+  jsonpatch  JSON patch (RFC 6902) per document
+  mergepatch JSON merge patch (RFC 7386) per document
+
+Volatile nodes can be excluded from the comparison with --ignore, for
+example --ignore 'jobs.*.properties.password'. The unified format
+compares the normalized documents textually, so the comparison options
+do not apply to it.`,
 	Args: func(cmd *cobra.Command, args []string) error {
 		if len(args) != 2 {
 			return errors.New("requires two args")
@@ -34,7 +90,10 @@ are shown for each document separately.`,
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		diff(args[0], args[1], separator)
+		found := diff(args[0], args[1], separator, diffFormat)
+		if found && exitCode {
+			os.Exit(1)
+		}
 	},
 }
 
@@ -42,46 +101,89 @@ func init() {
 	rootCmd.AddCommand(diffCmd)
 
 	diffCmd.Flags().StringVar(&separator, "separator", "", "Separator to print between diffs")
+	diffCmd.Flags().StringVar(&diffFormat, "format", "text", "output format (text, unified, json, jsonpatch or mergepatch)")
+	diffCmd.Flags().BoolVar(&exitCode, "exit-code", false, "exit with 1 if differences are found")
+	diffOptions.addFlags(diffCmd)
 }
 
-func diff(aFilePath, bFilePath string, separator string) {
-	aFile, err := ReadFile(aFilePath)
+func readDocuments(filePath string, desc string) []yaml.Node {
+	file, err := ReadFile(filePath)
 	if err != nil {
-		log.Fatalln(fmt.Sprintf("error reading a [%s]:", path.Clean(aFilePath)), err)
+		log.Fatalln(fmt.Sprintf("error reading %s [%s]:", desc, path.Clean(filePath)), err)
 	}
 
-	aYAMLs, err := yaml.ParseMulti(aFilePath, aFile)
+	docs, err := yaml.ParseMulti(filePath, file)
 	if err != nil {
-		log.Fatalln(fmt.Sprintf("error parsing a [%s]:", path.Clean(aFilePath)), err)
+		log.Fatalln(fmt.Sprintf("error parsing %s [%s]:", desc, path.Clean(filePath)), err)
 	}
+	return docs
+}
 
-	bFile, err := ReadFile(bFilePath)
-	if err != nil {
-		log.Fatalln(fmt.Sprintf("error reading b [%s]:", path.Clean(bFilePath)), err)
+// diff prints the differences of two document streams in the given
+// format and reports whether differences are found.
+func diff(aFilePath, bFilePath string, separator string, format string) bool {
+	switch format {
+	case "", "text", "unified", "json", "jsonpatch", "mergepatch":
+	default:
+		log.Fatalln(fmt.Sprintf("invalid diff format %q", format))
 	}
 
-	bYAMLs, err := yaml.ParseMulti(bFilePath, bFile)
-	if err != nil {
-		log.Fatalln(fmt.Sprintf("error parsing b [%s]:", path.Clean(bFilePath)), err)
+	opts := diffOptions.options()
+
+	aYAMLs := readDocuments(aFilePath, "a")
+	bYAMLs := readDocuments(bFilePath, "b")
+
+	if format == "unified" {
+		return diffUnified(aFilePath, bFilePath, aYAMLs, bYAMLs)
 	}
 
 	if len(aYAMLs) != len(bYAMLs) {
+		switch format {
+		case "json":
+			return diffJSON(aYAMLs, bYAMLs, opts)
+		case "jsonpatch", "mergepatch":
+			log.Fatalln(fmt.Sprintf("different number of documents (%d != %d)", len(aYAMLs), len(bYAMLs)))
+		}
 		fmt.Printf("Different number of documents (%d != %d)\n", len(aYAMLs), len(bYAMLs))
-		return
+		return true
 	}
 
 	ddiffs := make([][]compare.Diff, len(aYAMLs))
 	found := false
 	for no, aYAML := range aYAMLs {
 		bYAML := bYAMLs[no]
-		ddiffs[no] = compare.Compare(aYAML, bYAML)
+		ddiffs[no] = compare.Compare(aYAML, bYAML, opts)
 		if len(ddiffs[no]) != 0 {
 			found = true
 		}
 	}
+
+	switch format {
+	case "json":
+		return diffJSON(aYAMLs, bYAMLs, opts)
+	case "jsonpatch":
+		for no := range aYAMLs {
+			ops, err := compare.JSONPatch(aYAMLs[no], bYAMLs[no], ddiffs[no], opts)
+			if err != nil {
+				log.Fatalln(fmt.Sprintf("error creating patch for document %d:", no+1), err)
+			}
+			printJSON(ops)
+		}
+		return found
+	case "mergepatch":
+		for no := range aYAMLs {
+			patch, err := compare.MergePatch(aYAMLs[no], bYAMLs[no], ddiffs[no])
+			if err != nil {
+				log.Fatalln(fmt.Sprintf("error creating patch for document %d:", no+1), err)
+			}
+			printJSON(patch)
+		}
+		return found
+	}
+
 	if !found {
 		fmt.Println("no differences!")
-		return
+		return false
 	}
 	for no := range aYAMLs {
 		if len(ddiffs[no]) == 0 {
@@ -119,4 +221,87 @@ func diff(aFilePath, bFilePath string, separator string) {
 			}
 		}
 	}
+	return true
+}
+
+func printJSON(v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Fatalln("error marshalling diff:", err)
+	}
+	fmt.Println(string(data))
+}
+
+// diffUnified prints a unified diff of the normalized yaml documents.
+func diffUnified(aFilePath, bFilePath string, aYAMLs, bYAMLs []yaml.Node) bool {
+	text := func(docs []yaml.Node) string {
+		s := ""
+		for _, d := range docs {
+			data, err := yaml.Marshal(d)
+			if err != nil {
+				log.Fatalln("error marshalling document:", err)
+			}
+			if len(docs) > 1 {
+				s += "---\n"
+			}
+			s += string(data)
+		}
+		return s
+	}
+	out := compare.Unified(aFilePath, bFilePath, text(aYAMLs), text(bYAMLs), 3)
+	fmt.Print(out)
+	return out != ""
+}
+
+// diffJSON prints the differences as a json list. Documents only found
+// in one of the streams are reported as differences with an empty path.
+func diffJSON(aYAMLs, bYAMLs []yaml.Node, opts compare.Options) bool {
+	type entry struct {
+		Document int         `json:"document"`
+		Path     []string    `json:"path"`
+		A        interface{} `json:"a,omitempty"`
+		B        interface{} `json:"b,omitempty"`
+	}
+	value := func(n yaml.Node) interface{} {
+		if n == nil {
+			return nil
+		}
+		v, err := yaml.Normalize(n)
+		if err != nil {
+			log.Fatalln("error marshalling diff:", err)
+		}
+		if v == nil {
+			// keep explicit null values
+			return json.RawMessage("null")
+		}
+		return v
+	}
+
+	result := []entry{}
+	for no := 0; no < len(aYAMLs) || no < len(bYAMLs); no++ {
+		var diffs []compare.Diff
+		var a, b yaml.Node
+		if no < len(aYAMLs) {
+			a = aYAMLs[no]
+		}
+		if no < len(bYAMLs) {
+			b = bYAMLs[no]
+		}
+		if a != nil && b != nil {
+			diffs = compare.Compare(a, b, opts)
+			compare.SortDiffs(diffs)
+		} else {
+			diffs = []compare.Diff{{A: a, B: b, Path: []string{}}}
+		}
+		for _, d := range diffs {
+			result = append(result, entry{
+				Document: no + 1,
+				Path:     d.Path,
+				A:        value(d.A),
+				B:        value(d.B),
+			})
+		}
+	}
+	printJSON(result)
+	return len(result) > 0
 }
@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mandelsoft/spiff/compare"
+	"github.com/mandelsoft/spiff/legacy/candiedyaml"
+	"github.com/mandelsoft/spiff/yaml"
+)
+
+var merge3 bool
+var diff3Options compareFlags
+
+// diff3Cmd represents the diff3 command
+var diff3Cmd = &cobra.Command{
+	Use:   "diff3",
+	Short: "Structurally compare two YAML files derived from a common base",
+	Long: `Show the structural changes of two deployment manifests (ours and theirs)
+compared to a common base manifest. Every changed path is classified as
+changed in ours, changed in theirs, changed identically in both or as
+conflict, if both manifests changed it differently.
+
+With --merge the changes of theirs are merged into ours and the merged
+manifest is printed. Conflicting nodes are replaced by a map with the
+conflict markers as keys (ours, base and theirs) and the nodes of all
+three manifests as values.
+
+The command exits with 1 if conflicts are found.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 3 {
+			return errors.New("requires three args")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		if diff3(args[0], args[1], args[2], merge3) {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(diff3Cmd)
+
+	diff3Cmd.Flags().BoolVar(&merge3, "merge", false, "print the merged manifest")
+	diff3Cmd.Flags().BoolVar(&asJSON, "json", false, "print merged manifest in json format")
+	diff3Options.addFlags(diff3Cmd)
+}
+
+// diff3 prints the changes or the merged documents and reports
+// whether conflicts are found.
+func diff3(baseFilePath, oursFilePath, theirsFilePath string, merge bool) bool {
+	opts := diff3Options.options()
+
+	baseYAMLs := readDocuments(baseFilePath, "base")
+	oursYAMLs := readDocuments(oursFilePath, "ours")
+	theirsYAMLs := readDocuments(theirsFilePath, "theirs")
+
+	if len(baseYAMLs) != len(oursYAMLs) || len(baseYAMLs) != len(theirsYAMLs) {
+		log.Fatalln(fmt.Sprintf("different number of documents (%d, %d, %d)", len(baseYAMLs), len(oursYAMLs), len(theirsYAMLs)))
+	}
+
+	conflicts := 0
+	for no := range baseYAMLs {
+		diffs := compare.Compare3(baseYAMLs[no], oursYAMLs[no], theirsYAMLs[no], opts)
+		if merge {
+			merged, n := compare.Merge3(oursYAMLs[no], diffs, opts)
+			conflicts += n
+			var data []byte
+			var err error
+			if asJSON {
+				data, err = yaml.ToJSON(merged)
+			} else {
+				data, err = yaml.Marshal(merged)
+			}
+			if err != nil {
+				log.Fatalln(fmt.Sprintf("error marshalling manifest (document %d):", no+1), err)
+			}
+			if !asJSON && len(baseYAMLs) > 1 {
+				fmt.Println("---")
+			}
+			fmt.Print(string(data))
+			if asJSON {
+				fmt.Println()
+			}
+			continue
+		}
+
+		doc := ""
+		if len(baseYAMLs) > 1 {
+			doc = fmt.Sprintf(" in document %d", no+1)
+		}
+		for _, d := range diffs {
+			if d.Change == compare.CONFLICT {
+				conflicts++
+			}
+			change := d.Change.String()
+			fmt.Printf("%s%s: %s\n", strings.ToUpper(change[:1])+change[1:], doc, strings.Join(d.Path, "."))
+			if d.Ours != nil {
+				printNode(baseFilePath, d.Ours.A, 0)
+			} else {
+				printNode(baseFilePath, d.Theirs.A, 0)
+			}
+			if d.Ours != nil {
+				printNode(oursFilePath, d.Ours.B, 31)
+			}
+			if d.Theirs != nil {
+				printNode(theirsFilePath, d.Theirs.B, 32)
+			}
+		}
+	}
+	if conflicts > 0 {
+		fmt.Fprintf(os.Stderr, "%d conflict(s) found\n", conflicts)
+	}
+	return conflicts > 0
+}
+
+func printNode(file string, node yaml.Node, color int) {
+	if node == nil {
+		fmt.Printf("  %s has: <none>\n", file)
+		return
+	}
+	data, err := candiedyaml.Marshal(node)
+	if err != nil {
+		panic(err)
+	}
+	text := strings.Replace(string(data), "\n", "\n    ", -1)
+	if color == 0 {
+		fmt.Printf("  %s has:\n    %s\n", file, text)
+	} else {
+		fmt.Printf("  %s has:\n    \x1b[%dm%s\x1b[0m\n", file, color, text)
+	}
+}
@@ -7,7 +7,11 @@ import (
 	"log"
 	"os"
 	"path"
+	"regexp"
+	"strings"
+	"time"
 
+	"filippo.io/age"
 	"github.com/spf13/cobra"
 
 	"github.com/mandelsoft/spiff/dynaml/passwd"
@@ -16,20 +20,54 @@ import (
 )
 
 var decrypt bool
+var rekey bool
+var oldKey string
+var recipients []string
+var identities []string
+var keygen bool
+var encryptPaths []string
+var keyRegex string
 
 // encryptCmd represents the diff command
 var encryptCmd = &cobra.Command{
 	Use:     "encrypt <file> [<password>] [<method>]",
 	Aliases: []string{"e"},
 	Short:   "Encrypt/Decrypt yaml document",
-	Long:    ``,
+	Long: `Encrypt or decrypt a yaml document.
+
+With --recipient the document is encrypted with the age method for the
+given recipients (public keys). For the decryption of age encrypted data
+the identities (private keys) are read from the files given by --identity
+or by the environment variable SPIFF_AGE_IDENTITY. A new identity can be
+generated with --keygen.
+
+With --path or --key-regex only the selected leaf values of the document
+are encrypted with a data key generated for the document, keys and
+structure stay readable. The data key is encrypted with the given key
+and method. A MAC over the encryption settings, the structure and all
+values of the document is used to detect modifications.
+
+With --rekey all encrypted values found in the document are decrypted
+with the key given by --old-key (default is the actual key) and encrypted
+again with the actual key and method. The rest of the document is kept
+as it is.`,
 	Args: func(cmd *cobra.Command, args []string) error {
+		if keygen {
+			if len(args) != 0 {
+				return errors.New("--keygen requires no args")
+			}
+			return nil
+		}
 		if len(args) < 1 || len(args) > 3 {
 			return errors.New("requires one, two or three args")
 		}
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
+		if keygen {
+			generateIdentity()
+			return
+		}
 		encrypt(decrypt, args)
 	},
 }
@@ -38,6 +76,13 @@ func init() {
 	rootCmd.AddCommand(encryptCmd)
 
 	encryptCmd.Flags().BoolVarP(&decrypt, "decrypt", "d", false, "decrypt content")
+	encryptCmd.Flags().BoolVar(&rekey, "rekey", false, "encrypt all encrypted values again with the actual key and method")
+	encryptCmd.Flags().StringVar(&oldKey, "old-key", "", "key used to decrypt the values for --rekey")
+	encryptCmd.Flags().StringArrayVarP(&recipients, "recipient", "r", nil, "encrypt with age for the given recipient")
+	encryptCmd.Flags().StringArrayVarP(&identities, "identity", "i", nil, "age identity file used for decryption")
+	encryptCmd.Flags().BoolVar(&keygen, "keygen", false, "generate an age identity")
+	encryptCmd.Flags().StringArrayVar(&encryptPaths, "path", nil, "encrypt only the values below paths matching the pattern (* matches a path step, ** any steps)")
+	encryptCmd.Flags().StringVar(&keyRegex, "key-regex", "", "encrypt only the values below map keys matching the regular expression")
 }
 
 func encrypt(decrypt bool, args []string) {
@@ -57,7 +102,7 @@ func encrypt(decrypt bool, args []string) {
 	}
 
 	key := features.EncryptionKey()
-	method := passwd.TRIPPLEDES
+	method := ""
 	v := ""
 	if len(args) > 1 {
 		v = args[1]
@@ -71,37 +116,277 @@ func encrypt(decrypt bool, args []string) {
 			key = v
 		}
 	case 3:
+		key = v
 		method = args[2]
 	}
 
-	if key == "" {
-		log.Fatalln("invalid empty encyption key")
+	if len(recipients) > 0 {
+		if method != "" && method != passwd.AGE {
+			log.Fatalf("--recipient cannot be used with method %q", method)
+		}
+		method = passwd.AGE
 	}
 
-	e := passwd.GetEncoding(method)
-	if e == nil {
+	if method != "" && passwd.GetEncoding(method) == nil {
 		log.Fatalf("invalid encyption method %q", method)
 	}
 
-	if key == "" {
-		log.Fatalf("invalid empty encyption key")
+	docs := []yaml.Node{}
+	if !decrypt && !rekey {
+		docs, err = yaml.ParseMulti(filePath, file)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	} else if d, err := yaml.ParseMulti(filePath, file); err == nil && isEncryptedDocuments(d) {
+		// partially encrypted documents
+		docs = d
 	}
+	partial := len(encryptPaths) > 0 || keyRegex != ""
 
-	if decrypt {
-		result, err := e.Decode(string(file), key)
+	switch {
+	case rekey:
+		if decrypt {
+			log.Fatalln("--rekey cannot be used together with --decrypt")
+		}
+		if len(docs) > 0 {
+			for i, doc := range docs {
+				if passwd.IsEncryptedDocument(doc) {
+					old := oldKey
+					if old == "" {
+						old = key
+					}
+					docs[i], err = rekeyDocument(doc, old, key, method)
+					if err != nil {
+						log.Fatalln(fmt.Sprintf("error rekeying data [%s]:", path.Clean(filePath)), err)
+					}
+				}
+			}
+			printDocuments(docs)
+			break
+		}
+		if passwd.GetPublicKeyEncoding(method) != nil {
+			log.Fatalf("--rekey cannot be used with method %q", method)
+		}
+		checkKey(key)
+		old := oldKey
+		if old == "" {
+			old = key
+		}
+		result, n, err := rekeyData(string(file), old, key, method)
+		if err != nil {
+			log.Fatalln(fmt.Sprintf("error rekeying data [%s]:", path.Clean(filePath)), err)
+		}
+		fmt.Print(result)
+		fmt.Fprintf(os.Stderr, "%d value(s) encrypted again\n", n)
+	case decrypt:
+		if len(docs) > 0 {
+			for i, doc := range docs {
+				if passwd.IsEncryptedDocument(doc) {
+					m, err := passwd.DocumentMethod(doc)
+					if err != nil {
+						log.Fatalln(fmt.Sprintf("error decoding data [%s]:", path.Clean(filePath)), err)
+					}
+					docs[i], err = passwd.DecryptDocument(doc, decryptionKey(m, key))
+					if err != nil {
+						log.Fatalln(fmt.Sprintf("error decoding data [%s]:", path.Clean(filePath)), err)
+					}
+				}
+			}
+			printDocuments(docs)
+			break
+		}
+		if method == "" {
+			method, err = passwd.DetectMethod(string(file))
+			if err != nil {
+				log.Fatalln(fmt.Sprintf("error decoding data [%s]:", path.Clean(filePath)), err)
+			}
+		}
+		result, err := passwd.Decrypt(string(file), decryptionKey(method, key), method)
 		if err != nil {
 			log.Fatalln(fmt.Sprintf("error decoding data [%s]:", path.Clean(filePath)), err)
 		}
 		fmt.Printf("%s\n", result)
-	} else {
-		_, err := yaml.Parse(filePath, file)
+	case partial:
+		sel := passwd.Selection{Paths: encryptPaths, KeyRegex: keyRegex}
+		for i, doc := range docs {
+			docs[i], err = passwd.EncryptDocument(doc, encryptionKey(method, key), method, sel)
+			if err != nil {
+				log.Fatalln(fmt.Sprintf("error encrypting data [%s]:", path.Clean(filePath)), err)
+			}
+		}
+		printDocuments(docs)
+	default:
+		result, err := passwd.Encrypt(string(file), encryptionKey(method, key), method)
 		if err != nil {
 			log.Fatalln(err)
 		}
-		result, err := e.Encode(string(file), key)
+		fmt.Printf("%s\n", result)
+	}
+}
+
+// encryptionKey provides the key used for the encryption with a method.
+// Public key methods use the recipients given by --recipient.
+func encryptionKey(method, key string) string {
+	if passwd.GetPublicKeyEncoding(method) != nil {
+		if len(recipients) == 0 {
+			log.Fatalf("method %q requires at least one recipient", method)
+		}
+		return strings.Join(recipients, "\n")
+	}
+	checkKey(key)
+	return key
+}
+
+// decryptionKey provides the key used for the decryption with a method.
+// Public key methods use the identity files.
+func decryptionKey(method, key string) string {
+	if e := passwd.GetPublicKeyEncoding(method); e != nil {
+		return readIdentities(e)
+	}
+	checkKey(key)
+	return key
+}
+
+func isEncryptedDocuments(docs []yaml.Node) bool {
+	for _, doc := range docs {
+		if passwd.IsEncryptedDocument(doc) {
+			return true
+		}
+	}
+	return false
+}
+
+// rekeyDocument encrypts the values of a partially encrypted document
+// again with the actual key and method.
+func rekeyDocument(doc yaml.Node, old, key, method string) (yaml.Node, error) {
+	m, err := passwd.DocumentMethod(doc)
+	if err != nil {
+		return nil, err
+	}
+	if passwd.GetPublicKeyEncoding(m) != nil {
+		old = decryptionKey(m, old)
+	}
+	sel, err := passwd.DocumentSelection(doc)
+	if err != nil {
+		return nil, err
+	}
+	doc, err = passwd.DecryptDocument(doc, old)
+	if err != nil {
+		return nil, err
+	}
+	if method == "" {
+		method = m
+	}
+	return passwd.EncryptDocument(doc, encryptionKey(method, key), method, sel)
+}
+
+func printDocuments(docs []yaml.Node) {
+	for _, doc := range docs {
+		data, err := yaml.Marshal(doc)
 		if err != nil {
 			log.Fatalln(err)
 		}
-		fmt.Printf("%s\n", result)
+		if len(docs) > 1 {
+			fmt.Println("---")
+		}
+		fmt.Print(string(data))
+	}
+}
+
+// decryptDocument decrypts a partially encrypted document. It fails if
+// the key required for its method is not available.
+func decryptDocument(filePath string, doc yaml.Node) (yaml.Node, error) {
+	if !passwd.IsEncryptedDocument(doc) {
+		return doc, nil
+	}
+	method, err := passwd.DocumentMethod(doc)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting [%s]: %s", path.Clean(filePath), err)
+	}
+	key := features.EncryptionKey()
+	if e := passwd.GetPublicKeyEncoding(method); e != nil {
+		key = ""
+		for _, f := range e.IdentityFiles() {
+			data, err := ReadFile(f)
+			if err != nil {
+				return nil, fmt.Errorf("error reading identity file [%s]: %s", path.Clean(f), err)
+			}
+			key += string(data) + "\n"
+		}
+	}
+	if key == "" {
+		return nil, fmt.Errorf("error decrypting [%s]: document is encrypted but no key is available", path.Clean(filePath))
+	}
+	result, err := passwd.DecryptDocument(doc, key)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting [%s]: %s", path.Clean(filePath), err)
+	}
+	return result, nil
+}
+
+func checkKey(key string) {
+	if key == "" {
+		log.Fatalln("invalid empty encyption key")
+	}
+}
+
+// readIdentities reads the identity files given by --identity or
+// the default identity files of a public key encoding.
+func readIdentities(e passwd.PublicKeyEncoding) string {
+	files := identities
+	if len(files) == 0 {
+		files = e.IdentityFiles()
 	}
+	if len(files) == 0 {
+		log.Fatalf("no identity file given for method %q", e.Name())
+	}
+	key := ""
+	for _, f := range files {
+		data, err := ReadFile(f)
+		if err != nil {
+			log.Fatalln(fmt.Sprintf("error reading identity file [%s]:", path.Clean(f)), err)
+		}
+		key += string(data) + "\n"
+	}
+	return key
+}
+
+// generateIdentity prints a new age identity in the format
+// of an age identity file.
+func generateIdentity() {
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	fmt.Printf("# created: %s\n", time.Now().Format(time.RFC3339))
+	fmt.Printf("# public key: %s\n", id.Recipient())
+	fmt.Printf("%s\n", id)
+}
+
+// ciphertexts matches the values of the supported encryption methods:
+// values with a ciphertext header and hex encoded 3DES values (at least
+// an initialization vector and a MAC).
+var ciphertexts = regexp.MustCompile(regexp.QuoteMeta(passwd.HEADER_PREFIX) + `[0-9]+:[A-Za-z0-9-]+:[A-Za-z0-9+/]+|\b[0-9a-f]{96,}\b`)
+
+// rekeyData encrypts all encrypted values found in a text again. Values
+// which cannot be decrypted with the old key are kept.
+func rekeyData(text, old, key, method string) (string, int, error) {
+	var err error
+	n := 0
+	result := ciphertexts.ReplaceAllStringFunc(text, func(value string) string {
+		if err != nil {
+			return value
+		}
+		plain, derr := passwd.Decrypt(value, old, "")
+		if derr != nil {
+			if passwd.HasHeader(value) {
+				err = derr
+			}
+			return value
+		}
+		value, err = passwd.Encrypt(plain, key, method)
+		n++
+		return value
+	})
+	return result, n, err
 }
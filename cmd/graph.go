@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mandelsoft/spiff/features"
+	"github.com/mandelsoft/spiff/lint"
+	"github.com/mandelsoft/spiff/yaml"
+)
+
+var graphFormat string
+
+// graphCmd represents the graph command
+var graphCmd = &cobra.Command{
+	Use:   "graph",
+	Short: "Export the dependency graph of a template and its stubs",
+	Long: `Statically analyse the dynaml expressions of a template and its stub
+files and print the dependency graph between the document paths. Besides
+references the graph contains edges for nodes overridden or merged by stubs,
+tag references and files imported by read(). Nodes and edges being part
+of a dependency cycle are marked. The graph is printed in dot or json format.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.New("requires at least one arg")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		vals, err := createValuesFromArgs(values)
+		if err != nil {
+			log.Fatalf("%s\n", err)
+		}
+		graph(args[0], args[1:], bindings, vals, graphFormat)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(graphCmd)
+
+	graphCmd.Flags().StringVar(&graphFormat, "format", "dot", "output format (dot or json)")
+	graphCmd.Flags().BoolVar(&interpolation, "interpolation", interpolation, "enable interpolation alpha feature")
+	graphCmd.Flags().StringVar(&bindings, "bindings", "", "yaml file with additional bindings to use")
+	graphCmd.Flags().StringArrayVarP(&values, "define", "D", nil, "key/value bindings")
+	graphCmd.Flags().StringArrayVar(&featureFlags, "features", []string{}, "set feature flags")
+}
+
+func graph(templateFilePath string, stubFilePaths []string, bindingFilePath string, values map[string]string, format string) {
+	if format != "dot" && format != "json" {
+		log.Fatalf("invalid output format %q\n", format)
+	}
+
+	templateFile, err := ReadFile(templateFilePath)
+	if err != nil {
+		log.Fatalln(fmt.Sprintf("error reading template [%s]:", path.Clean(templateFilePath)), err)
+	}
+	templateYAMLs, err := yaml.ParseMulti(templateFilePath, templateFile)
+	if err != nil {
+		log.Fatalln(fmt.Sprintf("error parsing template [%s]:", path.Clean(templateFilePath)), err)
+	}
+
+	var stubs []yaml.Node
+	for _, stubFilePath := range stubFilePaths {
+		stubFile, err := ReadFile(stubFilePath)
+		if err != nil {
+			log.Fatalln(fmt.Sprintf("error reading stub [%s]:", path.Clean(stubFilePath)), err)
+		}
+		stubYAML, err := yaml.Parse(stubFilePath, stubFile)
+		if err != nil {
+			log.Fatalln(fmt.Sprintf("error parsing stub [%s]:", path.Clean(stubFilePath)), err)
+		}
+		stubs = append(stubs, stubYAML)
+	}
+
+	opts := &lint.Options{
+		Features: features.Features(),
+	}
+	for _, list := range featureFlags {
+		for _, f := range strings.Split(list, ",") {
+			if err := opts.Features.Set(strings.TrimSpace(f), true); err != nil {
+				log.Fatalln(err.Error())
+			}
+		}
+	}
+	if interpolation {
+		opts.Features.SetInterpolation(true)
+	}
+
+	opts.Bindings, err = readBindings(bindingFilePath, values)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	g := lint.DependencyGraph(templateYAMLs, stubs, opts)
+	if format == "json" {
+		data, err := g.JSON()
+		if err != nil {
+			log.Fatalln("error marshalling graph:", err)
+		}
+		fmt.Println(string(data))
+	} else {
+		fmt.Print(string(g.DOT()))
+	}
+}
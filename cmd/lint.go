@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mandelsoft/spiff/features"
+	"github.com/mandelsoft/spiff/lint"
+	"github.com/mandelsoft/spiff/yaml"
+)
+
+var lintFormat string
+
+// lintCmd represents the lint command
+var lintCmd = &cobra.Command{
+	Use:   "lint",
+	Short: "Statically check a template and its stubs",
+	Long: `Parse all dynaml expressions of a template and its stub files without
+evaluating them. Reported are syntax errors, calls of unknown functions,
+references to paths provided neither by the template nor by a stub,
+unused temporary and local nodes and shadowed lambda parameters.
+The findings are printed in json or sarif format. The command fails
+if an error is found.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.New("requires at least one arg")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		vals, err := createValuesFromArgs(values)
+		if err != nil {
+			log.Fatalf("%s\n", err)
+		}
+		if lintTemplate(args[0], args[1:], bindings, vals, lintFormat) {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(lintCmd)
+
+	lintCmd.Flags().StringVar(&lintFormat, "format", "json", "output format (json or sarif)")
+	lintCmd.Flags().BoolVar(&interpolation, "interpolation", interpolation, "enable interpolation alpha feature")
+	lintCmd.Flags().StringVar(&bindings, "bindings", "", "yaml file with additional bindings to use")
+	lintCmd.Flags().StringArrayVarP(&values, "define", "D", nil, "key/value bindings")
+	lintCmd.Flags().StringArrayVar(&featureFlags, "features", []string{}, "set feature flags")
+}
+
+func lintTemplate(templateFilePath string, stubFilePaths []string, bindingFilePath string, values map[string]string, format string) bool {
+	if format != "json" && format != "sarif" {
+		log.Fatalf("invalid output format %q\n", format)
+	}
+
+	templateFile, err := ReadFile(templateFilePath)
+	if err != nil {
+		log.Fatalln(fmt.Sprintf("error reading template [%s]:", path.Clean(templateFilePath)), err)
+	}
+	templateYAMLs, err := yaml.ParseMulti(templateFilePath, templateFile)
+	if err != nil {
+		templateYAMLs = nil
+	}
+
+	findings := []lint.Finding{}
+	if err != nil {
+		findings = append(findings, parseFinding(templateFilePath, err))
+	}
+
+	var stubs []yaml.Node
+	for _, stubFilePath := range stubFilePaths {
+		stubFile, err := ReadFile(stubFilePath)
+		if err != nil {
+			log.Fatalln(fmt.Sprintf("error reading stub [%s]:", path.Clean(stubFilePath)), err)
+		}
+		stubYAML, err := yaml.Parse(stubFilePath, stubFile)
+		if err != nil {
+			findings = append(findings, parseFinding(stubFilePath, err))
+			continue
+		}
+		stubs = append(stubs, stubYAML)
+	}
+
+	opts := &lint.Options{
+		Features: features.Features(),
+	}
+	for _, list := range featureFlags {
+		for _, f := range strings.Split(list, ",") {
+			if err := opts.Features.Set(strings.TrimSpace(f), true); err != nil {
+				log.Fatalln(err.Error())
+			}
+		}
+	}
+	if interpolation {
+		opts.Features.SetInterpolation(true)
+	}
+
+	opts.Bindings, err = readBindings(bindingFilePath, values)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	findings = append(findings, lint.Lint(templateYAMLs, stubs, opts)...)
+
+	var data []byte
+	if format == "sarif" {
+		data, err = lint.SARIF(rootCmd.Version, findings)
+	} else {
+		data, err = lint.JSON(findings)
+	}
+	if err != nil {
+		log.Fatalln("error marshalling findings:", err)
+	}
+	fmt.Println(string(data))
+	return lint.HasErrors(findings)
+}
+
+func parseFinding(file string, err error) lint.Finding {
+	f := lint.Finding{
+		Rule:     lint.RULE_SYNTAX_ERROR,
+		Severity: lint.ERROR,
+		Message:  err.Error(),
+		File:     file,
+	}
+	if perr, ok := err.(yaml.ParseError); ok {
+		f.Line = perr.Position.Line
+		f.Column = perr.Position.Column
+	}
+	return f
+}
+
+// readBindings provides the bindings given by a bindings file and
+// key/value definitions.
+func readBindings(bindingFilePath string, values map[string]string) (map[string]yaml.Node, error) {
+	bindingYAML := readYAML(bindingFilePath, "bindings file", true)
+	if bindingYAML == nil && len(values) == 0 {
+		return nil, nil
+	}
+	result := map[string]yaml.Node{}
+	if bindingYAML != nil {
+		m, ok := bindingYAML.Value().(map[string]yaml.Node)
+		if !ok {
+			return nil, fmt.Errorf("bindings must be given as map")
+		}
+		for k, v := range m {
+			result[k] = v
+		}
+	}
+	for k, v := range values {
+		result[k] = yaml.NewNode(v, "define")
+	}
+	return result, nil
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mandelsoft/spiff/features"
+	"github.com/mandelsoft/spiff/flow"
+	"github.com/mandelsoft/spiff/lsp"
+	"github.com/mandelsoft/spiff/yaml"
+)
+
+var lspStubs []string
+var lspOSAccess bool
+
+// lspCmd represents the lsp command
+var lspCmd = &cobra.Command{
+	Use:   "lsp",
+	Short: "Run a language server for spiff templates",
+	Long: `Run a language server speaking the language server protocol via stdin
+and stdout. It provides diagnostics for syntax errors, unknown functions and
+unresolved nodes, hover with the evaluated value of a node, go-to-definition
+for references and tag references, and completion of function names.
+The edited documents are evaluated with the given stubs and bindings.
+Command execution is disabled unless --os-access is given.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		vals, err := createValuesFromArgs(values)
+		if err != nil {
+			log.Fatalf("%s\n", err)
+		}
+		if err := runLSP(lspStubs, bindings, vals, lspOSAccess); err != nil {
+			log.Fatalln(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(lspCmd)
+
+	lspCmd.Flags().StringArrayVar(&lspStubs, "stub", nil, "stub file used to evaluate the edited documents")
+	lspCmd.Flags().BoolVar(&lspOSAccess, "os-access", false, "allow command execution functions (exec, pipe)")
+	lspCmd.Flags().BoolVar(&interpolation, "interpolation", interpolation, "enable interpolation alpha feature")
+	lspCmd.Flags().StringVar(&bindings, "bindings", "", "yaml file with additional bindings to use")
+	lspCmd.Flags().StringArrayVarP(&values, "define", "D", nil, "key/value bindings")
+	lspCmd.Flags().StringArrayVar(&featureFlags, "features", []string{}, "set feature flags")
+}
+
+func runLSP(stubFilePaths []string, bindingFilePath string, values map[string]string, osAccess bool) error {
+	opts := &lsp.Options{
+		Mode:     flow.MODE_FILE_ACCESS,
+		Features: features.Features(),
+		Version:  rootCmd.Version,
+	}
+	if osAccess {
+		opts.Mode |= flow.MODE_OS_ACCESS
+	}
+	for _, list := range featureFlags {
+		for _, f := range strings.Split(list, ",") {
+			if err := opts.Features.Set(strings.TrimSpace(f), true); err != nil {
+				return err
+			}
+		}
+	}
+	if interpolation {
+		opts.Features.SetInterpolation(true)
+	}
+
+	for _, stubFilePath := range stubFilePaths {
+		stubFile, err := ReadFile(stubFilePath)
+		if err != nil {
+			return fmt.Errorf("error reading stub [%s]: %s", path.Clean(stubFilePath), err)
+		}
+		stubYAML, err := yaml.Parse(stubFilePath, stubFile)
+		if err != nil {
+			return fmt.Errorf("error parsing stub [%s]: %s", path.Clean(stubFilePath), err)
+		}
+		opts.Stubs = append(opts.Stubs, stubYAML)
+	}
+
+	bindings, err := readBindings(bindingFilePath, values)
+	if err != nil {
+		return err
+	}
+	opts.Bindings = bindings
+
+	return lsp.NewServer(os.Stdin, os.Stdout, opts).Run()
+}
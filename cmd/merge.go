@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -9,6 +10,7 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 
@@ -17,10 +19,12 @@ import (
 	"github.com/mandelsoft/spiff/features"
 	"github.com/mandelsoft/spiff/flow"
 	"github.com/mandelsoft/spiff/legacy/candiedyaml"
+	"github.com/mandelsoft/spiff/statestore"
 	"github.com/mandelsoft/spiff/yaml"
 )
 
 var asJSON bool
+var outputFormat string
 var outputPath string
 var selection []string
 var tagdefs []string
@@ -32,6 +36,13 @@ var processingOptions flow.Options
 var state string
 var bindings string
 var values []string
+var preserveComments bool
+var explainPath string
+var outputFile string
+var watchMode bool
+var redactOutput bool
+var stateLockTimeout time.Duration
+var stateForceUnlock bool
 
 // mergeCmd represents the merge command
 var mergeCmd = &cobra.Command{
@@ -50,6 +61,28 @@ var mergeCmd = &cobra.Command{
 		if err != nil {
 			log.Fatalf("%s\n", err)
 		}
+		if watchMode {
+			if outputFile == "" {
+				log.Fatalln("watch mode requires an output file (--output)")
+			}
+			for _, f := range args {
+				if f == "-" {
+					log.Fatalln("stdin cannot be used in watch mode")
+				}
+			}
+			watch(&merger{
+				templateFilePath: args[0],
+				opts:             processingOptions,
+				json:             asJSON,
+				split:            split,
+				subpath:          outputPath,
+				selection:        selection,
+				stateFilePath:    state,
+				bindingFilePath:  bindings,
+				values:           vals,
+				stubFilePaths:    args[1:],
+			}, outputFile)
+		}
 		merge(false, args[0], processingOptions, asJSON, split, outputPath, selection, state, bindings, vals, nil, args[1:])
 	},
 }
@@ -62,19 +95,29 @@ func init() {
 
 	mergeCmd.Flags().BoolVar(&interpolation, "interpolation", interpolation, "enable interpolation alpha feature")
 	mergeCmd.Flags().BoolVar(&asJSON, "json", false, "print output in json format")
+	mergeCmd.Flags().StringVar(&outputFormat, "output-format", yaml.FORMAT_YAML, outputFormatUsage())
 	mergeCmd.Flags().BoolVar(&debug.DebugFlag, "debug", false, "Print state info")
 	mergeCmd.Flags().BoolVar(&processingOptions.Partial, "partial", false, "Allow partial evaluation only")
 	mergeCmd.Flags().StringVar(&outputPath, "path", "", "output is taken from given path")
 	mergeCmd.Flags().BoolVar(&split, "split", false, "if the output is a list it will be split into separate documents")
 	mergeCmd.Flags().BoolVar(&processingOptions.PreserveEscapes, "preserve-escapes", false, "preserve escaping for escaped expressions and merges")
 	mergeCmd.Flags().BoolVar(&processingOptions.PreserveTemporary, "preserve-temporary", false, "preserve temporary fields")
+	mergeCmd.Flags().BoolVar(&preserveComments, "preserve-comments", false, "preserve comments of the template in yaml output")
+	mergeCmd.Flags().BoolVar(&processingOptions.KeepOrder, "keep-order", false, "keep the original key order of maps in the output")
 	mergeCmd.Flags().StringVar(&state, "state", "", "select state file to maintain")
+	mergeCmd.Flags().DurationVar(&stateLockTimeout, "state-lock-timeout", statestore.DefaultLockTimeout, "maximum time to wait for the lock of the state file")
+	mergeCmd.Flags().BoolVar(&stateForceUnlock, "force-unlock", false, "remove a left over lock of the state file before the processing")
 	mergeCmd.Flags().StringVar(&bindings, "bindings", "", "yaml file with additional bindings to use")
 	mergeCmd.Flags().StringArrayVarP(&values, "define", "D", nil, "key/value bindings")
 	mergeCmd.Flags().StringArrayVar(&selection, "select", []string{}, "filter dedicated output fields")
 	mergeCmd.Flags().StringArrayVar(&tagdefs, "tag", []string{}, "tag files (tag:path)")
 	mergeCmd.Flags().StringArrayVar(&featureFlags, "features", []string{}, "set feature flags")
 	mergeCmd.Flags().StringVar(&expr, "evaluate", "", "evaluation expression")
+	mergeCmd.Flags().StringVar(&explainPath, "explain", "", "trace the evaluation of the node with the given path")
+	mergeCmd.Flags().StringVar(&outputFile, "output", "", "write the output to the given file")
+	mergeCmd.Flags().BoolVar(&watchMode, "watch", false, "watch the used files and regenerate the output file on changes")
+	mergeCmd.Flags().BoolVar(&redactOutput, "redact", false, "replace sensitive values by <redacted> in the output")
+	mergeCmd.Flags().IntVar(&processingOptions.Parallel, "parallel", 0, "maximum number of workers processing independent nodes concurrently")
 }
 
 func createValuesFromArgs(values []string) (map[string]string, error) {
@@ -104,161 +147,238 @@ func fileExists(filename string) bool {
 }
 
 func readYAML(filename string, desc string, required bool) yaml.Node {
+	doc, err := readYAMLFile(filename, desc, required)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return doc
+}
+
+func readYAMLFile(filename string, desc string, required bool) (yaml.Node, error) {
 	if filename != "" {
 		if fileExists(filename) {
 			data, err := ioutil.ReadFile(filename)
 			if required && err != nil {
-				log.Fatalln(fmt.Sprintf("error reading %s [%s]:", desc, path.Clean(filename)), err)
+				return nil, failure(fmt.Sprintf("error reading %s [%s]:", desc, path.Clean(filename)), err)
 			}
 			doc, err := yaml.Parse(filename, data)
 			if err != nil {
-				log.Fatalln(fmt.Sprintf("error parsing %s [%s]:", desc, path.Clean(filename)), err)
+				return nil, failure(fmt.Sprintf("error parsing %s [%s]:", desc, path.Clean(filename)), err)
 			}
-			return doc
+			return doc, nil
 		}
 	}
-	return nil
+	return nil, nil
+}
+
+func parseTemplate(templateFilePath string, templateFile []byte) ([]yaml.Node, error) {
+	if preserveComments {
+		return yaml.ParseMultiWithComments(templateFilePath, templateFile)
+	}
+	return yaml.ParseMulti(templateFilePath, templateFile)
+}
+
+func outputFormatUsage() string {
+	return fmt.Sprintf("output format (%s)", strings.Join(yaml.FormatterNames(), ", "))
+}
+
+// outputFormatter provides the formatter for the selected output format.
+// The option --json is a shortcut for --output-format json.
+func outputFormatter(json bool) (yaml.Formatter, error) {
+	name := outputFormat
+	if json {
+		if name != yaml.FORMAT_YAML && name != yaml.FORMAT_JSON {
+			return nil, fmt.Errorf("option --json conflicts with output format %q", name)
+		}
+		name = yaml.FORMAT_JSON
+	}
+	return yaml.LookupFormatter(name)
+}
+
+func marshalOptions() yaml.MarshalOptions {
+	return yaml.MarshalOptions{
+		Comments:  preserveComments,
+		KeepOrder: processingOptions.KeepOrder,
+	}
 }
 
 func merge(stdin bool, templateFilePath string, opts flow.Options, json, split bool,
 	subpath string, selection []string, stateFilePath, bindingFilePath string, values map[string]string, stubs []yaml.Node, stubFilePaths []string) {
+	m := &merger{
+		stdin:            stdin,
+		templateFilePath: templateFilePath,
+		opts:             opts,
+		json:             json,
+		split:            split,
+		subpath:          subpath,
+		selection:        selection,
+		stateFilePath:    stateFilePath,
+		bindingFilePath:  bindingFilePath,
+		values:           values,
+		stubs:            stubs,
+		stubFilePaths:    stubFilePaths,
+	}
+	result, err := m.run(nil)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if outputFile != "" {
+		if err := writeOutput(outputFile, result); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+	fmt.Print(string(result))
+}
+
+const legend = "\nerror classification:\n" +
+	" *: error in local dynaml expression\n" +
+	" @: dependent of or involved in a cycle\n" +
+	" -: depending on a node with an error"
+
+// failure provides an error with the message log.Fatalln would print
+// for the given arguments.
+func failure(args ...interface{}) error {
+	return errors.New(strings.TrimSuffix(fmt.Sprintln(args...), "\n"))
+}
+
+// merger describes the input of a merge. In watch mode it keeps the
+// processing state and the prepared stubs to process the template
+// again after changes of the used files.
+type merger struct {
+	stdin            bool
+	templateFilePath string
+	opts             flow.Options
+	json             bool
+	split            bool
+	subpath          string
+	selection        []string
+	stateFilePath    string
+	bindingFilePath  string
+	values           map[string]string
+	stubs            []yaml.Node
+	stubFilePaths    []string
+
+	store     statestore.StateStore
+	watch     bool
+	state     *flow.State
+	binding   dynaml.Binding
+	features  features.FeatureFlags
+	prepared  []yaml.Node
+	tags      *flow.TagSnapshot // tags after the preparation of the stubs
+	stubFiles map[string]bool   // files used for the preparation of the stubs
+	documents []*document       // reusable results of the template documents
+}
+
+// document is the result of a template document kept in watch mode.
+type document struct {
+	source []byte            // canonical form of the template document
+	result yaml.Node         // processing result
+	tags   *flow.TagSnapshot // tags after the processing
+}
+
+// run processes the template and provides the resulting documents.
+// If changed files are given, the stubs are prepared again only if they
+// depend on a changed file. Then unchanged template documents are not
+// processed again (see apply).
+func (m *merger) run(changed map[string]bool) ([]byte, error) {
 	var templateFile []byte
 	var err error
 
-	if templateFilePath == "-" {
+	stdin := m.stdin
+	if m.templateFilePath == "-" {
 		templateFile, err = ioutil.ReadAll(os.Stdin)
 		stdin = true
 	} else {
-		templateFile, err = ReadFile(templateFilePath)
+		templateFile, err = ReadFile(m.templateFilePath)
 	}
 
 	if err != nil {
-		log.Fatalln(fmt.Sprintf("error reading template [%s]:", path.Clean(templateFilePath)), err)
+		return nil, failure(fmt.Sprintf("error reading template [%s]:", path.Clean(m.templateFilePath)), err)
 	}
 
-	templateYAMLs, err := yaml.ParseMulti(templateFilePath, templateFile)
+	templateYAMLs, err := parseTemplate(m.templateFilePath, templateFile)
 	if err != nil {
-		log.Fatalln(fmt.Sprintf("error parsing template [%s]:", path.Clean(templateFilePath)), err)
+		return nil, failure(fmt.Sprintf("error parsing template [%s]:", path.Clean(m.templateFilePath)), err)
 	}
-
-	var stateYAML yaml.Node
-	if stateFilePath != "" {
-		if len(templateYAMLs) > 1 {
-			log.Fatalln(fmt.Sprintf("state handling not supported gor multi documents [%s]:", path.Clean(templateFilePath)), err)
+	for i, doc := range templateYAMLs {
+		templateYAMLs[i], err = decryptDocument(m.templateFilePath, doc)
+		if err != nil {
+			return nil, failure(err)
 		}
-		stateYAML = readYAML(stateFilePath, "state file", false)
 	}
-	bindingYAML := readYAML(bindingFilePath, "bindings file", true)
 
-	if len(values) > 0 {
-		if bindingYAML == nil {
-			bindingYAML = yaml.NewNode(map[string]yaml.Node{}, "<values>")
-		}
-		m, ok := bindingYAML.Value().(map[string]yaml.Node)
-		if !ok {
-			log.Fatalf(fmt.Sprintf("binding %q must be a map\n", bindingFilePath))
-		}
-		for k, v := range values {
-			i, err := strconv.ParseInt(v, 10, 64)
-			if err == nil {
-				m[k] = yaml.NewNode(i, "<values>")
-			} else {
-				m[k] = yaml.NewNode(v, "<values>")
-			}
-		}
+	if m.stateFilePath != "" && len(templateYAMLs) > 1 {
+		return nil, failure(fmt.Sprintf("state handling not supported gor multi documents [%s]:", path.Clean(m.templateFilePath)), err)
 	}
 
-	tags := []*dynaml.Tag{}
-
-	for _, tagDef := range tagdefs {
-		i := strings.Index(tagDef, ":")
-		if i <= 0 {
-			log.Fatalln(fmt.Sprintf("tag file must be preceeded by a tag (<tag>:<path>)"))
-		}
-		tagName := tagDef[:i]
-		err := dynaml.CheckTagName(tagName)
-		if err != nil {
-			log.Fatalln(fmt.Sprintf("invalid tag name [%s]:", path.Clean(tagName)), err)
+	if m.stateFilePath != "" {
+		if m.store == nil {
+			m.store, err = statestore.New(m.stateFilePath)
+			if err != nil {
+				return nil, failure(err)
+			}
 		}
-		tagFilePath := tagDef[i+1:]
-		tagFile, err := ReadFile(tagFilePath)
-		if err != nil {
-			log.Fatalln(fmt.Sprintf("error reading tag file [%s]:", path.Clean(tagFilePath)), err)
+		if stateForceUnlock {
+			if err := m.store.ForceUnlock(); err != nil {
+				return nil, failure(err)
+			}
 		}
-
-		tagYAML, err := yaml.Parse(tagFilePath, tagFile)
-		if err != nil {
-			log.Fatalln(fmt.Sprintf("error parsing tag file [%s]:", path.Clean(tagFilePath)), err)
+		if err := m.store.Lock(stateLockTimeout); err != nil {
+			return nil, failure(fmt.Sprintf("cannot lock state file %q:", m.stateFilePath), err)
 		}
-
-		tags = append(tags, dynaml.NewTag(tagName, tagYAML, nil, dynaml.TAG_SCOPE_GLOBAL))
+		defer m.store.Unlock()
 	}
 
-	if stubs == nil {
-		stubs = []yaml.Node{}
+	var explanation *flow.Explanation
+	if explainPath != "" {
+		explanation = flow.NewExplanation(dynaml.PathComponents(explainPath, false)...)
 	}
-	stubs = append(stubs[:0:0], stubs...)
-
-	for _, stubFilePath := range stubFilePaths {
-		var stubFile []byte
-		var err error
-		if stubFilePath == "-" {
-			if stdin {
-				log.Fatalln(fmt.Sprintf("stdin cannot be used twice"))
-			}
-			stubFile, err = ioutil.ReadAll(os.Stdin)
-			stdin = true
-		} else {
-			stubFile, err = ReadFile(stubFilePath)
-		}
-		if err != nil {
-			log.Fatalln(fmt.Sprintf("error reading stub [%s]:", path.Clean(stubFilePath)), err)
+	explain := func() {
+		if explanation != nil {
+			fmt.Fprint(os.Stderr, explanation)
 		}
+	}
 
-		stubYAML, err := yaml.Parse(stubFilePath, stubFile)
+	// the results of the last run can be reused, if the stubs
+	// are not prepared again.
+	reuse := explanation == nil
+	if m.tags != nil && !m.stubsChanged(changed) {
+		m.state.SetExplanation(explanation)
+		m.state.RestoreTags(m.tags)
+		m.state.ResetExecCache()
+	} else {
+		reuse = false
+		m.tags = nil
+		stubs, err := m.setup(stdin, len(templateYAMLs), explanation)
 		if err != nil {
-			log.Fatalln(fmt.Sprintf("error parsing stub [%s]:", path.Clean(stubFilePath)), err)
+			return nil, err
 		}
-
-		stubs = append(stubs, stubYAML)
-	}
-
-	if stateYAML != nil {
-		stubs = append(stubs, stateYAML)
-	}
-
-	legend := "\nerror classification:\n" +
-		" *: error in local dynaml expression\n" +
-		" @: dependent of or involved in a cycle\n" +
-		" -: depending on a node with an error"
-
-	var binding dynaml.Binding
-	features := features.Features()
-	for _, list := range featureFlags {
-		for _, f := range strings.Split(list, ",") {
-			if err := features.Set(strings.TrimSpace(f), true); err != nil {
-				log.Fatalln(err.Error())
-			}
+		var accesses uint64
+		if m.state != nil {
+			m.state.ResetExecCache()
+			accesses = m.state.ExternalAccesses()
 		}
-	}
-	if bindingYAML != nil || interpolation || len(tags) > 0 || len(templateYAMLs) > 1 {
-		defstate := flow.NewDefaultState().SetInterpolation(interpolation)
-		defstate.SetTags(tags...)
-		binding = flow.NewEnvironment(
-			nil, "context", defstate)
-		if bindingYAML != nil {
-			values, ok := bindingYAML.Value().(map[string]yaml.Node)
-			if !ok {
-				log.Fatalln("bindings must be given as map")
+		m.prepared, err = flow.PrepareStubsWithOptions(m.binding, m.opts, stubs...)
+		if !m.opts.Partial && err != nil {
+			explain()
+			return nil, failure("error generating manifest:", err, legend)
+		}
+		// stubs executing commands or accessing other external resources
+		// are always prepared again.
+		if m.watch && err == nil && m.state.ExternalAccesses() == accesses {
+			m.tags = m.state.SaveTags()
+			m.stubFiles = map[string]bool{}
+			for _, f := range m.watchedInputs() {
+				m.stubFiles[f] = true
 			}
-			binding = binding.WithLocalScope(values)
 		}
-		features = binding.GetFeatures()
 	}
-
-	prepared, err := flow.PrepareStubs(binding, processingOptions.Partial, stubs...)
-	if !processingOptions.Partial && err != nil {
-		log.Fatalln("error generating manifest:", err, legend)
+	binding := m.binding
+	features := m.features
+	formatter, err := outputFormatter(m.json)
+	if err != nil {
+		return nil, failure(err)
 	}
 
 	result := [][]byte{}
@@ -271,27 +391,29 @@ func merge(stdin bool, templateFilePath string, opts flow.Options, json, split b
 		var bytes []byte
 		if templateYAML.Value() != nil {
 			count++
-			flowed, err := flow.Apply(binding, templateYAML, prepared, opts)
-			if !opts.Partial && err != nil {
-				log.Fatalln(fmt.Sprintf("error generating manifest%s:", doc), err, legend)
+			flowed, err := m.apply(no, templateYAML, &reuse)
+			if !m.opts.Partial && err != nil {
+				explain()
+				return nil, failure(fmt.Sprintf("error generating manifest%s:", doc), err, legend)
 			}
 			if err != nil {
 				flowed = dynaml.ResetUnresolvedNodes(flowed)
 			}
-			if !opts.PreserveTemporary && flowed.Temporary() {
+			if !m.opts.PreserveTemporary && flowed.Temporary() {
 				continue
 			}
-			if subpath != "" {
-				comps := dynaml.PathComponents(subpath, false)
+			if m.subpath != "" {
+				comps := dynaml.PathComponents(m.subpath, false)
 				node, ok := yaml.FindR(true, flowed, features, comps...)
 				if !ok {
-					log.Fatalln(fmt.Sprintf("path %q not found%s", subpath, doc))
+					return nil, failure(fmt.Sprintf("path %q not found%s", m.subpath, doc))
 				}
 				flowed = node
 			}
-			if stateFilePath != "" {
+			if m.stateFilePath != "" {
+				stateFilePath := m.stateFilePath
 				state := flow.Cleanup(flowed, flow.DiscardNonState)
-				json := json
+				json := m.json || outputFormat == yaml.FORMAT_JSON
 				if strings.HasSuffix(stateFilePath, ".yaml") || strings.HasSuffix(stateFilePath, ".yml") {
 					json = false
 				} else {
@@ -304,90 +426,256 @@ func merge(stdin bool, templateFilePath string, opts flow.Options, json, split b
 				} else {
 					bytes, err = candiedyaml.Marshal(state)
 				}
-				old := false
-				if fileExists(stateFilePath) {
-					os.Rename(stateFilePath, stateFilePath+".bak")
-					old = true
-				}
-				err := ioutil.WriteFile(stateFilePath, bytes, 0664)
 				if err != nil {
-					os.Remove(stateFilePath)
-					os.Remove(stateFilePath)
-					if old {
-						os.Rename(stateFilePath+".bak", stateFilePath)
-					}
-					log.Fatalln(fmt.Sprintf("cannot write state file %q", stateFilePath))
+					return nil, failure(fmt.Sprintf("error marshalling state%s:", doc), err)
+				}
+				if err := m.store.Write(bytes); err != nil {
+					return nil, failure(fmt.Sprintf("cannot write state file %q:", stateFilePath), err)
 				}
 			}
 
 			if len(expr) > 0 {
 				e, err := dynaml.Parse(expr, []string{}, []string{})
 				if err != nil {
-					log.Fatalln(fmt.Sprintf("invalid expression %q: %s", expr, err))
+					return nil, failure(fmt.Sprintf("invalid expression %q: %s", expr, err))
 				}
-				if m, ok := flowed.Value().(map[string]yaml.Node); ok {
-					binding := flow.NewNestedEnvironment(nil, "context", binding).WithLocalScope(m)
+				if v, ok := flowed.Value().(map[string]yaml.Node); ok {
+					binding := flow.NewNestedEnvironment(nil, "context", binding).WithLocalScope(v)
 					v, err := flow.Cascade(binding, yaml.NewNode(e, "<expr>"), flow.Options{})
 					if err != nil {
-						log.Fatalln(fmt.Sprintf("expression %q failed: %s", expr, err))
+						return nil, failure(fmt.Sprintf("expression %q failed: %s", expr, err))
 					}
 					flowed = v
 				} else {
-					log.Fatalln("no map document")
+					return nil, failure("no map document")
 				}
 			}
 
-			if len(selection) > 0 {
+			if len(m.selection) > 0 {
 				new := map[string]yaml.Node{}
-				for _, p := range selection {
+				keys := []string{}
+				for _, p := range m.selection {
 					comps := dynaml.PathComponents(p, false)
 					node, ok := yaml.FindR(true, flowed, features, comps...)
 					if !ok {
-						log.Fatalln(fmt.Sprintf("path %q not found%s", subpath, doc))
+						return nil, failure(fmt.Sprintf("path %q not found%s", m.subpath, doc))
 					}
 					new[comps[len(comps)-1]] = node
+					keys = append(keys, comps[len(comps)-1])
 
 				}
-				flowed = yaml.NewNode(new, "")
+				flowed = yaml.OrderedNode(yaml.NewNode(new, ""), keys)
 			}
 
-			if split {
+			if redactOutput {
+				flowed = flow.Redact(flowed)
+			}
+
+			if m.split {
 				if list, ok := flowed.Value().([]yaml.Node); ok {
 					for _, d := range list {
-						if json {
-							bytes, err = yaml.ToJSON(d)
-						} else {
-							bytes, err = candiedyaml.Marshal(d)
-						}
+						bytes, err = formatter.Marshal(d, marshalOptions())
 						if err != nil {
-							log.Fatalln(fmt.Sprintf("error marshalling manifest%s:", doc), err)
+							return nil, failure(fmt.Sprintf("error marshalling manifest%s:", doc), err)
 						}
 						result = append(result, bytes)
 					}
 					continue
 				}
 			}
-			if json {
-				bytes, err = yaml.ToJSON(flowed)
-			} else {
-				bytes, err = candiedyaml.Marshal(flowed)
-			}
+			bytes, err = formatter.Marshal(flowed, marshalOptions())
 			if err != nil {
-				log.Fatalln(fmt.Sprintf("error marshalling manifest%s:", doc), err)
+				return nil, failure(fmt.Sprintf("error marshalling manifest%s:", doc), err)
 			}
 		}
 		result = append(result, bytes)
 	}
+	explain()
+
+	out, err := formatter.Join(result)
+	if err != nil {
+		return nil, failure("error marshalling manifest:", err)
+	}
+	return out, nil
+}
+
+// apply processes a template document. In watch mode the result of the
+// last run is reused, if the document and the stubs are unchanged and
+// the results of all preceding documents could be reused, too (they
+// might set tags used by the document). Documents executing commands or
+// accessing files or other external resources are always processed.
+func (m *merger) apply(no int, templateYAML yaml.Node, reuse *bool) (yaml.Node, error) {
+	if !m.watch {
+		return flow.Apply(m.binding, templateYAML, m.prepared, m.opts)
+	}
+	source, err := yaml.MarshalWithOptions(templateYAML, yaml.MarshalOptions{Comments: true, KeepOrder: true})
+	if err != nil {
+		source = nil
+	}
+	for len(m.documents) <= no {
+		m.documents = append(m.documents, nil)
+	}
+	if d := m.documents[no]; *reuse && d != nil && source != nil && bytes.Equal(d.source, source) {
+		m.state.RestoreTags(d.tags)
+		return d.result, nil
+	}
+	*reuse = false
+	m.documents[no] = nil
+	accesses := m.state.ExternalAccesses()
+	flowed, err := flow.Apply(m.binding, templateYAML, m.prepared, m.opts)
+	if err == nil && source != nil && m.state.ExternalAccesses() == accesses {
+		m.documents[no] = &document{source: source, result: flowed, tags: m.state.SaveTags()}
+	}
+	return flowed, err
+}
+
+// readState reads the actual state from the state store.
+func (m *merger) readState() (yaml.Node, error) {
+	data, err := m.store.Read()
+	if err != nil {
+		return nil, failure(fmt.Sprintf("error reading state file [%s]:", m.store.Name()), err)
+	}
+	if data == nil {
+		return nil, nil
+	}
+	doc, err := yaml.Parse(m.store.Name(), data)
+	if err != nil {
+		return nil, failure(fmt.Sprintf("error parsing state file [%s]:", m.store.Name()), err)
+	}
+	return doc, nil
+}
+
+// setup reads the stubs, bindings and tag files and provides the
+// binding used to process the stubs and the template.
+func (m *merger) setup(stdin bool, documents int, explanation *flow.Explanation) ([]yaml.Node, error) {
+	var stateYAML yaml.Node
+	var err error
+	if m.stateFilePath != "" {
+		stateYAML, err = m.readState()
+		if err != nil {
+			return nil, err
+		}
+	}
+	bindingYAML, err := readYAMLFile(m.bindingFilePath, "bindings file", true)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(m.values) > 0 {
+		if bindingYAML == nil {
+			bindingYAML = yaml.NewNode(map[string]yaml.Node{}, "<values>")
+		}
+		v, ok := bindingYAML.Value().(map[string]yaml.Node)
+		if !ok {
+			return nil, failure(fmt.Sprintf("binding %q must be a map", m.bindingFilePath))
+		}
+		for k, s := range m.values {
+			i, err := strconv.ParseInt(s, 10, 64)
+			if err == nil {
+				v[k] = yaml.NewNode(i, "<values>")
+			} else {
+				v[k] = yaml.NewNode(s, "<values>")
+			}
+		}
+	}
+
+	tags := []*dynaml.Tag{}
+
+	for _, tagDef := range tagdefs {
+		i := strings.Index(tagDef, ":")
+		if i <= 0 {
+			return nil, failure(fmt.Sprintf("tag file must be preceeded by a tag (<tag>:<path>)"))
+		}
+		tagName := tagDef[:i]
+		err := dynaml.CheckTagName(tagName)
+		if err != nil {
+			return nil, failure(fmt.Sprintf("invalid tag name [%s]:", path.Clean(tagName)), err)
+		}
+		tagFilePath := tagDef[i+1:]
+		tagFile, err := ReadFile(tagFilePath)
+		if err != nil {
+			return nil, failure(fmt.Sprintf("error reading tag file [%s]:", path.Clean(tagFilePath)), err)
+		}
+
+		tagYAML, err := yaml.Parse(tagFilePath, tagFile)
+		if err != nil {
+			return nil, failure(fmt.Sprintf("error parsing tag file [%s]:", path.Clean(tagFilePath)), err)
+		}
+
+		tags = append(tags, dynaml.NewTag(tagName, tagYAML, nil, dynaml.TAG_SCOPE_GLOBAL))
+	}
 
-	for _, bytes := range result {
-		if !json && (len(result) > 1 || len(bytes) == 0) {
-			fmt.Println("---")
+	stubs := m.stubs
+	if stubs == nil {
+		stubs = []yaml.Node{}
+	}
+	stubs = append(stubs[:0:0], stubs...)
+
+	for _, stubFilePath := range m.stubFilePaths {
+		var stubFile []byte
+		var err error
+		if stubFilePath == "-" {
+			if stdin {
+				return nil, failure(fmt.Sprintf("stdin cannot be used twice"))
+			}
+			stubFile, err = ioutil.ReadAll(os.Stdin)
+			stdin = true
+		} else {
+			stubFile, err = ReadFile(stubFilePath)
+		}
+		if err != nil {
+			return nil, failure(fmt.Sprintf("error reading stub [%s]:", path.Clean(stubFilePath)), err)
+		}
+
+		stubYAML, err := yaml.Parse(stubFilePath, stubFile)
+		if err != nil {
+			return nil, failure(fmt.Sprintf("error parsing stub [%s]:", path.Clean(stubFilePath)), err)
 		}
-		if bytes != nil {
-			fmt.Print(string(bytes))
-			if json {
-				fmt.Println()
+		stubYAML, err = decryptDocument(stubFilePath, stubYAML)
+		if err != nil {
+			return nil, failure(err)
+		}
+
+		stubs = append(stubs, stubYAML)
+	}
+
+	if stateYAML != nil {
+		stubs = append(stubs, stateYAML)
+	}
+
+	var binding dynaml.Binding
+	features := features.Features()
+	for _, list := range featureFlags {
+		for _, f := range strings.Split(list, ",") {
+			if err := features.Set(strings.TrimSpace(f), true); err != nil {
+				return nil, failure(err.Error())
+			}
+		}
+	}
+	if m.watch || bindingYAML != nil || interpolation || len(tags) > 0 || documents > 1 || explanation != nil {
+		// watch mode requires a processing state to observe the used files
+		defstate := m.state
+		if defstate == nil {
+			defstate = flow.NewDefaultState().SetInterpolation(interpolation)
+		} else {
+			defstate.ResetTags()
+			defstate.ResetObservedFiles()
+		}
+		defstate.SetTags(tags...)
+		defstate.SetExplanation(explanation)
+		binding = flow.NewEnvironment(
+			nil, "context", defstate)
+		if bindingYAML != nil {
+			values, ok := bindingYAML.Value().(map[string]yaml.Node)
+			if !ok {
+				return nil, failure("bindings must be given as map")
 			}
+			binding = binding.WithLocalScope(values)
 		}
+		features = binding.GetFeatures()
+		m.state = defstate
 	}
+	m.binding = binding
+	m.features = features
+	return stubs, nil
 }
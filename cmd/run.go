@@ -12,6 +12,7 @@ import (
 
 	"github.com/mandelsoft/spiff/debug"
 	"github.com/mandelsoft/spiff/flow"
+	"github.com/mandelsoft/spiff/statestore"
 	"github.com/mandelsoft/spiff/yaml"
 )
 
@@ -36,13 +37,21 @@ func init() {
 	rootCmd.AddCommand(processCmd)
 
 	processCmd.Flags().BoolVar(&asJSON, "json", false, "print output in json format")
+	processCmd.Flags().StringVar(&outputFormat, "output-format", yaml.FORMAT_YAML, outputFormatUsage())
 	processCmd.Flags().BoolVar(&debug.DebugFlag, "debug", false, "Print state info")
 	processCmd.Flags().BoolVar(&processingOptions.Partial, "partial", false, "Allow partial evaluation only")
 	processCmd.Flags().StringVar(&outputPath, "path", "", "output is taken from given path")
 	processCmd.Flags().StringVar(&state, "state", "", "select state file to maintain")
+	processCmd.Flags().DurationVar(&stateLockTimeout, "state-lock-timeout", statestore.DefaultLockTimeout, "maximum time to wait for the lock of the state file")
+	processCmd.Flags().BoolVar(&stateForceUnlock, "force-unlock", false, "remove a left over lock of the state file before the processing")
 	processCmd.Flags().StringArrayVar(&selection, "select", []string{}, "filter dedicated output fields")
 	processCmd.Flags().BoolVar(&processingOptions.PreserveEscapes, "preserve-escapes", false, "preserve escaping for escaped expressions and merges")
 	processCmd.Flags().BoolVar(&processingOptions.PreserveTemporary, "preserve-temporary", false, "preserve temporary fields")
+	processCmd.Flags().BoolVar(&preserveComments, "preserve-comments", false, "preserve comments of the template in yaml output")
+	processCmd.Flags().BoolVar(&processingOptions.KeepOrder, "keep-order", false, "keep the original key order of maps in the output")
+	processCmd.Flags().StringVar(&explainPath, "explain", "", "trace the evaluation of the node with the given path")
+	processCmd.Flags().BoolVar(&redactOutput, "redact", false, "replace sensitive values by <redacted> in the output")
+	processCmd.Flags().IntVar(&processingOptions.Parallel, "parallel", 0, "maximum number of workers processing independent nodes concurrently")
 }
 
 func run(documentFilePath, templateFilePath string, opts flow.Options, json, split bool,
@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// watchInterval is the interval used to poll the watched files.
+var watchInterval = 500 * time.Millisecond
+
+// fileStamp describes the state of a watched file. A missing
+// file is described by the zero stamp.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func stampOf(file string) fileStamp {
+	info, err := os.Stat(file)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{info.ModTime(), info.Size()}
+}
+
+// changedFiles provides the files whose stamp differs from the recorded
+// one, or nil if no file changed.
+func changedFiles(files []string, stamps map[string]fileStamp) map[string]bool {
+	var changed map[string]bool
+	for _, f := range files {
+		if s := stampOf(f); s != stamps[f] {
+			if changed == nil {
+				changed = map[string]bool{}
+			}
+			changed[f] = true
+		}
+	}
+	return changed
+}
+
+// watchedInputs provides the input files given on the command line,
+// which are used for the preparation of the stubs.
+func (m *merger) watchedInputs() []string {
+	files := append([]string{}, m.stubFilePaths...)
+	for _, tagDef := range tagdefs {
+		if i := strings.Index(tagDef, ":"); i > 0 {
+			files = append(files, tagDef[i+1:])
+		}
+	}
+	if m.bindingFilePath != "" {
+		files = append(files, m.bindingFilePath)
+	}
+	return files
+}
+
+// watchedFiles provides all local files used by the last run.
+// The state file is maintained by the merge itself and is
+// therefore not watched.
+func (m *merger) watchedFiles(output string) []string {
+	set := map[string]bool{m.templateFilePath: true}
+	for _, f := range m.watchedInputs() {
+		set[f] = true
+	}
+	if m.state != nil {
+		for _, f := range m.state.ObservedFiles() {
+			set[f] = true
+		}
+	}
+	delete(set, m.stateFilePath)
+	delete(set, output)
+	delete(set, "-")
+	files := []string{}
+	for f := range set {
+		if !strings.HasPrefix(f, "http:") && !strings.HasPrefix(f, "https:") {
+			files = append(files, f)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// stubsChanged checks whether the prepared stubs depend on one
+// of the changed files. Stubs using a state file are always
+// prepared again, because the state file is written by every run.
+// Stubs executing commands or accessing other external resources
+// are not kept at all (see merger.run).
+func (m *merger) stubsChanged(changed map[string]bool) bool {
+	if changed == nil || m.stateFilePath != "" {
+		return true
+	}
+	for f := range changed {
+		if m.stubFiles[f] {
+			return true
+		}
+	}
+	return false
+}
+
+// watch processes the template again whenever one of the used files
+// changes and writes the result to the output file. Processing errors
+// are reported without stopping the watch.
+func watch(m *merger, output string) {
+	m.watch = true
+	stamps := map[string]fileStamp{}
+	var changed map[string]bool
+	for {
+		for _, f := range m.watchedFiles(output) {
+			stamps[f] = stampOf(f)
+		}
+		result, err := m.run(changed)
+		if err == nil {
+			err = writeOutput(output, result)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: written %s\n", time.Now().Format("15:04:05"), output)
+		}
+
+		files := m.watchedFiles(output)
+		for _, f := range files {
+			if _, ok := stamps[f]; !ok {
+				// newly used by this run
+				stamps[f] = stampOf(f)
+			}
+		}
+		changed = nil
+		for changed == nil {
+			time.Sleep(watchInterval)
+			changed = changedFiles(files, stamps)
+		}
+		list := []string{}
+		for f := range changed {
+			list = append(list, f)
+		}
+		sort.Strings(list)
+		fmt.Fprintf(os.Stderr, "changed: %s\n", strings.Join(list, ", "))
+		if m.state != nil {
+			m.state.InvalidateFiles(list...)
+		}
+	}
+}
+
+// writeOutput replaces the output file by a new content. The content
+// is written to a temporary file first, so that readers of the output
+// never see a partially written file.
+func writeOutput(output string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(output), "."+filepath.Base(output)+".")
+	if err != nil {
+		return fmt.Errorf("cannot write output %q: %s", output, err)
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0664)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), output)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("cannot write output %q: %s", output, err)
+	}
+	return nil
+}
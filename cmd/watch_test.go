@@ -0,0 +1,188 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/mandelsoft/spiff/flow"
+)
+
+var _ = Describe("watch mode", func() {
+	var dir string
+
+	file := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+
+	write := func(name, content string) string {
+		Expect(ioutil.WriteFile(file(name), []byte(content), 0644)).To(Succeed())
+		return file(name)
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "spiff-watch")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	Context("change detection", func() {
+		var stamps map[string]fileStamp
+		var files []string
+
+		BeforeEach(func() {
+			files = []string{write("a.yaml", "a: 1\n"), file("missing.yaml")}
+			stamps = map[string]fileStamp{}
+			for _, f := range files {
+				stamps[f] = stampOf(f)
+			}
+		})
+
+		It("detects no change", func() {
+			Expect(changedFiles(files, stamps)).To(BeNil())
+		})
+
+		It("detects size changes", func() {
+			write("a.yaml", "a: 10\n")
+			Expect(changedFiles(files, stamps)).To(Equal(map[string]bool{file("a.yaml"): true}))
+		})
+
+		It("detects modification time changes", func() {
+			t := stamps[file("a.yaml")].modTime.Add(-time.Minute)
+			Expect(os.Chtimes(file("a.yaml"), t, t)).To(Succeed())
+			Expect(changedFiles(files, stamps)).To(Equal(map[string]bool{file("a.yaml"): true}))
+		})
+
+		It("detects created and deleted files", func() {
+			write("missing.yaml", "")
+			os.Remove(file("a.yaml"))
+			Expect(changedFiles(files, stamps)).To(Equal(map[string]bool{
+				file("a.yaml"):       true,
+				file("missing.yaml"): true,
+			}))
+		})
+	})
+
+	Context("watched files", func() {
+		It("provides the used local files", func() {
+			old := tagdefs
+			defer func() { tagdefs = old }()
+			tagdefs = []string{"tag:" + file("tag.yaml")}
+
+			m := &merger{
+				templateFilePath: file("template.yaml"),
+				stubFilePaths:    []string{file("stub.yaml"), "-", "https://host/stub.yaml"},
+				bindingFilePath:  file("bindings.yaml"),
+				stateFilePath:    file("state.yaml"),
+				state:            flow.NewDefaultState(),
+			}
+			m.state.ObserveFile(file("data.txt"))
+			m.state.ObserveFile(file("out.yaml"))
+			Expect(m.watchedFiles(file("out.yaml"))).To(Equal([]string{
+				file("bindings.yaml"),
+				file("data.txt"),
+				file("stub.yaml"),
+				file("tag.yaml"),
+				file("template.yaml"),
+			}))
+		})
+
+		It("checks the stubs for changes", func() {
+			m := &merger{stubFiles: map[string]bool{file("stub.yaml"): true}}
+			Expect(m.stubsChanged(nil)).To(BeTrue())
+			Expect(m.stubsChanged(map[string]bool{file("stub.yaml"): true})).To(BeTrue())
+			Expect(m.stubsChanged(map[string]bool{file("template.yaml"): true})).To(BeFalse())
+
+			m.stateFilePath = file("state.yaml")
+			Expect(m.stubsChanged(map[string]bool{file("template.yaml"): true})).To(BeTrue())
+		})
+	})
+
+	Context("regeneration", func() {
+		var m *merger
+
+		BeforeEach(func() {
+			write("data.txt", "v1")
+			write("stub.yaml", "value: stub\n")
+			m = &merger{
+				templateFilePath: file("template.yaml"),
+				stubFilePaths:    []string{file("stub.yaml")},
+				watch:            true,
+			}
+		})
+
+		It("processes changed documents only", func() {
+			write("template.yaml", `
+---
+value: default
+---
+data: (( read("`+file("data.txt")+`", "text") ))
+`)
+			out, err := m.run(nil)
+			Expect(err).To(BeNil())
+			Expect(string(out)).To(Equal("---\nvalue: stub\n---\ndata: v1\n"))
+			first := m.documents[0]
+			Expect(first).NotTo(BeNil())
+			Expect(m.documents[1]).To(BeNil())
+
+			write("data.txt", "v2")
+			m.state.InvalidateFiles(file("data.txt"))
+			out, err = m.run(map[string]bool{file("data.txt"): true})
+			Expect(err).To(BeNil())
+			Expect(string(out)).To(Equal("---\nvalue: stub\n---\ndata: v2\n"))
+			Expect(m.documents[0]).To(BeIdenticalTo(first))
+
+			write("stub.yaml", "value: changed\n")
+			out, err = m.run(map[string]bool{file("stub.yaml"): true})
+			Expect(err).To(BeNil())
+			Expect(string(out)).To(Equal("---\nvalue: changed\n---\ndata: v2\n"))
+			Expect(m.documents[0]).NotTo(BeIdenticalTo(first))
+		})
+
+		It("keeps the tags of reused documents", func() {
+			write("template.yaml", `
+---
+marked: (( &tag:v("tagged") ))
+---
+other: 1
+---
+tagged: (( v::. ))
+`)
+			out, err := m.run(nil)
+			Expect(err).To(BeNil())
+			Expect(string(out)).To(Equal("---\nmarked: tagged\n---\nother: 1\n---\ntagged: tagged\n"))
+			first := m.documents[0]
+
+			write("template.yaml", `
+---
+marked: (( &tag:v("tagged") ))
+---
+other: 2
+---
+tagged: (( v::. ))
+`)
+			out, err = m.run(map[string]bool{file("template.yaml"): true})
+			Expect(err).To(BeNil())
+			Expect(string(out)).To(Equal("---\nmarked: tagged\n---\nother: 2\n---\ntagged: tagged\n"))
+			Expect(m.documents[0]).To(BeIdenticalTo(first))
+		})
+
+		It("resets the exec cache for every run", func() {
+			write("template.yaml", "value: 1\n")
+			_, err := m.run(nil)
+			Expect(err).To(BeNil())
+			cache := m.state.GetExecCache()
+			_, err = m.run(map[string]bool{file("template.yaml"): true})
+			Expect(err).To(BeNil())
+			Expect(m.state.GetExecCache()).NotTo(BeIdenticalTo(cache))
+		})
+	})
+})
@@ -14,24 +14,32 @@ type Diff struct {
 	Path []string
 }
 
-func Compare(a, b yaml.Node) []Diff {
-	return compare(a, b, []string{})
+// Compare determines the structural differences of two documents.
+// Entries of lists are matched by their identity, if the entries
+// provide an identity according to the key rules given by the options
+// or the default heuristic (see Options).
+func Compare(a, b yaml.Node, opts ...Options) []Diff {
+	return newComparer(opts...).diff(a, b, []string{})
 }
 
-func compare(a, b yaml.Node, path []string) []Diff {
+func (c *comparer) compare(a, b yaml.Node, path []string) []Diff {
 	mismatch := Diff{A: a, B: b, Path: path}
 
+	if len(c.opts.Ignore) > 0 && c.ignored(path) {
+		return []Diff{}
+	}
+
 	switch av := a.Value().(type) {
 	case map[string]yaml.Node:
 		switch bv := b.Value().(type) {
 		case map[string]yaml.Node:
-			return compareMap(av, bv, path)
+			return c.compareMap(av, bv, path)
 
 		case []yaml.Node:
 			toMap := listToMap(bv)
 
 			if toMap != nil {
-				return compareMap(av, toMap, path)
+				return c.compareMap(av, toMap, path)
 			} else {
 				return []Diff{mismatch}
 			}
@@ -43,7 +51,7 @@ func compare(a, b yaml.Node, path []string) []Diff {
 	case []yaml.Node:
 		switch bv := b.Value().(type) {
 		case []yaml.Node:
-			return compareList(av, bv, path)
+			return c.compareList(av, bv, path)
 		default:
 			return []Diff{mismatch}
 		}
@@ -56,7 +64,7 @@ func compare(a, b yaml.Node, path []string) []Diff {
 			return []Diff{mismatch}
 		}
 
-		if av != b.Value() {
+		if !c.scalarEqual(av, b.Value()) {
 			return []Diff{Diff{A: a, B: b, Path: path}}
 		}
 	}
@@ -91,14 +99,17 @@ func listToMap(list []yaml.Node) map[string]yaml.Node {
 	return toMap
 }
 
-func compareMap(a, b map[string]yaml.Node, path []string) []Diff {
+func (c *comparer) compareMap(a, b map[string]yaml.Node, path []string) []Diff {
 	diff := []Diff{}
 
 	for key, aval := range a {
 		bval, present := b[key]
 		if present {
-			diff = append(diff, compare(aval, bval, addPath(path, key))...)
+			diff = append(diff, c.compare(aval, bval, addPath(path, key))...)
 		} else {
+			if c.opts.NullAsAbsent && isNull(aval) {
+				continue
+			}
 			diff = append(diff, Diff{A: aval, B: nil, Path: addPath(path, key)})
 		}
 	}
@@ -106,6 +117,9 @@ func compareMap(a, b map[string]yaml.Node, path []string) []Diff {
 	for key, bval := range b {
 		_, present := a[key]
 		if !present {
+			if c.opts.NullAsAbsent && isNull(bval) {
+				continue
+			}
 			diff = append(diff, Diff{A: nil, B: bval, Path: addPath(path, key)})
 			continue
 		}
@@ -114,11 +128,19 @@ func compareMap(a, b map[string]yaml.Node, path []string) []Diff {
 	return diff
 }
 
-func compareList(a, b []yaml.Node, path []string) []Diff {
+func (c *comparer) compareList(a, b []yaml.Node, path []string) []Diff {
 	diff := []Diff{}
 
+	if key := c.listKey(path, a, b); key != nil {
+		return c.compareKeyed(a, b, path, key)
+	}
+
 	if len(path) == 1 && path[0] == "jobs" {
-		return compareJobs(a, b, path)
+		return c.compareJobs(a, b, path)
+	}
+
+	if c.opts.UnorderedLists && !keyed([]string{"name"}, a) {
+		return c.compareUnordered(a, b, path)
 	}
 
 	for index, aval := range a {
@@ -129,7 +151,7 @@ func compareList(a, b []yaml.Node, path []string) []Diff {
 			continue
 		}
 
-		diff = append(diff, compare(aval, bval, addPath(path, key))...)
+		diff = append(diff, c.compare(aval, bval, addPath(path, key))...)
 	}
 
 	for index, bval := range b {
@@ -144,15 +166,20 @@ func compareList(a, b []yaml.Node, path []string) []Diff {
 	return diff
 }
 
-func compareJobs(ajobs, bjobs []yaml.Node, path []string) []Diff {
-	return compareMap(jobMap(ajobs), jobMap(bjobs), path)
+func (c *comparer) compareJobs(ajobs, bjobs []yaml.Node, path []string) []Diff {
+	return c.compareMap(jobMap(ajobs), jobMap(bjobs), path)
 }
 
 func jobMap(jobs []yaml.Node) map[string]yaml.Node {
 	byName := make(map[string]yaml.Node)
 
 	for index, job := range jobs {
-		attrs, ok := job.Value().(map[string]yaml.Node)
+		orig, ok := job.Value().(map[string]yaml.Node)
+		// don't modify the compared documents
+		attrs := make(map[string]yaml.Node, len(orig)+1)
+		for k, v := range orig {
+			attrs[k] = v
+		}
 		attrs["index"] = yaml.NewNode(index, job.SourceName())
 
 		name, ok := yaml.FindString(job, nil, "name")
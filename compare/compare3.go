@@ -0,0 +1,354 @@
+package compare
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/mandelsoft/spiff/legacy/candiedyaml"
+	"github.com/mandelsoft/spiff/yaml"
+)
+
+// Change classifies a difference of a three-way comparison.
+type Change int
+
+const (
+	CHANGED_OURS   = Change(iota) // changed in ours, only
+	CHANGED_THEIRS                // changed in theirs, only
+	CHANGED_BOTH                  // changed identically in ours and theirs
+	CONFLICT                      // changed differently in ours and theirs
+)
+
+func (c Change) String() string {
+	switch c {
+	case CHANGED_OURS:
+		return "changed in ours"
+	case CHANGED_THEIRS:
+		return "changed in theirs"
+	case CHANGED_BOTH:
+		return "changed in both"
+	case CONFLICT:
+		return "conflict"
+	}
+	return "unknown"
+}
+
+// Conflict markers used as keys of the map replacing a conflicting
+// node in a merged document.
+const (
+	MARKER_OURS   = "<<<<<<< ours"
+	MARKER_BASE   = "||||||| base"
+	MARKER_THEIRS = ">>>>>>> theirs"
+)
+
+// Diff3 describes a difference found by a three-way comparison.
+// Ours and Theirs describe the differences of the base document
+// and ours or theirs for the path, they are nil if the node
+// is unchanged in the appropriate document.
+type Diff3 struct {
+	Ours   *Diff
+	Theirs *Diff
+	Change Change
+
+	Path []string
+}
+
+// Compare3 compares two documents (ours and theirs) derived from a common
+// base document. Changes of overlapping paths in both documents are reported
+// as conflict for the shorter path, if the resulting nodes are not identical.
+func Compare3(base, ours, theirs yaml.Node, opts ...Options) []Diff3 {
+	c := newThreeWayComparer(opts...)
+	odiffs := c.diff(base, ours, []string{})
+	tdiffs := c.diff(base, theirs, []string{})
+	SortDiffs(odiffs)
+	SortDiffs(tdiffs)
+
+	result := []Diff3{}
+	conflicts := [][]string{}
+	overlapping := map[int]bool{}
+	for i := range odiffs {
+		o := &odiffs[i]
+		found := false
+		for j := range tdiffs {
+			t := &tdiffs[j]
+			if !overlaps(o.Path, t.Path) {
+				continue
+			}
+			found = true
+			overlapping[j] = true
+			if len(o.Path) == len(t.Path) && c.equal(o.B, t.B, o.Path) {
+				result = append(result, Diff3{Ours: o, Theirs: t, Change: CHANGED_BOTH, Path: o.Path})
+				continue
+			}
+			if len(o.Path) <= len(t.Path) {
+				conflicts = append(conflicts, o.Path)
+			} else {
+				conflicts = append(conflicts, t.Path)
+			}
+		}
+		if !found {
+			result = append(result, Diff3{Ours: o, Change: CHANGED_OURS, Path: o.Path})
+		}
+	}
+	for j := range tdiffs {
+		if !overlapping[j] {
+			result = append(result, Diff3{Theirs: &tdiffs[j], Change: CHANGED_THEIRS, Path: tdiffs[j].Path})
+		}
+	}
+
+	done := map[string]bool{}
+	for _, p := range conflicts {
+		key := strings.Join(p, "\000")
+		if done[key] || covers(conflicts, p) {
+			continue
+		}
+		done[key] = true
+		b := c.lookup(base, p)
+		result = append(result, Diff3{
+			Ours:   &Diff{A: b, B: c.lookup(ours, p), Path: p},
+			Theirs: &Diff{A: b, B: c.lookup(theirs, p), Path: p},
+			Change: CONFLICT,
+			Path:   p,
+		})
+	}
+
+	// remove changes in both documents covered by a conflict
+	filtered := result[:0]
+	for _, d := range result {
+		if d.Change == CONFLICT || !covers(conflicts, d.Path) && !contained(conflicts, d.Path) {
+			filtered = append(filtered, d)
+		}
+	}
+	result = filtered
+
+	sortDiff3(result)
+	return result
+}
+
+// Merge3 merges the changes of theirs into ours according to the result of
+// Compare3. Conflicting nodes are replaced by maps with the conflict markers
+// as keys and the nodes of the three documents as values. The second result
+// reports the number of conflicts.
+func Merge3(ours yaml.Node, diffs []Diff3, opts ...Options) (yaml.Node, int) {
+	c := newThreeWayComparer(opts...)
+	conflicts := 0
+	removes := []Diff3{}
+	for _, d := range diffs {
+		switch d.Change {
+		case CHANGED_THEIRS:
+			if d.Theirs.A != nil && c.lookup(ours, d.Path) == nil {
+				// no real node, like the index of jobs
+				continue
+			}
+			if d.Theirs.B == nil {
+				removes = append(removes, d)
+				continue
+			}
+			if d.Theirs.A == nil {
+				ours = c.add(ours, d.Path, d.Theirs.B)
+				continue
+			}
+			ours = c.set(ours, d.Path, d.Theirs.B)
+		case CONFLICT:
+			conflicts++
+			ours = c.set(ours, d.Path, conflictNode{yaml.NewNode(map[string]yaml.Node{
+				MARKER_OURS:   nullNode(d.Ours.B),
+				MARKER_BASE:   nullNode(d.Ours.A),
+				MARKER_THEIRS: nullNode(d.Theirs.B),
+			}, "<conflict>")})
+		}
+	}
+	// remove list entries with higher indices first
+	for i := len(removes) - 1; i >= 0; i-- {
+		ours = c.set(ours, removes[i].Path, nil)
+	}
+	return ours, conflicts
+}
+
+// conflictNode is the map replacing a conflicting node. It is
+// marshalled with the conflict markers in the order ours, base and
+// theirs, like the markers of a textual merge.
+type conflictNode struct {
+	yaml.Node
+}
+
+func (n conflictNode) MarshalYAML() (string, interface{}, error) {
+	m := n.Value().(map[string]yaml.Node)
+	return "", candiedyaml.MapSlice{
+		{Key: MARKER_OURS, Value: m[MARKER_OURS]},
+		{Key: MARKER_BASE, Value: m[MARKER_BASE]},
+		{Key: MARKER_THEIRS, Value: m[MARKER_THEIRS]},
+	}, nil
+}
+
+func nullNode(n yaml.Node) yaml.Node {
+	if n == nil {
+		return yaml.NewNode(nil, "<conflict>")
+	}
+	return n
+}
+
+// newThreeWayComparer provides a comparer matching list entries by their
+// identity, only. Entries missing in one of the lists are added or
+// removed, they are never matched with another entry by their index.
+func newThreeWayComparer(opts ...Options) *comparer {
+	c := newComparer(opts...)
+	c.threeWay = true
+	return c
+}
+
+func sortDiff3(diffs []Diff3) {
+	sort.SliceStable(diffs, func(i, j int) bool {
+		return comparePaths(diffs[i].Path, diffs[j].Path) < 0
+	})
+}
+
+// overlaps checks whether one path is a prefix of the other one.
+func overlaps(a, b []string) bool {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	return isPrefix(a, b)
+}
+
+func isPrefix(prefix, path []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i, s := range prefix {
+		if path[i] != s {
+			return false
+		}
+	}
+	return true
+}
+
+// covers checks whether one of the paths is a real prefix of the given path.
+func covers(paths [][]string, path []string) bool {
+	for _, p := range paths {
+		if len(p) < len(path) && isPrefix(p, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// contained checks whether a path is contained in a list of paths.
+func contained(paths [][]string, path []string) bool {
+	for _, p := range paths {
+		if len(p) == len(path) && isPrefix(p, path) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *comparer) equal(a, b yaml.Node, path []string) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return len(c.diff(a, b, path)) == 0
+}
+
+// index provides the index of the list entry described by a path step.
+// Steps of the form [<n>] describe entries by their index, other steps
+// by their identity.
+func (c *comparer) index(list []yaml.Node, path []string) int {
+	step := path[len(path)-1]
+	if strings.HasPrefix(step, "[") && strings.HasSuffix(step, "]") {
+		return indexOf(list, step)
+	}
+	if key := c.listKey(path[:len(path)-1], list, list); key != nil {
+		return indexOfIdentity(list, key, step)
+	}
+	return indexOf(list, step)
+}
+
+// field provides the map key used for a path step, respecting
+// fields marked as key field for a list.
+func field(m map[string]yaml.Node, step string) string {
+	if _, ok := m[step]; !ok {
+		if _, ok := m["key:"+step]; ok {
+			return "key:" + step
+		}
+	}
+	return step
+}
+
+// lookup provides the node for a path of a difference.
+func (c *comparer) lookup(node yaml.Node, path []string) yaml.Node {
+	for i := range path {
+		if node == nil {
+			return nil
+		}
+		switch v := node.Value().(type) {
+		case map[string]yaml.Node:
+			node = v[field(v, path[i])]
+		case []yaml.Node:
+			n := c.index(v, path[:i+1])
+			if n < 0 {
+				return nil
+			}
+			node = v[n]
+		default:
+			return nil
+		}
+	}
+	return node
+}
+
+// set provides a copy of a document with the node for the given path
+// replaced by a new node. If the new node is nil, the node is removed.
+func (c *comparer) set(node yaml.Node, path []string, value yaml.Node) yaml.Node {
+	return c.setAt(node, path, 0, value, false)
+}
+
+// add provides a copy of a document with a new node added for the given
+// path. New list entries described by their index are appended to the list.
+func (c *comparer) add(node yaml.Node, path []string, value yaml.Node) yaml.Node {
+	return c.setAt(node, path, 0, value, true)
+}
+
+func (c *comparer) setAt(node yaml.Node, path []string, i int, value yaml.Node, add bool) yaml.Node {
+	if i == len(path) {
+		return value
+	}
+	if node == nil {
+		return node
+	}
+	last := i == len(path)-1
+	switch v := node.Value().(type) {
+	case map[string]yaml.Node:
+		n := make(map[string]yaml.Node, len(v))
+		for k, e := range v {
+			n[k] = e
+		}
+		k := field(v, path[i])
+		switch {
+		case last && value == nil:
+			delete(n, k)
+		case last:
+			n[k] = value
+		case n[k] != nil:
+			n[k] = c.setAt(n[k], path, i+1, value, add)
+		}
+		return yaml.SubstituteNode(n, node)
+	case []yaml.Node:
+		n := append([]yaml.Node{}, v...)
+		index := c.index(v, path[:i+1])
+		if last && add && isIndexStep(path[:i+1]) {
+			index = -1
+		}
+		switch {
+		case index < 0:
+			if last && value != nil {
+				n = append(n, value)
+			}
+		case last && value == nil:
+			n = append(n[:index], n[index+1:]...)
+		default:
+			n[index] = c.setAt(n[index], path, i+1, value, add)
+		}
+		return yaml.SubstituteNode(n, node)
+	}
+	return node
+}
@@ -0,0 +1,245 @@
+package compare
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/mandelsoft/spiff/yaml"
+)
+
+var _ = Describe("Three-way comparison", func() {
+	base := parseYAML(`
+---
+replicas: 1
+image: v1
+env:
+- name: A
+  value: a
+- name: B
+  value: b
+removed: x
+`)
+
+	ours := parseYAML(`
+---
+replicas: 2
+image: v2
+env:
+- name: A
+  value: a
+- name: B
+  value: c
+removed: x
+`)
+
+	theirs := parseYAML(`
+---
+replicas: 3
+image: v2
+env:
+- name: B
+  value: b
+- name: A
+  value: a
+- name: C
+  value: d
+extra: true
+`)
+
+	type change struct {
+		path   string
+		change Change
+	}
+
+	changes := func(diffs []Diff3) []change {
+		result := []change{}
+		for _, d := range diffs {
+			p := ""
+			for i, s := range d.Path {
+				if i > 0 {
+					p += "."
+				}
+				p += s
+			}
+			result = append(result, change{p, d.Change})
+		}
+		return result
+	}
+
+	It("classifies the changes", func() {
+		Expect(changes(Compare3(base, ours, theirs))).To(Equal([]change{
+			{"env.B.value", CHANGED_OURS},
+			{"env.C", CHANGED_THEIRS},
+			{"extra", CHANGED_THEIRS},
+			{"image", CHANGED_BOTH},
+			{"removed", CHANGED_THEIRS},
+			{"replicas", CONFLICT},
+		}))
+	})
+
+	It("reports conflicts for the shorter path", func() {
+		ours := parseYAML(`
+---
+env: none
+`)
+		theirs := parseYAML(`
+---
+env:
+- name: A
+  value: b
+`)
+		diffs := Compare3(parseYAML("env: [ { name: A, value: a } ]"), ours, theirs)
+		Expect(changes(diffs)).To(Equal([]change{
+			{"env", CONFLICT},
+		}))
+		Expect(diffs[0].Ours.B.EquivalentToNode(parseYAML("none"))).To(BeTrue())
+		Expect(diffs[0].Theirs.B.EquivalentToNode(parseYAML("[ { name: A, value: b } ]"))).To(BeTrue())
+	})
+
+	It("merges the changes of theirs", func() {
+		merged, conflicts := Merge3(ours, Compare3(base, ours, theirs))
+		Expect(conflicts).To(Equal(1))
+		Expect(merged.EquivalentToNode(parseYAML(`
+---
+replicas:
+  <<<<<<< ours: 2
+  "||||||| base": 1
+  ">>>>>>> theirs": 3
+image: v2
+env:
+- name: A
+  value: a
+- name: B
+  value: c
+- name: C
+  value: d
+extra: true
+`))).To(BeTrue())
+	})
+
+	Context("keyed list entries", func() {
+		base := parseYAML(`
+---
+list:
+- name: x
+  v: 1
+- name: z
+  v: 2
+`)
+
+		merge := func(ours, theirs yaml.Node) (yaml.Node, int) {
+			return Merge3(ours, Compare3(base, ours, theirs))
+		}
+
+		It("removes entries", func() {
+			theirs := parseYAML(`
+---
+list:
+- name: z
+  v: 2
+`)
+			Expect(changes(Compare3(base, base, theirs))).To(Equal([]change{
+				{"list.x", CHANGED_THEIRS},
+			}))
+			merged, conflicts := merge(base, theirs)
+			Expect(conflicts).To(Equal(0))
+			Expect(merged.EquivalentToNode(theirs)).To(BeTrue())
+		})
+
+		It("adds entries", func() {
+			ours := parseYAML(`
+---
+list:
+- name: x
+  v: 1
+- name: z
+  v: 2
+- name: a
+  v: 3
+`)
+			theirs := parseYAML(`
+---
+list:
+- name: z
+  v: 2
+- name: b
+  v: 4
+`)
+			merged, conflicts := merge(ours, theirs)
+			Expect(conflicts).To(Equal(0))
+			Expect(merged.EquivalentToNode(parseYAML(`
+---
+list:
+- name: z
+  v: 2
+- name: a
+  v: 3
+- name: b
+  v: 4
+`))).To(BeTrue())
+		})
+
+		It("merges reordered entries", func() {
+			ours := parseYAML(`
+---
+list:
+- name: z
+  v: 2
+- name: x
+  v: 1
+`)
+			theirs := parseYAML(`
+---
+list:
+- name: x
+  v: 3
+- name: z
+  v: 2
+`)
+			merged, conflicts := merge(ours, theirs)
+			Expect(conflicts).To(Equal(0))
+			Expect(merged.EquivalentToNode(parseYAML(`
+---
+list:
+- name: z
+  v: 2
+- name: x
+  v: 3
+`))).To(BeTrue())
+		})
+
+		It("reports conflicts for disagreeing changes", func() {
+			ours := parseYAML(`
+---
+list:
+- name: z
+  v: 2
+- name: w
+  v: 5
+`)
+			theirs := parseYAML(`
+---
+list:
+- name: x
+  v: 3
+- name: z
+  v: 2
+- name: w
+  v: 6
+`)
+			Expect(changes(Compare3(base, ours, theirs))).To(Equal([]change{
+				{"list.w", CONFLICT},
+				{"list.x", CONFLICT},
+			}))
+			_, conflicts := merge(ours, theirs)
+			Expect(conflicts).To(Equal(2))
+		})
+	})
+
+	It("marshals conflict markers in the order ours, base and theirs", func() {
+		merged, _ := Merge3(ours, Compare3(base, ours, theirs))
+		data, err := yaml.Marshal(merged)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(ContainSubstring("replicas:\n  <<<<<<< ours: 2\n  '||||||| base': 1\n  '>>>>>>> theirs': 3\n"))
+	})
+})
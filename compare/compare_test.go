@@ -27,7 +27,7 @@ foo: 2
 `)
 
 			It("reports one difference with the key as the path", func() {
-				Expect(Compare(a, b)).To(Equal([]Diff{
+				Expect(Compare(a, b)).To(EqualDiffs([]Diff{
 					{
 						A:    parseYAML("1"),
 						B:    parseYAML("2"),
@@ -51,7 +51,7 @@ foo:
 `)
 
 			It("reports one difference for the nested difference, not the wholistic one", func() {
-				Expect(Compare(a, b)).To(Equal([]Diff{
+				Expect(Compare(a, b)).To(EqualDiffs([]Diff{
 					{
 						A:    parseYAML("1"),
 						B:    parseYAML("2"),
@@ -74,7 +74,7 @@ foo: 2
 `)
 
 			It("reports one difference with the different nodes", func() {
-				Expect(Compare(a, b)).To(Equal([]Diff{
+				Expect(Compare(a, b)).To(EqualDiffs([]Diff{
 					{
 						A:    parseYAML("bar: 1"),
 						B:    parseYAML("2"),
@@ -95,7 +95,7 @@ foo: 1
 `)
 
 			It("reports one difference", func() {
-				Expect(Compare(a, b)).To(Equal([]Diff{
+				Expect(Compare(a, b)).To(EqualDiffs([]Diff{
 					{
 						A:    parseYAML("1"),
 						B:    nil,
@@ -116,7 +116,7 @@ foo: 1
 `)
 
 			It("reports one difference", func() {
-				Expect(Compare(a, b)).To(Equal([]Diff{
+				Expect(Compare(a, b)).To(EqualDiffs([]Diff{
 					Diff{
 						A:    nil,
 						B:    parseYAML("1"),
@@ -139,7 +139,7 @@ bar: 2
 			It("reports both differences", func() {
 				diff := Compare(a, b)
 
-				Expect(diff).To(ContainElement(
+				Expect(diff).To(ContainDiff(
 					Diff{
 						A:    parseYAML("1"),
 						B:    nil,
@@ -147,7 +147,7 @@ bar: 2
 					},
 				))
 
-				Expect(diff).To(ContainElement(
+				Expect(diff).To(ContainDiff(
 					Diff{
 						A:    nil,
 						B:    parseYAML("2"),
@@ -184,7 +184,7 @@ foo:
 `)
 
 				It("reports no differences", func() {
-					Expect(Compare(a, b)).To(Equal([]Diff{
+					Expect(Compare(a, b)).To(EqualDiffs([]Diff{
 						{
 							A:    parseYAML("1"),
 							B:    parseYAML("2"),
@@ -209,7 +209,7 @@ foo:
 `)
 
 			It("reports one difference with the index in the path", func() {
-				Expect(Compare(a, b)).To(Equal([]Diff{
+				Expect(Compare(a, b)).To(EqualDiffs([]Diff{
 					{
 						A:    parseYAML("1"),
 						B:    parseYAML("2"),
@@ -232,7 +232,7 @@ foo:
 `)
 
 			It("reports one difference with the index in the path", func() {
-				Expect(Compare(a, b)).To(Equal([]Diff{
+				Expect(Compare(a, b)).To(EqualDiffs([]Diff{
 					{
 						A:    parseYAML("[hello, world]"),
 						B:    parseYAML("42"),
@@ -266,7 +266,7 @@ jobs:
 
 				Expect(diff).To(HaveLen(2))
 
-				Expect(diff).To(ContainElement(
+				Expect(diff).To(ContainDiff(
 					Diff{
 						A:    parseYAML("0"),
 						B:    parseYAML("1"),
@@ -274,7 +274,7 @@ jobs:
 					},
 				))
 
-				Expect(diff).To(ContainElement(
+				Expect(diff).To(ContainDiff(
 					Diff{
 						A:    parseYAML("1"),
 						B:    parseYAML("0"),
@@ -304,7 +304,7 @@ jobs:
 			It("reports it as different", func() {
 				diff := Compare(a, b)
 
-				Expect(diff).To(Equal([]Diff{
+				Expect(diff).To(EqualDiffs([]Diff{
 					Diff{
 						A:    nil,
 						B:    parseYAML("name: b\nvalue: bar\nindex: 1\n"),
@@ -357,7 +357,7 @@ foo:
 `)
 
 			It("reports each difference", func() {
-				Expect(Compare(a, b)).To(Equal([]Diff{
+				Expect(Compare(a, b)).To(EqualDiffs([]Diff{
 					Diff{
 						A:    parseYAML("2"),
 						B:    nil,
@@ -390,7 +390,7 @@ foo:
 `)
 
 			It("reports one difference", func() {
-				Expect(Compare(a, b)).To(Equal([]Diff{
+				Expect(Compare(a, b)).To(EqualDiffs([]Diff{
 					Diff{
 						A:    nil,
 						B:    parseYAML("2"),
@@ -400,4 +400,216 @@ foo:
 			})
 		})
 	})
+
+	Describe("list identities", func() {
+		Context("when there are kubernetes objects differing in order", func() {
+			a := parseYAML(`
+---
+- kind: Deployment
+  metadata:
+    name: web
+  replicas: 1
+- kind: Service
+  metadata:
+    name: web
+`)
+
+			b := parseYAML(`
+---
+- kind: Service
+  metadata:
+    name: web
+- kind: Deployment
+  metadata:
+    name: web
+  replicas: 2
+`)
+
+			It("matches them by kind and name", func() {
+				Expect(Compare(a, b)).To(EqualDiffs([]Diff{
+					{
+						A:    parseYAML("1"),
+						B:    parseYAML("2"),
+						Path: []string{"Deployment/web", "replicas"},
+					},
+				}))
+			})
+		})
+
+		Context("when the key field is tagged", func() {
+			a := parseYAML(`
+---
+- key:id: a
+  value: 1
+- id: b
+  value: 2
+`)
+
+			b := parseYAML(`
+---
+- key:id: b
+  value: 2
+- id: a
+  value: 3
+`)
+
+			It("matches the entries by the tagged field", func() {
+				Expect(Compare(a, b)).To(EqualDiffs([]Diff{
+					{
+						A:    parseYAML("1"),
+						B:    parseYAML("3"),
+						Path: []string{"a", "value"},
+					},
+				}))
+			})
+		})
+
+		Context("when a key rule is given", func() {
+			a := parseYAML(`
+---
+list:
+- ref: x
+  value: 1
+- ref: z
+  value: 2
+`)
+
+			b := parseYAML(`
+---
+list:
+- ref: z
+  value: 2
+- ref: x
+  value: 3
+`)
+
+			It("matches the entries by the rule", func() {
+				rule, err := ParseKeyRule("*=ref")
+				Expect(err).To(BeNil())
+				Expect(Compare(a, b, Options{Keys: []KeyRule{rule}})).To(EqualDiffs([]Diff{
+					{
+						A:    parseYAML("1"),
+						B:    parseYAML("3"),
+						Path: []string{"list", "x", "value"},
+					},
+				}))
+			})
+
+			It("reports entries only found in one list", func() {
+				b := parseYAML(`
+---
+list:
+- ref: x
+  value: 1
+- ref: w
+  value: 2
+`)
+				rule, err := ParseKeyRule("list=ref")
+				Expect(err).To(BeNil())
+				Expect(Compare(a, b, Options{Keys: []KeyRule{rule}})).To(ConsistOfDiffs(
+					Diff{
+						A:    parseYAML("ref: z\nvalue: 2"),
+						B:    nil,
+						Path: []string{"list", "z"},
+					},
+					Diff{
+						A:    nil,
+						B:    parseYAML("ref: w\nvalue: 2"),
+						Path: []string{"list", "w"},
+					},
+				))
+			})
+
+			It("rejects invalid rules", func() {
+				_, err := ParseKeyRule("list")
+				Expect(err).NotTo(BeNil())
+				_, err = ParseKeyRule("list=")
+				Expect(err).NotTo(BeNil())
+			})
+		})
+	})
+
+	Describe("options", func() {
+		a := parseYAML(`
+---
+jobs:
+- name: web
+  properties:
+    password: abc
+    port: 1
+    ratio: 1.5
+    enabled: true
+    opt: ~
+tags: [ a, b, c ]
+`)
+		b := parseYAML(`
+---
+jobs:
+- name: web
+  properties:
+    password: xyz
+    port: "1"
+    ratio: 1.5
+    enabled: "true"
+tags: [ c, a, b ]
+`)
+
+		It("ignores matching paths", func() {
+			diffs := Compare(a, b, Options{Ignore: []string{"jobs.*.properties.password", "tags", "**.opt"}})
+			Expect(diffs).To(ConsistOfDiffs(
+				Diff{A: parseYAML("1"), B: parseYAML(`"1"`), Path: []string{"jobs", "web", "properties", "port"}},
+				Diff{A: parseYAML("true"), B: parseYAML(`"true"`), Path: []string{"jobs", "web", "properties", "enabled"}},
+			))
+		})
+
+		It("compares numbers by value", func() {
+			Expect(Compare(parseYAML("1"), parseYAML("1.0"))).To(HaveLen(1))
+			Expect(Compare(parseYAML("1"), parseYAML("1.0"), Options{NumericEquivalence: true})).To(BeEmpty())
+			Expect(Compare(parseYAML("1"), parseYAML(`"1"`), Options{NumericEquivalence: true})).To(HaveLen(1))
+		})
+
+		It("compares scalars by their string representation", func() {
+			Expect(Compare(parseYAML("1"), parseYAML(`"1"`), Options{StringEquivalence: true})).To(BeEmpty())
+			Expect(Compare(parseYAML("1.0"), parseYAML(`"1"`), Options{StringEquivalence: true})).To(BeEmpty())
+			Expect(Compare(parseYAML("true"), parseYAML(`"true"`), Options{StringEquivalence: true})).To(BeEmpty())
+			Expect(Compare(parseYAML("~"), parseYAML(`""`), Options{StringEquivalence: true})).To(HaveLen(1))
+		})
+
+		It("handles null values like missing fields", func() {
+			Expect(Compare(parseYAML("a: ~\nb: 1"), parseYAML("b: 1\nc: ~"), Options{NullAsAbsent: true})).To(BeEmpty())
+			Expect(Compare(parseYAML("a: 1"), parseYAML("b: ~"), Options{NullAsAbsent: true})).To(EqualDiffs([]Diff{
+				{A: parseYAML("1"), B: nil, Path: []string{"a"}},
+			}))
+		})
+
+		It("compares unordered lists", func() {
+			opts := Options{Ignore: []string{"jobs"}, UnorderedLists: true}
+			Expect(Compare(a, b, opts)).To(BeEmpty())
+			Expect(Compare(parseYAML("[ a, b, b ]"), parseYAML("[ b, c, a ]"), opts)).To(EqualDiffs([]Diff{
+				{A: parseYAML("b"), B: nil, Path: []string{"[2]"}},
+				{A: nil, B: parseYAML("c"), Path: []string{"[1]"}},
+			}))
+		})
+
+		It("combines the options", func() {
+			opts := Options{
+				Ignore:            []string{"jobs.*.properties.password"},
+				StringEquivalence: true,
+				NullAsAbsent:      true,
+				UnorderedLists:    true,
+			}
+			Expect(Compare(a, b, opts)).To(BeEmpty())
+		})
+
+		It("adds entries of unordered lists to the end", func() {
+			a := parseYAML("list: [ a, b ]")
+			b := parseYAML("list: [ c, b, a ]")
+			opts := Options{UnorderedLists: true}
+			ops, err := JSONPatch(a, b, Compare(a, b, opts), opts)
+			Expect(err).To(BeNil())
+			Expect(ops).To(Equal([]Operation{
+				{Op: OP_ADD, Path: "/list/-", Value: "c"},
+			}))
+		})
+	})
 })
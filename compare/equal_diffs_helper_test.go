@@ -0,0 +1,57 @@
+package compare
+
+import (
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/types"
+
+	"github.com/mandelsoft/spiff/yaml"
+)
+
+// EqualDiffs matches a list of differences like Equal, but ignores the
+// source positions and key orders recorded by the parser for the nodes.
+func EqualDiffs(expected []Diff) types.GomegaMatcher {
+	return WithTransform(withoutSourceInfo, Equal(withoutSourceInfo(expected)))
+}
+
+// ContainDiff matches a list of differences like ContainElement, but
+// ignores the source positions and key orders recorded by the parser
+// for the nodes.
+func ContainDiff(expected Diff) types.GomegaMatcher {
+	return WithTransform(withoutSourceInfo, ContainElement(withoutSourceInfo([]Diff{expected})[0]))
+}
+
+// ConsistOfDiffs matches a list of differences like ConsistOf, but
+// ignores the source positions and key orders recorded by the parser
+// for the nodes.
+func ConsistOfDiffs(expected ...Diff) types.GomegaMatcher {
+	return WithTransform(withoutSourceInfo, ConsistOf(withoutSourceInfo(expected)))
+}
+
+func withoutSourceInfo(diffs []Diff) []Diff {
+	result := make([]Diff, len(diffs))
+	for i, d := range diffs {
+		result[i] = Diff{A: plainNode(d.A), B: plainNode(d.B), Path: d.Path}
+	}
+	return result
+}
+
+func plainNode(node yaml.Node) yaml.Node {
+	if node == nil {
+		return nil
+	}
+	switch v := node.Value().(type) {
+	case map[string]yaml.Node:
+		m := make(map[string]yaml.Node, len(v))
+		for k, e := range v {
+			m[k] = plainNode(e)
+		}
+		node = yaml.SubstituteNode(m, node)
+	case []yaml.Node:
+		l := make([]yaml.Node, len(v))
+		for i, e := range v {
+			l[i] = plainNode(e)
+		}
+		node = yaml.SubstituteNode(l, node)
+	}
+	return yaml.OrderedNode(yaml.PositionNode(node, yaml.Position{}), nil)
+}
@@ -0,0 +1,344 @@
+package compare
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mandelsoft/spiff/yaml"
+)
+
+// KeyRule defines the fields identifying the entries of the lists
+// found at paths matching a path pattern. A step * of the pattern
+// matches any path step.
+type KeyRule struct {
+	Path   []string
+	Fields []string // dot separated field paths
+}
+
+// ParseKeyRule parses a key rule of the form <path>=<field>{,<field>},
+// for example items=kind,metadata.name.
+func ParseKeyRule(s string) (KeyRule, error) {
+	i := strings.Index(s, "=")
+	if i < 0 {
+		return KeyRule{}, fmt.Errorf("invalid key rule %q: expected <path>=<field>", s)
+	}
+	rule := KeyRule{Path: []string{}}
+	if p := s[:i]; p != "" {
+		rule.Path = strings.Split(p, ".")
+	}
+	for _, f := range strings.Split(s[i+1:], ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			return KeyRule{}, fmt.Errorf("invalid key rule %q: empty field", s)
+		}
+		rule.Fields = append(rule.Fields, f)
+	}
+	return rule, nil
+}
+
+func (r KeyRule) matches(path []string) bool {
+	if len(r.Path) != len(path) {
+		return false
+	}
+	for i, s := range r.Path {
+		if s != "*" && s != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Options controls the comparison of documents.
+//
+// Entries of lists are matched by the fields given by the first key rule
+// matching the path of the list. Without a matching rule the entries are
+// matched by the field marked with a key tag (key:<field>) in the first
+// list entry, by their name field, by kind and metadata.name, by a key or
+// by an id field, whatever is provided by all entries of both lists.
+// Otherwise the entries are compared by their index.
+type Options struct {
+	Keys []KeyRule
+
+	// Ignore lists path patterns for nodes excluded from the comparison.
+	// A step * matches any path step, a step ** any sequence of steps.
+	Ignore []string
+	// NumericEquivalence compares numbers by their value (1 equals 1.0).
+	NumericEquivalence bool
+	// StringEquivalence compares scalar values by their string
+	// representation ("1" equals 1 and "true" equals true).
+	StringEquivalence bool
+	// NullAsAbsent handles map fields with null values like missing fields.
+	NullAsAbsent bool
+	// UnorderedLists compares lists without identities of their entries
+	// as unordered sets.
+	UnorderedLists bool
+}
+
+// heuristicKeys are the candidate identities of list entries
+// used if there is no explicit rule or key tag.
+var heuristicKeys = [][]string{
+	{"kind", "metadata.name"},
+	{"key"},
+	{"id"},
+}
+
+type comparer struct {
+	opts Options
+	// threeWay matches the entries of lists with name fields by their
+	// name only. A three-way merge must not mix up entries removed or
+	// added by one document with other entries at the same index.
+	threeWay bool
+}
+
+func newComparer(opts ...Options) *comparer {
+	c := &comparer{}
+	for _, o := range opts {
+		c.opts.Keys = append(c.opts.Keys, o.Keys...)
+		c.opts.Ignore = append(c.opts.Ignore, o.Ignore...)
+		c.opts.NumericEquivalence = c.opts.NumericEquivalence || o.NumericEquivalence
+		c.opts.StringEquivalence = c.opts.StringEquivalence || o.StringEquivalence
+		c.opts.NullAsAbsent = c.opts.NullAsAbsent || o.NullAsAbsent
+		c.opts.UnorderedLists = c.opts.UnorderedLists || o.UnorderedLists
+	}
+	return c
+}
+
+// diff compares two nodes and omits the differences for ignored paths.
+func (c *comparer) diff(a, b yaml.Node, path []string) []Diff {
+	diffs := c.compare(a, b, path)
+	if len(c.opts.Ignore) == 0 {
+		return diffs
+	}
+	result := diffs[:0]
+	for _, d := range diffs {
+		if !c.ignored(d.Path) {
+			result = append(result, d)
+		}
+	}
+	return result
+}
+
+// ignored checks whether a path or one of its prefixes matches
+// an ignore pattern.
+func (c *comparer) ignored(path []string) bool {
+	for _, p := range c.opts.Ignore {
+		steps := []string{}
+		if p != "" {
+			steps = strings.Split(p, ".")
+		}
+		for i := len(path); i >= 0; i-- {
+			if matchPath(steps, path[:i]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchPath(pattern, path []string) bool {
+	if len(pattern) == 0 {
+		return len(path) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(path); i++ {
+			if matchPath(pattern[1:], path[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(path) == 0 || (pattern[0] != "*" && pattern[0] != path[0]) {
+		return false
+	}
+	return matchPath(pattern[1:], path[1:])
+}
+
+// scalarEqual compares scalar values according to the
+// equivalence options.
+func (c *comparer) scalarEqual(a, b interface{}) bool {
+	if a == b {
+		return true
+	}
+	if c.opts.NumericEquivalence || c.opts.StringEquivalence {
+		af, aok := number(a)
+		bf, bok := number(b)
+		if aok && bok {
+			return af == bf
+		}
+	}
+	if c.opts.StringEquivalence && a != nil && b != nil {
+		switch b.(type) {
+		case map[string]yaml.Node, []yaml.Node:
+			return false
+		}
+		return scalarString(a) == scalarString(b)
+	}
+	return false
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func scalarString(v interface{}) string {
+	if f, ok := number(v); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// isNull checks whether a node is missing or null.
+func isNull(n yaml.Node) bool {
+	return n == nil || n.Value() == nil
+}
+
+// compareUnordered compares lists as unordered sets. Entries are matched
+// with an equal entry of the other list, unmatched entries are reported
+// with their index.
+func (c *comparer) compareUnordered(a, b []yaml.Node, path []string) []Diff {
+	diff := []Diff{}
+	used := make([]bool, len(b))
+	for i, aval := range a {
+		found := false
+		for j, bval := range b {
+			if !used[j] && c.equal(aval, bval, addPath(path, fmt.Sprintf("[%d]", i))) {
+				used[j] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			diff = append(diff, Diff{A: aval, B: nil, Path: addPath(path, fmt.Sprintf("[%d]", i))})
+		}
+	}
+	for j, bval := range b {
+		if !used[j] {
+			diff = append(diff, Diff{A: nil, B: bval, Path: addPath(path, fmt.Sprintf("[%d]", j))})
+		}
+	}
+	return diff
+}
+
+// listKey determines the fields identifying the entries of the lists found
+// at the given path. The result is nil for lists handled by the standard
+// name or index based matching. For three-way comparisons lists with
+// unique names are always matched by name.
+func (c *comparer) listKey(path []string, a, b []yaml.Node) []string {
+	for _, r := range c.opts.Keys {
+		if r.matches(path) {
+			if keyed(r.Fields, a, b) {
+				return r.Fields
+			}
+			break
+		}
+	}
+	if len(path) == 1 && path[0] == "jobs" {
+		return nil
+	}
+	for _, list := range [][]yaml.Node{a, b} {
+		if len(list) > 0 {
+			if _, key := yaml.ProcessKeyTag(list[0]); key != "" {
+				if keyed([]string{key}, a, b) {
+					return []string{key}
+				}
+				return nil
+			}
+		}
+	}
+	if keyed([]string{"name"}, a) {
+		if c.threeWay && keyed([]string{"name"}, b) {
+			return []string{"name"}
+		}
+		return nil
+	}
+	for _, fields := range heuristicKeys {
+		if keyed(fields, a, b) {
+			return fields
+		}
+	}
+	return nil
+}
+
+// keyed checks whether all entries of the given lists provide
+// unique values for the key fields.
+func keyed(fields []string, lists ...[]yaml.Node) bool {
+	for _, list := range lists {
+		found := map[string]bool{}
+		for _, e := range list {
+			id, ok := identity(e, fields)
+			if !ok || found[id] {
+				return false
+			}
+			found[id] = true
+		}
+	}
+	return true
+}
+
+// identity provides the path step used for a list entry
+// identified by the given key fields.
+func identity(node yaml.Node, fields []string) (string, bool) {
+	node, _ = yaml.ProcessKeyTag(node)
+	if _, ok := node.Value().(map[string]yaml.Node); !ok {
+		return "", false
+	}
+	values := []string{}
+	for _, f := range fields {
+		v, ok := yaml.FindR(true, node, nil, strings.Split(f, ".")...)
+		if !ok || v == nil {
+			return "", false
+		}
+		switch v.Value().(type) {
+		case map[string]yaml.Node, []yaml.Node, nil:
+			return "", false
+		}
+		values = append(values, fmt.Sprintf("%v", v.Value()))
+	}
+	return strings.Join(values, "/"), true
+}
+
+// indexOfIdentity provides the index of the list entry with
+// the given identity.
+func indexOfIdentity(list []yaml.Node, fields []string, id string) int {
+	for i, e := range list {
+		if eid, ok := identity(e, fields); ok && eid == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// compareKeyed compares lists whose entries are matched by their
+// identity. The path step of an entry is its identity.
+func (c *comparer) compareKeyed(a, b []yaml.Node, path []string, fields []string) []Diff {
+	diff := []Diff{}
+
+	for _, aval := range a {
+		id, _ := identity(aval, fields)
+		i := indexOfIdentity(b, fields, id)
+		if i < 0 {
+			diff = append(diff, Diff{A: aval, B: nil, Path: addPath(path, id)})
+			continue
+		}
+		an, _ := yaml.ProcessKeyTag(aval)
+		bn, _ := yaml.ProcessKeyTag(b[i])
+		diff = append(diff, c.compare(an, bn, addPath(path, id))...)
+	}
+
+	for _, bval := range b {
+		id, _ := identity(bval, fields)
+		if indexOfIdentity(a, fields, id) < 0 {
+			diff = append(diff, Diff{A: nil, B: bval, Path: addPath(path, id)})
+		}
+	}
+	return diff
+}
@@ -0,0 +1,411 @@
+package compare
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mandelsoft/spiff/yaml"
+)
+
+const (
+	OP_ADD     = "add"
+	OP_REMOVE  = "remove"
+	OP_REPLACE = "replace"
+)
+
+// Operation is a JSON patch operation (RFC 6902).
+type Operation struct {
+	Op    string
+	Path  string
+	Value interface{}
+}
+
+func (o Operation) MarshalJSON() ([]byte, error) {
+	if o.Op == OP_REMOVE {
+		return json.Marshal(struct {
+			Op   string `json:"op"`
+			Path string `json:"path"`
+		}{o.Op, o.Path})
+	}
+	return json.Marshal(struct {
+		Op    string      `json:"op"`
+		Path  string      `json:"path"`
+		Value interface{} `json:"value"`
+	}{o.Op, o.Path, o.Value})
+}
+
+// JSONPointer provides the JSON pointer (RFC 6901) for a sequence
+// of path steps.
+func JSONPointer(steps []string) string {
+	r := strings.NewReplacer("~", "~0", "/", "~1")
+	s := ""
+	for _, step := range steps {
+		s += "/" + r.Replace(step)
+	}
+	return s
+}
+
+// location describes the position of a difference in the
+// compared documents.
+type location struct {
+	steps []string  // steps in document a
+	a     yaml.Node // node of document a, nil if not present
+	b     yaml.Node // node of document b, nil if not present
+	whole bool      // the path below this location cannot be mapped
+}
+
+// locate maps the path of a difference to the real structure of the compared
+// documents. Lists of maps with name fields are compared by the names of the
+// entries, maps and such lists are compared like maps. If the path cannot
+// be mapped to both documents, the location of the first node not
+// described by the path is returned.
+func (c *comparer) locate(a, b yaml.Node, path []string) location {
+	loc := location{steps: []string{}, a: a, b: b}
+	for i, step := range path {
+		if loc.a == nil || loc.b == nil {
+			loc.whole = true
+			return loc
+		}
+		switch av := loc.a.Value().(type) {
+		case map[string]yaml.Node:
+			bv, ok := loc.b.Value().(map[string]yaml.Node)
+			if !ok {
+				loc.whole = true
+				return loc
+			}
+			loc.a = av[step]
+			loc.b = bv[step]
+			if bv[step] == nil && bv["key:"+step] != nil {
+				loc.b = bv["key:"+step]
+			}
+			if _, ok := av[step]; !ok && av["key:"+step] != nil {
+				// field marked as key field for a list
+				loc.a = av["key:"+step]
+				step = "key:" + step
+			}
+			loc.steps = append(loc.steps, step)
+		case []yaml.Node:
+			bv, ok := loc.b.Value().([]yaml.Node)
+			if !ok {
+				loc.whole = true
+				return loc
+			}
+			if i == 1 && path[0] == "jobs" && i+2 == len(path) && path[i+1] == "index" {
+				// jobs are compared including their index
+				loc.whole = true
+				return loc
+			}
+			var ai, bi int
+			if key := c.listKey(path[:i], av, bv); key != nil {
+				ai = indexOfIdentity(av, key, step)
+				bi = indexOfIdentity(bv, key, step)
+			} else {
+				ai = indexOf(av, step)
+				bi = indexOf(bv, step)
+			}
+			if ai < 0 {
+				loc.steps = append(loc.steps, "-")
+				loc.a = nil
+			} else {
+				loc.steps = append(loc.steps, strconv.Itoa(ai))
+				loc.a = av[ai]
+			}
+			if bi < 0 {
+				loc.b = nil
+			} else {
+				loc.b = bv[bi]
+			}
+		default:
+			loc.whole = true
+			return loc
+		}
+	}
+	return loc
+}
+
+// indexOf provides the index of a list entry described by a path
+// step, which is either an index or the name of the entry.
+func indexOf(list []yaml.Node, step string) int {
+	if strings.HasPrefix(step, "[") && strings.HasSuffix(step, "]") {
+		i, err := strconv.Atoi(step[1 : len(step)-1])
+		if err == nil {
+			if i < len(list) {
+				return i
+			}
+			return -1
+		}
+	}
+	for i, e := range list {
+		if name, ok := yaml.FindString(e, nil, "name"); ok && name == step {
+			return i
+		}
+	}
+	return -1
+}
+
+// SortDiffs sorts differences by their paths. Indices of list
+// entries are compared numerically.
+func SortDiffs(diffs []Diff) {
+	sort.SliceStable(diffs, func(i, j int) bool {
+		return comparePaths(diffs[i].Path, diffs[j].Path) < 0
+	})
+}
+
+func comparePaths(a, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := compareSteps(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return len(a) - len(b)
+}
+
+func compareSteps(a, b string) int {
+	ai, aerr := strconv.Atoi(strings.Trim(a, "[]"))
+	bi, berr := strconv.Atoi(strings.Trim(b, "[]"))
+	if aerr == nil && berr == nil {
+		return ai - bi
+	}
+	return strings.Compare(a, b)
+}
+
+// JSONPatch provides the JSON patch operations (RFC 6902) transforming
+// document a into document b according to the differences found by Compare.
+// The options must be the ones used for the comparison.
+// Compare ignores the order of list entries matched by their identity, but
+// the patch must reproduce it: a list whose entries are reordered is
+// replaced as a whole. The same applies to lists matched by the names of
+// their entries if entries are added or removed anywhere else than at
+// their end.
+func JSONPatch(a, b yaml.Node, diffs []Diff, opts ...Options) ([]Operation, error) {
+	c := newComparer(opts...)
+	diffs = append(diffs[:0:0], diffs...)
+	reordered := map[string]bool{}
+	for _, p := range c.reorderedLists(a, b, []string{}) {
+		reordered[strings.Join(p, "\000")] = true
+		diffs = append(diffs, Diff{A: c.lookup(a, p), B: c.lookup(b, p), Path: p})
+	}
+	SortDiffs(diffs)
+
+	locs := make([]location, len(diffs))
+	whole := []string{}
+	for i, d := range diffs {
+		locs[i] = c.locate(a, b, d.Path)
+		if reordered[strings.Join(d.Path, "\000")] {
+			locs[i].whole = true
+		}
+		if locs[i].whole {
+			whole = append(whole, JSONPointer(locs[i].steps))
+		}
+	}
+
+	ops := []Operation{}
+	removes := []Operation{}
+	done := map[string]bool{}
+	for i, loc := range locs {
+		ptr := JSONPointer(loc.steps)
+		if covered(ptr, whole) {
+			continue
+		}
+		if loc.whole {
+			if done[ptr] {
+				continue
+			}
+			done[ptr] = true
+		} else {
+			// entries of unordered lists are matched independently of
+			// their index, so the difference determines the operation
+			d := diffs[i]
+			if d.A == nil && d.B != nil && loc.a != nil && isIndexStep(d.Path) {
+				loc.steps[len(loc.steps)-1] = "-"
+				ptr = JSONPointer(loc.steps)
+				loc.a = nil
+			}
+			if d.B == nil && d.A != nil {
+				loc.b = nil
+			} else if d.B != nil {
+				loc.b = d.B
+			}
+		}
+		switch {
+		case loc.b == nil:
+			removes = append(removes, Operation{Op: OP_REMOVE, Path: ptr})
+			continue
+		case loc.a == nil:
+			ops = append(ops, Operation{Op: OP_ADD, Path: ptr})
+		default:
+			ops = append(ops, Operation{Op: OP_REPLACE, Path: ptr})
+		}
+		v, err := yaml.Normalize(loc.b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", ptr, err)
+		}
+		ops[len(ops)-1].Value = v
+	}
+
+	// remove list entries with higher indices first
+	sort.SliceStable(removes, func(i, j int) bool {
+		return comparePaths(strings.Split(removes[i].Path, "/"), strings.Split(removes[j].Path, "/")) > 0
+	})
+	return append(ops, removes...), nil
+}
+
+// reorderedLists provides the paths of the lists whose entries are matched
+// by their identity, but whose order in document b differs from the order
+// resulting from patching the entries of document a: entries kept in
+// their original order followed by the added entries. Lists matched by
+// the names of their entries are included if entries are added or removed
+// anywhere else than at their end.
+func (c *comparer) reorderedLists(a, b yaml.Node, path []string) [][]string {
+	if a == nil || b == nil || c.ignored(path) {
+		return nil
+	}
+	result := [][]string{}
+	switch av := a.Value().(type) {
+	case map[string]yaml.Node:
+		bv, ok := b.Value().(map[string]yaml.Node)
+		if !ok {
+			return nil
+		}
+		for k, e := range av {
+			result = append(result, c.reorderedLists(e, bv[k], addPath(path, k))...)
+		}
+	case []yaml.Node:
+		bv, ok := b.Value().([]yaml.Node)
+		if !ok {
+			return nil
+		}
+		fields := c.listKey(path, av, bv)
+		switch {
+		case fields != nil:
+		case len(path) == 1 && path[0] == "jobs":
+			// jobs are compared including their index
+			return nil
+		case keyed([]string{"name"}, av):
+			// entries are matched by their names or else by their indices,
+			// which only describes removed or added trailing entries
+			fields = []string{"name"}
+			if !keyed(fields, bv) || !trailing(av, bv, fields) || !trailing(bv, av, fields) {
+				return [][]string{path}
+			}
+		case c.opts.UnorderedLists:
+			return nil
+		default:
+			for i := 0; i < len(av) && i < len(bv); i++ {
+				result = append(result, c.reorderedLists(av[i], bv[i], addPath(path, fmt.Sprintf("[%d]", i)))...)
+			}
+			return result
+		}
+		expected := []string{}
+		for _, e := range av {
+			id, _ := identity(e, fields)
+			if i := indexOfIdentity(bv, fields, id); i >= 0 {
+				expected = append(expected, id)
+				result = append(result, c.reorderedLists(e, bv[i], addPath(path, id))...)
+			}
+		}
+		for _, e := range bv {
+			id, _ := identity(e, fields)
+			if indexOfIdentity(av, fields, id) < 0 {
+				expected = append(expected, id)
+			}
+		}
+		for i, e := range bv {
+			if id, _ := identity(e, fields); id != expected[i] {
+				return [][]string{path}
+			}
+		}
+	}
+	return result
+}
+
+// trailing checks whether all entries of list a not found in list b
+// are located behind the end of list b.
+func trailing(a, b []yaml.Node, fields []string) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if id, _ := identity(a[i], fields); indexOfIdentity(b, fields, id) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func isIndexStep(path []string) bool {
+	if len(path) == 0 {
+		return false
+	}
+	step := path[len(path)-1]
+	return strings.HasPrefix(step, "[") && strings.HasSuffix(step, "]")
+}
+
+// covered checks whether a JSON pointer describes a node below
+// one of the given nodes.
+func covered(ptr string, nodes []string) bool {
+	for _, n := range nodes {
+		if strings.HasPrefix(ptr, n+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// MergePatch provides the JSON merge patch (RFC 7386) transforming document a
+// into document b according to the differences found by Compare. Lists
+// cannot be patched partially, they are always replaced as a whole.
+// Null values of document b cannot be described by a merge patch, they
+// are handled like missing fields.
+func MergePatch(a, b yaml.Node, diffs []Diff) (interface{}, error) {
+	var patch interface{} = map[string]interface{}{}
+
+	for _, d := range diffs {
+		an, bn := a, b
+		keys := []string{}
+		for _, step := range d.Path {
+			am, aok := valueOf(an).(map[string]yaml.Node)
+			bm, bok := valueOf(bn).(map[string]yaml.Node)
+			if !aok || !bok {
+				break
+			}
+			keys = append(keys, step)
+			an, bn = am[step], bm[step]
+		}
+
+		var value interface{}
+		if bn != nil {
+			v, err := yaml.Normalize(bn)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", strings.Join(keys, "."), err)
+			}
+			value = v
+		}
+		if len(keys) == 0 {
+			return value, nil
+		}
+		m, ok := patch.(map[string]interface{})
+		for _, k := range keys[:len(keys)-1] {
+			if !ok {
+				break
+			}
+			n, found := m[k]
+			if !found {
+				n = map[string]interface{}{}
+				m[k] = n
+			}
+			m, ok = n.(map[string]interface{})
+		}
+		if ok {
+			m[keys[len(keys)-1]] = value
+		}
+	}
+	return patch, nil
+}
+
+func valueOf(node yaml.Node) interface{} {
+	if node == nil {
+		return nil
+	}
+	return node.Value()
+}
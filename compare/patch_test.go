@@ -0,0 +1,293 @@
+package compare
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/mandelsoft/spiff/yaml"
+)
+
+// applyPatch applies the JSON patch operations used by JSONPatch to a
+// normalized document.
+func applyPatch(doc interface{}, ops []Operation) interface{} {
+	for _, op := range ops {
+		doc = applyOperation(doc, strings.Split(op.Path, "/")[1:], op)
+	}
+	return doc
+}
+
+func applyOperation(doc interface{}, steps []string, op Operation) interface{} {
+	if len(steps) == 0 {
+		return op.Value
+	}
+	step := strings.NewReplacer("~1", "/", "~0", "~").Replace(steps[0])
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		if len(steps) > 1 {
+			v[step] = applyOperation(v[step], steps[1:], op)
+		} else if op.Op == OP_REMOVE {
+			delete(v, step)
+		} else {
+			v[step] = op.Value
+		}
+		return v
+	case []interface{}:
+		if step == "-" {
+			return append(v, op.Value)
+		}
+		i, err := strconv.Atoi(step)
+		Expect(err).To(BeNil())
+		switch {
+		case len(steps) > 1:
+			v[i] = applyOperation(v[i], steps[1:], op)
+		case op.Op == OP_REMOVE:
+			v = append(v[:i], v[i+1:]...)
+		case op.Op == OP_ADD:
+			v = append(v[:i], append([]interface{}{op.Value}, v[i:]...)...)
+		default:
+			v[i] = op.Value
+		}
+		return v
+	}
+	Fail("invalid patch path " + op.Path)
+	return nil
+}
+
+func normalize(node yaml.Node) interface{} {
+	v, err := yaml.Normalize(node)
+	Expect(err).To(BeNil())
+	return v
+}
+
+func asYAML(node yaml.Node) string {
+	data, err := yaml.Marshal(node)
+	Expect(err).To(BeNil())
+	return string(data)
+}
+
+var _ = Describe("Patches", func() {
+	a := parseYAML(`
+---
+name: a
+nested:
+  x: 1
+  y/z: 2
+list:
+- name: alice
+  age: 25
+- name: bob
+  age: 30
+plain: [ 1, 2, 3 ]
+gone: true
+`)
+
+	b := parseYAML(`
+---
+name: b
+nested:
+  x: 1
+  y/z: 3
+list:
+- name: alice
+  age: 26
+plain: [ 1, 2, 4, 5 ]
+new:
+  a: 1
+`)
+
+	asJSON := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		Expect(err).To(BeNil())
+		return string(data)
+	}
+
+	Describe("json patch", func() {
+		It("derives the operations from the differences", func() {
+			ops, err := JSONPatch(a, b, Compare(a, b))
+			Expect(err).To(BeNil())
+			Expect(asJSON(ops)).To(MatchJSON(`[
+  {"op":"replace","path":"/list/0/age","value":26},
+  {"op":"replace","path":"/name","value":"b"},
+  {"op":"replace","path":"/nested/y~1z","value":3},
+  {"op":"add","path":"/new","value":{"a":1}},
+  {"op":"replace","path":"/plain/2","value":4},
+  {"op":"add","path":"/plain/-","value":5},
+  {"op":"remove","path":"/list/1"},
+  {"op":"remove","path":"/gone"}
+]`))
+		})
+
+		It("replaces nodes with different structure as a whole", func() {
+			a := parseYAML(`
+---
+jobs:
+- name: j1
+  instances: 1
+- name: j2
+  instances: 1
+`)
+			b := parseYAML(`
+---
+jobs:
+- name: j2
+  instances: 1
+- name: j1
+  instances: 2
+`)
+			ops, err := JSONPatch(a, b, Compare(a, b))
+			Expect(err).To(BeNil())
+			Expect(asJSON(ops)).To(MatchJSON(`[
+  {"op":"replace","path":"/jobs","value":[{"name":"j2","instances":1},{"name":"j1","instances":2}]}
+]`))
+		})
+
+		It("locates list entries by their identity", func() {
+			a := parseYAML(`
+---
+list:
+- key:id: a
+  value: 1
+- id: b
+  value: 2
+- id: c
+  value: 3
+`)
+			b := parseYAML(`
+---
+list:
+- key:id: b
+  value: 2
+- id: c
+  value: 4
+`)
+			ops, err := JSONPatch(a, b, Compare(a, b))
+			Expect(err).To(BeNil())
+			Expect(asJSON(ops)).To(MatchJSON(`[
+  {"op":"replace","path":"/list/2/value","value":4},
+  {"op":"remove","path":"/list/0"}
+]`))
+		})
+
+		It("replaces lists with reordered entries", func() {
+			a := parseYAML(`
+---
+list:
+- key:id: a
+  value: 1
+- id: b
+  value: 2
+`)
+			b := parseYAML(`
+---
+list:
+- key:id: b
+  value: 3
+- id: a
+  value: 1
+`)
+			ops, err := JSONPatch(a, b, Compare(a, b))
+			Expect(err).To(BeNil())
+			Expect(asJSON(ops)).To(MatchJSON(`[
+  {"op":"replace","path":"/list","value":[{"key:id":"b","value":3},{"id":"a","value":1}]}
+]`))
+		})
+
+		DescribeTable("transforms a into b",
+			func(a, b string) {
+				na, nb := parseYAML(a), parseYAML(b)
+				ops, err := JSONPatch(na, nb, Compare(na, nb))
+				Expect(err).To(BeNil())
+				Expect(asJSON(applyPatch(normalize(na), ops))).To(MatchJSON(asJSON(normalize(nb))))
+			},
+			Entry("for the test documents", asYAML(a), asYAML(b)),
+			Entry("for reordered named entries", `
+list: [ { name: x, v: 1 }, { name: y, v: 2 }, { name: z, v: 3 } ]
+`, `
+list: [ { name: z, v: 3 }, { name: x, v: 1 }, { name: y, v: 5 } ]
+`),
+			Entry("for reordered entries with ids", `
+list: [ { id: x, v: 1 }, { id: y, v: 2 } ]
+`, `
+list: [ { id: y, v: 2 }, { id: w, v: 0 }, { id: x, v: 1 } ]
+`),
+			Entry("for removed and added entries", `
+list: [ { name: x, v: 1 }, { name: y, v: 2 }, { name: z, v: 3 } ]
+`, `
+list: [ { name: x, v: 1 }, { name: z, v: 4 }, { name: w, v: 0 } ]
+`),
+			Entry("for appended entries", `
+list: [ { name: x, v: 1 } ]
+`, `
+list: [ { name: x, v: 2 }, { name: y, v: 2 } ]
+`),
+			Entry("for nested reordered entries", `
+outer: [ { name: o, inner: [ { id: a }, { id: b } ] } ]
+`, `
+outer: [ { name: o, inner: [ { id: b }, { id: a } ] } ]
+`),
+		)
+
+		It("removes list entries with higher indices first", func() {
+			a := parseYAML(`
+---
+list: [ 1, 2, 3 ]
+`)
+			b := parseYAML(`
+---
+list: [ 1 ]
+`)
+			ops, err := JSONPatch(a, b, Compare(a, b))
+			Expect(err).To(BeNil())
+			Expect(asJSON(ops)).To(MatchJSON(`[
+  {"op":"remove","path":"/list/2"},
+  {"op":"remove","path":"/list/1"}
+]`))
+		})
+	})
+
+	Describe("merge patch", func() {
+		It("derives the patch from the differences", func() {
+			patch, err := MergePatch(a, b, Compare(a, b))
+			Expect(err).To(BeNil())
+			Expect(asJSON(patch)).To(MatchJSON(`{
+  "gone": null,
+  "list": [{"age":26,"name":"alice"}],
+  "name": "b",
+  "nested": {"y/z":3},
+  "new": {"a":1},
+  "plain": [1,2,4,5]
+}`))
+		})
+
+		It("replaces documents of different type", func() {
+			patch, err := MergePatch(parseYAML("a: 1"), parseYAML("[ 1 ]"), Compare(parseYAML("a: 1"), parseYAML("[ 1 ]")))
+			Expect(err).To(BeNil())
+			Expect(asJSON(patch)).To(MatchJSON(`[1]`))
+		})
+	})
+
+	Describe("unified diff", func() {
+		It("reports no differences", func() {
+			Expect(Unified("a", "b", "a: 1\n", "a: 1\n", 3)).To(Equal(""))
+		})
+
+		It("provides hunks with context", func() {
+			Expect(Unified("a", "b", "a\nb\nc\nd\ne\nf\ng\nh\ni\n", "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\n", 1)).To(Equal(`--- a
++++ b
+@@ -1,3 +1,3 @@
+ a
+-b
++B
+ c
+@@ -9 +9,2 @@
+ i
++j
+`))
+		})
+	})
+})
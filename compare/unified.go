@@ -0,0 +1,195 @@
+package compare
+
+import (
+	"fmt"
+	"strings"
+)
+
+type editKind int
+
+const (
+	editKeep editKind = iota
+	editDelete
+	editInsert
+)
+
+type edit struct {
+	kind editKind
+	line string
+}
+
+// Unified provides a unified diff of two texts with the given number of
+// context lines. An empty string is returned for identical texts.
+func Unified(aName, bName string, a, b string, context int) string {
+	alines := splitLines(a)
+	blines := splitLines(b)
+	edits := lineDiff(alines, blines)
+
+	changed := false
+	for _, e := range edits {
+		if e.kind != editKeep {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return ""
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", aName, bName)
+
+	// determine hunks as ranges of edits
+	for start := 0; start < len(edits); {
+		for start < len(edits) && edits[start].kind == editKeep {
+			start++
+		}
+		if start == len(edits) {
+			break
+		}
+		first := start - context
+		if first < 0 {
+			first = 0
+		}
+		end := start
+		for end < len(edits) {
+			if edits[end].kind != editKeep {
+				end++
+				continue
+			}
+			// check whether the next change is near enough to be joined
+			next := end
+			for next < len(edits) && edits[next].kind == editKeep {
+				next++
+			}
+			if next == len(edits) || next-end > 2*context {
+				break
+			}
+			end = next
+		}
+		last := end + context
+		if last > len(edits) {
+			last = len(edits)
+		}
+
+		astart, bstart := 1, 1
+		for _, e := range edits[:first] {
+			if e.kind != editInsert {
+				astart++
+			}
+			if e.kind != editDelete {
+				bstart++
+			}
+		}
+		acount, bcount := 0, 0
+		for _, e := range edits[first:last] {
+			if e.kind != editInsert {
+				acount++
+			}
+			if e.kind != editDelete {
+				bcount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(astart, acount), hunkRange(bstart, bcount))
+		for _, e := range edits[first:last] {
+			switch e.kind {
+			case editKeep:
+				out.WriteString(" ")
+			case editDelete:
+				out.WriteString("-")
+			case editInsert:
+				out.WriteString("+")
+			}
+			out.WriteString(e.line)
+			out.WriteString("\n")
+		}
+		start = last
+	}
+	return out.String()
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start-1)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// lineDiff determines a shortest edit script transforming a into b
+// using the algorithm of Myers.
+func lineDiff(a, b []string) []edit {
+	n, m := len(a), len(b)
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	trace := [][]int{}
+
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(a, b, trace, d, offset)
+			}
+		}
+	}
+	return nil
+}
+
+func backtrack(a, b []string, trace [][]int, d int, offset int) []edit {
+	edits := []edit{}
+	x, y := len(a), len(b)
+	for ; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prev int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prev = k + 1
+		} else {
+			prev = k - 1
+		}
+		px := v[offset+prev]
+		py := px - prev
+		for x > px && y > py {
+			x--
+			y--
+			edits = append(edits, edit{editKeep, a[x]})
+		}
+		if x == px {
+			y--
+			edits = append(edits, edit{editInsert, b[y]})
+		} else {
+			x--
+			edits = append(edits, edit{editDelete, a[x]})
+		}
+	}
+	for x > 0 {
+		x--
+		edits = append(edits, edit{editKeep, a[x]})
+	}
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
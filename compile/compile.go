@@ -8,6 +8,7 @@ import (
 
 	"github.com/mandelsoft/spiff/dynaml"
 	"github.com/mandelsoft/spiff/flow"
+	"github.com/mandelsoft/spiff/legacy/candiedyaml"
 	"github.com/mandelsoft/spiff/yaml"
 )
 
@@ -15,11 +16,16 @@ var mapType = reflect.TypeOf(map[string]interface{}{})
 var arrayType = reflect.TypeOf([]interface{}{})
 
 type CompileError struct {
-	Path    []string
-	Message error
+	Path     []string
+	Message  error
+	Source   string
+	Position yaml.Position
 }
 
 func (c CompileError) Error() string {
+	if c.Position.IsValid() {
+		return fmt.Sprintf("%s:%s: %s: %s", c.Source, c.Position, strings.Join(c.Path, "."), c.Message)
+	}
 	return fmt.Sprintf("%s: %s", strings.Join(c.Path, "."), c.Message)
 }
 
@@ -54,7 +60,18 @@ func (c CompileErrors) Swap(i, j int) {
 
 func (c *CompileErrors) Add(path []string, err error) {
 	if err != nil {
-		*c = append(*c, CompileError{path, err})
+		*c = append(*c, CompileError{Path: path, Message: err})
+	}
+}
+
+// locate assigns a source location to all errors for the given
+// path not yet providing a location.
+func (c CompileErrors) locate(path []string, source string, pos yaml.Position) {
+	for i, e := range c {
+		if !e.Position.IsValid() && reflect.DeepEqual(e.Path, path) {
+			c[i].Source = source
+			c[i].Position = pos
+		}
 	}
 }
 
@@ -71,6 +88,14 @@ func compile(env dynaml.Binding, root interface{}) (yaml.Node, CompileErrors) {
 	var errors CompileErrors
 
 	switch rootVal := root.(type) {
+	case candiedyaml.Marked:
+		pos := yaml.MarkPosition(rootVal.Mark)
+		node, errors := compile(env, rootVal.Value)
+		errors.locate(env.Path(), env.SourceName(), pos)
+		if node != nil {
+			node = yaml.PositionNode(node, pos)
+		}
+		return node, errors
 	case time.Time:
 		return yaml.NewNode(rootVal.Format("2019-01-08T10:06:26Z"), env.SourceName()), errors
 	case string:
@@ -84,7 +109,7 @@ func compile(env dynaml.Binding, root interface{}) (yaml.Node, CompileErrors) {
 		for key, val := range rootVal {
 			str, ok := key.(string)
 			if !ok {
-				errors.Add(env.Path(), yaml.NonStringKeyError{key})
+				errors.Add(env.Path(), yaml.NonStringKeyError{Key: key})
 			} else {
 				sub, errs := compile(env.WithPath(str), val)
 				if errs == nil {
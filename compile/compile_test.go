@@ -51,5 +51,20 @@ val:
 				ContainElement(`val.nested.bob: parse error near symbol 5 - symbol 6: '['`),
 				ContainElement(`val.alice: parse error near symbol 7 - symbol 8: ' '`)))
 		})
+
+		It("reports source locations for marked documents", func() {
+			source := parseMarkedYAML(`
+---
+val: 
+  alice: (( blub( ))
+  nested:
+    bob: (( map[] ))
+`)
+			_, err := Compile("test", source)
+			Expect(err).To(HaveOccurred())
+			Expect(strings.Split(err.Error(), "\n")).To(And(
+				ContainElement(`test:6:10: val.nested.bob: parse error near symbol 5 - symbol 6: '['`),
+				ContainElement(`test:4:10: val.alice: parse error near symbol 7 - symbol 8: ' '`)))
+		})
 	})
 })
@@ -16,8 +16,19 @@ func Test(t *testing.T) {
 }
 
 func parseYAML(source string) interface{} {
+	return decodeYAML(source, false)
+}
+
+func parseMarkedYAML(source string) interface{} {
+	return decodeYAML(source, true)
+}
+
+func decodeYAML(source string, marks bool) interface{} {
 	r := bytes.NewBuffer([]byte(source))
 	d := candiedyaml.NewDecoder(r)
+	if marks {
+		d.UseMarks()
+	}
 	for d.HasNext() {
 		var parsed interface{}
 		err := d.Decode(&parsed)
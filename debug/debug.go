@@ -1,13 +1,14 @@
 package debug
 
 import (
+	"fmt"
 	"log"
 )
 
 var DebugFlag bool
 
-func Debug(fmt string, args ...interface{}) {
+func Debug(format string, args ...interface{}) {
 	if DebugFlag {
-		log.Printf(fmt, args...)
+		log.Print(Redact(fmt.Sprintf(format, args...)))
 	}
 }
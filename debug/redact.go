@@ -0,0 +1,151 @@
+package debug
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// REDACTED is the replacement for sensitive values in
+// diagnostic output.
+const REDACTED = "<redacted>"
+
+// Short sensitive values would redact arbitrary parts of a text: values
+// shorter than MIN_REDACTED are not redacted at all, values shorter than
+// MIN_SUBSTRING only if they appear as complete words.
+const (
+	MIN_REDACTED  = 3
+	MIN_SUBSTRING = 8
+)
+
+// Redactor keeps a set of sensitive values, which must never
+// appear in clear text in diagnostic output.
+type Redactor struct {
+	lock    sync.RWMutex
+	secrets map[string]bool
+	values  []string
+}
+
+func NewRedactor() *Redactor {
+	return &Redactor{secrets: map[string]bool{}}
+}
+
+// Add registers sensitive values.
+func (r *Redactor) Add(values ...string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, v := range values {
+		if len(v) >= MIN_REDACTED && !r.secrets[v] {
+			r.secrets[v] = true
+			r.values = append(r.values, v)
+		}
+	}
+}
+
+// Redact replaces all registered sensitive values in a text.
+// Overlapping or adjacent occurrences are replaced as a whole.
+func (r *Redactor) Redact(text string) string {
+	if r == nil {
+		return text
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	var covered []bool
+	for _, v := range r.values {
+		covered = mark(covered, text, v)
+	}
+	return replaceMarked(text, covered)
+}
+
+// mark marks the occurrences of a value in a text.
+func mark(covered []bool, text, value string) []bool {
+	for start := 0; start < len(text); {
+		i := strings.Index(text[start:], value)
+		if i < 0 {
+			break
+		}
+		i += start
+		end := i + len(value)
+		if len(value) >= MIN_SUBSTRING || (!wordBefore(text, i) && !wordAfter(text, end)) {
+			if covered == nil {
+				covered = make([]bool, len(text))
+			}
+			for j := i; j < end; j++ {
+				covered[j] = true
+			}
+		}
+		start = i + 1
+	}
+	return covered
+}
+
+func wordBefore(text string, i int) bool {
+	r, _ := utf8.DecodeLastRuneInString(text[:i])
+	return i > 0 && isWordRune(r)
+}
+
+func wordAfter(text string, i int) bool {
+	r, _ := utf8.DecodeRuneInString(text[i:])
+	return i < len(text) && isWordRune(r)
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func replaceMarked(text string, covered []bool) string {
+	if covered == nil {
+		return text
+	}
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		if !covered[i] {
+			b.WriteByte(text[i])
+			continue
+		}
+		b.WriteString(REDACTED)
+		for i+1 < len(text) && covered[i+1] {
+			i++
+		}
+	}
+	return b.String()
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// The sensitive values are kept by the processing states. The debug
+// output is redacted with the redactors of the active processings.
+var redactors = struct {
+	lock sync.RWMutex
+	list []*Redactor
+}{}
+
+// AddRedactor registers a redactor for the debug output. The returned
+// function removes it again.
+func AddRedactor(r *Redactor) func() {
+	redactors.lock.Lock()
+	defer redactors.lock.Unlock()
+	redactors.list = append(redactors.list, r)
+	return func() {
+		redactors.lock.Lock()
+		defer redactors.lock.Unlock()
+		for i, e := range redactors.list {
+			if e == r {
+				redactors.list = append(redactors.list[:i:i], redactors.list[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// Redact replaces the sensitive values of the registered redactors
+// in a text.
+func Redact(text string) string {
+	redactors.lock.RLock()
+	defer redactors.lock.RUnlock()
+	for _, r := range redactors.list {
+		text = r.Redact(text)
+	}
+	return text
+}
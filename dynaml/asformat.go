@@ -0,0 +1,65 @@
+package dynaml
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/mandelsoft/spiff/yaml"
+)
+
+func init() {
+	RegisterFunction("asformat", func_asformat)
+}
+
+// data_parsers are the additional data formats supported by the
+// modes of read and parse.
+var data_parsers = map[string]func(string, []byte) (yaml.Node, error){
+	"toml":       yaml.ParseTOML,
+	"ini":        yaml.ParseINI,
+	"properties": yaml.ParseProperties,
+	"dotenv":     yaml.ParseDotEnv,
+	"csv":        yaml.ParseCSV,
+}
+
+// FormatData serializes a value in the given data format using the
+// formatters registered in the yaml package.
+func FormatData(value interface{}, format string) ([]byte, error) {
+	f := yaml.GetFormatter(format)
+	if f == nil {
+		return nil, fmt.Errorf("unknown data format %q", format)
+	}
+	data, err := f.Marshal(NewNode(value, nil), yaml.MarshalOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal %s: %s", format, err)
+	}
+	return data, nil
+}
+
+func parseDataFormat(file string, data []byte, mode string) (interface{}, EvaluationInfo, bool) {
+	info := DefaultInfo()
+	info.Source = file
+	info.Raw = true
+
+	n, err := data_parsers[mode](file, data)
+	if err != nil {
+		return info.Error("error parsing %s file [%s]: %s", mode, path.Clean(file), err)
+	}
+	return n.Value(), info, true
+}
+
+func func_asformat(arguments []interface{}, binding Binding) (interface{}, EvaluationInfo, bool) {
+	info := DefaultInfo()
+
+	if len(arguments) != 2 {
+		return info.Error("asformat takes exactly two arguments")
+	}
+	format, ok := arguments[1].(string)
+	if !ok {
+		return info.Error("format string for argument two of function asformat required")
+	}
+	data, err := FormatData(arguments[0], format)
+	if err != nil {
+		return info.Error("asformat: %s", err)
+	}
+	return string(data), info, true
+}
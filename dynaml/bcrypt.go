@@ -30,7 +30,7 @@ func func_bcrypt(arguments []interface{}, binding Binding) (interface{}, Evaluat
 		return info.Error("bcrypt error: %s", err)
 	}
 
-	return fmt.Sprintf("%s", result), info, true
+	return Sensitive(fmt.Sprintf("%s", result), info, true)
 }
 
 func func_bcrypt_check(arguments []interface{}, binding Binding) (interface{}, EvaluationInfo, bool) {
@@ -51,5 +51,5 @@ func func_bcrypt_check(arguments []interface{}, binding Binding) (interface{}, E
 	}
 
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(passwd))
-	return err == nil, info, true
+	return NonSensitive(err == nil, info, true)
 }
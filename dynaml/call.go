@@ -2,6 +2,7 @@ package dynaml
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/mandelsoft/spiff/yaml"
@@ -36,12 +37,67 @@ func (r *functionRegistry) LookupFunction(name string) Function {
 	return function_registry.(*functionRegistry).functions[name]
 }
 
+func (r *functionRegistry) names() []string {
+	names := []string{}
+	for n := range r.functions {
+		names = append(names, n)
+	}
+	if r != function_registry {
+		for n := range function_registry.(*functionRegistry).functions {
+			if r.functions[n] == nil {
+				names = append(names, n)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
 func RegisterFunction(name string, f Function) {
 	function_registry.RegisterFunction(name, f)
 }
 
 var function_registry = NewFunctions()
 
+// builtin_functions lists the functions handled directly by CallExpr.
+// It must be kept in sync with the function switches in CallExpr.Evaluate,
+// the tests check that every listed function is handled there.
+var builtin_functions = map[string]bool{
+	"defined": true, "require": true, "valid": true, "stub": true, "catch": true,
+	"sync": true, "static_ips": true, "join": true, "split": true,
+	"split_match": true, "trim": true, "length": true, "uniq": true,
+	"element": true, "contains": true, "index": true, "lastindex": true,
+	"replace": true, "replace_match": true, "match": true, "sort": true,
+	"exec": true, "exec_uncached": true, "pipe": true, "pipe_uncached": true,
+	"eval": true, "env": true, "rand": true, "read": true, "read_uncached": true,
+	"write": true, "lookup_file": true, "lookup_dir": true, "list_files": true,
+	"list_dirs": true, "tempfile": true, "format": true, "error": true,
+	"min_ip": true, "max_ip": true, "num_ip": true, "contains_ip": true,
+	"makemap": true, "list_to_map": true, "ipset": true, "merge": true,
+	"base64": true, "base64_decode": true, "md5": true, "hash": true,
+	"bcrypt": true, "bcrypt_check": true, "md5crypt": true,
+	"md5crypt_check": true, "asjson": true, "asyaml": true, "parse": true,
+	"substr": true, "lower": true, "upper": true, "keys": true, "archive": true,
+	"validate": true, "check": true, "type": true,
+}
+
+// IsBuiltinFunction checks whether the given name is a builtin
+// function handled by the call expression itself. Additional
+// functions are provided by the function registry.
+func IsBuiltinFunction(name string) bool {
+	return builtin_functions[name]
+}
+
+// BuiltinFunctionNames provides the sorted list of builtin function names.
+func BuiltinFunctionNames() []string {
+	names := []string{}
+	for n := range builtin_functions {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
 type NameArgument struct {
 	Name string
 	Expression
@@ -339,6 +395,13 @@ func (e CallExpr) Evaluate(binding Binding, locally bool) (interface{}, Evaluati
 	if cleaned {
 		info.Cleanup()
 	}
+	if sub.Declassified {
+		sub.Declassified = false
+		info.NodeFlags &^= yaml.FLAG_SENSITIVE
+	}
+	if ok && sub.Sensitive() {
+		RedactValue(result, binding)
+	}
 	if ok && (!resolved || IsExpression(result)) {
 		return e, sub.Join(info), true
 	}
@@ -1,6 +1,8 @@
 package dynaml
 
 import (
+	"fmt"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
@@ -22,7 +24,61 @@ func newNetworkFakeBinding(subnets yaml.Node, instances interface{}) Binding {
 	}
 }
 
+// noFunctions provides no additional functions, so every function
+// not handled by the call expression itself is unknown.
+type noFunctions struct {
+	Registry
+}
+
+func (noFunctions) LookupFunction(string) Function {
+	return nil
+}
+
+type noFunctionsState struct {
+	State
+}
+
+func (noFunctionsState) GetRegistry() Registry {
+	return noFunctions{DefaultRegistry()}
+}
+
+type noFunctionsBinding struct {
+	FakeBinding
+}
+
+func (noFunctionsBinding) GetState() State {
+	return noFunctionsState{}
+}
+
+// callIssue provides the issue reported for calling a function without
+// arguments. Functions failing for the incomplete binding are handled
+// by the call expression, so they report no issue.
+func callIssue(name string) (issue string) {
+	defer func() {
+		if r := recover(); r != nil {
+			issue = ""
+		}
+	}()
+	expr := CallExpr{Function: ReferenceExpr{Path: []string{name}}}
+	_, info, _ := expr.Evaluate(noFunctionsBinding{}, false)
+	return info.Issue.Issue
+}
+
 var _ = Describe("calls", func() {
+	Describe("builtin functions", func() {
+		It("are handled by the call expression", func() {
+			for _, name := range BuiltinFunctionNames() {
+				Expect(IsBuiltinFunction(name)).To(BeTrue())
+				Expect(callIssue(name)).NotTo(Equal(fmt.Sprintf("unknown function '%s'", name)), name)
+			}
+		})
+
+		It("do not include other functions", func() {
+			Expect(IsBuiltinFunction("nofunc")).To(BeFalse())
+			Expect(callIssue("nofunc")).To(Equal("unknown function 'nofunc'"))
+		})
+	})
+
 	Describe("CIDR functions", func() {
 		It("contains IP", func() {
 			expr := CallExpr{
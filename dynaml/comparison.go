@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/mandelsoft/spiff/debug"
 	"github.com/mandelsoft/spiff/yaml"
@@ -42,6 +43,18 @@ func (e ComparisonExpr) Evaluate(binding Binding, locally bool) (interface{}, Ev
 		result, infor, ok = compareEquals(a, b)
 		result = !result
 	case "<=", "<", ">", ">=":
+		if isTime(a) || isTime(b) {
+			ta, err := TimeArgument(a)
+			if err == nil {
+				var tb time.Time
+				tb, err = TimeArgument(b)
+				result = compareTimes(e.Op, ta, tb)
+			}
+			if err != nil {
+				return infor.Error("comparision %s for time values: %s", e.Op, err)
+			}
+			break
+		}
 		switch va := a.(type) {
 		case int64:
 			vb, ok := b.(int64)
@@ -88,6 +101,25 @@ func (e ComparisonExpr) String() string {
 	return fmt.Sprintf("%s %s %s", e.A, e.Op, e.B)
 }
 
+func isTime(v interface{}) bool {
+	_, ok := v.(TimeValue)
+	return ok
+}
+
+func compareTimes(op string, a, b time.Time) bool {
+	switch op {
+	case "<=":
+		return !a.After(b)
+	case "<":
+		return a.Before(b)
+	case ">":
+		return a.After(b)
+	case ">=":
+		return !a.Before(b)
+	}
+	return false
+}
+
 func compareEquals(a, b interface{}) (bool, EvaluationInfo, bool) {
 	info := DefaultInfo()
 
@@ -105,6 +137,9 @@ func compareEquals(a, b interface{}) (bool, EvaluationInfo, bool) {
 			return false, info, false
 		}
 	}
+	if isTime(b) && !isTime(a) {
+		a, b = b, a
+	}
 	switch va := a.(type) {
 	case string:
 		var vb string
@@ -177,6 +212,14 @@ func compareEquals(a, b interface{}) (bool, EvaluationInfo, bool) {
 		debug.Debug("compare failed: %v != %v\n", va, vb)
 		return false, info, true
 
+	case TimeValue:
+		vb, err := TimeArgument(b)
+		if err != nil {
+			debug.Debug("compare failed: no time '%v'\n", b)
+			return false, info, true
+		}
+		return va.Time.Equal(vb), info, true
+
 	case yaml.ComparableValue:
 		if vb, ok := b.(yaml.ComparableValue); ok {
 			return va.EquivalentTo(vb), info, true
@@ -63,6 +63,8 @@ func (e ConcatenationExpr) Evaluate(binding Binding, locally bool) (interface{},
 			return info.Error("type '%s'(%s) cannot be concatenated with type '%s'(%s)", ExpressionType(b), e.B, ExpressionType(a), e.A)
 		}
 	} else {
+		// never append to the backing array of the document list
+		alist = alist[:len(alist):len(alist)]
 		switch b.(type) {
 		case []yaml.Node:
 			debug.Debug("CONCAT --> %s\n", ExpressionType(alist))
@@ -91,6 +93,8 @@ func concatenateString(a interface{}, b interface{}) (string, bool) {
 		aString = strconv.FormatInt(v, 10)
 	case bool:
 		aString = strconv.FormatBool(v)
+	case TimeValue:
+		aString = v.String()
 	default:
 		return "", false
 	}
@@ -104,6 +108,8 @@ func concatenateString(a interface{}, b interface{}) (string, bool) {
 		return aString + strconv.FormatBool(v), true
 	case LambdaValue:
 		return aString + fmt.Sprintf("%s", v), true
+	case TimeValue:
+		return aString + v.String(), true
 	default:
 		return "", false
 	}
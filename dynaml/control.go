@@ -179,7 +179,7 @@ func ControlIssueByIssue(ctx *ControlContext, issue yaml.Issue, final bool) (yam
 	if !final {
 		return yaml.IssueNode(ctx.Node, true, true, issue), false
 	}
-	return yaml.IssueNode(yaml.NewNode(control, ctx.Node.SourceName()), true, true, issue), false
+	return yaml.IssueNode(yaml.NewPositionedNode(control, ctx.Node.SourceName(), ctx.Node.Position()), true, true, issue), false
 }
 
 func IsControl(node yaml.Node, env Binding) (bool, error) {
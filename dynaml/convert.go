@@ -46,6 +46,8 @@ func func_string(arguments []interface{}, binding Binding) (interface{}, Evaluat
 			return "true", info, true
 		}
 		return "false", info, true
+	case TimeValue:
+		return v.String(), info, true
 	default:
 		return info.Error("cannot convert %T to string", v)
 	}
@@ -103,6 +103,13 @@ func getArg(key interface{}, value interface{}, wopt WriteOpts, allowyaml bool)
 		if !allowyaml || value == nil {
 			return "", false, fmt.Errorf("yaml or empty data not supported")
 		}
+		if wopt.Format != "" {
+			data, err := FormatData(value, wopt.Format)
+			if err != nil {
+				return "", false, err
+			}
+			return string(data), false, nil
+		}
 		if wopt.Multi {
 			if list, ok := value.([]yaml.Node); ok {
 				result := ""
@@ -141,8 +148,8 @@ func cachedExecute(cache ExecCache, content *string, args []string) ([]byte, err
 	hash := fmt.Sprintf("%x", h.Sum(nil))
 	if cache != nil {
 		cache.Lock()
-		defer cache.Unlock()
 		result := cache.Get(hash)
+		cache.Unlock()
 		if result != nil {
 			debug.Debug("exec: reusing cache %s for %v\n", hash, args)
 			return result, nil
@@ -160,6 +167,13 @@ func cachedExecute(cache ExecCache, content *string, args []string) ([]byte, err
 		fmt.Fprintf(os.Stderr, "  error: %v\n", stderr)
 	}
 	if cache != nil {
+		// the lock is not held during the execution, so concurrent
+		// evaluations may execute the same command. The first result wins.
+		cache.Lock()
+		defer cache.Unlock()
+		if cached := cache.Get(hash); cached != nil {
+			return cached, nil
+		}
 		cache.Set(hash, result)
 	}
 	return result, err
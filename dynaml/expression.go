@@ -42,6 +42,24 @@ type State interface {
 	GetTags(name string) []*TagInfo
 }
 
+// FileObserver is optionally implemented by a State to get
+// informed about the local files used by the processing.
+type FileObserver interface {
+	ObserveFile(file string)
+}
+
+// KeyCache is optionally implemented by a State to reuse keys derived
+// from passwords by the (intentionally expensive) key derivation
+// functions of the encryption methods.
+type KeyCache interface {
+	// DerivedKey provides the key derived from a password and salt for
+	// an encryption method. derive is called for unknown combinations, only.
+	DerivedKey(method, password string, salt []byte, derive func() ([]byte, error)) ([]byte, error)
+	// EncryptionSalt provides the salt used for all encryptions with a
+	// method and password. generate is called for the first encryption.
+	EncryptionSalt(method, password string, generate func() ([]byte, error)) ([]byte, error)
+}
+
 type Binding interface {
 	SourceProvider
 	GetStaticBinding() map[string]yaml.Node
@@ -82,11 +100,13 @@ type EvaluationInfo struct {
 	Merged       bool
 	Preferred    bool
 	KeyName      string
+	KeyOrder     []string
 	Source       string
 	LocalError   bool
 	Failed       bool
 	Undefined    bool
 	Raw          bool
+	Declassified bool // result of a function call is not sensitive
 	Issue        yaml.Issue
 	Cleanups     []Cleanup
 	yaml.NodeFlags
@@ -138,8 +158,8 @@ func (e EvaluationInfo) SourceName() string {
 
 func DefaultInfo() EvaluationInfo {
 	return EvaluationInfo{nil, false, false,
-		false, "", "",
-		false, false, false, false,
+		false, "", nil, "",
+		false, false, false, false, false,
 		yaml.Issue{}, nil, 0}
 }
 
@@ -221,6 +241,9 @@ func (i EvaluationInfo) Join(o EvaluationInfo) EvaluationInfo {
 	if o.KeyName != "" {
 		i.KeyName = o.KeyName
 	}
+	if o.KeyOrder != nil {
+		i.KeyOrder = o.KeyOrder
+	}
 	if o.Issue.Issue != "" {
 		i.Issue = o.Issue
 	}
@@ -74,6 +74,9 @@ func checkExistence(binding Binding, path string, directory bool) bool {
 	if !binding.GetState().FileAccessAllowed() {
 		return false
 	}
+	if o, ok := binding.GetState().(FileObserver); ok {
+		o.ObserveFile(path)
+	}
 	s, err := binding.GetState().FileSystem().Stat(path)
 	if vfs.IsErrNotExist(err) || err != nil {
 		return false
@@ -42,7 +42,11 @@ func (e MappingExpr) Evaluate(binding Binding, locally bool) (interface{}, Evalu
 	if !e.Context.Supports(value) {
 		return info.Error("%s%s does not support %s values", e.Context.Keyword(), e.Context.Brackets(), ExpressionType(value))
 	}
+	sensitive := info.Sensitive()
 	result, info, ok = e.Context.CreateMappingAggregation(value).DoMapping(inline, value, lambda, binding)
+	if sensitive {
+		info.SetSensitive()
+	}
 
 	if !ok {
 		return nil, info, false
@@ -249,6 +253,7 @@ func mapList(inline bool, value interface{}, e LambdaValue, binding Binding, agg
 	source := value.([]yaml.Node)
 	inp := make([]interface{}, len(e.lambda.Parameters))
 	info := DefaultInfo()
+	sensitive := false
 
 	if len(e.lambda.Parameters) > 2 {
 		info.Error("mapping expression takes a maximum of 2 arguments")
@@ -259,6 +264,7 @@ func mapList(inline bool, value interface{}, e LambdaValue, binding Binding, agg
 		inp[0] = i
 		inp[len(inp)-1] = n.Value()
 		resolved, mapped, info, ok := e.Evaluate(inline, false, false, nil, inp, binding, false)
+		sensitive = sensitive || n.Sensitive() || info.Sensitive()
 		if !ok {
 			debug.Debug("map:  %d %+v: failed\n", i, n)
 			return nil, info, false
@@ -277,6 +283,9 @@ func mapList(inline bool, value interface{}, e LambdaValue, binding Binding, agg
 			return info.Error("%s", err)
 		}
 	}
+	if sensitive {
+		info.SetSensitive()
+	}
 	return aggr.Result(), info, true
 }
 
@@ -284,6 +293,7 @@ func mapMap(inline bool, value interface{}, e LambdaValue, binding Binding, aggr
 	source := value.(map[string]yaml.Node)
 	inp := make([]interface{}, len(e.lambda.Parameters))
 	info := DefaultInfo()
+	sensitive := false
 
 	keys := getSortedKeys(source)
 	for _, k := range keys {
@@ -292,6 +302,7 @@ func mapMap(inline bool, value interface{}, e LambdaValue, binding Binding, aggr
 		inp[0] = k
 		inp[len(inp)-1] = n.Value()
 		resolved, mapped, info, ok := e.Evaluate(inline, false, false, nil, inp, binding, false)
+		sensitive = sensitive || n.Sensitive() || info.Sensitive()
 		if !ok {
 			debug.Debug("map:  %s %+v: failed\n", k, n)
 			return nil, info, false
@@ -310,6 +321,9 @@ func mapMap(inline bool, value interface{}, e LambdaValue, binding Binding, aggr
 			return info.Error("%s", err)
 		}
 	}
+	if sensitive {
+		info.SetSensitive()
+	}
 	return aggr.Result(), info, true
 }
 
@@ -1,7 +1,6 @@
 package dynaml
 
 import (
-	"regexp"
 	"strconv"
 
 	"github.com/mandelsoft/spiff/yaml"
@@ -54,7 +53,7 @@ func func_match(arguments []interface{}, binding Binding) (interface{}, Evaluati
 		return info.Error("simple value for argument two of function match required")
 	}
 
-	re, err := regexp.Compile(pattern)
+	re, err := CompileRegexp(pattern, binding)
 	if err != nil {
 		return info.Error("match: %s", err)
 	}
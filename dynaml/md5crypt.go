@@ -20,7 +20,7 @@ func func_md5crypt(arguments []interface{}, binding Binding) (interface{}, Evalu
 
 	result := crypt.MD5Crypt([]byte(passwd), crypt.GenerateSALT(8), []byte(crypt.MD5_MAGIC))
 
-	return fmt.Sprintf("%s", result), info, true
+	return Sensitive(fmt.Sprintf("%s", result), info, true)
 }
 
 func func_md5crypt_check(arguments []interface{}, binding Binding) (interface{}, EvaluationInfo, bool) {
@@ -45,5 +45,5 @@ func func_md5crypt_check(arguments []interface{}, binding Binding) (interface{},
 		return info.Error("invalid md5crypt hash: must contain three '$' characters")
 	}
 	check := crypt.MD5Crypt([]byte(passwd), []byte(parts[2]), []byte("$"+parts[1]+"$"))
-	return string(check) == hash, info, true
+	return NonSensitive(string(check) == hash, info, true)
 }
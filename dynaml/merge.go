@@ -32,6 +32,7 @@ func (e MergeExpr) Evaluate(binding Binding, locally bool) (interface{}, Evaluat
 		info.Replace = e.Replace
 		info.Merged = true
 		info.Source = node.SourceName()
+		info.KeyOrder = node.KeyOrder()
 		info.NodeFlags = node.Flags()
 		return node.Value(), info, ok
 	} else {
@@ -0,0 +1,514 @@
+package passwd
+
+import (
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mandelsoft/spiff/legacy/candiedyaml"
+	"github.com/mandelsoft/spiff/yaml"
+)
+
+// Documents can be encrypted partially: only the values of selected leaf
+// nodes are encrypted, the keys and the structure of the document stay
+// readable. The values are encrypted with a random data key generated
+// for the document, which is encrypted with the method and key given
+// for the document. The encryption settings and the encrypted data key
+// are kept in a top level metadata field, together with a keyed hash
+// (MAC) of the settings, the structure and all leaf values of the
+// document used to detect modifications of the document.
+
+// METADATA is the name of the top level field holding the encryption
+// settings of a partially encrypted document.
+const METADATA = "spiff_encryption"
+
+const DOCUMENT_VERSION = 1
+
+// DATA is the method name used in the ciphertext header of the values
+// encrypted with the data key of a document.
+const DATA = "data"
+
+const dataKeySize = 32
+
+// Selection describes the leaf nodes of a document to encrypt. A leaf is
+// selected if its path or a prefix of it matches one of the path patterns,
+// or if one of the map keys on its path matches the key regex.
+// Path patterns are dot separated sequences of map keys and list indices
+// ([<n>]), a step * matches any step, a step ** any sequence of steps.
+type Selection struct {
+	Paths    []string
+	KeyRegex string
+}
+
+type documentEncryption struct {
+	method     string
+	selection  Selection
+	regex      *regexp.Regexp
+	recipients []string
+	dataKey    string // data key encrypted with the method
+	mac        string
+
+	key  []byte      // data key
+	aead cipher.AEAD // cipher for the values using the data key
+}
+
+// IsEncryptedDocument checks whether a document is partially encrypted.
+func IsEncryptedDocument(doc yaml.Node) bool {
+	if doc == nil {
+		return false
+	}
+	m, ok := doc.Value().(map[string]yaml.Node)
+	if !ok {
+		return false
+	}
+	_, ok = m[METADATA]
+	return ok
+}
+
+// DocumentMethod provides the encryption method of a partially
+// encrypted document.
+func DocumentMethod(doc yaml.Node) (string, error) {
+	e, err := getDocumentEncryption(doc)
+	if err != nil {
+		return "", err
+	}
+	return e.method, nil
+}
+
+// DocumentSelection provides the selection of the encrypted values
+// of a partially encrypted document.
+func DocumentSelection(doc yaml.Node) (Selection, error) {
+	e, err := getDocumentEncryption(doc)
+	if err != nil {
+		return Selection{}, err
+	}
+	return e.selection, nil
+}
+
+// EncryptDocument encrypts the selected leaf values of a document with the
+// given method and key. If no method is given, the default method is used.
+func EncryptDocument(doc yaml.Node, key, method string, sel Selection) (yaml.Node, error) {
+	if method == "" {
+		method = DEFAULT_METHOD
+	}
+	e := GetEncoding(method)
+	if e == nil {
+		return nil, fmt.Errorf("invalid encyption method %q", method)
+	}
+	if len(sel.Paths) == 0 && sel.KeyRegex == "" {
+		return nil, fmt.Errorf("no values selected for encryption")
+	}
+	m, ok := doc.Value().(map[string]yaml.Node)
+	if !ok {
+		return nil, fmt.Errorf("document must be a map")
+	}
+	if _, ok := m[METADATA]; ok {
+		return nil, fmt.Errorf("document already encrypted")
+	}
+	enc := &documentEncryption{method: method, selection: sel}
+	if sel.KeyRegex != "" {
+		r, err := regexp.Compile(sel.KeyRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key regex: %s", err)
+		}
+		enc.regex = r
+	}
+	if _, ok := e.(PublicKeyEncoding); ok {
+		enc.recipients = recipientList(key)
+	}
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+	wrapped, err := e.Encode(base64.StdEncoding.EncodeToString(dataKey), key)
+	if err != nil {
+		return nil, err
+	}
+	enc.dataKey = wrapped
+	if err := enc.setKey(dataKey); err != nil {
+		return nil, err
+	}
+
+	entries := structureEntries(doc, []string{})
+	result, err := enc.walk(doc, []string{}, func(path []string, node yaml.Node) (yaml.Node, error) {
+		text, err := marshalLeaf(node)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, macEntry(path, text))
+		if !enc.selected(path) {
+			return node, nil
+		}
+		c, err := enc.seal(path, text)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", strings.Join(path, "."), err)
+		}
+		return yaml.SubstituteNode(c, node), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	enc.mac = enc.documentMAC(entries)
+	m = copyMap(result.Value().(map[string]yaml.Node))
+	m[METADATA] = enc.metadata(doc)
+	return yaml.SubstituteNode(m, doc), nil
+}
+
+// DecryptDocument decrypts a partially encrypted document with the given key
+// and checks the MAC of the document. The metadata field is removed.
+func DecryptDocument(doc yaml.Node, key string) (yaml.Node, error) {
+	enc, err := getDocumentEncryption(doc)
+	if err != nil {
+		return nil, err
+	}
+	e := GetEncoding(enc.method)
+	if e == nil {
+		return nil, fmt.Errorf("invalid encyption method %q", enc.method)
+	}
+	wrapped, err := e.Decode(enc.dataKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("data key: %s", err)
+	}
+	dataKey, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil || len(dataKey) != dataKeySize {
+		return nil, fmt.Errorf("invalid data key")
+	}
+	if err := enc.setKey(dataKey); err != nil {
+		return nil, err
+	}
+	m := copyMap(doc.Value().(map[string]yaml.Node))
+	delete(m, METADATA)
+
+	entries := structureEntries(yaml.SubstituteNode(m, doc), []string{})
+	result, err := enc.walk(yaml.SubstituteNode(m, doc), []string{}, func(path []string, node yaml.Node) (yaml.Node, error) {
+		if !enc.selected(path) {
+			text, err := marshalLeaf(node)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, macEntry(path, text))
+			return node, nil
+		}
+		c, ok := node.Value().(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: value not encrypted", strings.Join(path, "."))
+		}
+		text, err := enc.open(path, c)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", strings.Join(path, "."), err)
+		}
+		entries = append(entries, macEntry(path, text))
+		value, err := yaml.Parse(node.SourceName(), []byte(text))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", strings.Join(path, "."), err)
+		}
+		return yaml.SubstituteNode(value.Value(), node), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal([]byte(enc.mac), []byte(enc.documentMAC(entries))) {
+		return nil, fmt.Errorf("MAC mismatch: document has been modified")
+	}
+	return result, nil
+}
+
+func getDocumentEncryption(doc yaml.Node) (*documentEncryption, error) {
+	if !IsEncryptedDocument(doc) {
+		return nil, fmt.Errorf("document not encrypted")
+	}
+	meta := doc.Value().(map[string]yaml.Node)[METADATA]
+	m, ok := meta.Value().(map[string]yaml.Node)
+	if !ok {
+		return nil, fmt.Errorf("invalid %s field", METADATA)
+	}
+	field := func(name string) string {
+		if n := m[name]; n != nil {
+			if s, ok := n.Value().(string); ok {
+				return s
+			}
+		}
+		return ""
+	}
+	list := func(name string) []string {
+		result := []string{}
+		if n := m[name]; n != nil {
+			if l, ok := n.Value().([]yaml.Node); ok {
+				for _, e := range l {
+					if s, ok := e.Value().(string); ok {
+						result = append(result, s)
+					}
+				}
+			}
+		}
+		return result
+	}
+	if v, ok := m["version"]; !ok || v.Value() != int64(DOCUMENT_VERSION) {
+		return nil, fmt.Errorf("unsupported %s version", METADATA)
+	}
+	enc := &documentEncryption{
+		method: field("method"),
+		selection: Selection{
+			Paths:    list("paths"),
+			KeyRegex: field("key_regex"),
+		},
+		recipients: list("recipients"),
+		dataKey:    field("data_key"),
+		mac:        field("mac"),
+	}
+	if enc.method == "" || enc.dataKey == "" || enc.mac == "" {
+		return nil, fmt.Errorf("incomplete %s field", METADATA)
+	}
+	if enc.selection.KeyRegex != "" {
+		r, err := regexp.Compile(enc.selection.KeyRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key regex: %s", err)
+		}
+		enc.regex = r
+	}
+	return enc, nil
+}
+
+func (e *documentEncryption) metadata(doc yaml.Node) yaml.Node {
+	node := func(v interface{}) yaml.Node {
+		return yaml.NewNode(v, doc.SourceName())
+	}
+	list := func(values []string) yaml.Node {
+		l := []yaml.Node{}
+		for _, v := range values {
+			l = append(l, node(v))
+		}
+		return node(l)
+	}
+	m := map[string]yaml.Node{
+		"version":  node(int64(DOCUMENT_VERSION)),
+		"method":   node(e.method),
+		"data_key": node(e.dataKey),
+		"mac":      node(e.mac),
+	}
+	if len(e.selection.Paths) > 0 {
+		m["paths"] = list(e.selection.Paths)
+	}
+	if e.selection.KeyRegex != "" {
+		m["key_regex"] = node(e.selection.KeyRegex)
+	}
+	if len(e.recipients) > 0 {
+		m["recipients"] = list(e.recipients)
+	}
+	return node(m)
+}
+
+// setKey sets the data key used to encrypt the values. The cipher is
+// the one of the method, if it is an authenticated encryption method,
+// and AES-256-GCM otherwise.
+func (e *documentEncryption) setKey(key []byte) error {
+	newCipher := newAESGCM
+	if a, ok := GetEncoding(e.method).(aeadEncoding); ok {
+		newCipher = a.cipher
+	}
+	aead, err := newCipher(key)
+	if err != nil {
+		return err
+	}
+	e.key = key
+	e.aead = aead
+	return nil
+}
+
+// seal encrypts the value of a leaf with the data key. The path of the
+// leaf is authenticated, also, so values cannot be moved to other paths.
+func (e *documentEncryption) seal(path []string, text string) (string, error) {
+	nonce := make([]byte, e.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	header := Header(DATA)
+	data := e.aead.Seal(nonce, nonce, []byte(text), additionalData(header, path))
+	return header + base64.RawStdEncoding.EncodeToString(data), nil
+}
+
+// open decrypts the value of a leaf with the data key.
+func (e *documentEncryption) open(path []string, text string) (string, error) {
+	method, payload, err := ParseHeader(text)
+	if err != nil {
+		return "", err
+	}
+	if method != DATA {
+		return "", fmt.Errorf("value not encrypted with the data key")
+	}
+	data, err := base64.RawStdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", fmt.Errorf("invalid ciphertext: %s", err)
+	}
+	if len(data) < e.aead.NonceSize() {
+		return "", fmt.Errorf("ciphertext too short")
+	}
+	n := e.aead.NonceSize()
+	plain, err := e.aead.Open(nil, data[:n], data[n:], additionalData(Header(DATA), path))
+	if err != nil {
+		return "", fmt.Errorf("invalid data key or corrupted data")
+	}
+	return string(plain), nil
+}
+
+func additionalData(header string, path []string) []byte {
+	p, _ := json.Marshal(path)
+	return append([]byte(header), p...)
+}
+
+// settings provides the canonical form of the encryption settings
+// covered by the MAC.
+func (e *documentEncryption) settings() []byte {
+	data, _ := json.Marshal(map[string]interface{}{
+		"version":    DOCUMENT_VERSION,
+		"method":     e.method,
+		"paths":      sortedList(e.selection.Paths),
+		"key_regex":  e.selection.KeyRegex,
+		"recipients": sortedList(e.recipients),
+		"data_key":   e.dataKey,
+	})
+	return data
+}
+
+func sortedList(list []string) []string {
+	result := append([]string{}, list...)
+	sort.Strings(result)
+	return result
+}
+
+// selected checks whether a leaf path is selected for encryption.
+func (e *documentEncryption) selected(path []string) bool {
+	if e.regex != nil {
+		for _, step := range path {
+			if !strings.HasPrefix(step, "[") && e.regex.MatchString(step) {
+				return true
+			}
+		}
+	}
+	for _, p := range e.selection.Paths {
+		pattern := strings.Split(p, ".")
+		for i := len(path); i >= 0; i-- {
+			if matchSteps(pattern, path[:i]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchSteps(pattern, path []string) bool {
+	if len(pattern) == 0 {
+		return len(path) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(path); i++ {
+			if matchSteps(pattern[1:], path[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(path) == 0 || (pattern[0] != "*" && pattern[0] != path[0]) {
+		return false
+	}
+	return matchSteps(pattern[1:], path[1:])
+}
+
+// walk maps the leaf nodes of a document. Empty maps and lists are
+// kept as they are.
+func (e *documentEncryption) walk(node yaml.Node, path []string, f func([]string, yaml.Node) (yaml.Node, error)) (yaml.Node, error) {
+	switch v := node.Value().(type) {
+	case map[string]yaml.Node:
+		m := make(map[string]yaml.Node, len(v))
+		for k, n := range v {
+			r, err := e.walk(n, append(path[:len(path):len(path)], k), f)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = r
+		}
+		return yaml.SubstituteNode(m, node), nil
+	case []yaml.Node:
+		l := make([]yaml.Node, len(v))
+		for i, n := range v {
+			r, err := e.walk(n, append(path[:len(path):len(path)], fmt.Sprintf("[%d]", i)), f)
+			if err != nil {
+				return nil, err
+			}
+			l[i] = r
+		}
+		return yaml.SubstituteNode(l, node), nil
+	}
+	return f(path, node)
+}
+
+func marshalLeaf(node yaml.Node) (string, error) {
+	data, err := candiedyaml.Marshal(node)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func macEntry(path []string, text string) string {
+	p, _ := json.Marshal(path)
+	return string(p) + "\000" + text
+}
+
+// structureEntries provides the MAC entries for the maps and lists of a
+// document: the keys of the maps and the lengths of the lists. They
+// cover the paths without leaf values, like empty maps and lists.
+// The separator differs from the one of the leaf entries, so both
+// kinds of entries cannot be confused.
+func structureEntries(node yaml.Node, path []string) []string {
+	p, _ := json.Marshal(path)
+	entries := []string{}
+	switch v := node.Value().(type) {
+	case map[string]yaml.Node:
+		keys := []string{}
+		for k, n := range v {
+			keys = append(keys, k)
+			entries = append(entries, structureEntries(n, append(path[:len(path):len(path)], k))...)
+		}
+		sort.Strings(keys)
+		k, _ := json.Marshal(keys)
+		entries = append(entries, string(p)+"\001map"+string(k))
+	case []yaml.Node:
+		for i, n := range v {
+			entries = append(entries, structureEntries(n, append(path[:len(path):len(path)], fmt.Sprintf("[%d]", i)))...)
+		}
+		entries = append(entries, fmt.Sprintf("%s\001list%d", p, len(v)))
+	}
+	return entries
+}
+
+// documentMAC provides the keyed hash of the encryption settings, the
+// structure and all leaf values of a document.
+func (e *documentEncryption) documentMAC(entries []string) string {
+	sort.Strings(entries)
+	h := hmac.New(sha256.New, e.key)
+	h.Write(e.settings())
+	h.Write([]byte{0})
+	for _, entry := range entries {
+		h.Write([]byte(entry))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func copyMap(m map[string]yaml.Node) map[string]yaml.Node {
+	r := make(map[string]yaml.Node, len(m))
+	for k, v := range m {
+		r[k] = v
+	}
+	return r
+}
@@ -0,0 +1,58 @@
+package passwd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Ciphertexts of the authenticated encryption methods start with a
+// versioned header spiff:<version>:<method>: describing the layout of
+// the data and the method used for the encryption.
+const HEADER_PREFIX = "spiff:"
+const HEADER_VERSION = 1
+
+// Header provides the ciphertext header for an encryption method.
+func Header(method string) string {
+	return fmt.Sprintf("%s%d:%s:", HEADER_PREFIX, HEADER_VERSION, method)
+}
+
+// HasHeader checks whether a text starts with a ciphertext header.
+func HasHeader(text string) bool {
+	return strings.HasPrefix(strings.TrimSpace(text), HEADER_PREFIX)
+}
+
+// ParseHeader splits a ciphertext into the encryption method
+// described by its header and the payload.
+func ParseHeader(text string) (string, string, error) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, HEADER_PREFIX) {
+		return "", "", fmt.Errorf("missing ciphertext header")
+	}
+	fields := strings.SplitN(text[len(HEADER_PREFIX):], ":", 3)
+	if len(fields) != 3 {
+		return "", "", fmt.Errorf("invalid ciphertext header")
+	}
+	version, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return "", "", fmt.Errorf("invalid ciphertext header version %q", fields[0])
+	}
+	if version != HEADER_VERSION {
+		return "", "", fmt.Errorf("unsupported ciphertext header version %d", version)
+	}
+	return fields[1], fields[2], nil
+}
+
+// DetectMethod determines the encryption method used for a ciphertext.
+// Armored age files are encrypted with age, other ciphertexts without
+// header with 3DES.
+func DetectMethod(text string) (string, error) {
+	if IsAgeArmored(text) {
+		return AGE, nil
+	}
+	if !HasHeader(text) {
+		return TRIPPLEDES, nil
+	}
+	method, _, err := ParseHeader(text)
+	return method, err
+}
@@ -0,0 +1,150 @@
+package passwd
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/mandelsoft/spiff/dynaml"
+)
+
+const AES256GCM = "AES-256-GCM"
+const CHACHA20POLY1305 = "ChaCha20-Poly1305"
+
+const saltSize = 16
+
+// aeadEncoding is an authenticated encryption method with a key
+// derived from the password by a salted key derivation function.
+// The result is the ciphertext header followed by the base64 encoded
+// salt, nonce and sealed text. The header is authenticated, also.
+// With a key cache the derived keys are reused and all encryptions
+// with the same password share a salt.
+type aeadEncoding struct {
+	name   string
+	kdf    func(password string, salt []byte) ([]byte, error)
+	cipher func(key []byte) (cipher.AEAD, error)
+	cache  dynaml.KeyCache
+}
+
+var _ CachingEncoding = aeadEncoding{}
+
+func (e aeadEncoding) WithKeyCache(cache dynaml.KeyCache) Encoding {
+	e.cache = cache
+	return e
+}
+
+func (e aeadEncoding) Name() string {
+	return e.name
+}
+
+func (e aeadEncoding) Encode(text string, key string) (string, error) {
+	var salt []byte
+	var err error
+	if e.cache != nil {
+		salt, err = e.cache.EncryptionSalt(e.name, key, newSalt)
+	} else {
+		salt, err = newSalt()
+	}
+	if err != nil {
+		return "", err
+	}
+	aead, err := e.aead(key, salt)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	header := Header(e.name)
+	data := append(salt[:len(salt):len(salt)], nonce...)
+	data = aead.Seal(data, nonce, []byte(text), []byte(header))
+	return header + base64.RawStdEncoding.EncodeToString(data), nil
+}
+
+func (e aeadEncoding) Decode(text string, key string) (string, error) {
+	method, payload, err := ParseHeader(text)
+	if err != nil {
+		return "", err
+	}
+	if method != e.name {
+		return "", fmt.Errorf("data encrypted with %q instead of %q", method, e.name)
+	}
+	data, err := base64.RawStdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", fmt.Errorf("invalid ciphertext: %s", err)
+	}
+	if len(data) < saltSize {
+		return "", fmt.Errorf("ciphertext too short")
+	}
+	aead, err := e.aead(key, data[:saltSize])
+	if err != nil {
+		return "", err
+	}
+	data = data[saltSize:]
+	if len(data) < aead.NonceSize() {
+		return "", fmt.Errorf("ciphertext too short")
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(Header(e.name)))
+	if err != nil {
+		return "", fmt.Errorf("invalid key or corrupted data")
+	}
+	return string(plain), nil
+}
+
+func (e aeadEncoding) aead(key string, salt []byte) (cipher.AEAD, error) {
+	derive := func() ([]byte, error) {
+		return e.kdf(key, salt)
+	}
+	var k []byte
+	var err error
+	if e.cache != nil {
+		k, err = e.cache.DerivedKey(e.name, key, salt, derive)
+	} else {
+		k, err = derive()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return e.cipher(k)
+}
+
+func newSalt() ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+///////////////////////////////////////////////////////////////////////////////
+
+// scryptKey derives a 256 bit key with the recommended
+// scrypt parameters for interactive use.
+func scryptKey(password string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(password), salt, 1<<15, 8, 1, 32)
+}
+
+// argon2Key derives a 256 bit key with the argon2id parameters
+// recommended by RFC 9106.
+func argon2Key(password string, salt []byte) ([]byte, error) {
+	return argon2.IDKey([]byte(password), salt, 1, 64*1024, 4, chacha20poly1305.KeySize), nil
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(c)
+}
+
+var aes256gcm = aeadEncoding{name: AES256GCM, kdf: scryptKey, cipher: newAESGCM}
+var chacha20 = aeadEncoding{name: CHACHA20POLY1305, kdf: argon2Key, cipher: chacha20poly1305.New}
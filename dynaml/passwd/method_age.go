@@ -0,0 +1,118 @@
+package passwd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+
+	"github.com/mandelsoft/spiff/features"
+)
+
+// The age method uses the age file format (age-encryption.org/v1)
+// for X25519 recipients. The results are armored age files, which can
+// be decrypted by the age tools, also.
+
+const AGE = "age"
+
+type ageEncoding struct {
+}
+
+func (e ageEncoding) Name() string {
+	return AGE
+}
+
+// Encode encrypts a text for the recipients given by the key in
+// the format of an age recipients file.
+func (e ageEncoding) Encode(text string, key string) (string, error) {
+	recipients, err := age.ParseRecipients(strings.NewReader(key))
+	if err != nil {
+		return "", err
+	}
+	return AgeEncrypt([]byte(text), recipients...)
+}
+
+// Decode decrypts a text with the identities given by the key in
+// the format of an age identity file.
+func (e ageEncoding) Decode(text string, key string) (string, error) {
+	identities, err := age.ParseIdentities(strings.NewReader(key))
+	if err != nil {
+		return "", err
+	}
+	plain, err := AgeDecrypt([]byte(text), identities...)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// IdentityFiles provides the identity files configured by the
+// environment variable SPIFF_AGE_IDENTITY.
+func (e ageEncoding) IdentityFiles() []string {
+	return features.AgeIdentityFiles()
+}
+
+// recipientList provides the recipients given in the format of
+// an age recipients file.
+func recipientList(key string) []string {
+	result := []string{}
+	for _, line := range strings.Split(key, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			result = append(result, line)
+		}
+	}
+	return result
+}
+
+// IsAgeArmored checks whether a text is an armored age file.
+func IsAgeArmored(text string) bool {
+	return strings.HasPrefix(strings.TrimSpace(text), armor.Header)
+}
+
+// AgeEncrypt encrypts data for a set of recipients. The result is
+// an armored age file.
+func AgeEncrypt(plain []byte, recipients ...age.Recipient) (string, error) {
+	if len(recipients) == 0 {
+		return "", fmt.Errorf("no age recipient given")
+	}
+	var buf bytes.Buffer
+	a := armor.NewWriter(&buf)
+	w, err := age.Encrypt(a, recipients...)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write(plain); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	if err := a.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// AgeDecrypt decrypts an armored or binary age file with one of the
+// given identities.
+func AgeDecrypt(data []byte, identities ...age.Identity) ([]byte, error) {
+	var src io.Reader = bytes.NewReader(data)
+	if IsAgeArmored(string(data)) {
+		src = armor.NewReader(strings.NewReader(strings.TrimSpace(string(data)) + "\n"))
+	}
+	r, err := age.Decrypt(src, identities...)
+	if err != nil {
+		var nomatch *age.NoIdentityMatchError
+		if errors.As(err, &nomatch) {
+			return nil, fmt.Errorf("no matching age identity found")
+		}
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
@@ -2,9 +2,11 @@ package passwd
 
 import (
 	"fmt"
+	"strings"
 
 	. "github.com/mandelsoft/spiff/dynaml"
 	"github.com/mandelsoft/spiff/legacy/candiedyaml"
+	"github.com/mandelsoft/spiff/yaml"
 )
 
 type Encoding interface {
@@ -13,10 +15,37 @@ type Encoding interface {
 	Name() string
 }
 
+// PublicKeyEncoding is an Encoding using public keys (recipients) for the
+// encryption and private keys (identities) for the decryption. The key
+// used for Encode is a list of recipients, the key used for Decode is the
+// content of identity files.
+// The functions encrypt and decrypt take the recipients or identity
+// files as additional arguments following the method name.
+type PublicKeyEncoding interface {
+	Encoding
+	// IdentityFiles provides the identity files used for the
+	// decryption if no files are given.
+	IdentityFiles() []string
+}
+
+// CachingEncoding is optionally implemented by an Encoding deriving its
+// keys from passwords. It is used with the key cache of the processing
+// state, if available.
+type CachingEncoding interface {
+	Encoding
+	WithKeyCache(cache KeyCache) Encoding
+}
+
 var encodings = map[string]Encoding{
-	TRIPPLEDES: des1{},
+	TRIPPLEDES:       des1{},
+	AES256GCM:        aes256gcm,
+	CHACHA20POLY1305: chacha20,
+	AGE:              ageEncoding{},
 }
 
+// DEFAULT_METHOD is the encryption method used if no method is given.
+const DEFAULT_METHOD = AES256GCM
+
 const F_Decrypt = "decrypt"
 const F_Encrypt = "encrypt"
 
@@ -33,9 +62,84 @@ func GetEncoding(name string) Encoding {
 	return encodings[name]
 }
 
+// GetPublicKeyEncoding provides the public key encoding for a method
+// argument, or nil if the argument does not name such an encoding.
+func GetPublicKeyEncoding(arg interface{}) PublicKeyEncoding {
+	if name, ok := arg.(string); ok {
+		if e, ok := encodings[name].(PublicKeyEncoding); ok {
+			return e
+		}
+	}
+	return nil
+}
+
+// keyCache provides the key cache of the processing state, if available.
+func keyCache(binding Binding) KeyCache {
+	if binding != nil {
+		if c, ok := binding.GetState().(KeyCache); ok {
+			return c
+		}
+	}
+	return nil
+}
+
+// cachedEncoding provides the encoding for a method using the given
+// key cache, if possible.
+func cachedEncoding(method string, cache KeyCache) Encoding {
+	e := GetEncoding(method)
+	if c, ok := e.(CachingEncoding); ok && cache != nil {
+		return c.WithKeyCache(cache)
+	}
+	return e
+}
+
+// Encrypt encrypts a text with the given method. If no method
+// is given, the default method is used.
+func Encrypt(text, key, method string) (string, error) {
+	return encrypt(text, key, method, nil)
+}
+
+func encrypt(text, key, method string, cache KeyCache) (string, error) {
+	if method == "" {
+		method = DEFAULT_METHOD
+	}
+	e := cachedEncoding(method, cache)
+	if e == nil {
+		return "", fmt.Errorf("invalid encyption method %q", method)
+	}
+	if key == "" {
+		return "", fmt.Errorf("invalid empty encyption key")
+	}
+	return e.Encode(text, key)
+}
+
+// Decrypt decrypts a text with the given method. If no method
+// is given, it is determined by the ciphertext header.
+func Decrypt(text, key, method string) (string, error) {
+	return decrypt(text, key, method, nil)
+}
+
+func decrypt(text, key, method string, cache KeyCache) (string, error) {
+	if method == "" {
+		m, err := DetectMethod(text)
+		if err != nil {
+			return "", err
+		}
+		method = m
+	}
+	e := cachedEncoding(method, cache)
+	if e == nil {
+		return "", fmt.Errorf("invalid encyption method %q", method)
+	}
+	if key == "" {
+		return "", fmt.Errorf("invalid empty encyption key")
+	}
+	return e.Decode(text, key)
+}
+
 func func_decrypt(arguments []interface{}, binding Binding) (interface{}, EvaluationInfo, bool) {
 	info := DefaultInfo()
-	if len(arguments) < 1 || len(arguments) > 3 {
+	if len(arguments) < 1 || (len(arguments) > 3 && GetPublicKeyEncoding(arguments[1]) == nil) {
 		return info.Error("%s expects one, two or three arguments", F_Decrypt)
 	}
 
@@ -44,8 +148,28 @@ func func_decrypt(arguments []interface{}, binding Binding) (interface{}, Evalua
 		return info.Error(err)
 	}
 
+	var e PublicKeyEncoding
+	var files []interface{}
+	if len(arguments) > 1 {
+		e = GetPublicKeyEncoding(arguments[1])
+		files = arguments[2:]
+	} else if method, err := DetectMethod(value); err == nil {
+		e = GetPublicKeyEncoding(method)
+	}
+	if e != nil {
+		key, err := readIdentities(e, files, binding)
+		if err != nil {
+			return info.Error("%s: %s", F_Decrypt, err)
+		}
+		result, err := e.Decode(value, key)
+		if err != nil {
+			return info.Error(err)
+		}
+		return Sensitive(ParseData("<decrypt>", []byte(result), "import", binding))
+	}
+
 	key := binding.GetState().GetEncryptionKey()
-	method := TRIPPLEDES
+	method := ""
 	v := ""
 	if len(arguments) > 1 {
 		v, err = StringValue(fmt.Sprintf("%s: 2nd argument", F_Decrypt), arguments[1])
@@ -66,28 +190,21 @@ func func_decrypt(arguments []interface{}, binding Binding) (interface{}, Evalua
 		if err != nil {
 			return info.Error(err)
 		}
+		key = v
 		method = m
 	}
 
-	e := encodings[method]
-	if e == nil {
-		return info.Error("invalid encyption method %q", method)
-	}
-
-	if key == "" {
-		return info.Error("invalid empty encyption key")
-	}
-	result, err := e.Decode(value, key)
+	result, err := decrypt(value, key, method, keyCache(binding))
 	if err != nil {
 		return info.Error(err)
 	}
-	return ParseData("<decrypt>", []byte(result), "import", binding)
+	return Sensitive(ParseData("<decrypt>", []byte(result), "import", binding))
 
 }
 
 func func_encrypt(arguments []interface{}, binding Binding) (interface{}, EvaluationInfo, bool) {
 	info := DefaultInfo()
-	if len(arguments) < 1 || len(arguments) > 3 {
+	if len(arguments) < 1 || (len(arguments) > 3 && GetPublicKeyEncoding(arguments[1]) == nil) {
 		return info.Error("%s expects one, two or three arguments", F_Encrypt)
 	}
 
@@ -96,8 +213,25 @@ func func_encrypt(arguments []interface{}, binding Binding) (interface{}, Evalua
 		return info.Error(err)
 	}
 
+	if len(arguments) > 1 {
+		if e := GetPublicKeyEncoding(arguments[1]); e != nil {
+			recipients, err := stringList(fmt.Sprintf("%s: recipient", F_Encrypt), arguments[2:])
+			if err != nil {
+				return info.Error(err)
+			}
+			if len(recipients) == 0 {
+				return info.Error("%s: method %s requires at least one recipient", F_Encrypt, e.Name())
+			}
+			result, err := e.Encode(string(value), strings.Join(recipients, "\n"))
+			if err != nil {
+				return info.Error(err)
+			}
+			return NonSensitive(result, info, true)
+		}
+	}
+
 	key := binding.GetState().GetEncryptionKey()
-	method := TRIPPLEDES
+	method := DEFAULT_METHOD
 	v := ""
 	if len(arguments) > 1 {
 		v, err = StringValue(fmt.Sprintf("%s: 2nd argument", F_Encrypt), arguments[1])
@@ -118,20 +252,64 @@ func func_encrypt(arguments []interface{}, binding Binding) (interface{}, Evalua
 		if err != nil {
 			return info.Error(err)
 		}
+		key = v
 		method = m
 	}
 
-	e := GetEncoding(method)
-	if e == nil {
-		return info.Error("invalid encyption method %q", method)
+	result, err := encrypt(string(value), key, method, keyCache(binding))
+	if err != nil {
+		return info.Error(err)
 	}
+	return NonSensitive(result, info, true)
+}
 
-	if key == "" {
-		return info.Error("invalid empty encyption key")
-	}
-	result, err := e.Encode(string(value), key)
+// readIdentities reads the content of the given identity files or the
+// default identity files of a public key encoding.
+func readIdentities(e PublicKeyEncoding, args []interface{}, binding Binding) (string, error) {
+	files, err := stringList("identity file", args)
 	if err != nil {
-		return info.Error(err)
+		return "", err
+	}
+	if len(files) == 0 {
+		files = e.IdentityFiles()
+	}
+	if len(files) == 0 {
+		return "", fmt.Errorf("no identity file given for method %s", e.Name())
+	}
+	if !binding.GetState().FileAccessAllowed() {
+		return "", fmt.Errorf("reading identity files not allowed")
+	}
+	key := ""
+	for _, f := range files {
+		data, err := binding.GetFileContent(f, true)
+		if err != nil {
+			return "", fmt.Errorf("identity file %q: %s", f, err)
+		}
+		key += string(data) + "\n"
+	}
+	return key, nil
+}
+
+// stringList provides the strings given by a list of arguments, which
+// are strings or lists of strings.
+func stringList(msg string, args []interface{}) ([]string, error) {
+	result := []string{}
+	for _, a := range args {
+		if list, ok := a.([]yaml.Node); ok {
+			for _, e := range list {
+				s, err := StringValue(msg, e.Value())
+				if err != nil {
+					return nil, err
+				}
+				result = append(result, s)
+			}
+			continue
+		}
+		s, err := StringValue(msg, a)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, s)
 	}
-	return result, info, true
+	return result, nil
 }
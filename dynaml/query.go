@@ -0,0 +1,29 @@
+package dynaml
+
+import (
+	"github.com/mandelsoft/spiff/yaml"
+)
+
+func init() {
+	RegisterFunction("query", func_query)
+}
+
+func func_query(arguments []interface{}, binding Binding) (interface{}, EvaluationInfo, bool) {
+	info := DefaultInfo()
+
+	if len(arguments) != 2 {
+		return info.Error("query takes exactly two arguments")
+	}
+	path, ok := arguments[1].(string)
+	if !ok {
+		return info.Error("path string for argument two of function query required")
+	}
+	q, err := yaml.ParseJSONPath(path)
+	if err != nil {
+		return info.Error("query: %s", err)
+	}
+	if arguments[0] == nil {
+		return []yaml.Node{}, info, true
+	}
+	return q.Evaluate(NewNode(arguments[0], binding), binding.GetFeatures()), info, true
+}
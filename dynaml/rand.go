@@ -74,5 +74,5 @@ func func_rand(arguments []interface{}, binding Binding) (interface{}, Evaluatio
 		}
 	}
 
-	return result, info, true
+	return Sensitive(result, info, true)
 }
@@ -127,6 +127,9 @@ func ParseData(file string, data []byte, mode string, binding Binding) (interfac
 		return Base64Encode(data, 60), info, true
 
 	default:
+		if data_parsers[mode] != nil {
+			return parseDataFormat(file, data, mode)
+		}
 		return info.Error("invalid file type [%s] %s", path.Clean(file), mode)
 	}
 }
@@ -109,6 +109,10 @@ func (e ReferenceExpr) find(f func(int, []string) (node yaml.Node, x bool), bind
 		if !ok {
 			return info.Error("'%s' not found", strings.Join(e.Path[0:i+1], "."))
 		}
+		if step.Sensitive() {
+			// values of sensitive nodes are sensitive, too
+			info.SetSensitive()
+		}
 
 		if !isLocallyResolved(step, binding) {
 			debug.Debug("  locally unresolved %T\n", step.Value())
@@ -131,6 +135,7 @@ func (e ReferenceExpr) find(f func(int, []string) (node yaml.Node, x bool), bind
 
 	debug.Debug("reference %v -> %+v\n", e.Path, step)
 	info.KeyName = step.KeyName()
+	info.KeyOrder = step.KeyOrder()
 	return value(yaml.ReferencedNode(step)), info, true
 }
 
@@ -0,0 +1,180 @@
+package dynaml
+
+import (
+	"regexp"
+	"strconv"
+
+	"github.com/mandelsoft/spiff/yaml"
+)
+
+func init() {
+	RegisterFunction("match_all", func_match_all)
+	RegisterFunction("match_named", func_match_named)
+	RegisterFunction("regex_escape", func_regex_escape)
+	RegisterFunction("grep", func_grep)
+}
+
+// RegexpCache is optionally implemented by a State to reuse
+// compiled regular expressions.
+type RegexpCache interface {
+	Regexp(pattern string) (*regexp.Regexp, error)
+}
+
+// CompileRegexp compiles a regular expression using the cache of the
+// processing state, if available.
+func CompileRegexp(pattern string, binding Binding) (*regexp.Regexp, error) {
+	if binding != nil {
+		if c, ok := binding.GetState().(RegexpCache); ok {
+			return c.Regexp(pattern)
+		}
+	}
+	return regexp.Compile(pattern)
+}
+
+// simpleString provides the string representation of a simple value.
+func simpleString(v interface{}) (string, bool) {
+	switch s := v.(type) {
+	case string:
+		return s, true
+	case int64:
+		return strconv.FormatInt(s, 10), true
+	case bool:
+		return strconv.FormatBool(s), true
+	default:
+		return "", false
+	}
+}
+
+func func_match_all(arguments []interface{}, binding Binding) (interface{}, EvaluationInfo, bool) {
+	info := DefaultInfo()
+
+	if len(arguments) != 2 {
+		return info.Error("match_all takes two arguments")
+	}
+	pattern, ok := arguments[0].(string)
+	if !ok {
+		return info.Error("pattern string for argument one of function match_all required")
+	}
+	if arguments[1] == nil {
+		return []yaml.Node{}, info, true
+	}
+	elem, ok := simpleString(arguments[1])
+	if !ok {
+		return info.Error("simple value for argument two of function match_all required")
+	}
+	re, err := CompileRegexp(pattern, binding)
+	if err != nil {
+		return info.Error("match_all: %s", err)
+	}
+	return MakeStringList(re.FindAllString(elem, -1), info), info, true
+}
+
+func func_match_named(arguments []interface{}, binding Binding) (interface{}, EvaluationInfo, bool) {
+	info := DefaultInfo()
+
+	if len(arguments) < 2 || len(arguments) > 3 {
+		return info.Error("match_named takes two or three arguments")
+	}
+	pattern, ok := arguments[0].(string)
+	if !ok {
+		return info.Error("pattern string for argument one of function match_named required")
+	}
+	all := false
+	if len(arguments) == 3 {
+		all, ok = arguments[2].(bool)
+		if !ok {
+			return info.Error("boolean value for argument three of function match_named required")
+		}
+	}
+	re, err := CompileRegexp(pattern, binding)
+	if err != nil {
+		return info.Error("match_named: %s", err)
+	}
+
+	elem := ""
+	if arguments[1] != nil {
+		elem, ok = simpleString(arguments[1])
+		if !ok {
+			return info.Error("simple value for argument two of function match_named required")
+		}
+	}
+
+	groups := func(match []string) map[string]yaml.Node {
+		result := map[string]yaml.Node{}
+		for i, n := range re.SubexpNames() {
+			if n != "" && i < len(match) {
+				result[n] = NewNode(match[i], info)
+			}
+		}
+		return result
+	}
+
+	if all {
+		list := []yaml.Node{}
+		if arguments[1] != nil {
+			for _, m := range re.FindAllStringSubmatch(elem, -1) {
+				list = append(list, NewNode(groups(m), info))
+			}
+		}
+		return list, info, true
+	}
+	if arguments[1] == nil {
+		return map[string]yaml.Node{}, info, true
+	}
+	return groups(re.FindStringSubmatch(elem)), info, true
+}
+
+func func_regex_escape(arguments []interface{}, binding Binding) (interface{}, EvaluationInfo, bool) {
+	info := DefaultInfo()
+
+	if len(arguments) != 1 {
+		return info.Error("regex_escape takes exactly one argument")
+	}
+	str, ok := simpleString(arguments[0])
+	if !ok {
+		return info.Error("simple value for argument of function regex_escape required")
+	}
+	return regexp.QuoteMeta(str), info, true
+}
+
+func func_grep(arguments []interface{}, binding Binding) (interface{}, EvaluationInfo, bool) {
+	info := DefaultInfo()
+
+	if len(arguments) < 2 || len(arguments) > 3 {
+		return info.Error("grep takes two or three arguments")
+	}
+	list, ok := arguments[0].([]yaml.Node)
+	if !ok {
+		return info.Error("list for argument one of function grep required")
+	}
+	pattern, ok := arguments[1].(string)
+	if !ok {
+		return info.Error("pattern string for argument two of function grep required")
+	}
+	invert := false
+	if len(arguments) == 3 {
+		invert, ok = arguments[2].(bool)
+		if !ok {
+			return info.Error("boolean value for argument three of function grep required")
+		}
+	}
+	re, err := CompileRegexp(pattern, binding)
+	if err != nil {
+		return info.Error("grep: %s", err)
+	}
+
+	result := []yaml.Node{}
+	for i, e := range list {
+		var s string
+		if e != nil && e.Value() != nil {
+			s, ok = simpleString(e.Value())
+			if !ok {
+				return info.Error("grep: list entry %d is no simple value", i)
+			}
+		}
+		if re.MatchString(s) != invert {
+			result = append(result, e)
+		}
+	}
+	return result, info, true
+}
@@ -42,6 +42,17 @@ func (r *registry) LookupFunction(name string) Function {
 	return r.functions.LookupFunction(name)
 }
 
+// FunctionNames provides the sorted names of the non-builtin functions
+// known by a registry.
+func FunctionNames(r Registry) []string {
+	if reg, ok := r.(*registry); ok && reg != nil && reg.functions != nil {
+		if f, ok := reg.functions.(*functionRegistry); ok {
+			return f.names()
+		}
+	}
+	return function_registry.(*functionRegistry).names()
+}
+
 func (r *registry) LookupControl(name string) (*Control, bool) {
 	if r == nil || r.controls == nil {
 		return control_registry.LookupControl(name)
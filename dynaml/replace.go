@@ -35,7 +35,7 @@ func func_replaceMatch(arguments []interface{}, binding Binding) (interface{}, E
 
 func ReplaceRegExp(str string, src string, dst interface{}, cnt int, binding Binding) (bool, string, error) {
 	var expand Expander
-	exp, err := regexp.Compile(src)
+	exp, err := CompileRegexp(src, binding)
 	if err != nil {
 		return false, "", err
 	}
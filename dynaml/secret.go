@@ -0,0 +1,70 @@
+package dynaml
+
+import (
+	"sync"
+)
+
+// SecretProvider resolves references to secrets managed
+// outside of the processed documents.
+type SecretProvider interface {
+	GetSecret(ref string, binding Binding) (string, error)
+}
+
+// SecretProviders is optionally implemented by a State to
+// provide additional or replaced secret providers.
+type SecretProviders interface {
+	GetSecretProvider(name string) SecretProvider
+}
+
+func init() {
+	RegisterFunction("secret", func_secret)
+}
+
+var secretLock sync.RWMutex
+var secret_providers = map[string]SecretProvider{}
+
+// RegisterSecretProvider registers a secret provider available
+// for all processings.
+func RegisterSecretProvider(name string, p SecretProvider) {
+	secretLock.Lock()
+	defer secretLock.Unlock()
+	secret_providers[name] = p
+}
+
+// LookupSecretProvider provides the secret provider for a name
+// used by the given processing state.
+func LookupSecretProvider(name string, state State) SecretProvider {
+	if s, ok := state.(SecretProviders); ok {
+		if p := s.GetSecretProvider(name); p != nil {
+			return p
+		}
+	}
+	secretLock.RLock()
+	defer secretLock.RUnlock()
+	return secret_providers[name]
+}
+
+func func_secret(arguments []interface{}, binding Binding) (interface{}, EvaluationInfo, bool) {
+	info := DefaultInfo()
+
+	if len(arguments) != 2 {
+		return info.Error("secret requires two arguments")
+	}
+	name, ok := arguments[0].(string)
+	if !ok {
+		return info.Error("secret provider name must be a string")
+	}
+	ref, ok := arguments[1].(string)
+	if !ok {
+		return info.Error("secret reference must be a string")
+	}
+	p := LookupSecretProvider(name, binding.GetState())
+	if p == nil {
+		return info.Error("unknown secret provider %q", name)
+	}
+	value, err := p.GetSecret(ref, binding)
+	if err != nil {
+		return info.Error("secret %s(%s): %s", name, ref, err)
+	}
+	return Sensitive(value, info, true)
+}
@@ -0,0 +1,24 @@
+package secrets
+
+import (
+	"fmt"
+	"os"
+
+	. "github.com/mandelsoft/spiff/dynaml"
+)
+
+type envProvider struct{}
+
+// NewEnvProvider provides a secret provider resolving
+// references to environment variables.
+func NewEnvProvider() SecretProvider {
+	return envProvider{}
+}
+
+func (envProvider) GetSecret(ref string, binding Binding) (string, error) {
+	value, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", fmt.Errorf("environment variable %q not set", ref)
+	}
+	return value, nil
+}
@@ -0,0 +1,29 @@
+package secrets
+
+import (
+	"fmt"
+	"strings"
+
+	. "github.com/mandelsoft/spiff/dynaml"
+)
+
+type fileProvider struct{}
+
+// NewFileProvider provides a secret provider resolving references
+// to files in the virtual filesystem of the processing. A single
+// trailing newline of the file content is removed.
+func NewFileProvider() SecretProvider {
+	return fileProvider{}
+}
+
+func (fileProvider) GetSecret(ref string, binding Binding) (string, error) {
+	if !binding.GetState().FileAccessAllowed() {
+		return "", fmt.Errorf("file access not allowed")
+	}
+	data, err := binding.GetFileContent(ref, false)
+	if err != nil {
+		return "", err
+	}
+	s := strings.TrimSuffix(string(data), "\n")
+	return strings.TrimSuffix(s, "\r"), nil
+}
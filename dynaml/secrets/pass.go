@@ -0,0 +1,148 @@
+package secrets
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+
+	. "github.com/mandelsoft/spiff/dynaml"
+	"github.com/mandelsoft/spiff/dynaml/passwd"
+	"github.com/mandelsoft/spiff/features"
+)
+
+// PassProvider resolves references to entries of a password store as
+// used by pass or gopass. An entry <name> is stored in the file
+// <name>.gpg, encrypted with gpg, or <name>.age, encrypted with age.
+// The first line of an entry is the password, further lines may
+// describe additional fields (<field>: <value>). A reference
+// <name>#<field> resolves such a field instead of the password.
+type PassProvider struct {
+	dir        string
+	identities []string
+}
+
+var _ SecretProvider = &PassProvider{}
+
+// NewPassProvider provides a secret provider for a password store
+// directory. If no directory is given, the environment variable
+// PASSWORD_STORE_DIR or the default store ~/.password-store is used.
+// age encrypted entries are decrypted with the given identity files,
+// or with the files configured by SPIFF_AGE_IDENTITY.
+func NewPassProvider(dir string, identities ...string) *PassProvider {
+	return &PassProvider{dir: dir, identities: identities}
+}
+
+// Dir provides the store directory used by the provider.
+func (p *PassProvider) Dir() string {
+	if p.dir != "" {
+		return p.dir
+	}
+	if dir := os.Getenv("PASSWORD_STORE_DIR"); dir != "" {
+		return dir
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".password-store"
+	}
+	return filepath.Join(home, ".password-store")
+}
+
+func (p *PassProvider) GetSecret(ref string, binding Binding) (string, error) {
+	state := binding.GetState()
+	if !state.FileAccessAllowed() {
+		return "", fmt.Errorf("file access not allowed")
+	}
+	name, field := ref, ""
+	if i := strings.LastIndex(ref, "#"); i >= 0 {
+		name, field = ref[:i], ref[i+1:]
+	}
+	if name == "" || strings.Contains("/"+name+"/", "/../") {
+		return "", fmt.Errorf("invalid entry name %q", name)
+	}
+
+	fs := state.FileSystem()
+	path := fs.Join(p.Dir(), name)
+	var data []byte
+	if ok, _ := fs.IsFile(path + ".age"); ok {
+		d, err := p.decryptAge(path+".age", binding)
+		if err != nil {
+			return "", err
+		}
+		data = d
+	} else if ok, _ := fs.IsFile(path + ".gpg"); ok {
+		d, err := p.decryptGPG(path+".gpg", binding)
+		if err != nil {
+			return "", err
+		}
+		data = d
+	} else {
+		return "", fmt.Errorf("entry %q not found", name)
+	}
+	return passField(string(data), field)
+}
+
+func (p *PassProvider) decryptAge(path string, binding Binding) ([]byte, error) {
+	files := p.identities
+	if len(files) == 0 {
+		files = features.AgeIdentityFiles()
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no age identity file configured")
+	}
+	ids := []age.Identity{}
+	for _, f := range files {
+		data, err := binding.GetFileContent(f, true)
+		if err != nil {
+			return nil, fmt.Errorf("identity file %q: %s", f, err)
+		}
+		list, err := age.ParseIdentities(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("identity file %q: %s", f, err)
+		}
+		ids = append(ids, list...)
+	}
+	data, err := binding.GetFileContent(path, false)
+	if err != nil {
+		return nil, err
+	}
+	return passwd.AgeDecrypt(data, ids...)
+}
+
+func (p *PassProvider) decryptGPG(path string, binding Binding) ([]byte, error) {
+	if !binding.GetState().OSAccessAllowed() {
+		return nil, fmt.Errorf("gpg execution not allowed")
+	}
+	data, err := binding.GetFileContent(path, false)
+	if err != nil {
+		return nil, err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("gpg", "--quiet", "--batch", "--decrypt")
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("gpg: %s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// passField extracts the password (first line) or a dedicated
+// field of a password store entry.
+func passField(content, field string) (string, error) {
+	lines := strings.Split(strings.Replace(content, "\r\n", "\n", -1), "\n")
+	if field == "" {
+		return lines[0], nil
+	}
+	for _, l := range lines[1:] {
+		if i := strings.Index(l, ":"); i > 0 && strings.TrimSpace(l[:i]) == field {
+			return strings.TrimSpace(l[i+1:]), nil
+		}
+	}
+	return "", fmt.Errorf("field %q not found", field)
+}
@@ -0,0 +1,27 @@
+package secrets
+
+import (
+	. "github.com/mandelsoft/spiff/dynaml"
+)
+
+// Names of the built-in secret providers
+const (
+	ENV   = "env"
+	FILE  = "file"
+	PASS  = "pass"
+	VAULT = "vault"
+)
+
+func init() {
+	RegisterSecretProvider(ENV, NewEnvProvider())
+	RegisterSecretProvider(FILE, NewFileProvider())
+	RegisterSecretProvider(PASS, NewPassProvider(""))
+	RegisterSecretProvider(VAULT, NewVaultProvider("", ""))
+}
+
+// SecretProviderFunc is a function usable as SecretProvider.
+type SecretProviderFunc func(ref string, binding Binding) (string, error)
+
+func (f SecretProviderFunc) GetSecret(ref string, binding Binding) (string, error) {
+	return f(ref, binding)
+}
@@ -0,0 +1,138 @@
+package secrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	. "github.com/mandelsoft/spiff/dynaml"
+)
+
+// VaultProvider resolves references to secrets of a key/value secrets
+// engine (version 2) provided by an HTTP server with a Vault compatible
+// API. A reference has the form <mount>/<path>#<key>. The key may be
+// omitted for secrets with a single key.
+type VaultProvider struct {
+	addr   string
+	token  string
+	client *http.Client
+}
+
+var _ SecretProvider = &VaultProvider{}
+
+// NewVaultProvider provides a secret provider for a Vault compatible
+// server. If no address or token is given, the environment variables
+// VAULT_ADDR and VAULT_TOKEN are used.
+func NewVaultProvider(addr, token string) *VaultProvider {
+	return &VaultProvider{
+		addr:   addr,
+		token:  token,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// WithClient provides a provider using the given HTTP client.
+func (p *VaultProvider) WithClient(c *http.Client) *VaultProvider {
+	n := *p
+	n.client = c
+	return &n
+}
+
+func (p *VaultProvider) GetSecret(ref string, binding Binding) (string, error) {
+	if !binding.GetState().OSAccessAllowed() {
+		return "", fmt.Errorf("network access not allowed")
+	}
+	addr := p.addr
+	if addr == "" {
+		addr = os.Getenv("VAULT_ADDR")
+	}
+	if addr == "" {
+		return "", fmt.Errorf("no vault address configured")
+	}
+	token := p.token
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+
+	path, key := ref, ""
+	if i := strings.LastIndex(ref, "#"); i >= 0 {
+		path, key = ref[:i], ref[i+1:]
+	}
+	path = strings.Trim(path, "/")
+	i := strings.Index(path, "/")
+	if i <= 0 {
+		return "", fmt.Errorf("reference must have the form <mount>/<path>[#<key>]")
+	}
+	url := fmt.Sprintf("%s/v1/%s/data/%s", strings.TrimRight(addr, "/"), path[:i], path[i+1:])
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	var result struct {
+		Errors []string `json:"errors"`
+		Data   struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil && resp.StatusCode == http.StatusOK {
+		return "", fmt.Errorf("invalid response: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if len(result.Errors) > 0 {
+			return "", fmt.Errorf("%s: %s", resp.Status, strings.Join(result.Errors, ", "))
+		}
+		return "", fmt.Errorf("%s", resp.Status)
+	}
+	data := result.Data.Data
+	if key == "" {
+		if len(data) != 1 {
+			keys := []string{}
+			for k := range data {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			return "", fmt.Errorf("key required to select one of [%s]", strings.Join(keys, ", "))
+		}
+		for k := range data {
+			key = k
+		}
+	}
+	v, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("key %q not found", key)
+	}
+	switch s := v.(type) {
+	case string:
+		return s, nil
+	case nil:
+		return "", nil
+	default:
+		d, err := json.Marshal(s)
+		if err != nil {
+			return "", err
+		}
+		return string(d), nil
+	}
+}
@@ -0,0 +1,60 @@
+package dynaml
+
+import (
+	"github.com/mandelsoft/spiff/yaml"
+)
+
+// Sensitive marks the result of a successful evaluation as sensitive.
+// The flag is propagated to all values derived from it, and such
+// values are never shown in clear text in diagnostic output.
+func Sensitive(value interface{}, info EvaluationInfo, ok bool) (interface{}, EvaluationInfo, bool) {
+	if ok && !IsExpression(value) {
+		info.SetSensitive()
+	}
+	return value, info, ok
+}
+
+// NonSensitive marks the result of a successful function call as not
+// sensitive, even if it is derived from sensitive arguments, for example
+// a public key or a ciphertext.
+func NonSensitive(value interface{}, info EvaluationInfo, ok bool) (interface{}, EvaluationInfo, bool) {
+	if ok {
+		info.Declassified = true
+	}
+	return value, info, ok
+}
+
+// SensitiveValues is optionally implemented by a State to keep the
+// sensitive values to be redacted in its diagnostic output.
+type SensitiveValues interface {
+	AddSensitive(values ...string)
+}
+
+// RedactValue registers the string values of a sensitive value to be
+// redacted in the diagnostic output of the processing state of a binding.
+func RedactValue(value interface{}, binding Binding) {
+	if binding == nil {
+		return
+	}
+	if s, ok := binding.GetState().(SensitiveValues); ok {
+		if values := sensitiveStrings(value, nil); len(values) > 0 {
+			s.AddSensitive(values...)
+		}
+	}
+}
+
+func sensitiveStrings(value interface{}, values []string) []string {
+	switch v := value.(type) {
+	case string:
+		values = append(values, v)
+	case []yaml.Node:
+		for _, e := range v {
+			values = sensitiveStrings(e.Value(), values)
+		}
+	case map[string]yaml.Node:
+		for _, e := range v {
+			values = sensitiveStrings(e.Value(), values)
+		}
+	}
+	return values
+}
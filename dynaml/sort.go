@@ -16,6 +16,8 @@ func func_sort(arguments []interface{}, binding Binding) (result interface{}, in
 	if !ok {
 		return info.Error("argument for sort must be a list")
 	}
+	// sort a copy, the argument may still be referenced by the document
+	list = append([]yaml.Node{}, list...)
 
 	var less Less
 
@@ -1,7 +1,6 @@
 package dynaml
 
 import (
-	"regexp"
 	"strings"
 
 	"github.com/mandelsoft/spiff/yaml"
@@ -90,7 +89,7 @@ func func_splitMatch(arguments []interface{}, binding Binding) (interface{}, Eva
 		n = int(m)
 	}
 
-	exp, err := regexp.Compile(sep)
+	exp, err := CompileRegexp(sep, binding)
 	if err != nil {
 		return info.Error("split_match: %s", err)
 	}
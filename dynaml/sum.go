@@ -44,6 +44,7 @@ func (e SumExpr) Evaluate(binding Binding, locally bool) (interface{}, Evaluatio
 
 	debug.Debug("map: using lambda %+v\n", lambda)
 	var result interface{}
+	sensitive := info.Sensitive()
 	switch value.(type) {
 	case []yaml.Node:
 		resolved, result, info, ok = sumList(inline, value.([]yaml.Node), lambda, initial, binding)
@@ -60,6 +61,9 @@ func (e SumExpr) Evaluate(binding Binding, locally bool) (interface{}, Evaluatio
 	if !resolved {
 		return e, info, true
 	}
+	if sensitive {
+		info.SetSensitive()
+	}
 	debug.Debug("sum: --> %+v\n", result)
 	return result, info, true
 }
@@ -77,6 +81,7 @@ func sumList(inline bool, source []yaml.Node, e LambdaValue, initial interface{}
 	inp := make([]interface{}, len(e.lambda.Parameters))
 	result := initial
 	info := DefaultInfo()
+	sensitive := false
 
 	if len(e.lambda.Parameters) > 3 {
 		info.SetError("mapping expression take a maximum of 3 arguments")
@@ -93,6 +98,7 @@ func sumList(inline bool, source []yaml.Node, e LambdaValue, initial interface{}
 		inp[1] = i
 		inp[len(inp)-1] = n.Value()
 		resolved, mapped, info, ok := e.Evaluate(inline, false, false, nil, inp, binding, false)
+		sensitive = sensitive || n.Sensitive() || info.Sensitive()
 		if !ok {
 			debug.Debug("sum:  %d %+v: failed\n", i, n)
 			return true, nil, info, false
@@ -109,6 +115,9 @@ func sumList(inline bool, source []yaml.Node, e LambdaValue, initial interface{}
 		result = mapped
 	}
 	debug.Debug("sum:  result: %+v\n", result)
+	if sensitive {
+		info.SetSensitive()
+	}
 	return true, result, info, true
 }
 
@@ -116,6 +125,7 @@ func sumMap(inline bool, source map[string]yaml.Node, e LambdaValue, initial int
 	inp := make([]interface{}, len(e.lambda.Parameters))
 	result := initial
 	info := DefaultInfo()
+	sensitive := false
 
 	keys := getSortedKeys(source)
 	for _, k := range keys {
@@ -125,6 +135,7 @@ func sumMap(inline bool, source map[string]yaml.Node, e LambdaValue, initial int
 		inp[1] = k
 		inp[len(inp)-1] = n.Value()
 		resolved, mapped, info, ok := e.Evaluate(inline, false, false, nil, inp, binding, false)
+		sensitive = sensitive || n.Sensitive() || info.Sensitive()
 		if !ok {
 			debug.Debug("map:  %s %+v: failed\n", k, n)
 			return true, nil, info, false
@@ -140,5 +151,8 @@ func sumMap(inline bool, source map[string]yaml.Node, e LambdaValue, initial int
 		debug.Debug("map:  %s --> %+v\n", k, mapped)
 		result = mapped
 	}
+	if sensitive {
+		info.SetSensitive()
+	}
 	return true, result, info, true
 }
@@ -0,0 +1,259 @@
+package dynaml
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mandelsoft/spiff/yaml"
+)
+
+func init() {
+	RegisterFunction("now", func_now)
+	RegisterFunction("parsetime", func_parsetime)
+	RegisterFunction("formattime", func_formattime)
+	RegisterFunction("addduration", func_addduration)
+	RegisterFunction("duration", func_duration)
+}
+
+// Clock provides the current time used by the function now.
+// It is optionally implemented by a State.
+type Clock interface {
+	Now() time.Time
+}
+
+// FixedClock is a clock always providing the same time.
+type FixedClock time.Time
+
+func (c FixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+// CurrentTime provides the current time according to the clock of the
+// processing state.
+func CurrentTime(binding Binding) time.Time {
+	if c, ok := binding.GetState().(Clock); ok {
+		return c.Now()
+	}
+	return time.Now()
+}
+
+// TimeValue is the value of the dynaml type time. It is marshalled
+// as RFC3339 string.
+type TimeValue struct {
+	Time time.Time
+}
+
+var _ yaml.ComparableValue = TimeValue{}
+
+func (t TimeValue) String() string {
+	return t.Time.Format(time.RFC3339Nano)
+}
+
+func (t TimeValue) EquivalentTo(val interface{}) bool {
+	o, ok := val.(TimeValue)
+	return ok && t.Time.Equal(o.Time)
+}
+
+func (t TimeValue) MarshalYAML() (tag string, value interface{}, err error) {
+	return "", t.String(), nil
+}
+
+var time_layouts = map[string]string{
+	"ANSIC":       time.ANSIC,
+	"UnixDate":    time.UnixDate,
+	"RubyDate":    time.RubyDate,
+	"RFC822":      time.RFC822,
+	"RFC822Z":     time.RFC822Z,
+	"RFC850":      time.RFC850,
+	"RFC1123":     time.RFC1123,
+	"RFC1123Z":    time.RFC1123Z,
+	"RFC3339":     time.RFC3339,
+	"RFC3339Nano": time.RFC3339Nano,
+	"Kitchen":     time.Kitchen,
+	"DateTime":    "2006-01-02 15:04:05",
+	"DateOnly":    "2006-01-02",
+	"TimeOnly":    "15:04:05",
+}
+
+// TIME_UNIX is the layout name for times given as seconds since epoch.
+const TIME_UNIX = "unix"
+
+func timeLayout(arguments []interface{}, index int) (string, error) {
+	if len(arguments) <= index {
+		return time.RFC3339, nil
+	}
+	layout, ok := arguments[index].(string)
+	if !ok {
+		return "", fmt.Errorf("layout must be a string")
+	}
+	if l, ok := time_layouts[layout]; ok {
+		return l, nil
+	}
+	return layout, nil
+}
+
+// TimeArgument provides the time value of a function argument. Besides
+// time values, RFC3339 strings and integers (seconds since epoch) are
+// accepted.
+func TimeArgument(arg interface{}) (time.Time, error) {
+	switch v := arg.(type) {
+	case TimeValue:
+		return v.Time, nil
+	case string:
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid time %q", v)
+		}
+		return t, nil
+	case int64:
+		return time.Unix(v, 0).UTC(), nil
+	default:
+		return time.Time{}, fmt.Errorf("time expected, but found %s", ExpressionType(arg))
+	}
+}
+
+var duration_units = regexp.MustCompile(`([0-9]+(?:\.[0-9]*)?)([dw])`)
+
+// ParseDuration parses a duration string. In addition to the units of
+// time.ParseDuration, the units d (24h) and w (7d) are supported.
+func ParseDuration(s string) (time.Duration, error) {
+	var err error
+	expanded := duration_units.ReplaceAllStringFunc(s, func(m string) string {
+		sub := duration_units.FindStringSubmatch(m)
+		f, perr := strconv.ParseFloat(sub[1], 64)
+		if perr != nil {
+			err = perr
+		}
+		if sub[2] == "w" {
+			f *= 7
+		}
+		return strconv.FormatFloat(f*24, 'f', -1, 64) + "h"
+	})
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	d, err := time.ParseDuration(expanded)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+// DurationArgument provides the duration of a function argument given
+// as duration string or as integer (seconds).
+func DurationArgument(arg interface{}) (time.Duration, error) {
+	switch v := arg.(type) {
+	case string:
+		return ParseDuration(strings.TrimSpace(v))
+	case int64:
+		return time.Duration(v) * time.Second, nil
+	default:
+		return 0, fmt.Errorf("duration expected, but found %s", ExpressionType(arg))
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func func_now(arguments []interface{}, binding Binding) (interface{}, EvaluationInfo, bool) {
+	info := DefaultInfo()
+	if len(arguments) != 0 {
+		return info.Error("function now takes no arguments")
+	}
+	return TimeValue{CurrentTime(binding)}, info, true
+}
+
+func func_parsetime(arguments []interface{}, binding Binding) (interface{}, EvaluationInfo, bool) {
+	info := DefaultInfo()
+	if len(arguments) < 1 || len(arguments) > 2 {
+		return info.Error("function parsetime requires one or two arguments")
+	}
+	layout, err := timeLayout(arguments, 1)
+	if err != nil {
+		return info.Error("parsetime: %s", err)
+	}
+	switch v := arguments[0].(type) {
+	case TimeValue:
+		return v, info, true
+	case int64:
+		return TimeValue{time.Unix(v, 0).UTC()}, info, true
+	case string:
+		if layout == TIME_UNIX {
+			s, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return info.Error("parsetime: invalid unix time %q", v)
+			}
+			return TimeValue{time.Unix(s, 0).UTC()}, info, true
+		}
+		t, err := time.Parse(layout, v)
+		if err != nil {
+			return info.Error("parsetime: %s", err)
+		}
+		return TimeValue{t}, info, true
+	default:
+		return info.Error("parsetime: string or integer expected, but found %s", ExpressionType(v))
+	}
+}
+
+func func_formattime(arguments []interface{}, binding Binding) (interface{}, EvaluationInfo, bool) {
+	info := DefaultInfo()
+	if len(arguments) < 1 || len(arguments) > 2 {
+		return info.Error("function formattime requires one or two arguments")
+	}
+	t, err := TimeArgument(arguments[0])
+	if err != nil {
+		return info.Error("formattime: %s", err)
+	}
+	layout, err := timeLayout(arguments, 1)
+	if err != nil {
+		return info.Error("formattime: %s", err)
+	}
+	if layout == TIME_UNIX {
+		return t.Unix(), info, true
+	}
+	return t.Format(layout), info, true
+}
+
+func func_addduration(arguments []interface{}, binding Binding) (interface{}, EvaluationInfo, bool) {
+	info := DefaultInfo()
+	if len(arguments) != 2 {
+		return info.Error("function addduration requires two arguments")
+	}
+	t, err := TimeArgument(arguments[0])
+	if err != nil {
+		return info.Error("addduration: %s", err)
+	}
+	d, err := DurationArgument(arguments[1])
+	if err != nil {
+		return info.Error("addduration: %s", err)
+	}
+	return TimeValue{t.Add(d)}, info, true
+}
+
+// func_duration provides the number of seconds of a duration string
+// or between two time values.
+func func_duration(arguments []interface{}, binding Binding) (interface{}, EvaluationInfo, bool) {
+	info := DefaultInfo()
+	switch len(arguments) {
+	case 1:
+		d, err := DurationArgument(arguments[0])
+		if err != nil {
+			return info.Error("duration: %s", err)
+		}
+		return int64(d / time.Second), info, true
+	case 2:
+		from, err := TimeArgument(arguments[0])
+		if err != nil {
+			return info.Error("duration: %s", err)
+		}
+		to, err := TimeArgument(arguments[1])
+		if err != nil {
+			return info.Error("duration: %s", err)
+		}
+		return int64(to.Sub(from) / time.Second), info, true
+	default:
+		return info.Error("function duration requires one or two arguments")
+	}
+}
@@ -44,6 +44,8 @@ func ExpressionType(elem interface{}) string {
 		return "template"
 	case LambdaValue:
 		return "lambda"
+	case TimeValue:
+		return "time"
 	case nil:
 		return "nil"
 	default:
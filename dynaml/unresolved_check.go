@@ -5,6 +5,7 @@ import (
 	"reflect"
 	"strings"
 
+	"github.com/mandelsoft/spiff/debug"
 	"github.com/mandelsoft/spiff/yaml"
 )
 
@@ -26,6 +27,11 @@ func PrintableNodeValue(node yaml.Node) interface{} {
 		nv = "<map>"
 	case []yaml.Node:
 		nv = "<list>"
+	case Expression:
+	default:
+		if node.Sensitive() {
+			nv = debug.REDACTED
+		}
 	}
 	return nv
 }
@@ -58,7 +64,7 @@ func (e UnresolvedNodes) Issue(msgfmt string, args ...interface{}) (result yaml.
 		message := fmt.Sprintf(
 			format,
 			nv,
-			node.SourceName(),
+			yaml.SourceLocation(node),
 			strings.Join(node.Context, "."),
 			strings.Join(node.Path, "."),
 			msg,
@@ -102,7 +108,7 @@ func (e UnresolvedNodes) Error() string {
 			format,
 			message,
 			val,
-			node.SourceName(),
+			yaml.SourceLocation(node),
 			strings.Join(node.Context, "."),
 			strings.Join(node.Path, "."),
 			msg,
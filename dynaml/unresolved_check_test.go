@@ -35,4 +35,24 @@ var _ = Describe("Reporting unresolved nodes", func() {
 	(( auto ))	in some-file.yml	foo.bar	(foo.bar)
 	(( merge ))	in some-other-file.yml	fizz.[2].buzz	(fizz.fizzbuzz.buzz)`))
 	})
+
+	It("adds source positions if known", func() {
+		err := UnresolvedNodes{
+			Nodes: []UnresolvedNode{
+				{
+					Node: yaml.NewPositionedNode(
+						AutoExpr{},
+						"some-file.yml",
+						yaml.Position{Line: 12, Column: 8},
+					),
+					Context: []string{"foo", "bar"},
+					Path:    []string{"foo", "bar"},
+				},
+			},
+		}
+
+		Expect(err.Error()).To(Equal(
+			`unresolved nodes:
+	(( auto ))	in some-file.yml:12:8	foo.bar	(foo.bar)`))
+	})
 })
@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"github.com/mandelsoft/spiff/yaml"
 	"net"
-	"regexp"
 	"strings"
 )
 
@@ -546,7 +545,7 @@ func handleStringType(value interface{}, op string, binding Binding, args ...yam
 			return ValidatorErrorf("match requires a regexp argument")
 		}
 
-		re, err := regexp.Compile(s)
+		re, err := CompileRegexp(s, binding)
 		if err != nil {
 			return ValidatorErrorf("regexp %s: %s", s, err)
 		}
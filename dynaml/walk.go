@@ -0,0 +1,85 @@
+package dynaml
+
+import (
+	"reflect"
+)
+
+var expressionType = reflect.TypeOf((*Expression)(nil)).Elem()
+
+// SubExpressions provides the direct nested expressions of a
+// parsed expression in the order they occur in the expression struct.
+func SubExpressions(e Expression) []Expression {
+	switch v := e.(type) {
+	case nil:
+		return nil
+	case MarkerExpr:
+		return appendExpression(nil, v.expr)
+	case MarkerExpressionExpr:
+		return appendExpression(nil, v.expr)
+	case PreferExpr:
+		return appendExpression(nil, v.expression)
+	}
+	return collectExpressions(nil, reflect.ValueOf(e))
+}
+
+// Walk traverses an expression tree in depth-first order. The
+// visitor is called for every expression. If it returns false, the
+// sub expressions of the visited expression are skipped.
+func Walk(e Expression, visitor func(Expression) bool) {
+	if e == nil || !visitor(e) {
+		return
+	}
+	for _, s := range SubExpressions(e) {
+		Walk(s, visitor)
+	}
+}
+
+// MarkerExpression provides the expression attached to a marker.
+func (e MarkerExpr) MarkerExpression() Expression {
+	return e.expr
+}
+
+func appendExpression(list []Expression, e Expression) []Expression {
+	if e == nil {
+		return list
+	}
+	return append(list, e)
+}
+
+func collectExpressions(list []Expression, v reflect.Value) []Expression {
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			fv := v.Field(i)
+			if f.Type == expressionType {
+				if !fv.IsNil() {
+					list = appendExpression(list, fv.Interface().(Expression))
+				}
+				continue
+			}
+			switch f.Type.Kind() {
+			case reflect.Struct, reflect.Slice:
+				list = collectExpressions(list, fv)
+			}
+		}
+	case reflect.Slice:
+		elem := v.Type().Elem()
+		for i := 0; i < v.Len(); i++ {
+			if elem == expressionType {
+				if !v.Index(i).IsNil() {
+					list = appendExpression(list, v.Index(i).Interface().(Expression))
+				}
+				continue
+			}
+			if elem.Kind() == reflect.Struct {
+				list = collectExpressions(list, v.Index(i))
+			}
+		}
+	}
+	return list
+}
@@ -0,0 +1,49 @@
+package dynaml
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func walkedReferences(source string) []string {
+	parsed, err := Parse(source, nil, nil)
+	Expect(err).NotTo(HaveOccurred())
+	refs := []string{}
+	Walk(parsed, func(e Expression) bool {
+		if r, ok := e.(ReferenceExpr); ok {
+			refs = append(refs, r.String())
+		}
+		return true
+	})
+	return refs
+}
+
+var _ = Describe("walking expressions", func() {
+	It("finds nested references", func() {
+		Expect(walkedReferences(`a + f(b, c.d) || [ e ]`)).To(Equal([]string{"a", "f", "b", "c.d", "e"}))
+	})
+
+	It("walks into markers", func() {
+		Expect(walkedReferences(`&temporary ( a )`)).To(Equal([]string{"a"}))
+	})
+
+	It("walks into lambdas and scopes", func() {
+		Expect(walkedReferences(`($x = a) |y|->x + y + b`)).To(Equal([]string{"a", "x", "y", "b"}))
+	})
+
+	It("walks into named arguments", func() {
+		Expect(walkedReferences(`f(x=a)`)).To(Equal([]string{"f", "a"}))
+	})
+
+	It("skips sub expressions on demand", func() {
+		parsed, err := Parse(`a + (b - c)`, nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		count := 0
+		Walk(parsed, func(e Expression) bool {
+			count++
+			_, ok := e.(GroupedExpr)
+			return !ok
+		})
+		Expect(count).To(Equal(3))
+	})
+})
@@ -37,5 +37,5 @@ func func_genkey(arguments []interface{}, binding Binding) (interface{}, Evaluat
 	if err != nil {
 		return info.Error("error generating key: %s", err)
 	}
-	return key.String(), info, true
+	return Sensitive(key.String(), info, true)
 }
@@ -25,5 +25,5 @@ func func_pubkey(arguments []interface{}, binding Binding) (interface{}, Evaluat
 	if err != nil {
 		return info.Error("error parsing key %q: %s", str, err)
 	}
-	return key.PublicKey().String(), info, true
+	return NonSensitive(key.PublicKey().String(), info, true)
 }
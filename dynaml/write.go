@@ -6,6 +6,8 @@ import (
 	"os"
 	"strconv"
 	"strings"
+
+	"github.com/mandelsoft/spiff/yaml"
 )
 
 func func_write(arguments []interface{}, binding Binding) (interface{}, EvaluationInfo, bool) {
@@ -45,6 +47,7 @@ func func_write(arguments []interface{}, binding Binding) (interface{}, Evaluati
 type WriteOpts struct {
 	Binary      bool
 	Multi       bool
+	Format      string
 	Permissions int64
 }
 
@@ -64,6 +67,12 @@ func getWriteOptions(arg interface{}, wopt WriteOpts, permonly bool) (WriteOpts,
 				wopt.Multi = true
 				opts = append(opts[:i], opts[i+1:]...)
 				i--
+			default:
+				if yaml.GetFormatter(o) != nil {
+					wopt.Format = o
+					opts = append(opts[:i], opts[i+1:]...)
+					i--
+				}
 			}
 		}
 		if len(opts) > 1 {
@@ -93,6 +102,9 @@ func getWriteOptions(arg interface{}, wopt WriteOpts, permonly bool) (WriteOpts,
 		if wopt.Multi {
 			return wopt, fmt.Errorf("multi option not support -> only permissions")
 		}
+		if wopt.Format != "" {
+			return wopt, fmt.Errorf("format option not support -> only permissions")
+		}
 	}
 	return wopt, err
 }
@@ -222,5 +222,5 @@ func func_x509cert(arguments []interface{}, binding Binding) (interface{}, Evalu
 		return info.Error("failed to write certificate pem block: %s", err)
 	}
 	writer.Flush()
-	return b.String(), info, true
+	return NonSensitive(b.String(), info, true)
 }
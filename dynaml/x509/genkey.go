@@ -74,5 +74,5 @@ func func_x509genkey(arguments []interface{}, binding Binding) (interface{}, Eva
 		return info.Error("failed to write key pem block: %s", err)
 	}
 	writer.Flush()
-	return b.String(), info, true
+	return Sensitive(b.String(), info, true)
 }
@@ -80,7 +80,7 @@ func func_x509publickey(arguments []interface{}, binding Binding) (interface{},
 	if err != nil {
 		return info.Error("%s", err)
 	}
-	return str, info, true
+	return NonSensitive(str, info, true)
 }
 
 func PublicKeyPEM(key interface{}, gen ...bool) (string, error) {
@@ -3,6 +3,7 @@ package features
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
@@ -65,3 +66,15 @@ func Features() FeatureFlags {
 func EncryptionKey() string {
 	return os.Getenv("SPIFF_ENCRYPTION_KEY")
 }
+
+// AgeIdentityFiles provides the age identity files given by the
+// environment variable SPIFF_AGE_IDENTITY as path list.
+func AgeIdentityFiles() []string {
+	files := []string{}
+	for _, f := range filepath.SplitList(os.Getenv("SPIFF_AGE_IDENTITY")) {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files
+}
@@ -1,6 +1,7 @@
 package flow
 
 import (
+	"github.com/mandelsoft/spiff/debug"
 	"github.com/mandelsoft/spiff/dynaml"
 	"github.com/mandelsoft/spiff/yaml"
 )
@@ -13,13 +14,28 @@ type Options struct {
 	PreserveTemporary bool
 	// Partial will not treat unevaluated dynaml expressions as error, but keep it in the output.
 	Partial bool
+	// KeepOrder keeps the original key order of maps for the final output instead of sorting the keys.
+	KeepOrder bool
+	// Parallel is the maximum number of workers processing independent nodes
+	// of a document concurrently. Zero keeps the setting of the processing state.
+	Parallel int
 }
 
 func PrepareStubs(outer dynaml.Binding, partial bool, stubs ...yaml.Node) ([]yaml.Node, error) {
+	return PrepareStubsWithOptions(outer, Options{Partial: partial}, stubs...)
+}
+
+// PrepareStubsWithOptions processes the stubs in reverse order. Every stub is
+// processed with its already processed successors as stubs. Their values
+// are looked up for the overrides of the first pass and global tags of
+// successors are visible, too, so a stub cannot be started before its
+// successors are finished. The nodes of every stub are processed
+// concurrently according to the worker limit.
+func PrepareStubsWithOptions(outer dynaml.Binding, opts Options, stubs ...yaml.Node) ([]yaml.Node, error) {
 	for i := len(stubs) - 1; i >= 0; i-- {
 		ResetStream(outer)
-		flowed, err := NestedFlow(outer, stubs[i], stubs[i+1:]...)
-		if !partial && err != nil {
+		flowed, err := nestedFlow(outer, opts.Parallel, stubs[i], stubs[i+1:]...)
+		if !opts.Partial && err != nil {
 			return nil, err
 		}
 
@@ -30,7 +46,7 @@ func PrepareStubs(outer dynaml.Binding, partial bool, stubs ...yaml.Node) ([]yam
 }
 
 func Apply(outer dynaml.Binding, template yaml.Node, prepared []yaml.Node, opts Options) (yaml.Node, error) {
-	result, err := NestedFlow(outer, template, prepared...)
+	result, err := nestedFlow(outer, opts.Parallel, template, prepared...)
 	if err == nil {
 		if !opts.PreserveTemporary {
 			result = Cleanup(result, discardTemporary)
@@ -44,7 +60,7 @@ func Apply(outer dynaml.Binding, template yaml.Node, prepared []yaml.Node, opts
 }
 
 func Cascade(outer dynaml.Binding, template yaml.Node, opts Options, stubs ...yaml.Node) (yaml.Node, error) {
-	prepared, err := PrepareStubs(outer, opts.Partial, stubs...)
+	prepared, err := PrepareStubsWithOptions(outer, opts, stubs...)
 	if err != nil {
 		return nil, err
 	}
@@ -93,6 +109,22 @@ func DiscardNonState(node yaml.Node) (yaml.Node, CleanupFunction) {
 	return nil, DiscardNonState
 }
 
+// Redact replaces the values of all sensitive nodes by a redaction
+// marker.
+func Redact(node yaml.Node) yaml.Node {
+	if node != nil && node.Sensitive() {
+		return yaml.SubstituteNode(debug.REDACTED, node)
+	}
+	return Cleanup(node, redactSensitive)
+}
+
+func redactSensitive(node yaml.Node) (yaml.Node, CleanupFunction) {
+	if node.Sensitive() {
+		return yaml.SubstituteNode(debug.REDACTED, node), keepAll
+	}
+	return node, redactSensitive
+}
+
 type CleanupFunction func(yaml.Node) (yaml.Node, CleanupFunction)
 
 func Cleanup(node yaml.Node, test CleanupFunction) yaml.Node {
@@ -122,6 +154,31 @@ func Cleanup(node yaml.Node, test CleanupFunction) yaml.Node {
 	return yaml.ReplaceValue(value, node)
 }
 
+// Visit calls a cleanup function for all nested nodes of a node
+// like Cleanup, but without copying the node. The nodes returned by
+// the function are ignored.
+func Visit(node yaml.Node, test CleanupFunction) {
+	if node == nil {
+		return
+	}
+	switch v := node.Value().(type) {
+	case []yaml.Node:
+		for _, e := range v {
+			if e != nil {
+				_, t := test(e)
+				Visit(e, t)
+			}
+		}
+	case map[string]yaml.Node:
+		for _, e := range v {
+			if e != nil {
+				_, t := test(e)
+				Visit(e, t)
+			}
+		}
+	}
+}
+
 func DetermineState(node yaml.Node) yaml.Node {
 	return Cleanup(node, DiscardNonState)
 }
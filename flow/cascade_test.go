@@ -5,6 +5,7 @@ import (
 	. "github.com/onsi/gomega"
 
 	"github.com/mandelsoft/spiff/features"
+	"github.com/mandelsoft/spiff/yaml"
 )
 
 var _ = Describe("Cascading YAML templates", func() {
@@ -1373,4 +1374,34 @@ foo:
 			})
 		})
 	})
+
+	Context("comments", func() {
+		It("keeps template comments for evaluated and overridden nodes", func() {
+			source, err := yaml.ParseWithComments("test", []byte(`# settings
+settings:
+  name: (( "x" suffix )) # computed
+  suffix: foo
+  size: 3 # default size
+temp: (( &temporary(1) )) # dropped
+# end
+`))
+			Expect(err).NotTo(HaveOccurred())
+			stub := parseYAML(`
+---
+settings:
+  size: 5
+`)
+			result, err := Cascade(nil, source, Options{}, stub)
+			Expect(err).NotTo(HaveOccurred())
+			data, err := yaml.MarshalWithComments(result)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal(`# settings
+settings:
+  name: xfoo # computed
+  size: 5 # default size
+  suffix: foo
+# end
+`))
+		})
+	})
 })
@@ -18,6 +18,9 @@ type Scope struct {
 	path   []string
 	next   *Scope
 	root   *Scope
+
+	document   bool        // scope of a document node
+	evaluation *evaluation // evaluation processing the document
 }
 
 func newFakeScope(outer *Scope, path []string, local map[string]yaml.Node) *Scope {
@@ -25,7 +28,7 @@ func newFakeScope(outer *Scope, path []string, local map[string]yaml.Node) *Scop
 }
 
 func newScope(outer *Scope, path []string, local, static map[string]yaml.Node) *Scope {
-	scope := &Scope{local, static, path, outer, nil}
+	scope := &Scope{local: local, static: static, path: path, next: outer}
 	if outer == nil || outer.root == nil {
 		scope.root = scope
 	} else {
@@ -51,6 +54,13 @@ type DefaultEnvironment struct {
 
 	active  bool
 	binding bool
+
+	evaluation *evaluation
+	deps       *dependencies // lookups of the actually evaluated expression
+	tags       *tagBuffer    // tags set by a concurrently processed node
+
+	limited bool        // workers overrides the worker limit of the state
+	workers *workerPool // workers for the concurrent processing
 }
 
 func keys(s map[string]yaml.Node) string {
@@ -74,6 +84,9 @@ func (e *DefaultEnvironment) String() string {
 }
 
 func (e *DefaultEnvironment) GetState() dynaml.State {
+	if e.tags != nil {
+		return e.tags
+	}
 	if e.state == nil {
 		if e.outer != nil {
 			return e.outer.GetState()
@@ -153,6 +166,7 @@ func (e *DefaultEnvironment) FindFromRoot(path []string) (yaml.Node, bool) {
 		return nil, false
 	}
 
+	e.dependsOnNode(e.scope.root, path)
 	return yaml.FindR(true, yaml.NewNode(e.scope.root.local, "scope"), e.GetFeatures(), path...)
 }
 
@@ -173,17 +187,28 @@ func (e *DefaultEnvironment) FindInScopes(nodescope *Scope, path []string) (yaml
 
 func (e *DefaultEnvironment) FindReference(path []string) (yaml.Node, bool) {
 	root, found, nodescope := resolveSymbol(e, path[0], e.scope)
+	if path[0] != "__ctx" {
+		e.dependsOn(e.scope, path)
+	}
 	if !found {
 		if path[0] == yaml.ROOT {
 			var outer dynaml.Binding = e
 			for outer.Outer() != nil {
 				outer = outer.Outer()
 			}
+			if o, ok := outer.(*DefaultEnvironment); ok && o.scope != nil {
+				e.dependsOnNode(o.scope.root, path[1:])
+			}
 			return yaml.FindR(true, node(outer.GetRootBinding()), e.GetFeatures(), path[1:]...)
 		}
 		//fmt.Printf("FIND %s: %s\n", strings.Join(path,"."), e)
 		//fmt.Printf("FOUND %s: %v\n", strings.Join(path,"."),  keys(nodescope))
 		if path[0] == yaml.DOCNODE && nodescope != nil {
+			if len(path) > 1 {
+				e.dependsOn(nodescope, path[1:])
+			} else {
+				e.dependsOnNode(nodescope, nil)
+			}
 			return e.FindInScopes(nodescope, path[1:])
 		}
 		if e.outer != nil {
@@ -195,6 +220,12 @@ func (e *DefaultEnvironment) FindReference(path []string) (yaml.Node, bool) {
 	//fmt.Printf("RESOLVE: %s: %s\n",path[0], dynaml.ExpressionType(root.Value()))
 	if len(path) > 1 && path[0] == yaml.SELF {
 		resolver := root.Resolver()
+		if r, ok := resolver.(*DefaultEnvironment); ok && r.deps != e.deps {
+			// record the lookups for the actual evaluation
+			n := *r
+			n.deps = e.deps
+			resolver = &n
+		}
 		return resolver.FindReference(path[1:])
 	}
 	return yaml.FindR(true, root, e.GetFeatures(), path[1:]...)
@@ -202,18 +233,27 @@ func (e *DefaultEnvironment) FindReference(path []string) (yaml.Node, bool) {
 
 func (e *DefaultEnvironment) FindInStubs(path []string) (yaml.Node, bool) {
 	debug.Debug("lookup %v in stubs\n", path)
+	x := explanation(e)
 	for _, stub := range e.stubs {
 		debug.Debug("checking stub %s\n", stub.SourceName())
 		val, found := yaml.Find(stub, e.GetFeatures(), path...)
 		if found {
 			if !val.Flags().Implied() {
 				debug.Debug("found %v\n", path)
+				if x != nil {
+					x.once("found %s in stub %s", strings.Join(path, "."), stub.SourceName())
+				}
 				return val, true
 			}
 			debug.Debug("skipping found stub %v\n", path)
+			if x != nil {
+				x.once("skipping implied %s in stub %s", strings.Join(path, "."), stub.SourceName())
+			}
 		}
 	}
-
+	if x != nil && len(e.stubs) > 0 {
+		x.once("%s not found in stubs", strings.Join(path, "."))
+	}
 	return nil, false
 }
 
@@ -226,6 +266,8 @@ func (e *DefaultEnvironment) WithSource(source string) dynaml.Binding {
 func (e *DefaultEnvironment) WithScope(step map[string]yaml.Node) dynaml.Binding {
 	n := *e
 	n.scope = newScope(e.scope, e.path, step, e.static)
+	n.scope.document = true
+	n.scope.evaluation = e.evaluation
 	return &n
 }
 
@@ -277,8 +319,22 @@ func (e *DefaultEnvironment) RedirectOverwrite(path []string) dynaml.Binding {
 func (e *DefaultEnvironment) Flow(source yaml.Node, shouldOverride bool) (yaml.Node, dynaml.Status) {
 	result := source
 
+	// every call keeps track of its own evaluation state, nested
+	// flows may process the same nodes in a different context.
+	old := e.evaluation
+	e.evaluation = newEvaluation()
+	defer func() { e.evaluation = old }()
+
+	x := explanationOf(e)
+	if x != nil {
+		x.begin(e.sourceName)
+	}
 	for {
 		debug.Debug("@@{ loop:  %+v\n", result)
+		if x != nil {
+			x.next()
+		}
+		e.evaluation.nextPass()
 		next := flow(result, e, shouldOverride, false)
 		if next.Undefined() {
 			result = yaml.UndefinedNode(node(nil))
@@ -286,20 +342,27 @@ func (e *DefaultEnvironment) Flow(source yaml.Node, shouldOverride bool) (yaml.N
 		}
 		debug.Debug("@@} --->   %+v\n", next)
 
-		next = Cleanup(next, updateBinding(next, e))
-		b := reflect.DeepEqual(result, next)
-		//b,r:=yaml.Equals(result, next,[]string{})
-		if b {
+		// keep the identity of the nodes, settled nodes are skipped
+		// by subsequent passes based on their identity.
+		Visit(next, updateBinding(next, e))
+		if x != nil {
+			x.iterated(next)
+		}
+		// the changed nodes determine the expressions to evaluate
+		// again by the next pass.
+		if !e.evaluation.compare(e.path, result, next) {
 			break
 		}
-		//fmt.Printf("****** found diff: %s\n", r)
 		result = next
 	}
 	debug.Debug("@@@ Done\n")
 	result = Cleanup(result, deactivateScopes)
+	if x != nil {
+		x.end(result)
+	}
 	unresolved := dynaml.FindUnresolvedNodes(result)
 	if len(unresolved) > 0 {
-		return result, dynaml.UnresolvedNodes{unresolved}
+		return result, dynaml.UnresolvedNodes{redactIssues(e.GetState(), unresolved)}
 	}
 
 	return result, nil
@@ -492,3 +555,35 @@ func createContext(env *DefaultEnvironment) yaml.Node {
 func node(val interface{}) yaml.Node {
 	return yaml.NewNode(val, "__ctx")
 }
+
+// redactIssues hides the sensitive values used by the processing
+// in the issues of unresolved nodes.
+func redactIssues(state dynaml.State, nodes []dynaml.UnresolvedNode) []dynaml.UnresolvedNode {
+	r, ok := state.(redactor)
+	if !ok {
+		return nodes
+	}
+	for i, n := range nodes {
+		issue := redactIssue(r, n.Issue())
+		if !reflect.DeepEqual(issue, n.Issue()) {
+			nodes[i].Node = yaml.IssueNode(n.Node, n.HasError(), n.Failed(), issue)
+		}
+	}
+	return nodes
+}
+
+type redactor interface {
+	Redact(text string) string
+}
+
+func redactIssue(r redactor, issue yaml.Issue) yaml.Issue {
+	issue.Issue = r.Redact(issue.Issue)
+	if issue.Nested != nil {
+		nested := make([]yaml.Issue, len(issue.Nested))
+		for i, n := range issue.Nested {
+			nested[i] = redactIssue(r, n)
+		}
+		issue.Nested = nested
+	}
+	return issue
+}
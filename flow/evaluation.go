@@ -0,0 +1,400 @@
+package flow
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/mandelsoft/spiff/dynaml"
+	"github.com/mandelsoft/spiff/yaml"
+)
+
+// evaluation keeps track of the evaluation state of a document
+// processed by DefaultEnvironment.Flow.
+//
+// A document is processed in passes until no node changes anymore.
+// A map or list node is settled, if it does not contain any dynaml
+// expression anymore and a pass did not change it. The processing of
+// such a node only depends on its content, its path and the stubs, so
+// it is not processed again by subsequent passes, and the settled
+// parts keep their identity, which makes the comparison of subsequent
+// passes cheap.
+//
+// The pending expressions form the work list of a pass. The evaluation
+// of an expression records the document nodes it looked up, and the
+// comparison of two passes records the paths of the changed nodes.
+// An expression, which could not be resolved, is evaluated again only,
+// if one of the nodes it depends on (or a tag) changed since its last
+// evaluation. Otherwise the previous result is reused. Evaluations
+// accessing files, commands or other external resources are not
+// tracked, they are evaluated again by every pass.
+type evaluation struct {
+	lock    sync.Mutex
+	settled map[settledKey]yaml.Node
+	// pure remembers the map and list values without expressions
+	pure map[uintptr]interface{}
+
+	pass    int                     // number of the actual pass
+	changed map[string]int          // last pass a node changed, by path
+	below   map[string]int          // last pass a nested node changed, by path
+	pending map[settledKey]*pending // unresolved evaluations, by path
+}
+
+// pending is the result of an evaluation of an expression, which
+// failed or still provides an expression.
+type pending struct {
+	expr  dynaml.Expression
+	value interface{}
+	info  dynaml.EvaluationInfo
+	ok    bool
+	deps  [][]string
+	pass  int    // pass of the evaluation
+	tags  uint64 // tag modifications seen by the evaluation
+}
+
+type settledKey struct {
+	value    uintptr
+	path     string
+	override bool
+	template bool
+	nomerge  bool
+}
+
+func newEvaluation() *evaluation {
+	return &evaluation{
+		settled: map[settledKey]yaml.Node{},
+		pure:    map[uintptr]interface{}{},
+		changed: map[string]int{},
+		below:   map[string]int{},
+		pending: map[settledKey]*pending{},
+	}
+}
+
+func evaluationOf(env dynaml.Binding) *evaluation {
+	if e, ok := env.(*DefaultEnvironment); ok {
+		return e.evaluation
+	}
+	return nil
+}
+
+// identity provides the identity of a non-empty map or list value.
+func identity(node yaml.Node) (uintptr, bool) {
+	if node == nil {
+		return 0, false
+	}
+	switch v := node.Value().(type) {
+	case map[string]yaml.Node:
+		if len(v) > 0 {
+			return reflect.ValueOf(v).Pointer(), true
+		}
+	case []yaml.Node:
+		if len(v) > 0 {
+			return reflect.ValueOf(v).Pointer(), true
+		}
+	}
+	return 0, false
+}
+
+func (ev *evaluation) key(id uintptr, env dynaml.Binding, shouldOverride, enforceTemplate bool) settledKey {
+	return settledKey{
+		value:    id,
+		path:     strings.Join(env.Path(), "\000") + "\001" + strings.Join(env.StubPath(), "\000"),
+		override: shouldOverride,
+		template: enforceTemplate,
+		nomerge:  env.NoMerge(),
+	}
+}
+
+// isSettled checks whether a node has already been settled for the
+// given processing context.
+func (ev *evaluation) isSettled(root yaml.Node, env dynaml.Binding, shouldOverride, enforceTemplate bool) bool {
+	id, ok := identity(root)
+	if !ok {
+		return false
+	}
+	key := ev.key(id, env, shouldOverride, enforceTemplate)
+	ev.lock.Lock()
+	settled, ok := ev.settled[key]
+	ev.lock.Unlock()
+	return ok && reflect.DeepEqual(settled, root)
+}
+
+// settle marks the result of processing a node as settled, if it is
+// free of expressions and identical to the processed node.
+func (ev *evaluation) settle(root, result yaml.Node, env dynaml.Binding, shouldOverride, enforceTemplate bool) {
+	id, ok := identity(result)
+	if !ok || !ev.isPure(result, env) {
+		return
+	}
+	settled := reflect.DeepEqual(root, result)
+	ev.lock.Lock()
+	defer ev.lock.Unlock()
+	ev.pure[id] = result.Value()
+	if settled {
+		ev.settled[ev.key(id, env, shouldOverride, enforceTemplate)] = result
+	}
+}
+
+func (ev *evaluation) isPure(node yaml.Node, env dynaml.Binding) bool {
+	switch v := node.Value().(type) {
+	case map[string]yaml.Node:
+		for _, e := range v {
+			if !ev.isPureElement(e, env) {
+				return false
+			}
+		}
+	case []yaml.Node:
+		for _, e := range v {
+			if !ev.isPureElement(e, env) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (ev *evaluation) isPureElement(node yaml.Node, env dynaml.Binding) bool {
+	if node == nil {
+		return true
+	}
+	if id, ok := identity(node); ok {
+		ev.lock.Lock()
+		_, ok := ev.pure[id]
+		ev.lock.Unlock()
+		return ok
+	}
+	switch node.Value().(type) {
+	case dynaml.Expression:
+		return false
+	case string:
+		return yaml.EmbeddedDynaml(node, env.GetState().InterpolationEnabled()) == nil
+	}
+	return true
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// nextPass starts the next pass of the document processing.
+func (ev *evaluation) nextPass() {
+	ev.pass++
+}
+
+// compare compares the nodes of two subsequent passes for a path and
+// records the paths of the changed nodes for the actual pass. Lists
+// are recorded as a whole, because references may address list
+// entries by name. The result is the same as for reflect.DeepEqual.
+func (ev *evaluation) compare(path []string, old, new yaml.Node) bool {
+	if old == nil || new == nil {
+		if old == nil && new == nil {
+			return false
+		}
+		ev.change(path)
+		return true
+	}
+	om, ok1 := old.Value().(map[string]yaml.Node)
+	nm, ok2 := new.Value().(map[string]yaml.Node)
+	_, ok3 := old.(yaml.AnnotatedNode)
+	_, ok4 := new.(yaml.AnnotatedNode)
+	if !ok1 || !ok2 || !ok3 || !ok4 || !reflect.DeepEqual(yaml.ReplaceValue(nil, old), yaml.ReplaceValue(nil, new)) {
+		if reflect.DeepEqual(old, new) {
+			return false
+		}
+		ev.change(path)
+		return true
+	}
+	if len(om) == len(nm) && reflect.ValueOf(om).Pointer() == reflect.ValueOf(nm).Pointer() {
+		return false
+	}
+	changed := false
+	for k, n := range nm {
+		sub := append(path[:len(path):len(path)], k)
+		o, ok := om[k]
+		if !ok {
+			ev.change(sub)
+			changed = true
+			continue
+		}
+		if ev.compare(sub, o, n) {
+			changed = true
+		}
+	}
+	for k := range om {
+		if _, ok := nm[k]; !ok {
+			ev.change(append(path[:len(path):len(path)], k))
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (ev *evaluation) change(path []string) {
+	ev.changed[pathKey(path)] = ev.pass
+	for i := 0; i < len(path); i++ {
+		ev.below[pathKey(path[:i])] = ev.pass
+	}
+}
+
+// changedSince checks whether a node related to one of the given paths
+// (the node itself, a parent or a nested node) changed since the given
+// pass.
+func (ev *evaluation) changedSince(paths [][]string, pass int) bool {
+	for _, p := range paths {
+		for i := 0; i <= len(p); i++ {
+			if c, ok := ev.changed[pathKey(p[:i])]; ok && c >= pass {
+				return true
+			}
+		}
+		if c, ok := ev.below[pathKey(p)]; ok && c >= pass {
+			return true
+		}
+	}
+	return false
+}
+
+func pathKey(path []string) string {
+	return strings.Join(path, "\000")
+}
+
+// evaluate evaluates a dynaml expression. The result of an evaluation
+// not resolving the expression is reused by subsequent passes as long
+// as none of the document nodes it looked up changed and it did not
+// access any external resource.
+func evaluate(expr dynaml.Expression, env dynaml.Binding) (interface{}, dynaml.EvaluationInfo, bool) {
+	e, ok := env.(*DefaultEnvironment)
+	if !ok || e.evaluation == nil || explanationOf(env) != nil {
+		return evaluateExpression(expr, env)
+	}
+	state := processingState(env)
+	if state == nil {
+		return evaluateExpression(expr, env)
+	}
+	tags := state.tagModifications()
+	ev := e.evaluation
+	key := ev.key(0, env, false, false)
+
+	ev.lock.Lock()
+	p := ev.pending[key]
+	ev.lock.Unlock()
+	if p != nil && p.tags == tags && reflect.DeepEqual(p.expr, expr) && !ev.changedSince(p.deps, p.pass) {
+		return p.value, p.info, p.ok
+	}
+
+	n := *e
+	deps := &dependencies{ev: ev, parent: e.deps}
+	n.deps = deps
+	accesses := state.ExternalAccesses()
+	value, info, ok := evaluateExpression(expr, &n)
+	paths, tracked := deps.close()
+	if state.ExternalAccesses() != accesses {
+		// files, commands or other external resources may provide
+		// another result without any change of the document.
+		tracked = false
+	}
+
+	ev.lock.Lock()
+	defer ev.lock.Unlock()
+	if !tracked || (ok && !dynaml.IsExpression(value)) {
+		delete(ev.pending, key)
+	} else {
+		ev.pending[key] = &pending{expr, value, info, ok, paths, ev.pass, tags}
+	}
+	return value, info, ok
+}
+
+func evaluateExpression(expr dynaml.Expression, env dynaml.Binding) (interface{}, dynaml.EvaluationInfo, bool) {
+	value, info, ok := expr.Evaluate(env, false)
+	if err := info.Cleanup(); err != nil {
+		info.SetError("%s", err)
+		return nil, info, false
+	}
+	return value, info, ok
+}
+
+// processingState provides the processing state of an environment.
+func processingState(env dynaml.Binding) *State {
+	switch s := env.GetState().(type) {
+	case *State:
+		return s
+	case *tagBuffer:
+		return s.State
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// dependencies records the paths of the document nodes looked up by
+// the evaluation of an expression. Lookups of nested evaluations (for
+// example template instantiations) are propagated to the evaluation
+// the scope of the found node belongs to.
+type dependencies struct {
+	lock     sync.Mutex
+	ev       *evaluation
+	parent   *dependencies
+	paths    [][]string
+	volatile bool // lookups could not be tracked
+	done     bool
+}
+
+func (d *dependencies) record(ev *evaluation, path []string) {
+	for ; d != nil; d = d.parent {
+		if d.ev == ev {
+			d.lock.Lock()
+			if !d.done {
+				d.paths = append(d.paths, path)
+			}
+			d.lock.Unlock()
+			return
+		}
+	}
+}
+
+// untracked marks the evaluations as not tracked, if a lookup cannot
+// be assigned to a document.
+func (d *dependencies) untracked() {
+	for ; d != nil; d = d.parent {
+		d.lock.Lock()
+		d.volatile = true
+		d.lock.Unlock()
+	}
+}
+
+// close finishes the recording and provides the recorded paths and
+// whether all lookups could be tracked.
+func (d *dependencies) close() ([][]string, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.done = true
+	return d.paths, !d.volatile
+}
+
+// dependsOn records the lookup of a path in a scope chain. The scopes
+// searched for the first path step are recorded, too, because they
+// might provide the symbol in a later pass.
+func (e *DefaultEnvironment) dependsOn(scope *Scope, path []string) {
+	if e.deps == nil || len(path) == 0 {
+		return
+	}
+	for s := scope; s != nil; s = s.next {
+		found := s.local[path[0]] != nil
+		if found {
+			e.dependsOnNode(s, path)
+			return
+		}
+		e.dependsOnNode(s, path[:1])
+	}
+}
+
+// dependsOnNode records the lookup of a path in a dedicated scope.
+func (e *DefaultEnvironment) dependsOnNode(scope *Scope, path []string) {
+	if e.deps == nil || scope == nil || !scope.document {
+		return
+	}
+	if scope.evaluation == nil {
+		e.deps.untracked()
+		return
+	}
+	p := make([]string, 0, len(scope.path)+len(path))
+	p = append(append(p, scope.path...), path...)
+	e.deps.record(scope.evaluation, p)
+}
@@ -0,0 +1,129 @@
+package flow
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/mandelsoft/vfs/pkg/memoryfs"
+
+	"github.com/mandelsoft/spiff/dynaml"
+	"github.com/mandelsoft/spiff/yaml"
+)
+
+var _ = Describe("Evaluation", func() {
+	var env *DefaultEnvironment
+	var ev *evaluation
+
+	pass := func(node yaml.Node) yaml.Node {
+		next := flow(node, env, false, false)
+		Visit(next, updateBinding(next, env))
+		return next
+	}
+
+	same := func(a, b yaml.Node) bool {
+		ida, _ := identity(a)
+		idb, _ := identity(b)
+		return ida == idb
+	}
+
+	field := func(node yaml.Node, name string) yaml.Node {
+		return node.Value().(map[string]yaml.Node)[name]
+	}
+
+	BeforeEach(func() {
+		env = NewEnvironment(nil, "test").(*DefaultEnvironment)
+		ev = newEvaluation()
+		env.evaluation = ev
+	})
+
+	It("settles unchanged nodes without expressions", func() {
+		source := parseYAML(`
+static:
+  list: [ a, b ]
+pending:
+  a: (( b ))
+  b: (( c ))
+  c: 1
+`)
+		first := pass(source)
+		second := pass(first)
+		Expect(ev.isSettled(field(second, "static"), env.WithPath("static"), true, false)).To(BeTrue())
+		Expect(ev.isSettled(field(second, "pending"), env.WithPath("pending"), true, false)).To(BeFalse())
+
+		third := pass(second)
+		Expect(same(field(third, "static"), field(second, "static"))).To(BeTrue())
+		Expect(same(field(third, "pending"), field(second, "pending"))).To(BeFalse())
+	})
+
+	It("does not settle nodes for a different context", func() {
+		source := parseYAML(`
+static:
+  value: 1
+`)
+		second := pass(pass(source))
+		static := field(second, "static")
+		Expect(ev.isSettled(static, env.WithPath("static"), true, false)).To(BeTrue())
+		Expect(ev.isSettled(static, env.WithPath("other"), true, false)).To(BeFalse())
+		Expect(ev.isSettled(static, env.WithPath("static"), false, false)).To(BeFalse())
+	})
+
+	It("evaluates pending expressions again only for changed dependencies", func() {
+		source := parseYAML(`
+chain:
+  a: (( b ))
+  b: (( c ))
+  c: (( d ))
+  d: 1
+other: (( missing ))
+`)
+		pending := func(path ...string) *pending {
+			var e dynaml.Binding = env
+			for _, p := range path {
+				e = e.WithPath(p)
+			}
+			return ev.pending[ev.key(0, e, false, false)]
+		}
+
+		node := source
+		next := func() bool {
+			ev.nextPass()
+			n := pass(node)
+			changed := ev.compare(nil, node, n)
+			node = n
+			return changed
+		}
+
+		// the first pass parses the expressions
+		Expect(next()).To(BeTrue())
+		Expect(next()).To(BeTrue())
+		Expect(pending("chain", "a").deps).To(Equal([][]string{{"chain", "b"}}))
+		Expect(pending("chain", "b").pass).To(Equal(2))
+		Expect(pending("chain", "c")).To(BeNil())
+		Expect(pending("other").deps).To(Equal([][]string{{"missing"}}))
+
+		Expect(next()).To(BeTrue())
+		Expect(pending("chain", "a").pass).To(Equal(3))
+		Expect(pending("chain", "b")).To(BeNil())
+		Expect(pending("other").pass).To(Equal(2))
+
+		Expect(next()).To(BeTrue())
+		Expect(pending("chain", "a")).To(BeNil())
+		Expect(next()).To(BeFalse())
+		Expect(pending("other").pass).To(Equal(2))
+	})
+
+	It("evaluates expressions accessing files again", func() {
+		source := parseYAML(`
+a: (( read("/wr.txt", "text") ))
+b: (( write("/wr.txt", "data") ))
+`)
+		state := NewState("", MODE_FILE_ACCESS, memoryfs.New())
+		result, err := Cascade(NewEnvironment(nil, "test", state), source, Options{})
+		Expect(err).To(BeNil())
+		Expect(result.EquivalentToNode(parseYAML(`
+a: data
+b: data
+`))).To(BeTrue())
+	})
+
+})
@@ -0,0 +1,288 @@
+package flow
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mandelsoft/spiff/debug"
+	"github.com/mandelsoft/spiff/dynaml"
+	"github.com/mandelsoft/spiff/yaml"
+)
+
+// Explanation records the processing steps of a dedicated node
+// to explain how its final value came about. It is attached to
+// a processing State (see State.SetExplanation).
+type Explanation struct {
+	path  []string
+	steps []ExplainStep
+
+	depth     int
+	source    string
+	start     int
+	iteration int
+	last      string
+	redactor  *debug.Redactor
+}
+
+// ExplainStep describes a single processing step of an explained node.
+type ExplainStep struct {
+	// Source is the name of the processed document
+	Source string
+	// Iteration is the iteration of the flow loop for the document
+	Iteration int
+	Message   string
+}
+
+// NewExplanation creates an explanation for the node with the given path.
+// List entries with a key field are addressed by the value of this field,
+// other entries by their index ([n]).
+func NewExplanation(path ...string) *Explanation {
+	return &Explanation{path: path}
+}
+
+func (x *Explanation) Path() []string {
+	return x.path
+}
+
+func (x *Explanation) Steps() []ExplainStep {
+	return x.steps
+}
+
+func (x *Explanation) String() string {
+	buf := &strings.Builder{}
+	fmt.Fprintf(buf, "explain %s\n", strings.Join(x.path, "."))
+	source := ""
+	iteration := 0
+	for _, s := range x.steps {
+		if s.Source != source {
+			source = s.Source
+			iteration = 0
+			fmt.Fprintf(buf, "processing %s\n", source)
+		}
+		if s.Iteration != iteration {
+			iteration = s.Iteration
+			if iteration > 0 {
+				fmt.Fprintf(buf, "  iteration %d\n", iteration)
+			}
+		}
+		indent := "  "
+		if iteration > 0 {
+			indent = "    "
+		}
+		fmt.Fprintf(buf, "%s%s\n", indent, strings.Replace(s.Message, "\n", "\n"+indent+"  ", -1))
+	}
+	return buf.String()
+}
+
+func (x *Explanation) matches(path []string) bool {
+	if len(path) != len(x.path) {
+		return false
+	}
+	for i, step := range path {
+		if step != x.path[i] {
+			if k := strings.Index(step, ":"); k < 0 || step[k+1:] != x.path[i] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (x *Explanation) add(msg string, args ...interface{}) {
+	x.steps = append(x.steps, ExplainStep{x.source, x.iteration, x.redactor.Redact(fmt.Sprintf(msg, args...))})
+}
+
+// once adds a step only once for the flow of a document.
+func (x *Explanation) once(msg string, args ...interface{}) {
+	m := x.redactor.Redact(fmt.Sprintf(msg, args...))
+	for _, s := range x.steps[x.start:] {
+		if s.Message == m {
+			return
+		}
+	}
+	x.add("%s", m)
+}
+
+// begin starts the flow of a document. Nested flows (for example
+// for templates or lambdas) are not traced.
+func (x *Explanation) begin(source string) {
+	x.depth++
+	if x.depth == 1 {
+		x.source = source
+		x.start = len(x.steps)
+		x.iteration = 0
+		x.last = "not present"
+	}
+}
+
+func (x *Explanation) end(result yaml.Node) {
+	if x.depth == 1 {
+		x.iteration = 0
+		if n, ok := yaml.FindR(true, result, nil, x.path...); ok {
+			x.add("result: %s", explainValue(n))
+		} else {
+			if len(x.steps) > x.start {
+				x.add("result: not present")
+			}
+		}
+	}
+	x.depth--
+}
+
+func (x *Explanation) next() {
+	if x.depth == 1 {
+		x.iteration++
+	}
+}
+
+// iterated records the state of the node after an iteration, if it has changed.
+func (x *Explanation) iterated(result yaml.Node) {
+	if x.depth != 1 {
+		return
+	}
+	value := "not present"
+	if n, ok := yaml.FindR(true, result, nil, x.path...); ok {
+		value = explainValue(n)
+	}
+	if value != x.last {
+		x.add("node: %s", value)
+		x.last = value
+	}
+}
+
+// explanation provides the explanation of a processing environment
+// if it is tracing the environment's current node.
+func explanation(env dynaml.Binding) *Explanation {
+	x := explanationOf(env)
+	if x == nil || x.depth != 1 || !x.matches(env.Path()) {
+		return nil
+	}
+	return x
+}
+
+func explanationOf(env dynaml.Binding) *Explanation {
+	if env == nil {
+		return nil
+	}
+	if s, ok := env.GetState().(*State); ok && s != nil {
+		return s.explanation
+	}
+	return nil
+}
+
+// explainExpression records the evaluation of an expression and the
+// values of the references it depends on.
+func (x *Explanation) explainExpression(env dynaml.Binding, expr dynaml.Expression, value interface{}, info dynaml.EvaluationInfo, ok bool) {
+	x.add("evaluate (( %s ))", expr)
+	refs := map[string]bool{}
+	dynaml.Walk(expr, func(e dynaml.Expression) bool {
+		switch r := e.(type) {
+		case dynaml.LambdaExpr:
+			return false
+		case dynaml.ReferenceExpr:
+			name := r.String()
+			if refs[name] {
+				return false
+			}
+			refs[name] = true
+			v, vinfo, ok := r.Evaluate(env, false)
+			if _, expr := v.(dynaml.Expression); ok && !expr {
+				x.add("depends on %s: %s", name, explainValue(sensitiveNode(v, vinfo)))
+			} else {
+				x.add("depends on %s: unresolved", name)
+			}
+		}
+		return true
+	})
+	if _, expr := value.(dynaml.Expression); ok && expr {
+		x.add("pending: %s", explainValue(yaml.NewNode(value, "")))
+	} else if ok {
+		x.add("value: %s", explainValue(sensitiveNode(value, info)))
+	} else {
+		msg := "unresolved"
+		if info.Issue.Issue != "" {
+			msg += ": " + info.Issue.Issue
+		}
+		x.add("%s", msg)
+	}
+}
+
+func sensitiveNode(value interface{}, info dynaml.EvaluationInfo) yaml.Node {
+	n := yaml.NewNode(value, "")
+	if info.Sensitive() {
+		n = yaml.AddFlags(n, yaml.FLAG_SENSITIVE)
+	}
+	return n
+}
+
+func explainValue(n yaml.Node) string {
+	if n == nil || n.Value() == nil {
+		return "~"
+	}
+	if n.Sensitive() {
+		if _, ok := n.Value().(dynaml.Expression); !ok {
+			return debug.REDACTED
+		}
+	}
+	if e, ok := n.Value().(dynaml.Expression); ok {
+		s := fmt.Sprintf("(( %s ))", e)
+		if issue := n.Issue().Issue; issue != "" {
+			s += " (" + issue + ")"
+		}
+		return s
+	}
+	n = yaml.ReplaceValue(Cleanup(n, explainExpressions).Value(), n)
+	data, err := yaml.Marshal(n)
+	if err != nil {
+		return fmt.Sprintf("%v", n.Value())
+	}
+	s := strings.TrimRight(string(data), "\n")
+	if strings.Contains(s, "\n") {
+		return "\n" + s
+	}
+	return s
+}
+
+func explainExpressions(node yaml.Node) (yaml.Node, CleanupFunction) {
+	if e, ok := node.Value().(dynaml.Expression); ok {
+		return yaml.NewNode(fmt.Sprintf("(( %s ))", e), node.SourceName()), explainExpressions
+	}
+	return node, explainExpressions
+}
+
+func explainMapMerge(x *Explanation, base yaml.Node, ok, replace bool) {
+	switch {
+	case base.Value() == nil:
+		x.add("map merge: no value to merge")
+	case !ok:
+		x.add("map merge: no map value to merge")
+	case replace:
+		x.add("map merge replace: entries taken from %s", base.SourceName())
+	default:
+		x.add("map merge: entries of %s merged", base.SourceName())
+	}
+	if base.RedirectPath() != nil {
+		x.add("map merge redirected to %s", strings.Join(base.RedirectPath(), "."))
+	}
+}
+
+func explainListMerge(x *Explanation, template, process, merged, replaced bool, redirect []string, keyName string) {
+	if keyName == "" {
+		keyName = "name"
+	}
+	switch {
+	case template:
+		x.add("list template")
+	case replaced:
+		x.add("list merge replace: entries taken from stub")
+	case merged:
+		x.add("list merge: stub entries added (key field %s)", keyName)
+	case !process:
+		x.add("list merge pending: unresolved merge expression")
+	default:
+		x.add("list merge: no stub list found")
+	}
+	if redirect != nil {
+		x.add("list merge redirected to %s", strings.Join(redirect, "."))
+	}
+}
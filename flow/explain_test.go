@@ -0,0 +1,131 @@
+package flow
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/mandelsoft/spiff/yaml"
+)
+
+func explain(template yaml.Node, path []string, stubs ...yaml.Node) []string {
+	x := NewExplanation(path...)
+	env := NewEnvironment(nil, "context", NewDefaultState().SetExplanation(x))
+	_, err := Cascade(env, template, Options{}, stubs...)
+	Expect(err).To(Succeed())
+	msgs := []string{}
+	for _, s := range x.Steps() {
+		msgs = append(msgs, s.Source+": "+s.Message)
+	}
+	return msgs
+}
+
+var _ = Describe("Explanation", func() {
+	It("traces expressions and dependencies", func() {
+		template := parseYAML(`
+values:
+  a: 1
+  b: (( a + c ))
+  c: (( a * 2 ))
+`, "template")
+		Expect(explain(template, []string{"values", "b"})).To(Equal([]string{
+			"template: parsed (( a + c ))",
+			"template: node: (( a + c ))",
+			"template: evaluate (( a + c ))",
+			"template: depends on a: 1",
+			"template: depends on c: unresolved",
+			"template: pending: (( a + c ))",
+			"template: node: (( a + c )) ('c' unresolved)",
+			"template: evaluate (( a + c ))",
+			"template: depends on a: 1",
+			"template: depends on c: 2",
+			"template: value: 3",
+			"template: node: 3",
+			"template: result: 3",
+		}))
+	})
+
+	It("traces stub overrides", func() {
+		template := parseYAML(`
+values:
+  a: (( 1 + 1 ))
+`, "template")
+		stub := parseYAML(`
+values:
+  a: 5
+`, "stub")
+		Expect(explain(template, []string{"values", "a"}, stub)).To(Equal([]string{
+			"stub: node: 5",
+			"stub: result: 5",
+			"template: parsed (( 1 + 1 ))",
+			"template: node: (( 1 + 1 ))",
+			"template: evaluate (( 1 + 1 ))",
+			"template: value: 2",
+			"template: found values.a in stub stub",
+			"template: overridden by stub stub: 5",
+			"template: node: 5",
+			"template: result: 5",
+		}))
+	})
+
+	It("traces list merges", func() {
+		template := parseYAML(`
+list:
+  - <<: (( merge ))
+  - name: bob
+`, "template")
+		stub := parseYAML(`
+list:
+  - name: alice
+`, "stub")
+		Expect(explain(template, []string{"list"}, stub)).To(ContainElement(
+			"template: list merge: stub entries added (key field name)",
+		))
+	})
+
+	It("traces map merges", func() {
+		template := parseYAML(`
+map:
+  <<: (( merge replace ))
+  bob: 26
+`, "template")
+		stub := parseYAML(`
+map:
+  alice: 25
+`, "stub")
+		Expect(explain(template, []string{"map"}, stub)).To(ContainElement(
+			"template: map merge replace: entries taken from stub",
+		))
+	})
+
+	It("addresses list entries by key", func() {
+		template := parseYAML(`
+list:
+  - name: alice
+    age: (( 20 + 5 ))
+`, "template")
+		Expect(explain(template, []string{"list", "alice", "age"})).To(ContainElement(
+			"template: value: 25",
+		))
+	})
+
+	It("renders the trace", func() {
+		template := parseYAML(`
+a: (( 1 + 1 ))
+`, "template")
+		x := NewExplanation("a")
+		env := NewEnvironment(nil, "context", NewDefaultState().SetExplanation(x))
+		_, err := Cascade(env, template, Options{})
+		Expect(err).To(Succeed())
+		Expect(x.String()).To(Equal(`explain a
+processing template
+  iteration 1
+    parsed (( 1 + 1 ))
+    node: (( 1 + 1 ))
+  iteration 2
+    evaluate (( 1 + 1 ))
+    value: 2
+    node: 2
+  result: 2
+`))
+	})
+})
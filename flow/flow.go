@@ -9,6 +9,7 @@ import (
 	"github.com/mandelsoft/spiff/yaml"
 
 	_ "github.com/mandelsoft/spiff/dynaml/passwd"
+	_ "github.com/mandelsoft/spiff/dynaml/secrets"
 	_ "github.com/mandelsoft/spiff/dynaml/semver"
 	_ "github.com/mandelsoft/spiff/dynaml/wireguard"
 	_ "github.com/mandelsoft/spiff/dynaml/x509"
@@ -19,8 +20,23 @@ func Flow(source yaml.Node, stubs ...yaml.Node) (yaml.Node, error) {
 }
 
 func NestedFlow(outer dynaml.Binding, source yaml.Node, stubs ...yaml.Node) (yaml.Node, error) {
+	return nestedFlow(outer, 0, source, stubs...)
+}
+
+// nestedFlow processes a document with the given worker limit
+// for the concurrent processing. Zero keeps the limit of the
+// processing state.
+func nestedFlow(outer dynaml.Binding, parallel int, source yaml.Node, stubs ...yaml.Node) (yaml.Node, error) {
 	env := NewNestedEnvironment(stubs, source.SourceName(), outer)
 	defer CleanupEnvironment(env)
+	if s, ok := env.GetState().(*State); ok {
+		defer debug.AddRedactor(s.redactor)()
+	}
+	if parallel != 0 {
+		e := env.(*DefaultEnvironment)
+		e.limited = true
+		e.workers = newWorkerPool(parallel)
+	}
 	return env.Flow(source, true)
 }
 
@@ -33,7 +49,15 @@ func get_inherited_flags(env dynaml.Binding) (yaml.NodeFlags, yaml.Node) {
 }
 
 func flow(root yaml.Node, env dynaml.Binding, shouldOverride, enforceTemplate bool) yaml.Node {
+	ev := evaluationOf(env)
+	if ev != nil && ev.isSettled(root, env, shouldOverride, enforceTemplate) {
+		return root
+	}
 	node := _flow(root, env, shouldOverride, enforceTemplate)
+	if root != nil && node.Comments() == nil && root.Comments() != nil {
+		// keep the source comments of the template for evaluated nodes
+		node = yaml.CommentedNode(node, root.Comments())
+	}
 	tag := node.GetAnnotation().Tag()
 	if tag != "" {
 		debug.Debug("found tag %q at %v\n", tag, env.Path())
@@ -54,6 +78,9 @@ func flow(root yaml.Node, env dynaml.Binding, shouldOverride, enforceTemplate bo
 			node = yaml.IssueNode(node, true, true, yaml.NewIssue("%s", err))
 		}
 	}
+	if ev != nil {
+		ev.settle(root, node, env, shouldOverride, enforceTemplate)
+	}
 	return node
 }
 
@@ -110,6 +137,8 @@ func _flow(root yaml.Node, env dynaml.Binding, shouldOverride, enforceTemplate b
 			}
 			var eval interface{} = nil
 			info := dynaml.DefaultInfo()
+			// sensitivity is determined by the actual evaluation
+			flags &^= yaml.FLAG_SENSITIVE
 
 			m, ok := asTemplate(val, enforceTemplate)
 			if ok {
@@ -130,14 +159,18 @@ func _flow(root yaml.Node, env dynaml.Binding, shouldOverride, enforceTemplate b
 				} else {
 					debug.Debug("  value template %s", tval)
 					eval = dynaml.NewTemplateValue(env.Path(), tval, root, env)
+					if x := explanation(env); x != nil {
+						x.add("template declaration (( %s ))", val)
+					}
 				}
 				flags |= m.GetFlags()
 			} else {
-				eval, info, ok = val.Evaluate(env, false)
-				if err := info.Cleanup(); err != nil {
-					info.SetError("%s", err)
-					eval = nil
-					ok = false
+				eval, info, ok = evaluate(val, env)
+				if ok && info.Sensitive() {
+					dynaml.RedactValue(eval, env)
+				}
+				if x := explanation(env); x != nil {
+					x.explainExpression(env, val, eval, info, ok)
 				}
 				if info.RedirectPath != nil {
 					debug.Debug("eval found redirect %v, %v", info.RedirectPath, ok)
@@ -176,6 +209,9 @@ func _flow(root yaml.Node, env dynaml.Binding, shouldOverride, enforceTemplate b
 				} else {
 					result = yaml.NewNode(eval, source)
 				}
+				if source == root.SourceName() {
+					result = yaml.PositionNode(result, root.Position())
+				}
 				_, ok = eval.(string)
 				if ok {
 					// map result to potential expression
@@ -200,6 +236,9 @@ func _flow(root yaml.Node, env dynaml.Binding, shouldOverride, enforceTemplate b
 					keyName = info.KeyName
 					result = yaml.KeyNameNode(result, keyName)
 				}
+				if info.KeyOrder != nil {
+					result = yaml.OrderedNode(result, info.KeyOrder)
+				}
 				if info.RedirectPath != nil {
 					redirect = info.RedirectPath
 					debug.Debug("found redirect %v", redirect)
@@ -233,8 +272,11 @@ func _flow(root yaml.Node, env dynaml.Binding, shouldOverride, enforceTemplate b
 		case string:
 			result, _ := FlowString(root, env)
 			if result != nil {
-				_, ok := result.Value().(dynaml.Expression)
+				e, ok := result.Value().(dynaml.Expression)
 				if ok {
+					if x := explanation(env); x != nil {
+						x.add("parsed (( %s ))", e)
+					}
 					// analyse expression before overriding
 					return result
 				}
@@ -246,6 +288,9 @@ func _flow(root yaml.Node, env dynaml.Binding, shouldOverride, enforceTemplate b
 		debug.Debug("/// lookup stub %v -> %v\n", env.Path(), env.StubPath())
 		overridden, found := env.FindInStubs(env.StubPath())
 		if found && !overridden.Flags().Default() && !root.Flags().Injected() {
+			if x := explanation(env); x != nil {
+				x.once("overridden by stub %s: %s", overridden.SourceName(), explainValue(overridden))
+			}
 			root, _ = substituteNode(overridden)
 			if keyName != "" {
 				root = yaml.KeyNameNode(root, keyName)
@@ -297,6 +342,7 @@ func flowMap(root yaml.Node, env dynaml.Binding, shouldOverride, template bool)
 
 	redirect := root.RedirectPath()
 	replace := root.ReplaceFlag()
+	order := root.KeyOrder()
 	newMap := make(map[string]yaml.Node)
 	undefined := make(map[string]yaml.Node)
 
@@ -315,6 +361,7 @@ func flowMap(root yaml.Node, env dynaml.Binding, shouldOverride, template bool)
 		mergeval, ok = rootMap[yaml.MERGEKEY]
 	}
 
+	x := explanation(rootEnv)
 	if ok {
 		val := mergeval
 		debug.Debug("handle map merge %#v\n", val)
@@ -350,14 +397,23 @@ func flowMap(root yaml.Node, env dynaml.Binding, shouldOverride, template bool)
 				if val != nil {
 					debug.Debug("  insert expression: %v\n", val)
 				}
+				if x != nil {
+					x.add("map template")
+				}
 			} else {
 				if simpleMergeCompatibilityCheck(initial, base) {
 					debug.Debug("  skip merge\n")
 					val = nil
+					if x != nil {
+						x.add("map merge skipped: no stub value")
+					}
 				} else {
 					debug.Debug("  continue merge\n")
 					processed = false
 					val = base
+					if x != nil && !ok {
+						x.add("map merge pending: (( %s ))", e)
+					}
 				}
 			}
 		} else {
@@ -379,9 +435,13 @@ func flowMap(root yaml.Node, env dynaml.Binding, shouldOverride, template bool)
 				for k, v := range baseMap {
 					newMap[k] = v
 				}
+				order = mergeKeyOrder(order, mergekey, base)
 			}
 			// still ignore non dynaml value (might be strange but compatible)
 			replace = base.ReplaceFlag()
+			if x != nil {
+				explainMapMerge(x, base, ok, replace)
+			}
 			parseError := yaml.EmbeddedDynaml(base, env.GetState().InterpolationEnabled()) != nil
 			if !ok && base.Value() != nil && !parseError {
 				err = fmt.Errorf("require map value for '<<' insert, found '%s'", dynaml.ExpressionType(base.Value()))
@@ -407,6 +467,18 @@ func flowMap(root yaml.Node, env dynaml.Binding, shouldOverride, template bool)
 
 	if addEntries {
 		sortedKeys := yaml.GetSortedKeys(rootMap)
+		tasks := map[string]*flowTask{}
+		if processed {
+			list := []*flowTask{}
+			for _, key := range sortedKeys {
+				if key != mergekey {
+					t := &flowTask{node: rootMap[key], env: env.WithPath(key), shouldOverride: shouldOverride, enforceTemplate: dynaml.RequireTemplate(key, env)}
+					tasks[key] = t
+					list = append(list, t)
+				}
+			}
+			flowTasks(list)
+		}
 		for i := range sortedKeys {
 			key := sortedKeys[i]
 			val := rootMap[key]
@@ -418,7 +490,7 @@ func flowMap(root yaml.Node, env dynaml.Binding, shouldOverride, template bool)
 				}
 			} else {
 				if processed {
-					val = flow(val, env.WithPath(key), shouldOverride, dynaml.RequireTemplate(key, env))
+					val = tasks[key].result
 				} else {
 					debug.Debug("skip %q flow for unprocessed indication\n", key)
 				}
@@ -455,7 +527,7 @@ func flowMap(root yaml.Node, env dynaml.Binding, shouldOverride, template bool)
 	var result interface{}
 	if template {
 		debug.Debug(" as template\n")
-		result = dynaml.NewTemplateValue(env.Path(), yaml.NewNode(newMap, root.SourceName()), root, rootEnv)
+		result = dynaml.NewTemplateValue(env.Path(), yaml.OrderedNode(yaml.NewNode(newMap, root.SourceName()), order), root, rootEnv)
 	} else {
 		result = newMap
 	}
@@ -465,6 +537,9 @@ func flowMap(root yaml.Node, env dynaml.Binding, shouldOverride, template bool)
 	} else {
 		node = yaml.RedirectNode(result, root, redirect)
 	}
+	if order != nil {
+		node = yaml.OrderedNode(node, order)
+	}
 
 	if err != nil || failed {
 		if err != nil {
@@ -478,6 +553,23 @@ func flowMap(root yaml.Node, env dynaml.Binding, shouldOverride, template bool)
 	return updateNode(node, flags, tag)
 }
 
+// mergeKeyOrder replaces the merge key in the key order of a map
+// by the keys of the merged map.
+func mergeKeyOrder(order []string, mergekey string, base yaml.Node) []string {
+	if order == nil {
+		return nil
+	}
+	result := []string{}
+	for _, k := range order {
+		if k == mergekey {
+			result = append(result, yaml.GetOrderedKeys(base)...)
+		} else {
+			result = append(result, k)
+		}
+	}
+	return result
+}
+
 func flowList(root yaml.Node, env dynaml.Binding, template bool) yaml.Node {
 	rootList := root.Value().([]yaml.Node)
 
@@ -490,12 +582,25 @@ func flowList(root yaml.Node, env dynaml.Binding, template bool) yaml.Node {
 		if redirectPath != nil {
 			env = env.RedirectOverwrite(redirectPath)
 		}
+		tasks := []*flowTask{}
 		for idx, val := range merged.([]yaml.Node) {
 			step, resolved := stepName(idx, val, keyName, env)
 			debug.Debug("  step %s\n", step)
 			if resolved {
-				val = flow(val, env.WithPath(step), false, false)
+				tasks = append(tasks, &flowTask{node: val, env: env.WithPath(step)})
+			} else {
+				tasks = append(tasks, &flowTask{result: val})
 			}
+		}
+		pending := []*flowTask{}
+		for _, t := range tasks {
+			if t.env != nil {
+				pending = append(pending, t)
+			}
+		}
+		flowTasks(pending)
+		for _, t := range tasks {
+			val := t.result
 			if !val.Undefined() {
 				newList = append(newList, val)
 			}
@@ -603,6 +708,7 @@ func processMerges(orig yaml.Node, root []yaml.Node, env dynaml.Binding, templat
 	replaced := orig.ReplaceFlag()
 	redirectPath := orig.RedirectPath()
 
+	inlined := false
 	for _, val := range root {
 		if val == nil {
 			continue
@@ -610,6 +716,7 @@ func processMerges(orig yaml.Node, root []yaml.Node, env dynaml.Binding, templat
 
 		inlineNode, qual, ok := yaml.UnresolvedListEntryMerge(val)
 		if ok {
+			inlined = true
 			debug.Debug("*** %+v\n", inlineNode.Value())
 			_, initial := inlineNode.Value().(string)
 			result := _flow(inlineNode, env, false, false)
@@ -671,7 +778,7 @@ func processMerges(orig yaml.Node, root []yaml.Node, env dynaml.Binding, templat
 			}
 		}
 
-		val, newKey := ProcessKeyTag(val)
+		val, newKey := yaml.ProcessKeyTag(val)
 		if newKey != "" {
 			keyName = newKey
 		}
@@ -703,39 +810,18 @@ func processMerges(orig yaml.Node, root []yaml.Node, env dynaml.Binding, templat
 	}
 
 	debug.Debug("--> %+v  proc=%v replaced=%v redirect=%v key=%s\n", result, process, replaced, redirectPath, keyName)
+	if x := explanation(env); x != nil && (inlined || template) {
+		explainListMerge(x, template, process, merged, replaced, redirectPath, keyName)
+	}
 	return result, process, replaced, redirectPath, keyName, merged, flags, tag, stub
 }
 
+// ProcessKeyTag removes the key tag from the field of a list entry
+// marked as key field.
+//
+// Deprecated: use yaml.ProcessKeyTag
 func ProcessKeyTag(val yaml.Node) (yaml.Node, string) {
-	keyName := ""
-
-	m, ok := val.Value().(map[string]yaml.Node)
-	if ok {
-		found := false
-		for key, _ := range m {
-			split := strings.Index(key, ":")
-			if split > 0 {
-				if key[:split] == "key" {
-					keyName = key[split+1:]
-					found = true
-				}
-			}
-		}
-		if found {
-			newMap := make(map[string]yaml.Node)
-			for key, v := range m {
-				split := strings.Index(key, ":")
-				if split > 0 {
-					if key[:split] == "key" {
-						key = key[split+1:]
-					}
-				}
-				newMap[key] = v
-			}
-			return yaml.SubstituteNode(newMap, val), keyName
-		}
-	}
-	return val, keyName
+	return yaml.ProcessKeyTag(val)
 }
 
 func newEntries(a []yaml.Node, b []yaml.Node, keyName string) []yaml.Node {
@@ -0,0 +1,54 @@
+package flow
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/mandelsoft/spiff/yaml"
+)
+
+// The benchmarks process the example documents of the repository.
+
+func readFixture(b *testing.B, name string) yaml.Node {
+	data, err := ioutil.ReadFile(filepath.Join("..", "examples", name))
+	if err != nil {
+		b.Fatal(err)
+	}
+	node, err := yaml.Parse(name, data)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return node
+}
+
+func benchmarkCascade(b *testing.B, opts Options, template yaml.Node, stubs ...yaml.Node) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, err := Cascade(nil, template, opts, append(stubs[:0:0], stubs...)...)
+		if err != nil && !opts.Partial {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFlowExamples(b *testing.B) {
+	for _, name := range []string{"cf-aws.yml", "multi-az-cf-aws.yml"} {
+		template := readFixture(b, name)
+		b.Run(name, func(b *testing.B) {
+			benchmarkCascade(b, Options{Partial: true}, template)
+		})
+		b.Run(name+"/parallel", func(b *testing.B) {
+			benchmarkCascade(b, Options{Partial: true, Parallel: 4}, template)
+		})
+	}
+}
+
+func BenchmarkFlowGraph(b *testing.B) {
+	template := readFixture(b, "graph/closures.yaml")
+	stubs := []yaml.Node{
+		readFixture(b, "graph/graph.yaml"),
+		readFixture(b, "graph/utilities.yaml"),
+	}
+	benchmarkCascade(b, Options{}, template, stubs...)
+}
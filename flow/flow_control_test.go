@@ -1139,7 +1139,7 @@ cond:
   <<then: alice
   <<else: (( 1 / 0 ))
 `)
-			Expect(source).To(FlowToErr("\t(( 1 / 0 ))\tin test\tcond\t(...<<else)\t*division by zero").WithFeatures(features.CONTROL))
+			Expect(source).To(FlowToErr("\t(( 1 / 0 ))\tin test:7:11\tcond\t(...<<else)\t*division by zero").WithFeatures(features.CONTROL))
 		})
 		It("fails for used nested error nodes", func() {
 			source := parseYAML(`
@@ -1151,7 +1151,7 @@ cond:
   <<else:
     nested: (( 1 / 0 ))
 `)
-			Expect(source).To(FlowToErr("\t(( 1 / 0 ))\tin test\tcond.nested\t(cond.<<else.nested)\t*division by zero").WithFeatures(features.CONTROL))
+			Expect(source).To(FlowToErr("\t(( 1 / 0 ))\tin test:8:13\tcond.nested\t(cond.<<else.nested)\t*division by zero").WithFeatures(features.CONTROL))
 		})
 		It("fails for missing cases case", func() {
 			source := parseYAML(`
@@ -1162,7 +1162,7 @@ selected:
   <<cases:
   - value: alice 
 `)
-			Expect(source).To(FlowToErr("\t<switch control>\tin test\tselected\t()\t*case 0 requires 'case' or `'match' field").WithFeatures(features.CONTROL))
+			Expect(source).To(FlowToErr("\t<switch control>\tin test:5:3\tselected\t()\t*case 0 requires 'case' or `'match' field").WithFeatures(features.CONTROL))
 		})
 		It("fails for used nested cases error nodes", func() {
 			source := parseYAML(`
@@ -1178,7 +1178,7 @@ selected:
     value:
       other: (( 1 / 0 ))
 `)
-			Expect(source).To(FlowToErr("\t(( 1 / 0 ))\tin test\tselected.nested\t(selected.<<cases.[0].value.nested)\t*division by zero").WithFeatures(features.CONTROL))
+			Expect(source).To(FlowToErr("\t(( 1 / 0 ))\tin test:9:15\tselected.nested\t(selected.<<cases.[0].value.nested)\t*division by zero").WithFeatures(features.CONTROL))
 		})
 
 	})
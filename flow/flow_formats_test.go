@@ -0,0 +1,88 @@
+package flow
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("data formats", func() {
+	It("parses data formats", func() {
+		source := parseYAML(`
+---
+toml: |
+  port = 8080
+  [db]
+  host = "db"
+env: |
+  HOST=localhost
+  MSG="hello world"
+csv: |
+  name,value
+  a,1
+ptoml: (( parse(toml, "toml") ))
+penv: (( parse(env, "dotenv") ))
+pcsv: (( parse(csv, "csv") ))
+`)
+		resolved := parseYAML(`
+---
+toml: |
+  port = 8080
+  [db]
+  host = "db"
+env: |
+  HOST=localhost
+  MSG="hello world"
+csv: |
+  name,value
+  a,1
+ptoml:
+  port: 8080
+  db:
+    host: db
+penv:
+  HOST: localhost
+  MSG: hello world
+pcsv:
+  - name: a
+    value: "1"
+`)
+		Expect(source).To(FlowAs(resolved))
+	})
+
+	It("formats values", func() {
+		source := parseYAML(`
+---
+data:
+  port: 8080
+  db:
+    host: db
+toml: (( asformat(data, "toml") ))
+props: (( asformat(data, "properties") ))
+ini: (( asformat(data, "ini") ))
+json: (( asformat(data, "json") ))
+env: (( asformat(data, "dotenv") ))
+`)
+		resolved := parseYAML(`
+---
+data:
+  port: 8080
+  db:
+    host: db
+toml: "port = 8080\n\n[db]\n  host = \"db\"\n"
+props: "db.host = db\nport = 8080\n"
+ini: "port = 8080\n\n[db]\nhost = db\n\n"
+json: '{"db":{"host":"db"},"port":8080}'
+env: "DB_HOST=db\nPORT=8080\n"
+`)
+		Expect(source).To(FlowAs(resolved))
+	})
+
+	It("fails for unknown formats", func() {
+		source := parseYAML(`
+---
+data: {}
+value: (( asformat(data, "xml") ))
+`)
+		Expect(source).To(FlowToErr(`	(( asformat(data, "xml") ))	in test:4:8	value	()	*asformat: unknown data format "xml"`))
+	})
+})
@@ -0,0 +1,65 @@
+package flow
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("query function", func() {
+	It("queries nested values", func() {
+		source := parseYAML(`
+---
+manifests:
+  - kind: Deployment
+    metadata:
+      name: frontend
+    spec:
+      template:
+        spec:
+          containers:
+            - name: web
+              image: nginx
+            - name: sidecar
+              image: envoy
+  - kind: Service
+    metadata:
+      name: frontend
+images: (( query(manifests, "$..containers[*].image") ))
+web: (( query(manifests, "$[?(@.kind == 'Deployment')]..containers.web.image") ))
+names: (( query(manifests, "[*].metadata.name") ))
+none: (( query(~, "$.a") ))
+`)
+		resolved := parseYAML(`
+---
+manifests:
+  - kind: Deployment
+    metadata:
+      name: frontend
+    spec:
+      template:
+        spec:
+          containers:
+            - name: web
+              image: nginx
+            - name: sidecar
+              image: envoy
+  - kind: Service
+    metadata:
+      name: frontend
+images: [ nginx, envoy ]
+web: [ nginx ]
+names: [ frontend, frontend ]
+none: []
+`)
+		Expect(source).To(FlowAs(resolved))
+	})
+
+	It("fails for invalid paths", func() {
+		source := parseYAML(`
+---
+data: {}
+value: (( query(data, "$[") ))
+`)
+		Expect(source).To(FlowToErr(`	(( query(data, "$[") ))	in test:4:8	value	()	*query: invalid path "$[": missing ]`))
+	})
+})
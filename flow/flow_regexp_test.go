@@ -0,0 +1,96 @@
+package flow
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("regular expression functions", func() {
+	It("finds all matches", func() {
+		source := parseYAML(`
+---
+versions: (( match_all("[0-9]+\\.[0-9]+\\.[0-9]+", "v 1.2.3 and v 4.5.6") ))
+none: (( match_all("[0-9]+", "none") ))
+`)
+		resolved := parseYAML(`
+---
+versions:
+  - 1.2.3
+  - 4.5.6
+none: []
+`)
+		Expect(source).To(FlowAs(resolved))
+	})
+
+	It("provides named groups", func() {
+		source := parseYAML(`
+---
+host: (( match_named("(?P<name>[^.]+)\\.(?P<domain>.*)", "web01.example.com") ))
+all: (( match_named("(?P<key>\\w+)=(?P<value>\\w+)", "a=1, b=2", true) ))
+none: (( match_named("(?P<name>[0-9]+)", "none") ))
+`)
+		resolved := parseYAML(`
+---
+host:
+  name: web01
+  domain: example.com
+all:
+  - key: a
+    value: "1"
+  - key: b
+    value: "2"
+none: {}
+`)
+		Expect(source).To(FlowAs(resolved))
+	})
+
+	It("escapes regular expressions", func() {
+		source := parseYAML(`
+---
+version: 1.2.3+build
+escaped: (( regex_escape(version) ))
+match: (( match_all(regex_escape(version), "1.2.3+build 1x2x3+build") ))
+`)
+		resolved := parseYAML(`
+---
+version: 1.2.3+build
+escaped: 1\.2\.3\+build
+match:
+  - 1.2.3+build
+`)
+		Expect(source).To(FlowAs(resolved))
+	})
+
+	It("filters lists", func() {
+		source := parseYAML(`
+---
+hosts: [ web01, db01, web02 ]
+web: (( grep(hosts, "^web") ))
+other: (( grep(hosts, "^web", true) ))
+`)
+		resolved := parseYAML(`
+---
+hosts: [ web01, db01, web02 ]
+web: [ web01, web02 ]
+other: [ db01 ]
+`)
+		Expect(source).To(FlowAs(resolved))
+	})
+
+	It("reports invalid patterns", func() {
+		source := parseYAML(`
+---
+web: (( grep([], "(") ))
+`)
+		Expect(source).To(FlowToErr(`	(( grep([], "(") ))	in test:3:6	web	()	*grep: error parsing regexp: missing closing ): ` + "`(`"))
+	})
+
+	It("caches compiled patterns in the processing state", func() {
+		state := NewDefaultState()
+		re, err := state.Regexp("^web")
+		Expect(err).To(BeNil())
+		again, err := state.Regexp("^web")
+		Expect(err).To(BeNil())
+		Expect(again).To(BeIdenticalTo(re))
+	})
+})
@@ -264,9 +264,9 @@ foo: (( auto ))
 			Expect(err).To(Equal(dynaml.UnresolvedNodes{
 				Nodes: []dynaml.UnresolvedNode{
 					{
-						Node: yaml.IssueNode(yaml.NewNode(
+						Node: yaml.IssueNode(yaml.NewPositionedNode(
 							dynaml.AutoExpr{Path: []string{"foo"}},
-							"test",
+							"test", yaml.Position{Line: 3, Column: 6},
 						), true, false, yaml.NewPathIssue([]string{"foo"}, "auto only allowed for size entry in resource pools")),
 						Context: []string{"foo"},
 						Path:    []string{"foo"},
@@ -8193,6 +8193,68 @@ decrypted: true
 `)
 			Expect(source).To(FlowAs(resolved))
 		})
+		It("encrypts with AES-256-GCM by default", func() {
+			source := parseYAML(`
+---
+password: this a very secret secret and may never be exposed to unauthorized people
+encrypted: (( &temporary(encrypt("spiff is a cool tool", password)) ))
+header: (( substr(encrypted, 0, 20) ))
+decrypted: (( decrypt(encrypted, password, "AES-256-GCM") ))
+`)
+			resolved := parseYAML(`
+---
+password: this a very secret secret and may never be exposed to unauthorized people
+header: "spiff:1:AES-256-GCM:"
+decrypted: spiff is a cool tool
+`)
+			Expect(source).To(FlowAs(resolved))
+		})
+		It("detects the encryption method", func() {
+			source := parseYAML(`
+---
+password: this a very secret secret and may never be exposed to unauthorized people
+encrypted: (( &temporary(encrypt("spiff is a cool tool", password, "ChaCha20-Poly1305")) ))
+header: (( substr(encrypted, 0, 26) ))
+decrypted: (( decrypt(encrypted, password) ))
+legacy: (( decrypt("d889f9e4cc7ae13effcbc8bb8cd0c38d1fb2197738444f753c48796d7946083e6639e5a1bf8f77648f2a1ddf37023c65ff57d52d0519d1d92cbcf87d3e263cba", password) ))
+`)
+			resolved := parseYAML(`
+---
+password: this a very secret secret and may never be exposed to unauthorized people
+header: "spiff:1:ChaCha20-Poly1305:"
+decrypted: spiff is a cool tool
+legacy: spiff is a cool tool
+`)
+			Expect(source).To(FlowAs(resolved))
+		})
+		It("rejects wrong keys", func() {
+			source := parseYAML(`
+---
+encrypted: (( encrypt("spiff is a cool tool", "key") ))
+decrypted: (( decrypt(encrypted, "other") ))
+`)
+			Expect(source).To(FlowToErr(`	(( decrypt(encrypted, "other") ))	in test:4:12	decrypted	()	*invalid key or corrupted data`))
+		})
+		It("derives keys once per processing", func() {
+			source := parseYAML(`
+---
+password: this a very secret secret and may never be exposed to unauthorized people
+alice: (( &temporary(encrypt("alice", password)) ))
+bob: (( &temporary(encrypt("bob", password)) ))
+decrypted: (( [ decrypt(alice, password), decrypt(bob, password) ] ))
+`)
+			resolved := parseYAML(`
+---
+password: this a very secret secret and may never be exposed to unauthorized people
+decrypted: [ alice, bob ]
+`)
+			state := NewDefaultState()
+			result, err := Cascade(NewEnvironment(nil, "context", state), source, Options{})
+			Expect(err).To(BeNil())
+			Expect(result.EquivalentToNode(resolved)).To(BeTrue())
+			Expect(len(state.salts)).To(Equal(1))
+			Expect(len(state.keys)).To(Equal(1))
+		})
 	})
 
 	Describe("basename", func() {
@@ -0,0 +1,104 @@
+package flow
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/mandelsoft/spiff/dynaml"
+	"github.com/mandelsoft/spiff/yaml"
+)
+
+var _ = Describe("time functions", func() {
+	var state *State
+
+	// time values are compared by their marshalled representation
+	process := func(template string) interface{} {
+		binding := NewEnvironment(nil, "context", state)
+		result, err := Cascade(binding, parseYAML(template), Options{})
+		Expect(err).To(BeNil())
+		normalized, err := yaml.Normalize(result)
+		Expect(err).To(BeNil())
+		return normalized
+	}
+
+	BeforeEach(func() {
+		state = NewDefaultState()
+	})
+
+	It("parses and formats times", func() {
+		Expect(process(`
+---
+time: (( parsetime("2024-02-29T10:00:00Z") ))
+type: (( type(time) ))
+custom: (( parsetime("29.02.2024 10:00", "02.01.2006 15:04") ))
+unix: (( parsetime(1709200800) ))
+date: (( formattime(time, "DateOnly") ))
+seconds: (( formattime(time, "unix") ))
+message: (( "created at " time ))
+`)).To(Equal(map[string]interface{}{
+			"time":    "2024-02-29T10:00:00Z",
+			"type":    "time",
+			"custom":  "2024-02-29T10:00:00Z",
+			"unix":    "2024-02-29T10:00:00Z",
+			"date":    "2024-02-29",
+			"seconds": int64(1709200800),
+			"message": "created at 2024-02-29T10:00:00Z",
+		}))
+	})
+
+	It("handles durations", func() {
+		Expect(process(`
+---
+time: (( parsetime("2024-02-29T10:00:00Z") ))
+later: (( addduration(time, "1d12h") ))
+earlier: (( addduration(time, -3600) ))
+duration: (( duration(time, later) ))
+week: (( duration("1w") ))
+`)).To(Equal(map[string]interface{}{
+			"time":     "2024-02-29T10:00:00Z",
+			"later":    "2024-03-01T22:00:00Z",
+			"earlier":  "2024-02-29T09:00:00Z",
+			"duration": int64(129600),
+			"week":     int64(604800),
+		}))
+	})
+
+	It("compares times", func() {
+		source := parseYAML(`
+---
+time: (( &temporary(parsetime("2024-02-29T10:00:00Z")) ))
+less: (( time < addduration(time, "1h") ))
+greater: (( time > "2024-02-29T11:00:00Z" ))
+equal: (( time == "2024-02-29T10:00:00Z" ))
+`)
+		resolved := parseYAML(`
+---
+less: true
+greater: false
+equal: true
+`)
+		Expect(source).To(FlowAs(resolved))
+	})
+
+	It("rejects invalid durations", func() {
+		source := parseYAML(`
+---
+time: (( addduration(now(), "1x") ))
+`)
+		Expect(source).To(FlowToErr(`	(( addduration(now(), "1x") ))	in test:3:7	time	()	*addduration: invalid duration "1x"`))
+	})
+
+	It("uses the clock of the processing state", func() {
+		state.SetClock(dynaml.FixedClock(time.Date(2024, 2, 29, 10, 0, 0, 0, time.UTC)))
+		Expect(process(`
+---
+now: (( now() ))
+valid: (( now() < "2025-01-01T00:00:00Z" ))
+`)).To(Equal(map[string]interface{}{
+			"now":   "2024-02-29T10:00:00Z",
+			"valid": true,
+		}))
+	})
+})
@@ -0,0 +1,317 @@
+package flow
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/mandelsoft/spiff/dynaml"
+	"github.com/mandelsoft/spiff/yaml"
+)
+
+// tagStore is the common interface of the State and tag buffers
+// used to set tags.
+type tagStore interface {
+	dynaml.State
+	lookupTag(name string) *dynaml.TagInfo
+	storeTag(tag *dynaml.TagInfo)
+}
+
+var _ tagStore = &State{}
+var _ tagStore = &tagBuffer{}
+
+// tagBuffer records the tags set by a concurrently processed node.
+// They are visible for the processing of the node itself, only.
+// Sibling nodes see them after all siblings have been processed
+// and the buffers are committed in the order of the nodes. This
+// keeps the tag handling independent of the scheduling of the workers.
+type tagBuffer struct {
+	*State
+	lock   sync.Mutex
+	parent tagStore
+	tags   map[string]*dynaml.TagInfo
+	order  []string
+	read   bool // tags have been looked up
+}
+
+func newTagBuffer(parent tagStore, state *State) *tagBuffer {
+	return &tagBuffer{
+		State:  state,
+		parent: parent,
+		tags:   map[string]*dynaml.TagInfo{},
+	}
+}
+
+func (b *tagBuffer) lookupTag(name string) *dynaml.TagInfo {
+	b.lock.Lock()
+	t := b.tags[name]
+	b.lock.Unlock()
+	if t != nil {
+		return t
+	}
+	return b.parent.lookupTag(name)
+}
+
+func (b *tagBuffer) storeTag(tag *dynaml.TagInfo) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.tags[tag.Name()] == nil {
+		b.order = append(b.order, tag.Name())
+	}
+	b.tags[tag.Name()] = tag
+}
+
+func (b *tagBuffer) SetTag(name string, node yaml.Node, path []string, scope dynaml.TagScope) error {
+	name = strings.Replace(name, ":", ".", -1)
+	old := b.lookupTag(name)
+	if err := checkTag(old, name, path); err != nil {
+		return err
+	}
+	tag := newTagInfo(name, node, path, scope)
+	if tagChanged(old, tag) {
+		b.State.tagsModified()
+	}
+	b.storeTag(tag)
+	return nil
+}
+
+func (b *tagBuffer) GetTag(name string) *dynaml.Tag {
+	b.lock.Lock()
+	b.read = true
+	t := b.tags[strings.Replace(name, ":", ".", -1)]
+	b.lock.Unlock()
+	if t != nil {
+		return t.Tag()
+	}
+	return b.parent.GetTag(name)
+}
+
+func (b *tagBuffer) GetTags(name string) []*dynaml.TagInfo {
+	name = strings.Replace(name, ":", ".", -1)
+	list := b.parent.GetTags(name)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.read = true
+	if strings.HasPrefix(name, "doc.") || len(b.tags) == 0 {
+		return list
+	}
+	prefix := name + "."
+	result := []*dynaml.TagInfo{}
+	for _, t := range list {
+		if b.tags[t.Name()] == nil {
+			result = append(result, t)
+		}
+	}
+	for _, t := range b.tags {
+		if t.Name() == name || strings.HasPrefix(t.Name(), prefix) {
+			result = append(result, t)
+		}
+	}
+	sortTags(result)
+	return result
+}
+
+// commit propagates the buffered tags to the parent store. If any
+// tag conflicts with a tag of the parent nothing is propagated.
+// The first result reports whether the tags of the parent changed.
+func (b *tagBuffer) commit() (bool, bool) {
+	for _, n := range b.order {
+		if checkTag(b.parent.lookupTag(n), n, b.tags[n].Path()) != nil {
+			return false, false
+		}
+	}
+	changed := false
+	for _, n := range b.order {
+		t := b.tags[n]
+		if tagChanged(b.parent.lookupTag(n), t) {
+			changed = true
+		}
+		b.parent.storeTag(t)
+	}
+	return changed, true
+}
+
+// workerPool limits the number of workers processing the nodes
+// of a document concurrently. The nil pool permits no additional
+// workers.
+type workerPool struct {
+	tokens chan struct{} // tokens for additional workers
+}
+
+// newWorkerPool provides a pool for the given maximum number of
+// workers. A value less than two disables the concurrent processing.
+func newWorkerPool(n int) *workerPool {
+	if n <= 1 {
+		return nil
+	}
+	return &workerPool{make(chan struct{}, n-1)}
+}
+
+func (p *workerPool) size() int {
+	if p == nil {
+		return 1
+	}
+	return cap(p.tokens) + 1
+}
+
+// acquire tries to reserve an additional worker.
+func (p *workerPool) acquire() bool {
+	if p == nil {
+		return false
+	}
+	select {
+	case p.tokens <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *workerPool) release() {
+	<-p.tokens
+}
+
+// parallelState provides the processing state and the worker pool,
+// if concurrent processing is enabled for an environment. The worker
+// limit of an environment (or its outer environments) overrides the
+// one of the processing state.
+func parallelState(env dynaml.Binding) (*State, *workerPool) {
+	e, ok := env.(*DefaultEnvironment)
+	if !ok {
+		return nil, nil
+	}
+	var state *State
+	switch s := e.GetState().(type) {
+	case *State:
+		state = s
+	case *tagBuffer:
+		state = s.State
+	}
+	if state == nil || state.explanation != nil {
+		// explanations require the sequential processing
+		return nil, nil
+	}
+	pool := state.workers
+	for o := e; o != nil; {
+		if o.limited {
+			pool = o.workers
+			break
+		}
+		o, _ = o.outer.(*DefaultEnvironment)
+	}
+	if pool.size() <= 1 {
+		return nil, nil
+	}
+	return state, pool
+}
+
+// flowTask describes the processing of a single node of a map or list.
+type flowTask struct {
+	node            yaml.Node
+	env             dynaml.Binding
+	shouldOverride  bool
+	enforceTemplate bool
+	result          yaml.Node
+}
+
+func (t *flowTask) flow(env dynaml.Binding) {
+	t.result = flow(t.node, env, t.shouldOverride, t.enforceTemplate)
+}
+
+// flowTasks processes the nodes of a map or list. If enabled for the
+// processing state, the nodes are processed concurrently by the
+// available workers. Tags set by nodes processed concurrently are
+// committed in the order of the tasks. A node setting a tag
+// conflicting with the tags of its predecessors is processed again
+// afterwards to report the conflict as it would be reported by the
+// sequential processing.
+func flowTasks(tasks []*flowTask) {
+	var state *State
+	var pool *workerPool
+	if len(tasks) > 1 {
+		state, pool = parallelState(tasks[0].env)
+	}
+	if state == nil {
+		for _, t := range tasks {
+			t.flow(t.env)
+		}
+		return
+	}
+
+	var wg sync.WaitGroup
+	var failure interface{}
+	var lock sync.Mutex
+
+	buffers := make([]*tagBuffer, len(tasks))
+	for i, t := range tasks {
+		n := t.buffered(state)
+		buffers[i] = n.tags
+		if !t.concurrent(n) || !pool.acquire() {
+			t.flow(n)
+			continue
+		}
+		wg.Add(1)
+		go func(t *flowTask, env dynaml.Binding) {
+			defer func() {
+				if r := recover(); r != nil {
+					lock.Lock()
+					if failure == nil {
+						failure = r
+					}
+					lock.Unlock()
+				}
+				pool.release()
+				wg.Done()
+			}()
+			t.flow(env)
+		}(t, n)
+	}
+	wg.Wait()
+	if failure != nil {
+		panic(failure)
+	}
+
+	dirty := false
+	for i, t := range tasks {
+		b := buffers[i]
+		if dirty && b.read {
+			// the node might depend on tags set by its predecessors,
+			// process it again like the sequential processing would do.
+			n := t.buffered(state)
+			n.evaluation = nil
+			t.flow(n)
+			b = n.tags
+		}
+		changed, ok := b.commit()
+		if !ok {
+			// process again without skipping settled nodes to set all tags
+			n := *t.env.(*DefaultEnvironment)
+			n.evaluation = nil
+			t.flow(&n)
+			changed = true
+		}
+		dirty = dirty || changed
+	}
+}
+
+// concurrent checks whether the processing of the node is worth
+// a dedicated worker. Plain values are processed directly.
+func (t *flowTask) concurrent(env dynaml.Binding) bool {
+	if t.node == nil {
+		return false
+	}
+	switch t.node.Value().(type) {
+	case map[string]yaml.Node, []yaml.Node, dynaml.Expression:
+		return true
+	case string:
+		return yaml.EmbeddedDynaml(t.node, env.GetState().InterpolationEnabled()) != nil
+	}
+	return false
+}
+
+// buffered provides an environment for the task buffering the tags.
+func (t *flowTask) buffered(state *State) *DefaultEnvironment {
+	e := t.env.(*DefaultEnvironment)
+	n := *e
+	n.tags = newTagBuffer(e.GetState().(tagStore), state)
+	return &n
+}
@@ -0,0 +1,161 @@
+package flow
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/mandelsoft/spiff/dynaml"
+	"github.com/mandelsoft/spiff/yaml"
+)
+
+var _ = Describe("Parallel processing", func() {
+	cascade := func(parallel int, template string, stubs ...string) (yaml.Node, error) {
+		nodes := []yaml.Node{}
+		for _, s := range stubs {
+			nodes = append(nodes, parseYAML(s, "stub"))
+		}
+		return Cascade(nil, parseYAML(template, "template"), Options{Parallel: parallel}, nodes...)
+	}
+
+	lines := func(err error) []string {
+		return strings.Split(err.Error(), "\n")
+	}
+
+	same := func(template string, stubs ...string) yaml.Node {
+		expected, experr := cascade(0, template, stubs...)
+		for i := 0; i < 10; i++ {
+			result, err := cascade(4, template, stubs...)
+			if experr != nil {
+				Expect(err).NotTo(BeNil())
+				// the order of the unresolved nodes is not stable
+				Expect(lines(err)).To(ConsistOf(lines(experr)))
+			} else {
+				Expect(err).To(BeNil())
+			}
+			Expect(result).To(Equal(expected))
+		}
+		return expected
+	}
+
+	It("processes maps and lists like the sequential processing", func() {
+		same(`
+values:
+  a: (( b + 1 ))
+  b: (( c.d + 1 ))
+  c:
+    d: (( length(other) ))
+other: [ a, b ]
+list:
+  - <<: (( merge ))
+  - name: bob
+    age: (( values.a ))
+  - name: peter
+    age: (( values.b ))
+names: (( map[list|e|->e.name] ))
+`, `
+list:
+  - name: alice
+    age: 25
+`)
+	})
+
+	It("provides tags of preceding nodes", func() {
+		result := same(`
+tags:
+  - <<: (( &temporary ))
+  - <<: (( &tag:lib.alice ))
+    data: alice.alice
+  - <<: (( &tag:lib.bob))
+    data: bob
+usage:
+  data: (( catch(lib::data) ))
+`)
+		Expect(result).To(FlowAs(parseYAML(`
+usage:
+  data:
+    error: 'ambigious tag resolution for lib::data: lib.alice <-> lib.bob'
+    valid: false
+`)))
+	})
+
+	It("reports duplicate tags like the sequential processing", func() {
+		for i := 0; i < 5; i++ {
+			same(`
+a:
+  <<: (( &tag:data ))
+  value: 1
+b:
+  <<: (( &tag:data ))
+  value: 2
+c:
+  <<: (( &tag:data ))
+  value: 3
+`)
+		}
+	})
+
+	It("limits the number of workers", func() {
+		pool := newWorkerPool(3)
+		Expect(pool.size()).To(Equal(3))
+		Expect(pool.acquire()).To(BeTrue())
+		Expect(pool.acquire()).To(BeTrue())
+		Expect(pool.acquire()).To(BeFalse())
+		pool.release()
+		Expect(pool.acquire()).To(BeTrue())
+	})
+
+	It("permits no additional workers for the sequential processing", func() {
+		pool := newWorkerPool(1)
+		Expect(pool).To(BeNil())
+		Expect(pool.size()).To(Equal(1))
+		Expect(pool.acquire()).To(BeFalse())
+	})
+
+	It("configures the workers of the state", func() {
+		Expect(NewDefaultState().SetParallel(3).Parallel()).To(Equal(3))
+		Expect(NewDefaultState().Parallel()).To(Equal(1))
+	})
+
+	It("uses the worker limit of the environment", func() {
+		state := NewDefaultState().SetParallel(3)
+		outer := NewEnvironment(nil, "test", state)
+		_, pool := parallelState(NewNestedEnvironment(nil, "test", outer).WithPath("a"))
+		Expect(pool.size()).To(Equal(3))
+
+		env := NewNestedEnvironment(nil, "test", outer).(*DefaultEnvironment)
+		env.limited = true
+		env.workers = newWorkerPool(5)
+		_, pool = parallelState(NewNestedEnvironment(nil, "test", env).WithPath("a"))
+		Expect(pool.size()).To(Equal(5))
+		Expect(state.Parallel()).To(Equal(3))
+
+		env.workers = newWorkerPool(1)
+		Expect(parallelState(env)).To(BeNil())
+	})
+
+	It("provides a state safe for concurrent use", func() {
+		state := NewDefaultState()
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				name := fmt.Sprintf("tag%d", i)
+				Expect(state.SetTag(name, yaml.NewNode(int64(i), "test"), []string{name}, dynaml.TAG_SCOPE_GLOBAL)).To(Succeed())
+				Expect(state.GetTag(name)).NotTo(BeNil())
+				_, err := state.GetTempName([]byte(name))
+				Expect(err).To(Succeed())
+				state.GetExecCache().Lock()
+				state.GetExecCache().Set(name, []byte(name))
+				state.GetExecCache().Unlock()
+			}(i)
+		}
+		wg.Wait()
+		Expect(state.GetTags("tag")).To(HaveLen(0))
+		state.Cleanup()
+	})
+})
@@ -0,0 +1,208 @@
+package flow
+
+import (
+	"fmt"
+
+	"filippo.io/age"
+	"github.com/mandelsoft/vfs/pkg/memoryfs"
+	"github.com/mandelsoft/vfs/pkg/vfs"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/mandelsoft/spiff/dynaml/passwd"
+	"github.com/mandelsoft/spiff/yaml"
+)
+
+var _ = Describe("age encryption", func() {
+	var fs vfs.FileSystem
+	var state *State
+	var alice, bob, eve *age.X25519Identity
+
+	cascade := func(template string) (yaml.Node, error) {
+		binding := NewEnvironment(nil, "context", state)
+		return Cascade(binding, parseYAML(template), Options{})
+	}
+
+	BeforeEach(func() {
+		var err error
+		fs = memoryfs.New()
+		state = NewState("", MODE_FILE_ACCESS, fs)
+		for _, id := range []**age.X25519Identity{&alice, &bob, &eve} {
+			*id, err = age.GenerateX25519Identity()
+			Expect(err).To(BeNil())
+		}
+		vfs.WriteFile(fs, "/alice.txt", []byte(fmt.Sprintf("# alice\n%s\n", alice)), 0600)
+		vfs.WriteFile(fs, "/bob.txt", []byte(bob.String()), 0600)
+		vfs.WriteFile(fs, "/eve.txt", []byte(eve.String()), 0600)
+	})
+
+	It("encrypts for several recipients", func() {
+		result, err := cascade(fmt.Sprintf(`
+value:
+  alice: 25
+encrypted: (( &temporary(encrypt(value, "age", "%s", [ "%s" ])) ))
+alice: (( decrypt(encrypted, "age", "/alice.txt") ))
+bob: (( decrypt(encrypted, "age", "/bob.txt") ))
+auto: (( decrypt(encrypted, "age", [ "/eve.txt", "/bob.txt" ]) ))
+`, alice.Recipient(), bob.Recipient()))
+		Expect(err).To(BeNil())
+		Expect(result.EquivalentToNode(parseYAML(`
+value:
+  alice: 25
+alice:
+  alice: 25
+bob:
+  alice: 25
+auto:
+  alice: 25
+`))).To(BeTrue())
+	})
+
+	It("detects the method", func() {
+		encrypted, err := passwd.GetEncoding(passwd.AGE).Encode("value: 1\n", alice.Recipient().String())
+		Expect(err).To(BeNil())
+		Expect(passwd.DetectMethod(encrypted)).To(Equal(passwd.AGE))
+		result, err := cascade(fmt.Sprintf(`
+encrypted: (( &temporary(%q) ))
+decrypted: (( decrypt(encrypted, "age", "/alice.txt") ))
+`, encrypted))
+		Expect(err).To(BeNil())
+		Expect(result.EquivalentToNode(parseYAML(`
+decrypted:
+  value: 1
+`))).To(BeTrue())
+	})
+
+	It("rejects foreign identities", func() {
+		_, err := cascade(fmt.Sprintf(`
+encrypted: (( encrypt("secret", "age", "%s") ))
+decrypted: (( decrypt(encrypted, "age", "/eve.txt") ))
+`, alice.Recipient()))
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("no matching age identity found"))
+	})
+
+	It("rejects invalid recipients", func() {
+		_, err := cascade(fmt.Sprintf(`
+encrypted: (( encrypt("secret", "age", "%s") ))
+`, alice))
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("malformed recipient"))
+	})
+})
+
+var _ = Describe("partial document encryption", func() {
+	doc := parseYAML(`
+secrets:
+  password: geheim
+  port: 8080
+  list: [ a, { b: true } ]
+name: test
+db:
+  user: admin
+  db_password: pw
+`)
+	sel := passwd.Selection{Paths: []string{"secrets.**"}, KeyRegex: "password$"}
+
+	value := func(node yaml.Node, path ...string) interface{} {
+		n, ok := yaml.FindR(true, node, nil, path...)
+		Expect(ok).To(BeTrue())
+		return n.Value()
+	}
+
+	It("encrypts the selected values", func() {
+		enc, err := passwd.EncryptDocument(doc, "key", "", sel)
+		Expect(err).To(BeNil())
+		Expect(passwd.IsEncryptedDocument(enc)).To(BeTrue())
+		Expect(value(enc, "name")).To(Equal("test"))
+		Expect(value(enc, "db", "user")).To(Equal("admin"))
+		for _, p := range [][]string{{"secrets", "password"}, {"secrets", "port"}, {"secrets", "list", "[0]"}, {"secrets", "list", "[1]", "b"}, {"db", "db_password"}} {
+			Expect(value(enc, p...)).To(HavePrefix("spiff:1:data:"))
+		}
+		Expect(value(enc, passwd.METADATA, "data_key")).To(HavePrefix("spiff:1:AES-256-GCM:"))
+
+		dec, err := passwd.DecryptDocument(enc, "key")
+		Expect(err).To(BeNil())
+		Expect(dec.EquivalentToNode(doc)).To(BeTrue())
+	})
+
+	It("detects modifications", func() {
+		enc, err := passwd.EncryptDocument(doc, "key", passwd.CHACHA20POLY1305, sel)
+		Expect(err).To(BeNil())
+		db := value(enc, "db").(map[string]yaml.Node)
+		db["user"] = yaml.NewNode("root", "test")
+
+		_, err = passwd.DecryptDocument(enc, "key")
+		Expect(err).To(MatchError("MAC mismatch: document has been modified"))
+	})
+
+	It("detects added empty maps", func() {
+		enc, err := passwd.EncryptDocument(doc, "key", "", sel)
+		Expect(err).To(BeNil())
+		db := value(enc, "db").(map[string]yaml.Node)
+		db["options"] = yaml.NewNode(map[string]yaml.Node{}, "test")
+
+		_, err = passwd.DecryptDocument(enc, "key")
+		Expect(err).To(MatchError("MAC mismatch: document has been modified"))
+	})
+
+	It("detects modified empty lists", func() {
+		doc := parseYAML(`
+secrets:
+  password: geheim
+hosts: []
+`)
+		enc, err := passwd.EncryptDocument(doc, "key", "", sel)
+		Expect(err).To(BeNil())
+		m := enc.Value().(map[string]yaml.Node)
+		m["hosts"] = yaml.NewNode(map[string]yaml.Node{}, "test")
+
+		_, err = passwd.DecryptDocument(enc, "key")
+		Expect(err).To(MatchError("MAC mismatch: document has been modified"))
+
+		delete(m, "hosts")
+		_, err = passwd.DecryptDocument(enc, "key")
+		Expect(err).To(MatchError("MAC mismatch: document has been modified"))
+	})
+
+	It("keeps empty maps and lists", func() {
+		doc := parseYAML(`
+secrets:
+  password: geheim
+  none: {}
+hosts: []
+`)
+		enc, err := passwd.EncryptDocument(doc, "key", "", sel)
+		Expect(err).To(BeNil())
+		dec, err := passwd.DecryptDocument(enc, "key")
+		Expect(err).To(BeNil())
+		Expect(dec.EquivalentToNode(doc)).To(BeTrue())
+	})
+
+	It("covers the encryption settings by the MAC", func() {
+		enc, err := passwd.EncryptDocument(doc, "key", "", sel)
+		Expect(err).To(BeNil())
+		meta := value(enc, passwd.METADATA).(map[string]yaml.Node)
+		meta["key_regex"] = yaml.NewNode("^$", "test")
+
+		_, err = passwd.DecryptDocument(enc, "key")
+		Expect(err).To(MatchError("MAC mismatch: document has been modified"))
+	})
+
+	It("binds values to their paths", func() {
+		enc, err := passwd.EncryptDocument(doc, "key", "", sel)
+		Expect(err).To(BeNil())
+		secrets := value(enc, "secrets").(map[string]yaml.Node)
+		secrets["password"], secrets["port"] = secrets["port"], secrets["password"]
+
+		_, err = passwd.DecryptDocument(enc, "key")
+		Expect(err).To(MatchError(ContainSubstring(": invalid data key or corrupted data")))
+	})
+
+	It("rejects wrong keys", func() {
+		enc, err := passwd.EncryptDocument(doc, "key", "", sel)
+		Expect(err).To(BeNil())
+		_, err = passwd.DecryptDocument(enc, "other")
+		Expect(err).NotTo(BeNil())
+	})
+})
@@ -0,0 +1,123 @@
+package flow
+
+import (
+	"fmt"
+	"os"
+
+	"filippo.io/age"
+	"github.com/mandelsoft/vfs/pkg/memoryfs"
+	"github.com/mandelsoft/vfs/pkg/vfs"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/mandelsoft/spiff/debug"
+	"github.com/mandelsoft/spiff/dynaml"
+	"github.com/mandelsoft/spiff/dynaml/passwd"
+	"github.com/mandelsoft/spiff/dynaml/secrets"
+	"github.com/mandelsoft/spiff/yaml"
+)
+
+var _ = Describe("secrets", func() {
+	var fs vfs.FileSystem
+	var state *State
+
+	cascade := func(template string) (yaml.Node, error) {
+		binding := NewEnvironment(nil, "context", state)
+		return Cascade(binding, parseYAML(template), Options{})
+	}
+
+	BeforeEach(func() {
+		fs = memoryfs.New()
+		state = NewState("", MODE_FILE_ACCESS, fs)
+	})
+
+	It("resolves environment variables", func() {
+		os.Setenv("SPIFF_TEST_SECRET", "env-secret")
+		defer os.Unsetenv("SPIFF_TEST_SECRET")
+		result, err := cascade(`
+value: (( secret("env", "SPIFF_TEST_SECRET") ))
+`)
+		Expect(err).To(BeNil())
+		Expect(result).To(FlowAs(parseYAML(`
+value: env-secret
+`)))
+	})
+
+	It("resolves files", func() {
+		vfs.WriteFile(fs, "/secret", []byte("file-secret\n"), 0600)
+		result, err := cascade(`
+value: (( secret("file", "/secret") ))
+`)
+		Expect(err).To(BeNil())
+		Expect(result).To(FlowAs(parseYAML(`
+value: file-secret
+`)))
+	})
+
+	It("resolves password store entries", func() {
+		id, err := age.GenerateX25519Identity()
+		Expect(err).To(BeNil())
+		data, err := passwd.AgeEncrypt([]byte("store-secret\nuser: admin\n"), id.Recipient())
+		Expect(err).To(BeNil())
+		fs.MkdirAll("/store/db", 0700)
+		vfs.WriteFile(fs, "/store/db/prod.age", []byte(data), 0600)
+		vfs.WriteFile(fs, "/identity", []byte(id.String()), 0600)
+
+		state.SetSecretProvider("pass", secrets.NewPassProvider("/store", "/identity"))
+		result, err := cascade(`
+password: (( secret("pass", "db/prod") ))
+user: (( secret("pass", "db/prod#user") ))
+`)
+		Expect(err).To(BeNil())
+		Expect(result).To(FlowAs(parseYAML(`
+password: store-secret
+user: admin
+`)))
+	})
+
+	It("uses state specific providers", func() {
+		state.SetSecretProvider("test", secrets.SecretProviderFunc(func(ref string, binding dynaml.Binding) (string, error) {
+			return "secret of " + ref, nil
+		}))
+		result, err := cascade(`
+value: (( secret("test", "alice") ))
+`)
+		Expect(err).To(BeNil())
+		Expect(result).To(FlowAs(parseYAML(`
+value: secret of alice
+`)))
+	})
+
+	It("rejects unknown providers", func() {
+		_, err := cascade(`
+value: (( secret("unknown", "alice") ))
+`)
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring(`unknown secret provider "unknown"`))
+	})
+
+	It("respects the processing mode", func() {
+		vfs.WriteFile(fs, "/secret", []byte("file-secret\n"), 0600)
+		state = NewState("", 0, fs)
+		_, err := cascade(`
+value: (( secret("file", "/secret") ))
+`)
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("file access not allowed"))
+	})
+
+	It("redacts secrets in error messages and explanations", func() {
+		vfs.WriteFile(fs, "/secret", []byte("hidden-value-4711\n"), 0600)
+		x := NewExplanation("value")
+		state.SetExplanation(x)
+		_, err := cascade(`
+value: (( secret("file", "/secret") ))
+failed: (( error("password is " value) ))
+`)
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("password is " + debug.REDACTED))
+		Expect(err.Error()).NotTo(ContainSubstring("hidden-value-4711"))
+		Expect(x.String()).To(ContainSubstring(fmt.Sprintf("value: %s", debug.REDACTED)))
+		Expect(x.String()).NotTo(ContainSubstring("hidden-value-4711"))
+	})
+})
@@ -0,0 +1,85 @@
+package flow
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/mandelsoft/spiff/debug"
+	"github.com/mandelsoft/spiff/yaml"
+)
+
+var _ = Describe("sensitive values", func() {
+	cascade := func(template string) (yaml.Node, error) {
+		binding := NewEnvironment(nil, "context", NewDefaultState())
+		return Cascade(binding, parseYAML(template), Options{})
+	}
+
+	It("propagates sensitivity to derived values", func() {
+		result, err := cascade(`
+pw: (( rand("[a-z]", 12) ))
+concat: (( "pw=" pw ))
+format: (( format("%s!", pw) ))
+mapped: (( map[[pw]|x|-> x "-" x] ))
+list:
+  - (( pw ))
+plain: (( "value" ))
+`)
+		Expect(err).To(BeNil())
+		m := result.Value().(map[string]yaml.Node)
+		for _, k := range []string{"pw", "concat", "format", "mapped"} {
+			Expect(m[k].Sensitive()).To(BeTrue(), k)
+		}
+		Expect(m["plain"].Sensitive()).To(BeFalse())
+		Expect(m["list"].Value().([]yaml.Node)[0].Sensitive()).To(BeTrue())
+	})
+
+	It("keeps declassified results", func() {
+		result, err := cascade(`
+pw: (( rand("[a-z]", 12) ))
+hash: (( bcrypt(pw, 4) ))
+check: (( bcrypt_check(pw, hash) ))
+`)
+		Expect(err).To(BeNil())
+		m := result.Value().(map[string]yaml.Node)
+		Expect(m["hash"].Sensitive()).To(BeTrue())
+		Expect(m["check"].Sensitive()).To(BeFalse())
+	})
+
+	It("redacts sensitive values in error messages", func() {
+		_, err := cascade(`
+pw: (( rand("[a-z]", 12) ))
+failed: (( error("password is " pw) ))
+`)
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("password is " + debug.REDACTED))
+	})
+
+	It("redacts short values only as complete words", func() {
+		state := NewDefaultState()
+		state.AddSensitive("no", "admin", "supersecret")
+		Expect(state.Redact("no admin: administrator, supersecrets")).To(Equal("no <redacted>: administrator, <redacted>s"))
+	})
+
+	It("keeps the sensitive values in the processing state", func() {
+		state := NewDefaultState()
+		state.AddSensitive("supersecret")
+		Expect(debug.Redact("supersecret")).To(Equal("supersecret"))
+		Expect(state.Redact("supersecret")).To(Equal(debug.REDACTED))
+	})
+
+	It("redacts sensitive values in the output", func() {
+		result, err := cascade(`
+pw: (( rand("[a-z]", 12) ))
+nested:
+  value: (( "pw=" pw ))
+  plain: value
+`)
+		Expect(err).To(BeNil())
+		Expect(Redact(result)).To(FlowAs(parseYAML(`
+pw: <redacted>
+nested:
+  value: <redacted>
+  plain: value
+`)))
+	})
+})
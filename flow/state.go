@@ -8,10 +8,13 @@ import (
 	"net/http"
 	"path"
 	"reflect"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/mandelsoft/vfs/pkg/osfs"
 	"github.com/mandelsoft/vfs/pkg/vfs"
@@ -53,8 +56,10 @@ func (c *execCache) Set(key string, content []byte) {
 var _ dynaml.ExecCache = &execCache{}
 
 type State struct {
+	lock       sync.Mutex        // guards files, fileCache, observed, tags, tagmods, docno, secrets, regexps, keys and salts
 	files      map[string]string // content hash to temp file name
 	fileCache  map[string][]byte // file content cache
+	observed   map[string]bool   // local files used by the processing
 	key        string            // default encryption key
 	mode       int
 	exec_cache dynaml.ExecCache // execution cache
@@ -62,10 +67,26 @@ type State struct {
 	registry   dynaml.Registry
 	features   features.FeatureFlags
 	tags       map[string]*dynaml.TagInfo
-	docno      int // document number
+	tagmods    uint64 // number of tag modifications
+	accesses   uint64 // number of file system and OS accesses (atomic)
+	docno      int    // document number
+	secrets    map[string]dynaml.SecretProvider
+	redactor   *debug.Redactor           // sensitive values used by the processing
+	clock      dynaml.Clock              // time source for the function now
+	regexps    map[string]*regexp.Regexp // compiled regular expressions
+	keys       map[string]*derivedKey    // keys derived from passwords
+	salts      map[string][]byte         // salts used for encryptions
+
+	explanation *Explanation // trace of a dedicated node
+	workers     *workerPool  // workers for the concurrent processing
 }
 
 var _ dynaml.State = &State{}
+var _ dynaml.SecretProviders = &State{}
+var _ dynaml.SensitiveValues = &State{}
+var _ dynaml.Clock = &State{}
+var _ dynaml.RegexpCache = &State{}
+var _ dynaml.KeyCache = &State{}
 
 func NewState(key string, mode int, optfs ...vfs.FileSystem) *State {
 	var fs vfs.FileSystem
@@ -81,6 +102,7 @@ func NewState(key string, mode int, optfs ...vfs.FileSystem) *State {
 		tags:       map[string]*dynaml.TagInfo{},
 		files:      map[string]string{},
 		fileCache:  map[string][]byte{},
+		observed:   map[string]bool{},
 		key:        key,
 		mode:       mode,
 		exec_cache: &execCache{cache: make(map[string][]byte)},
@@ -88,6 +110,10 @@ func NewState(key string, mode int, optfs ...vfs.FileSystem) *State {
 		docno:      1,
 		features:   features.Features(),
 		registry:   dynaml.DefaultRegistry(),
+		redactor:   debug.NewRedactor(),
+		regexps:    map[string]*regexp.Regexp{},
+		keys:       map[string]*derivedKey{},
+		salts:      map[string][]byte{},
 	}
 }
 
@@ -107,7 +133,154 @@ func (s *State) SetFeatures(f features.FeatureFlags) *State {
 	return s
 }
 
+// SetParallel sets the maximum number of workers used to process
+// independent nodes concurrently. A value less than two disables
+// the concurrent processing.
+func (s *State) SetParallel(n int) *State {
+	s.workers = newWorkerPool(n)
+	return s
+}
+
+// Parallel provides the maximum number of workers used to
+// process independent nodes.
+func (s *State) Parallel() int {
+	return s.workers.size()
+}
+
+// SetSecretProvider sets a secret provider used for the secret function
+// in addition to the globally registered providers. A nil provider
+// removes the provider.
+func (s *State) SetSecretProvider(name string, p dynaml.SecretProvider) *State {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if p == nil {
+		delete(s.secrets, name)
+	} else {
+		if s.secrets == nil {
+			s.secrets = map[string]dynaml.SecretProvider{}
+		}
+		s.secrets[name] = p
+	}
+	return s
+}
+
+func (s *State) GetSecretProvider(name string) dynaml.SecretProvider {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.secrets[name]
+}
+
+// SetClock sets the clock used by the function now. A nil clock
+// uses the system time.
+func (s *State) SetClock(c dynaml.Clock) *State {
+	s.clock = c
+	return s
+}
+
+// Now provides the current time of the processing.
+func (s *State) Now() time.Time {
+	if s.clock != nil {
+		return s.clock.Now()
+	}
+	return time.Now()
+}
+
+// Regexp provides the compiled regular expression for a pattern.
+// Compiled expressions are cached for the processing.
+func (s *State) Regexp(pattern string) (*regexp.Regexp, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if re, ok := s.regexps[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	s.regexps[pattern] = re
+	return re, nil
+}
+
+// derivedKey is the cache entry for a key derived from a password.
+// The key is derived once, concurrent requests wait for the result.
+type derivedKey struct {
+	once sync.Once
+	key  []byte
+	err  error
+}
+
+// DerivedKey provides the key derived from a password and salt for an
+// encryption method. Derived keys are cached for the processing.
+// The (expensive) derivation is done without holding the state lock,
+// so other workers are not blocked by it.
+func (s *State) DerivedKey(method, password string, salt []byte, derive func() ([]byte, error)) ([]byte, error) {
+	id := keyId(method, password, salt)
+	s.lock.Lock()
+	e := s.keys[id]
+	if e == nil {
+		e = &derivedKey{}
+		s.keys[id] = e
+	}
+	s.lock.Unlock()
+
+	e.once.Do(func() {
+		e.key, e.err = derive()
+	})
+	if e.err != nil {
+		// failed derivations are not cached
+		s.lock.Lock()
+		if s.keys[id] == e {
+			delete(s.keys, id)
+		}
+		s.lock.Unlock()
+		return nil, e.err
+	}
+	return e.key, nil
+}
+
+// EncryptionSalt provides the salt used for the encryptions of the
+// processing with a method and password.
+func (s *State) EncryptionSalt(method, password string, generate func() ([]byte, error)) ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	id := keyId(method, password, nil)
+	if salt, ok := s.salts[id]; ok {
+		return salt, nil
+	}
+	salt, err := generate()
+	if err != nil {
+		return nil, err
+	}
+	s.salts[id] = salt
+	return salt, nil
+}
+
+// keyId provides the cache key for a derived key. Passwords are
+// only kept hashed.
+func keyId(method, password string, salt []byte) string {
+	h := sha512.New()
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	h.Write([]byte(password))
+	h.Write([]byte{0})
+	h.Write(salt)
+	return string(h.Sum(nil))
+}
+
+// AddSensitive registers sensitive values used by the processing,
+// which are redacted in its error messages and explanations.
+func (s *State) AddSensitive(values ...string) {
+	s.redactor.Add(values...)
+}
+
+// Redact replaces the sensitive values used by the processing in a text.
+func (s *State) Redact(text string) string {
+	return s.redactor.Redact(text)
+}
+
 func (s *State) SetTags(tags ...*dynaml.Tag) *State {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	s.tags = map[string]*dynaml.TagInfo{}
 	for _, v := range tags {
 		s.tags[v.Name()] = dynaml.NewTagInfo(v)
@@ -115,6 +288,16 @@ func (s *State) SetTags(tags ...*dynaml.Tag) *State {
 	return s
 }
 
+// SetExplanation enables the tracing of the processing steps
+// for the node described by the explanation.
+func (s *State) SetExplanation(x *Explanation) *State {
+	if x != nil {
+		x.redactor = s.redactor
+	}
+	s.explanation = x
+	return s
+}
+
 func (s *State) SetInterpolation(b bool) *State {
 	s.features.SetInterpolation(b)
 	return s
@@ -133,14 +316,27 @@ func (s *State) ControlEnabled() bool {
 	return s.features.ControlEnabled()
 }
 
+// OSAccessAllowed is checked by all functions executing commands or
+// accessing other external resources, therefore it counts the accesses.
 func (s *State) OSAccessAllowed() bool {
+	atomic.AddUint64(&s.accesses, 1)
 	return s.mode&MODE_OS_ACCESS != 0
 }
 
+// FileAccessAllowed is checked by all functions accessing the file
+// system, therefore it counts the accesses.
 func (s *State) FileAccessAllowed() bool {
+	atomic.AddUint64(&s.accesses, 1)
 	return s.mode&MODE_FILE_ACCESS != 0
 }
 
+// ExternalAccesses provides the number of file system and OS accesses.
+// The result of an evaluation doing such accesses depends on more than
+// the document and must not be reused.
+func (s *State) ExternalAccesses() uint64 {
+	return atomic.LoadUint64(&s.accesses)
+}
+
 func (s *State) FileSystem() vfs.VFS {
 	return s.fileSystem
 }
@@ -167,6 +363,11 @@ func (s *State) GetExecCache() dynaml.ExecCache {
 	return s.exec_cache
 }
 
+// ResetExecCache discards the cached results of command executions.
+func (s *State) ResetExecCache() {
+	s.exec_cache = &execCache{cache: make(map[string][]byte)}
+}
+
 func (s *State) GetTempName(data []byte) (string, error) {
 	if !s.FileAccessAllowed() {
 		return "", fmt.Errorf("tempname: no OS operations supported in this execution environment")
@@ -174,6 +375,8 @@ func (s *State) GetTempName(data []byte) (string, error) {
 	sum := sha512.Sum512(data)
 	hash := base64.StdEncoding.EncodeToString(sum[:])
 
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	name, ok := s.files[hash]
 	if !ok {
 		file, err := s.fileSystem.TempFile("", "spiff-")
@@ -189,7 +392,47 @@ func (s *State) GetTempName(data []byte) (string, error) {
 func (s *State) SetTag(name string, node yaml.Node, path []string, scope dynaml.TagScope) error {
 	name = strings.Replace(name, ":", ".", -1)
 	debug.Debug("setting tag: %v\n", path)
-	old := s.tags[name]
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := checkTag(s.tags[name], name, path); err != nil {
+		return err
+	}
+	s.setTag(newTagInfo(name, node, path, scope))
+	return nil
+}
+
+// setTag stores a tag and counts the modification of the tags.
+// The caller must hold the lock.
+func (s *State) setTag(tag *dynaml.TagInfo) {
+	if tagChanged(s.tags[tag.Name()], tag) {
+		s.tagmods++
+	}
+	s.tags[tag.Name()] = tag
+}
+
+// tagModifications provides the number of modifications of the tags.
+// Evaluations depending on tags are outdated if it changes.
+func (s *State) tagModifications() uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.tagmods
+}
+
+// tagsModified counts a modification of the tags not yet stored
+// in the state.
+func (s *State) tagsModified() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.tagmods++
+}
+
+func newTagInfo(name string, node yaml.Node, path []string, scope dynaml.TagScope) *dynaml.TagInfo {
+	return dynaml.NewTagInfo(dynaml.NewTag(name, Cleanup(node, discardTags), path, scope))
+}
+
+// checkTag checks whether a tag may be set for a path
+// regarding an already existing tag.
+func checkTag(old *dynaml.TagInfo, name string, path []string) error {
 	if old != nil {
 		if !old.IsLocal() {
 			return fmt.Errorf("duplicate tag %q: %s in foreign document", name, strings.Join(path, "."))
@@ -198,12 +441,30 @@ func (s *State) SetTag(name string, node yaml.Node, path []string, scope dynaml.
 			return fmt.Errorf("duplicate tag %q: %s <-> %s", name, strings.Join(path, "."), strings.Join(old.Path(), "."))
 		}
 	}
-	s.tags[name] = dynaml.NewTagInfo(dynaml.NewTag(name, Cleanup(node, discardTags), path, scope))
 	return nil
 }
 
+// tagChanged checks whether a tag differs from its previous setting.
+func tagChanged(old, tag *dynaml.TagInfo) bool {
+	return old == nil || old.Scope() != tag.Scope() || !reflect.DeepEqual(old.Node(), tag.Node())
+}
+
+func (s *State) lookupTag(name string) *dynaml.TagInfo {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.tags[name]
+}
+
+func (s *State) storeTag(tag *dynaml.TagInfo) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.setTag(tag)
+}
+
 func (s *State) GetTag(name string) *dynaml.Tag {
 	name = strings.Replace(name, ":", ".", -1)
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	if strings.HasPrefix(name, "doc.") {
 		i, err := strconv.Atoi(name[4:])
 		if err != nil {
@@ -226,6 +487,8 @@ func (s *State) GetTag(name string) *dynaml.Tag {
 
 func (s *State) GetTags(name string) []*dynaml.TagInfo {
 	name = strings.Replace(name, ":", ".", -1)
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	if strings.HasPrefix(name, "doc.") {
 		i, err := strconv.Atoi(name[4:])
 		if err != nil {
@@ -252,21 +515,60 @@ func (s *State) GetTags(name string) []*dynaml.TagInfo {
 			list = append(list, t)
 		}
 	}
+	sortTags(list)
+	return list
+}
+
+func sortTags(list []*dynaml.TagInfo) {
 	sort.Slice(list, func(i, j int) bool {
 		if list[i].Level() != list[j].Level() {
 			return list[i].Level() < list[j].Level()
 		}
 		return strings.Compare(list[i].Name(), list[j].Name()) < 0
 	})
-	return list
 }
 
 func (s *State) ResetTags() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	s.tags = map[string]*dynaml.TagInfo{}
+	s.tagmods++
 	s.docno = 1
 }
 
+// TagSnapshot is a saved set of tags of a State.
+type TagSnapshot struct {
+	tags  []*dynaml.Tag
+	docno int
+}
+
+// SaveTags provides a snapshot of the actual tags, which can be
+// restored later on by RestoreTags.
+func (s *State) SaveTags() *TagSnapshot {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	snapshot := &TagSnapshot{docno: s.docno}
+	for _, t := range s.tags {
+		snapshot.tags = append(snapshot.tags, dynaml.NewTag(t.Name(), t.Node(), t.Path(), t.Scope()))
+	}
+	return snapshot
+}
+
+// RestoreTags replaces the actual tags by the tags of a snapshot.
+func (s *State) RestoreTags(snapshot *TagSnapshot) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.tags = map[string]*dynaml.TagInfo{}
+	for _, t := range snapshot.tags {
+		s.tags[t.Name()] = dynaml.NewTagInfo(dynaml.NewTag(t.Name(), t.Node(), t.Path(), t.Scope()))
+	}
+	s.tagmods++
+	s.docno = snapshot.docno
+}
+
 func (s *State) ResetStream() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	n := map[string]*dynaml.TagInfo{}
 	for _, v := range s.tags {
 		if !v.IsStream() {
@@ -275,12 +577,15 @@ func (s *State) ResetStream() {
 	}
 	s.docno = 1
 	s.tags = n
+	s.tagmods++
 }
 
 func (s *State) PushDocument(node yaml.Node) {
 	if node != nil {
 		s.SetTag(fmt.Sprintf("doc.%d", s.docno), node, nil, dynaml.TAG_SCOPE_GLOBAL)
 	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	for _, t := range s.tags {
 		t.ResetLocal()
 	}
@@ -288,6 +593,8 @@ func (s *State) PushDocument(node yaml.Node) {
 }
 
 func (s *State) Cleanup() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	for _, n := range s.files {
 		s.fileSystem.Remove(n)
 	}
@@ -297,7 +604,12 @@ func (s *State) Cleanup() {
 func (s *State) GetFileContent(file string, cached bool) ([]byte, error) {
 	var err error
 
+	s.lock.Lock()
 	data := s.fileCache[file]
+	if !strings.HasPrefix(file, "http:") && !strings.HasPrefix(file, "https:") {
+		s.observed[file] = true
+	}
+	s.lock.Unlock()
 	if !cached || data == nil {
 		debug.Debug("reading file %s\n", file)
 		if strings.HasPrefix(file, "http:") || strings.HasPrefix(file, "https:") {
@@ -318,7 +630,47 @@ func (s *State) GetFileContent(file string, cached bool) ([]byte, error) {
 				return nil, fmt.Errorf("error reading [%s]: %s", path.Clean(file), err)
 			}
 		}
+		s.lock.Lock()
 		s.fileCache[file] = data
+		s.lock.Unlock()
 	}
 	return data, nil
 }
+
+// ObserveFile records a local file used by the processing, for
+// example the result of a file lookup.
+func (s *State) ObserveFile(file string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.observed[file] = true
+}
+
+// ObservedFiles provides the sorted names of the local files read
+// by GetFileContent or observed by file lookups.
+func (s *State) ObservedFiles() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	list := make([]string, 0, len(s.observed))
+	for f := range s.observed {
+		list = append(list, f)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// ResetObservedFiles forgets the files observed so far.
+func (s *State) ResetObservedFiles() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.observed = map[string]bool{}
+}
+
+// InvalidateFiles removes files from the file content cache,
+// they are read again when used by the next processing.
+func (s *State) InvalidateFiles(files ...string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, f := range files {
+		delete(s.fileCache, f)
+	}
+}
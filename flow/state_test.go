@@ -0,0 +1,129 @@
+package flow
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mandelsoft/vfs/pkg/memoryfs"
+	"github.com/mandelsoft/vfs/pkg/vfs"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/mandelsoft/spiff/dynaml"
+	"github.com/mandelsoft/spiff/yaml"
+)
+
+var _ = Describe("State", func() {
+	var fs vfs.FileSystem
+	var state *State
+
+	cascade := func(template string) yaml.Node {
+		binding := NewEnvironment(nil, "context", state)
+		result, err := Cascade(binding, parseYAML(template), Options{})
+		Expect(err).To(BeNil())
+		return result
+	}
+
+	data := func(template string, value int) {
+		result := cascade(template)
+		Expect(result.EquivalentToNode(parseYAML(fmt.Sprintf("data:\n  value: %d\n", value)))).To(BeTrue())
+	}
+
+	BeforeEach(func() {
+		fs = memoryfs.New()
+		state = NewState("", MODE_FILE_ACCESS, fs)
+	})
+
+	Context("file observation", func() {
+		It("records read and looked up files", func() {
+			vfs.WriteFile(fs, "/data.yml", []byte("value: 1\n"), 0644)
+			fs.MkdirAll("/lib", 0755)
+			vfs.WriteFile(fs, "/lib/other.yml", []byte("value: 2\n"), 0644)
+			cascade(`
+data: (( read("/data.yml") ))
+found: (( lookup_file("other.yml", "/lib") ))
+missing: (( lookup_file("missing.yml", "/lib") ))
+`)
+			Expect(state.ObservedFiles()).To(Equal([]string{"/data.yml", "/lib/missing.yml", "/lib/other.yml"}))
+			state.ResetObservedFiles()
+			Expect(state.ObservedFiles()).To(BeEmpty())
+		})
+
+		It("reads invalidated files again", func() {
+			template := `
+data: (( read("/data.yml") ))
+`
+			vfs.WriteFile(fs, "/data.yml", []byte("value: 1\n"), 0644)
+			data(template, 1)
+			vfs.WriteFile(fs, "/data.yml", []byte("value: 2\n"), 0644)
+			data(template, 1)
+			state.InvalidateFiles("/data.yml")
+			data(template, 2)
+		})
+	})
+
+	Context("tag snapshots", func() {
+		It("restores saved tags", func() {
+			state.SetTags(dynaml.NewTag("lib", parseYAML("value: 1"), nil, dynaml.TAG_SCOPE_GLOBAL))
+			snapshot := state.SaveTags()
+			state.PushDocument(parseYAML("doc: 1"))
+			Expect(state.SetTag("other", parseYAML("value: 2"), []string{"other"}, dynaml.TAG_SCOPE_GLOBAL)).To(BeNil())
+
+			state.RestoreTags(snapshot)
+			Expect(state.GetTag("lib")).NotTo(BeNil())
+			Expect(state.GetTag("lib").Node()).To(Equal(parseYAML("value: 1")))
+			Expect(state.GetTag("other")).To(BeNil())
+			Expect(state.GetTag("doc.1")).To(BeNil())
+		})
+	})
+
+	Context("derived keys", func() {
+		It("derives keys without locking the state", func() {
+			key, err := state.DerivedKey("m", "password", nil, func() ([]byte, error) {
+				// the state must still be usable during the derivation
+				Expect(state.ObservedFiles()).To(BeEmpty())
+				return state.DerivedKey("m", "other", nil, func() ([]byte, error) {
+					return []byte("other"), nil
+				})
+			})
+			Expect(err).To(BeNil())
+			Expect(key).To(Equal([]byte("other")))
+			Expect(len(state.keys)).To(Equal(2))
+		})
+
+		It("derives keys once for concurrent requests", func() {
+			var count int32
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+					key, err := state.DerivedKey("m", "password", []byte("salt"), func() ([]byte, error) {
+						atomic.AddInt32(&count, 1)
+						time.Sleep(10 * time.Millisecond)
+						return []byte("key"), nil
+					})
+					Expect(err).To(BeNil())
+					Expect(key).To(Equal([]byte("key")))
+				}()
+			}
+			wg.Wait()
+			Expect(count).To(Equal(int32(1)))
+		})
+
+		It("does not cache failed derivations", func() {
+			_, err := state.DerivedKey("m", "password", nil, func() ([]byte, error) {
+				return nil, fmt.Errorf("failed")
+			})
+			Expect(err).To(MatchError("failed"))
+			key, err := state.DerivedKey("m", "password", nil, func() ([]byte, error) {
+				return []byte("key"), nil
+			})
+			Expect(err).To(BeNil())
+			Expect(key).To(Equal([]byte("key")))
+		})
+	})
+})
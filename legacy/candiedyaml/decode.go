@@ -50,6 +50,7 @@ type Decoder struct {
 	event         yaml_event_t
 	replay_events []yaml_event_t
 	useNumber     bool
+	useMarks      bool
 
 	anchors          map[string][]yaml_event_t
 	tracking_anchors [][]yaml_event_t
@@ -136,6 +137,47 @@ func (d *Decoder) Decode(v interface{}) (err error) {
 
 func (d *Decoder) UseNumber() { d.useNumber = true }
 
+// UseMarks enables the wrapping of all values decoded into an interface{}
+// with their start position in the source document (see Marked).
+// Map keys are never wrapped.
+func (d *Decoder) UseMarks() { d.useMarks = true }
+
+// UseComments enables marks (see UseMarks) and additionally
+// attaches the comments found in the source document to the
+// marked values.
+func (d *Decoder) UseComments() {
+	d.useMarks = true
+	d.parser.keep_comments = true
+}
+
+// Marked is a decoded value together with the start mark of
+// its yaml representation. It is only used by a decoder with
+// enabled marks. For mappings the original key order is kept.
+// If comments are enabled, the comments preceding
+// a map entry or list item (Head), the comment following it on the
+// same line (Line) and the comments at the end of the document (Foot)
+// are kept, too.
+type Marked struct {
+	Value interface{}
+	Mark  YAML_mark_t
+	// Keys is the key order of a decoded mapping.
+	Keys []interface{}
+
+	HeadComment string
+	LineComment string
+	FootComment string
+}
+
+// Line returns the 1-based line of the mark.
+func (m YAML_mark_t) Line() int {
+	return m.line + 1
+}
+
+// Column returns the 1-based column of the mark.
+func (m YAML_mark_t) Column() int {
+	return m.column + 1
+}
+
 func (d *Decoder) error(err error) {
 	panic(err)
 }
@@ -183,7 +225,21 @@ func (d *Decoder) document(rv reflect.Value) {
 	}
 
 	d.nextEvent()
-	d.parse(rv)
+	if d.useMarks && d.event.event_type != yaml_DOCUMENT_END_EVENT &&
+		rv.Kind() == reflect.Ptr && rv.Elem().Kind() == reflect.Interface && rv.Elem().NumMethod() == 0 {
+		head := d.documentComment(d.event.start_mark)
+		v := d.valueInterface()
+		if m, ok := v.(Marked); ok && d.parser.keep_comments {
+			m.HeadComment = head
+			m.FootComment = d.takeComments(func(c yaml_comment_t) bool {
+				return c.mark.index < d.event.start_mark.index
+			})
+			v = m
+		}
+		rv.Elem().Set(reflect.ValueOf(v))
+	} else {
+		d.parse(rv)
+	}
 
 	if d.event.event_type != yaml_DOCUMENT_END_EVENT {
 		d.error(fmt.Errorf("Expected document end at %s", d.event.start_mark))
@@ -539,7 +595,18 @@ func (d *Decoder) alias(rv reflect.Value) {
 }
 
 func (d *Decoder) valueInterface() interface{} {
+	if d.useMarks {
+		mark := d.event.start_mark
+		v, keys := d.plainValueInterface()
+		return Marked{Value: v, Mark: mark, Keys: keys}
+	}
+	v, _ := d.plainValueInterface()
+	return v
+}
+
+func (d *Decoder) plainValueInterface() (interface{}, []interface{}) {
 	var v interface{}
+	var keys []interface{}
 
 	anchor := string(d.event.anchor)
 	switch d.event.event_type {
@@ -548,14 +615,14 @@ func (d *Decoder) valueInterface() interface{} {
 		v = d.sequenceInterface()
 	case yaml_MAPPING_START_EVENT:
 		d.begin_anchor(anchor)
-		v = d.mappingInterface()
+		v, keys = d.orderedMappingInterface()
 	case yaml_SCALAR_EVENT:
 		d.begin_anchor(anchor)
 		v = d.scalarInterface()
 	case yaml_ALIAS_EVENT:
 		rv := reflect.ValueOf(&v)
 		d.alias(rv)
-		return v
+		return v, nil
 	case yaml_DOCUMENT_END_EVENT:
 		d.error(&UnexpectedEventError{
 			Value:     string(d.event.value),
@@ -566,7 +633,7 @@ func (d *Decoder) valueInterface() interface{} {
 	}
 	d.end_anchor(anchor)
 
-	return v
+	return v, keys
 }
 
 func (d *Decoder) scalarInterface() interface{} {
@@ -589,7 +656,9 @@ done:
 			break done
 		}
 
-		v = append(v, d.valueInterface())
+		mark := d.event.start_mark
+		head := d.headComment(mark)
+		v = append(v, d.withComments(d.valueInterface(), head, mark))
 	}
 
 	if d.event.event_type != yaml_DOCUMENT_END_EVENT {
@@ -601,7 +670,15 @@ done:
 
 // objectInterface is like object but returns map[string]interface{}.
 func (d *Decoder) mappingInterface() map[interface{}]interface{} {
+	m, _ := d.orderedMappingInterface()
+	return m
+}
+
+// orderedMappingInterface is like mappingInterface but additionally
+// returns the keys in the order of their occurrence.
+func (d *Decoder) orderedMappingInterface() (map[interface{}]interface{}, []interface{}) {
 	m := make(map[interface{}]interface{})
+	var keys []interface{}
 
 	d.nextEvent()
 
@@ -612,15 +689,90 @@ done:
 			break done
 		}
 
+		mark := d.event.start_mark
+		head := d.headComment(mark)
 		key := d.valueInterface()
+		if m, ok := key.(Marked); ok {
+			key = m.Value
+		}
 
 		// Read value.
-		m[key] = d.valueInterface()
+		if _, ok := m[key]; !ok {
+			keys = append(keys, key)
+		}
+		m[key] = d.withComments(d.valueInterface(), head, mark)
 	}
 
 	if d.event.event_type != yaml_DOCUMENT_END_EVENT {
 		d.nextEvent()
 	}
 
-	return m
+	return m, keys
+}
+
+// headComment consumes the pending comments on separate lines
+// before the given mark.
+func (d *Decoder) headComment(mark YAML_mark_t) string {
+	if !d.parser.keep_comments {
+		return ""
+	}
+	return d.takeComments(func(c yaml_comment_t) bool {
+		return !c.inline && c.mark.line < mark.line
+	})
+}
+
+// documentComment consumes the pending comments before the given
+// mark of the document content, which are separated from it by an
+// empty line. They belong to the document instead of the first map
+// entry or list item.
+func (d *Decoder) documentComment(mark YAML_mark_t) string {
+	if !d.parser.keep_comments {
+		return ""
+	}
+	lines := map[int]bool{}
+	for _, c := range d.parser.comments {
+		if !c.inline {
+			lines[c.mark.line] = true
+		}
+	}
+	first := mark.line
+	for lines[first-1] {
+		first--
+	}
+	return d.takeComments(func(c yaml_comment_t) bool {
+		return !c.inline && c.mark.line < first-1
+	})
+}
+
+// withComments attaches the given head comment and the pending
+// trailing comment on the line of the given mark to a marked value.
+func (d *Decoder) withComments(v interface{}, head string, mark YAML_mark_t) interface{} {
+	if !d.parser.keep_comments {
+		return v
+	}
+	line := d.takeComments(func(c yaml_comment_t) bool {
+		return c.inline && c.mark.line == mark.line
+	})
+	if m, ok := v.(Marked); ok {
+		m.HeadComment = head
+		m.LineComment = line
+		return m
+	}
+	return v
+}
+
+// takeComments removes all pending comments matching the given
+// filter and returns their text separated by newlines.
+func (d *Decoder) takeComments(match func(c yaml_comment_t) bool) string {
+	var lines []string
+	rest := d.parser.comments[:0]
+	for _, c := range d.parser.comments {
+		if match(c) {
+			lines = append(lines, c.text)
+		} else {
+			rest = append(rest, c)
+		}
+	}
+	d.parser.comments = rest
+	return strings.Join(lines, "\n")
 }
@@ -30,6 +30,7 @@ var (
 	timeTimeType  = reflect.TypeOf(time.Time{})
 	marshalerType = reflect.TypeOf(new(Marshaler)).Elem()
 	numberType    = reflect.TypeOf(Number(""))
+	mapSliceType  = reflect.TypeOf(MapSlice{})
 	nonPrintable  = regexp.MustCompile("[^\t\n\r\u0020-\u007E\u0085\u00A0-\uD7FF\uE000-\uFFFD]")
 	multiline     = regexp.MustCompile("\n|\u0085|\u2028|\u2029")
 
@@ -50,6 +51,16 @@ type Marshaler interface {
 	MarshalYAML() (tag string, value interface{}, err error)
 }
 
+// MapItem is a single entry of a MapSlice.
+type MapItem struct {
+	Key   interface{}
+	Value interface{}
+}
+
+// MapSlice is a mapping, whose entries are marshalled in the
+// order of the slice instead of the sorted key order.
+type MapSlice []MapItem
+
 // An Encoder writes JSON objects to an output stream.
 type Encoder struct {
 	w       io.Writer
@@ -136,7 +147,11 @@ func (e *Encoder) marshal(tag string, v reflect.Value, allowAddr bool) {
 	case reflect.Struct:
 		e.emitStruct(tag, v)
 	case reflect.Slice:
-		e.emitSlice(tag, v)
+		if vt == mapSliceType {
+			e.emitMapSlice(tag, v.Interface().(MapSlice))
+		} else {
+			e.emitSlice(tag, v)
+		}
 	case reflect.String:
 		e.emitString(tag, v)
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
@@ -163,6 +178,15 @@ func (e *Encoder) emitMap(tag string, v reflect.Value) {
 	})
 }
 
+func (e *Encoder) emitMapSlice(tag string, m MapSlice) {
+	e.mapping(tag, func() {
+		for _, item := range m {
+			e.marshal("", reflect.ValueOf(item.Key), true)
+			e.marshal("", reflect.ValueOf(&item.Value).Elem(), true)
+		}
+	})
+}
+
 func (e *Encoder) emitStruct(tag string, v reflect.Value) {
 	if v.Type() == timeTimeType {
 		e.emitTime(tag, v)
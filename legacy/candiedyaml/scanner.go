@@ -1769,6 +1769,33 @@ func yaml_parser_fetch_plain_scalar(parser *yaml_parser_t) bool {
 	return true
 }
 
+/*
+ * Eat a comment until a line break and record it, if comments are kept.
+ */
+
+func yaml_parser_scan_comment(parser *yaml_parser_t, inline bool) bool {
+	mark := parser.mark
+	var text []byte
+	for !is_breakz_at(parser.buffer, parser.buffer_pos) {
+		if parser.keep_comments {
+			w := width(parser.buffer[parser.buffer_pos])
+			text = append(text, parser.buffer[parser.buffer_pos:parser.buffer_pos+w]...)
+		}
+		skip(parser)
+		if !cache(parser, 1) {
+			return false
+		}
+	}
+	if parser.keep_comments {
+		parser.comments = append(parser.comments, yaml_comment_t{
+			mark:   mark,
+			text:   string(text),
+			inline: inline,
+		})
+	}
+	return true
+}
+
 /*
  * Eat whitespaces and comments until the next token is found.
  */
@@ -1783,6 +1810,8 @@ func yaml_parser_scan_to_next_token(parser *yaml_parser_t) bool {
 			return false
 		}
 
+		standalone := parser.mark.column == 0
+
 		if parser.mark.column == 0 && is_bom_at(parser.buffer, parser.buffer_pos) {
 			skip(parser)
 		}
@@ -1813,11 +1842,8 @@ func yaml_parser_scan_to_next_token(parser *yaml_parser_t) bool {
 		/* Eat a comment until a line break. */
 
 		if parser.buffer[parser.buffer_pos] == '#' {
-			for !is_breakz_at(parser.buffer, parser.buffer_pos) {
-				skip(parser)
-				if !cache(parser, 1) {
-					return false
-				}
+			if !yaml_parser_scan_comment(parser, !standalone) {
+				return false
 			}
 		}
 
@@ -2630,11 +2656,8 @@ func yaml_parser_scan_block_scalar(parser *yaml_parser_t, token *yaml_token_t,
 	}
 
 	if parser.buffer[parser.buffer_pos] == '#' {
-		for !is_breakz_at(parser.buffer, parser.buffer_pos) {
-			skip(parser)
-			if !cache(parser, 1) {
-				return false
-			}
+		if !yaml_parser_scan_comment(parser, true) {
+			return false
 		}
 	}
 
@@ -550,6 +550,19 @@ const (
 	yaml_PARSE_END_STATE
 )
 
+/**
+ * This structure holds a comment found by the scanner.
+ */
+
+type yaml_comment_t struct {
+	/** The position of the '#' indicator. */
+	mark YAML_mark_t
+	/** The comment text including the '#' indicator. */
+	text string
+	/** Does the comment follow other content on its line? */
+	inline bool
+}
+
 /**
  * This structure holds aliases data.
  */
@@ -672,6 +685,12 @@ type yaml_parser_t struct {
 	/** The stack of simple keys. */
 	simple_keys []yaml_simple_key_t
 
+	/** Should comments be recorded? */
+	keep_comments bool
+
+	/** The comments scanned but not yet consumed. */
+	comments []yaml_comment_t
+
 	/**
 	 * @}
 	 */
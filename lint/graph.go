@@ -0,0 +1,427 @@
+package lint
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/mandelsoft/spiff/dynaml"
+	"github.com/mandelsoft/spiff/features"
+	"github.com/mandelsoft/spiff/yaml"
+)
+
+// node kinds of a dependency graph
+const (
+	NODE_NODE       = "node"       // a node of a template or stub document
+	NODE_TAG        = "tag"        // a tag
+	NODE_FILE       = "file"       // a file imported by read()
+	NODE_BINDING    = "binding"    // an additional binding
+	NODE_UNRESOLVED = "unresolved" // a reference target that could not be found
+)
+
+// edge kinds of a dependency graph
+const (
+	EDGE_REFERENCE = "reference" // a dynaml reference
+	EDGE_STUB      = "stub"      // a node overridden by a stub
+	EDGE_MERGE     = "merge"     // a merge from a stub
+	EDGE_TAG       = "tag"       // a tag reference or a tag definition
+	EDGE_READ      = "read"      // a file imported by read()
+)
+
+// GraphNode is a node of a dependency graph.
+type GraphNode struct {
+	ID       string `json:"id"`
+	Kind     string `json:"kind"`
+	Document string `json:"document,omitempty"`
+	Path     string `json:"path,omitempty"`
+	// Cycle indicates that the node is part of a dependency cycle
+	Cycle bool `json:"cycle,omitempty"`
+}
+
+// Edge is a dependency of a graph node on another one.
+type Edge struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Kind  string `json:"kind"`
+	Label string `json:"label,omitempty"`
+	Cycle bool   `json:"cycle,omitempty"`
+}
+
+// Graph is the reference dependency graph between the document
+// paths of a template and its stubs.
+type Graph struct {
+	Nodes []*GraphNode `json:"nodes"`
+	Edges []*Edge      `json:"edges"`
+
+	nodes map[string]*GraphNode
+	edges map[Edge]bool
+}
+
+func newGraph() *Graph {
+	return &Graph{
+		Nodes: []*GraphNode{},
+		Edges: []*Edge{},
+		nodes: map[string]*GraphNode{},
+		edges: map[Edge]bool{},
+	}
+}
+
+// DependencyGraph statically determines the dependencies of the nodes
+// of the given template documents and the stubs used for a merge like
+// Lint does. Besides references, the graph contains edges for nodes
+// overridden or merged by stubs, tag references and files imported
+// by read() calls with a literal file name.
+func DependencyGraph(templates []yaml.Node, stubs []yaml.Node, opts *Options) *Graph {
+	if opts == nil {
+		opts = &Options{}
+	}
+	registry := opts.Registry
+	if registry == nil {
+		registry = dynaml.DefaultRegistry()
+	}
+	var bindings yaml.Node
+	if opts.Bindings != nil {
+		bindings = yaml.NewNode(opts.Bindings, "bindings")
+	}
+
+	g := newGraph()
+	names := []string{}
+	for _, s := range stubs {
+		names = append(names, s.SourceName())
+	}
+	docs := []string{}
+	for i, t := range templates {
+		if t == nil || t.Value() == nil {
+			continue
+		}
+		name := t.SourceName()
+		if len(templates) > 1 {
+			name = fmt.Sprintf("%s#%d", name, i+1)
+		}
+		docs = append(docs, name)
+		l := newLinter(registry, opts.Features, bindings, t, stubs)
+		l.graph, l.names = g, append([]string{name}, names...)
+		l.lint()
+		g.overrides(l, t, []string{})
+	}
+	for i, s := range stubs {
+		if s == nil || s.Value() == nil {
+			continue
+		}
+		l := newLinter(registry, opts.Features, bindings, s, stubs[i+1:])
+		l.graph, l.names = g, names[i:]
+		l.lint()
+		g.overrides(l, s, []string{})
+	}
+	for i, name := range docs {
+		if t := g.nodes[nodeID(NODE_TAG, "", []string{fmt.Sprintf("doc.%d", i+1)})]; t != nil {
+			g.edge(t, g.node(NODE_NODE, name, nil), EDGE_TAG, "")
+		}
+	}
+	g.cycles()
+	return g
+}
+
+func nodeID(kind, doc string, path []string) string {
+	switch kind {
+	case NODE_NODE:
+		return doc + ":" + strings.Join(path, ".")
+	case NODE_FILE:
+		return kind + ":" + doc
+	default:
+		return kind + ":" + strings.Join(path, ".")
+	}
+}
+
+func (g *Graph) node(kind, doc string, path []string) *GraphNode {
+	id := nodeID(kind, doc, path)
+	n := g.nodes[id]
+	if n == nil {
+		n = &GraphNode{ID: id, Kind: kind, Document: doc, Path: strings.Join(path, ".")}
+		g.nodes[id] = n
+		g.Nodes = append(g.Nodes, n)
+	}
+	return n
+}
+
+func (g *Graph) edge(from, to *GraphNode, kind, label string) {
+	e := Edge{From: from.ID, To: to.ID, Kind: kind, Label: label}
+	if !g.edges[e] {
+		g.edges[e] = true
+		g.Edges = append(g.Edges, &e)
+	}
+}
+
+func (g *Graph) tag(name string, doc string, path []string) {
+	g.edge(g.node(NODE_TAG, "", []string{normalizeTag(name)}), g.node(NODE_NODE, doc, path), EDGE_TAG, "")
+}
+
+func normalizeTag(name string) string {
+	return strings.Replace(strings.TrimPrefix(name, "*"), ":", ".", -1)
+}
+
+// overrides adds the edges for nodes of a document overridden by its stubs.
+// Maps are not overridden as a whole, but their fields.
+func (g *Graph) overrides(l *linter, node yaml.Node, path []string) {
+	if node == nil {
+		return
+	}
+	switch v := node.Value().(type) {
+	case map[string]yaml.Node:
+		for _, k := range yaml.GetOrderedKeys(node) {
+			if k != "<<" && k != yaml.MERGEKEY {
+				g.overrides(l, v[k], extend(path, k))
+			}
+		}
+		return
+	case []yaml.Node:
+		for _, e := range v {
+			if _, _, ok := yaml.UnresolvedListEntryMerge(e); ok {
+				return
+			}
+		}
+	}
+	if len(path) == 0 || isMerge(node, l.features) {
+		return
+	}
+	for i, s := range l.providers[1:] {
+		if st, canonical := lookup(s, path, l.features); st == found {
+			g.edge(l.graph.node(NODE_NODE, l.names[0], path), g.node(NODE_NODE, l.names[i+1], canonical), EDGE_STUB, "")
+			return
+		}
+	}
+}
+
+func isMerge(node yaml.Node, features features.FeatureFlags) bool {
+	src := yaml.EmbeddedDynaml(node, features.InterpolationEnabled())
+	if src == nil {
+		return false
+	}
+	e, err := dynaml.Parse(*src, nil, nil)
+	if err != nil {
+		return false
+	}
+	_, ok := e.(dynaml.MergeExpr)
+	return ok
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// recording dependencies while linting
+
+// from provides the graph node for the node of an expression.
+func (l *linter) from(ctx *context) *GraphNode {
+	return l.graph.node(NODE_NODE, l.names[0], l.nodePath(ctx.path))
+}
+
+// nodePath provides the path of the node of an expression.
+// Merge expressions are attributed to the merging map or list.
+func (l *linter) nodePath(path []string) []string {
+	if n := len(path); n > 0 && (path[n-1] == "<<" || path[n-1] == yaml.MERGEKEY) {
+		path = path[:n-1]
+		if n > 1 && strings.HasPrefix(path[n-2], "[") {
+			if e, ok := yaml.FindR(true, l.root, l.features, path...); ok {
+				if m, ok := e.Value().(map[string]yaml.Node); ok && len(m) == 1 {
+					path = path[:n-2]
+				}
+			}
+		}
+	}
+	return path
+}
+
+func (l *linter) depend(ctx *context, ref dynaml.ReferenceExpr, provider int, path []string) {
+	if l.graph == nil {
+		return
+	}
+	from := l.from(ctx)
+	var to *GraphNode
+	if provider < 0 {
+		to = l.graph.node(NODE_UNRESOLVED, "", path)
+	} else {
+		to = l.graph.node(NODE_NODE, l.names[provider], path)
+	}
+	l.graph.edge(from, to, EDGE_REFERENCE, ref.String())
+}
+
+func (l *linter) merge(ctx *context, e dynaml.MergeExpr) {
+	if e.None {
+		return
+	}
+	from := l.from(ctx)
+	path := e.Path
+	if !e.Redirect {
+		path = l.nodePath(ctx.path)
+	}
+	for i, s := range l.providers[1:] {
+		if st, canonical := lookup(s, path, l.features); st == found {
+			l.graph.edge(from, l.graph.node(NODE_NODE, l.names[i+1], canonical), EDGE_MERGE, e.String())
+			return
+		}
+	}
+}
+
+func (l *linter) read(ctx *context, call dynaml.CallExpr) {
+	if len(call.Arguments) == 0 {
+		return
+	}
+	if file, ok := call.Arguments[0].(dynaml.StringExpr); ok {
+		l.graph.edge(l.from(ctx), l.graph.node(NODE_FILE, file.Value, nil), EDGE_READ, "")
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// cycles marks the nodes and edges being part of a dependency cycle
+// (strongly connected components of the graph, Tarjan's algorithm).
+func (g *Graph) cycles() {
+	out := map[string][]*Edge{}
+	for _, e := range g.Edges {
+		out[e.From] = append(out[e.From], e)
+	}
+	index := map[string]int{}
+	low := map[string]int{}
+	onStack := map[string]bool{}
+	stack := []string{}
+	component := map[string]int{}
+	count := 0
+
+	var visit func(id string)
+	visit = func(id string) {
+		index[id] = len(index)
+		low[id] = index[id]
+		stack = append(stack, id)
+		onStack[id] = true
+		for _, e := range out[id] {
+			if _, ok := index[e.To]; !ok {
+				visit(e.To)
+				if low[e.To] < low[id] {
+					low[id] = low[e.To]
+				}
+			} else if onStack[e.To] && index[e.To] < low[id] {
+				low[id] = index[e.To]
+			}
+		}
+		if low[id] == index[id] {
+			count++
+			for {
+				n := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[n] = false
+				component[n] = count
+				if n == id {
+					break
+				}
+			}
+		}
+	}
+	for _, n := range g.Nodes {
+		if _, ok := index[n.ID]; !ok {
+			visit(n.ID)
+		}
+	}
+
+	size := map[int]int{}
+	for _, c := range component {
+		size[c]++
+	}
+	for _, e := range g.Edges {
+		if component[e.From] == component[e.To] && (size[component[e.From]] > 1 || e.From == e.To) {
+			e.Cycle = true
+			g.nodes[e.From].Cycle = true
+		}
+	}
+}
+
+// HasCycles checks whether the graph contains a dependency cycle.
+func (g *Graph) HasCycles() bool {
+	for _, n := range g.Nodes {
+		if n.Cycle {
+			return true
+		}
+	}
+	return false
+}
+
+// DOT provides the graph in the dot format of graphviz. Nodes are
+// grouped by document, nodes and edges of cycles are colored red.
+func (g *Graph) DOT() []byte {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "digraph dependencies {\n")
+	fmt.Fprintf(buf, "  rankdir=LR;\n")
+	fmt.Fprintf(buf, "  node [shape=box];\n")
+
+	docs := []string{}
+	nodes := map[string][]*GraphNode{}
+	for _, n := range g.Nodes {
+		doc := n.Document
+		if n.Kind != NODE_NODE {
+			doc = ""
+		}
+		if _, ok := nodes[doc]; !ok && doc != "" {
+			docs = append(docs, doc)
+		}
+		nodes[doc] = append(nodes[doc], n)
+	}
+	for i, doc := range docs {
+		fmt.Fprintf(buf, "  subgraph %s {\n", quote(fmt.Sprintf("cluster_%d", i)))
+		fmt.Fprintf(buf, "    label=%s;\n", quote(doc))
+		for _, n := range nodes[doc] {
+			label := n.Path
+			if label == "" {
+				label = "<root>"
+			}
+			fmt.Fprintf(buf, "    %s [label=%s%s];\n", quote(n.ID), quote(label), cycleAttr(n.Cycle))
+		}
+		fmt.Fprintf(buf, "  }\n")
+	}
+	for _, n := range nodes[""] {
+		shape := "ellipse"
+		switch n.Kind {
+		case NODE_FILE:
+			shape = "note"
+		case NODE_UNRESOLVED:
+			shape = "octagon"
+		}
+		fmt.Fprintf(buf, "  %s [shape=%s%s];\n", quote(n.ID), shape, cycleAttr(n.Cycle))
+	}
+	for _, e := range g.Edges {
+		attrs := []string{}
+		switch e.Kind {
+		case EDGE_STUB, EDGE_MERGE:
+			attrs = append(attrs, "style=dashed")
+		case EDGE_TAG:
+			attrs = append(attrs, "style=dotted")
+		case EDGE_READ:
+			attrs = append(attrs, "style=bold")
+		}
+		label := e.Label
+		if label == "" && e.Kind != EDGE_REFERENCE {
+			label = e.Kind
+		}
+		if label != "" {
+			attrs = append(attrs, "label="+quote(label))
+		}
+		if e.Cycle {
+			attrs = append(attrs, "color=red")
+		}
+		fmt.Fprintf(buf, "  %s -> %s [%s];\n", quote(e.From), quote(e.To), strings.Join(attrs, ", "))
+	}
+	fmt.Fprintf(buf, "}\n")
+	return buf.Bytes()
+}
+
+// JSON provides the graph in json format.
+func (g *Graph) JSON() ([]byte, error) {
+	return marshal(g)
+}
+
+func cycleAttr(cycle bool) string {
+	if cycle {
+		return ", color=red"
+	}
+	return ""
+}
+
+func quote(s string) string {
+	return `"` + strings.Replace(strings.Replace(s, `\`, `\\`, -1), `"`, `\"`, -1) + `"`
+}
@@ -0,0 +1,156 @@
+package lint
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/mandelsoft/spiff/yaml"
+)
+
+func graphOf(template string, stubs ...string) *Graph {
+	var nodes []yaml.Node
+	for _, s := range stubs {
+		nodes = append(nodes, parseYAML(s, "stub"))
+	}
+	return DependencyGraph([]yaml.Node{parseYAML(template, "template")}, nodes, nil)
+}
+
+func edges(g *Graph) []string {
+	result := []string{}
+	for _, e := range g.Edges {
+		s := e.Kind + ":" + e.From + "->" + e.To
+		if e.Cycle {
+			s += "!"
+		}
+		result = append(result, s)
+	}
+	return result
+}
+
+var _ = Describe("Dependency graph", func() {
+	It("records references", func() {
+		g := graphOf(`
+foo:
+  bar: 1
+list:
+  - name: alice
+    age: (( foo.bar ))
+values:
+  a: (( list.alice.age + .foo.bar ))
+  b: (( a ))
+`)
+		Expect(edges(g)).To(Equal([]string{
+			"reference:template:list.[0].age->template:foo.bar",
+			"reference:template:values.a->template:list.[0].age",
+			"reference:template:values.a->template:foo.bar",
+			"reference:template:values.b->template:values.a",
+		}))
+		Expect(g.HasCycles()).To(BeFalse())
+	})
+
+	It("marks cycles", func() {
+		g := graphOf(`
+a: (( b ))
+b: (( c ))
+c: (( a ))
+d: (( a ))
+`)
+		Expect(edges(g)).To(Equal([]string{
+			"reference:template:a->template:b!",
+			"reference:template:b->template:c!",
+			"reference:template:c->template:a!",
+			"reference:template:d->template:a",
+		}))
+		Expect(g.HasCycles()).To(BeTrue())
+		Expect(g.nodes["template:d"].Cycle).To(BeFalse())
+		Expect(g.nodes["template:a"].Cycle).To(BeTrue())
+	})
+
+	It("records stub dependencies", func() {
+		g := graphOf(`
+values:
+  a: 1
+  b: (( c ))
+list:
+  - <<: (( merge ))
+  - name: bob
+map:
+  <<: (( merge ))
+  bob: 26
+`, `
+values:
+  a: 2
+  c: 3
+list:
+  - name: alice
+map:
+  alice: 25
+`)
+		Expect(edges(g)).To(Equal([]string{
+			"reference:template:values.b->stub:values.c",
+			"merge:template:list->stub:list",
+			"merge:template:map->stub:map",
+			"stub:template:values.a->stub:values.a",
+		}))
+	})
+
+	It("records tags and read imports", func() {
+		g := graphOf(`
+data:
+  <<: (( &tag:data ))
+  value: 1
+file: (( read("values.yml") ))
+ref: (( data::value ))
+`)
+		Expect(edges(g)).To(Equal([]string{
+			"tag:tag:data->template:data",
+			"read:template:file->file:values.yml",
+			"tag:template:ref->tag:data",
+		}))
+	})
+
+	It("records unresolved references", func() {
+		g := graphOf(`
+a: (( missing.value ))
+`)
+		Expect(edges(g)).To(Equal([]string{
+			"reference:template:a->unresolved:missing.value",
+		}))
+	})
+
+	It("renders dot", func() {
+		g := graphOf(`
+a: (( b ))
+b: (( a ))
+`)
+		Expect(string(g.DOT())).To(Equal(`digraph dependencies {
+  rankdir=LR;
+  node [shape=box];
+  subgraph "cluster_0" {
+    label="template";
+    "template:a" [label="a", color=red];
+    "template:b" [label="b", color=red];
+  }
+  "template:a" -> "template:b" [label="b", color=red];
+  "template:b" -> "template:a" [label="a", color=red];
+}
+`))
+	})
+
+	It("renders json", func() {
+		g := graphOf(`
+a: (( b ))
+b: 1
+`)
+		data, err := g.JSON()
+		Expect(err).To(Succeed())
+		var result map[string]interface{}
+		Expect(json.Unmarshal(data, &result)).To(Succeed())
+		Expect(result["edges"]).To(Equal([]interface{}{
+			map[string]interface{}{"from": "template:a", "to": "template:b", "kind": "reference", "label": "b"},
+		}))
+		Expect(result["nodes"]).To(HaveLen(2))
+	})
+})
@@ -0,0 +1,23 @@
+package lint
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/mandelsoft/spiff/yaml"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Lint")
+}
+
+func parseYAML(source string, name string) yaml.Node {
+	parsed, err := yaml.Parse(name, []byte(source))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
@@ -0,0 +1,619 @@
+package lint
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mandelsoft/spiff/dynaml"
+	"github.com/mandelsoft/spiff/features"
+	"github.com/mandelsoft/spiff/yaml"
+
+	_ "github.com/mandelsoft/spiff/dynaml/passwd"
+	_ "github.com/mandelsoft/spiff/dynaml/secrets"
+	_ "github.com/mandelsoft/spiff/dynaml/semver"
+	_ "github.com/mandelsoft/spiff/dynaml/wireguard"
+	_ "github.com/mandelsoft/spiff/dynaml/x509"
+)
+
+type Severity string
+
+const (
+	ERROR   Severity = "error"
+	WARNING Severity = "warning"
+)
+
+const (
+	RULE_SYNTAX_ERROR         = "syntax-error"
+	RULE_UNKNOWN_FUNCTION     = "unknown-function"
+	RULE_UNRESOLVED_REFERENCE = "unresolved-reference"
+	RULE_UNUSED_NODE          = "unused-node"
+	RULE_LAMBDA_SHADOWING     = "lambda-shadowing"
+)
+
+type Rule struct {
+	ID          string
+	Severity    Severity
+	Description string
+}
+
+var Rules = []Rule{
+	{RULE_SYNTAX_ERROR, ERROR, "dynaml expression cannot be parsed"},
+	{RULE_UNKNOWN_FUNCTION, ERROR, "call of a function that is neither builtin nor registered"},
+	{RULE_UNRESOLVED_REFERENCE, ERROR, "reference to a path that is provided neither by the template nor by a stub"},
+	{RULE_UNUSED_NODE, WARNING, "temporary or local node is never referenced"},
+	{RULE_LAMBDA_SHADOWING, WARNING, "lambda parameter shadows an outer parameter or scope name"},
+}
+
+func LookupRule(id string) (int, *Rule) {
+	for i := range Rules {
+		if Rules[i].ID == id {
+			return i, &Rules[i]
+		}
+	}
+	return -1, nil
+}
+
+type Finding struct {
+	Rule       string   `json:"rule"`
+	Severity   Severity `json:"severity"`
+	Message    string   `json:"message"`
+	File       string   `json:"file"`
+	Line       int      `json:"line,omitempty"`
+	Column     int      `json:"column,omitempty"`
+	Path       string   `json:"path,omitempty"`
+	Expression string   `json:"expression,omitempty"`
+}
+
+func (f Finding) String() string {
+	loc := f.File
+	if f.Line > 0 {
+		loc = fmt.Sprintf("%s:%d:%d", loc, f.Line, f.Column)
+	}
+	return fmt.Sprintf("%s: %s: %s [%s]", loc, f.Severity, f.Message, f.Rule)
+}
+
+// HasErrors checks whether a list of findings contains
+// at least one finding with severity error.
+func HasErrors(findings []Finding) bool {
+	for _, f := range findings {
+		if f.Severity == ERROR {
+			return true
+		}
+	}
+	return false
+}
+
+type Options struct {
+	// Registry is used to lookup non-builtin functions,
+	// if not set the default registry is used.
+	Registry dynaml.Registry
+	Features features.FeatureFlags
+	// Bindings describe additional outer bindings
+	// available for processing the template.
+	Bindings map[string]yaml.Node
+}
+
+// Lint statically analyses the given template documents together
+// with the stubs used for a merge. The stubs are checked, also.
+// A stub may only refer to itself and its successors.
+// No expression is evaluated.
+func Lint(templates []yaml.Node, stubs []yaml.Node, opts *Options) []Finding {
+	if opts == nil {
+		opts = &Options{}
+	}
+	registry := opts.Registry
+	if registry == nil {
+		registry = dynaml.DefaultRegistry()
+	}
+	var bindings yaml.Node
+	if opts.Bindings != nil {
+		bindings = yaml.NewNode(opts.Bindings, "bindings")
+	}
+
+	findings := []Finding{}
+	for _, t := range templates {
+		if t == nil || t.Value() == nil {
+			continue
+		}
+		l := newLinter(registry, opts.Features, bindings, t, stubs)
+		findings = append(findings, l.lint()...)
+	}
+	for i, s := range stubs {
+		if s == nil || s.Value() == nil {
+			continue
+		}
+		l := newLinter(registry, opts.Features, bindings, s, stubs[i+1:])
+		findings = append(findings, l.lint()...)
+	}
+	files := map[string]int{}
+	for _, f := range findings {
+		if _, ok := files[f.File]; !ok {
+			files[f.File] = len(files)
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		if a.File != b.File {
+			return files[a.File] < files[b.File]
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return findings
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type status int
+
+const (
+	missing status = iota
+	unknown
+	found
+)
+
+type marked struct {
+	node yaml.Node
+	path []string
+	kind string
+}
+
+type context struct {
+	node     yaml.Node
+	path     []string
+	scopes   [][]string
+	template bool
+	source   string
+}
+
+type linter struct {
+	registry  dynaml.Registry
+	features  features.FeatureFlags
+	bindings  yaml.Node
+	root      yaml.Node
+	providers []yaml.Node
+	findings  []Finding
+	marked    []marked
+	used      [][]string
+
+	// graph and names are used to record the dependencies
+	// between the nodes of the providers
+	graph *Graph
+	names []string
+}
+
+func newLinter(registry dynaml.Registry, features features.FeatureFlags, bindings yaml.Node, root yaml.Node, stubs []yaml.Node) *linter {
+	return &linter{
+		registry:  registry,
+		features:  features,
+		bindings:  bindings,
+		root:      root,
+		providers: append([]yaml.Node{root}, stubs...),
+	}
+}
+
+func (l *linter) lint() []Finding {
+	l.value(l.root, []string{}, nil, false)
+	for _, m := range l.marked {
+		if !l.isUsed(m.path) {
+			l.report(RULE_UNUSED_NODE, m.node, m.path, "",
+				"%s node %q is never referenced", m.kind, strings.Join(m.path, "."))
+		}
+	}
+	return l.findings
+}
+
+func (l *linter) report(rule string, node yaml.Node, path []string, expr string, msg string, args ...interface{}) {
+	_, r := LookupRule(rule)
+	f := Finding{
+		Rule:       rule,
+		Severity:   r.Severity,
+		Message:    fmt.Sprintf(msg, args...),
+		File:       node.SourceName(),
+		Path:       strings.Join(path, "."),
+		Expression: expr,
+	}
+	if pos := node.Position(); pos.IsValid() {
+		f.Line = pos.Line
+		f.Column = pos.Column
+	}
+	l.findings = append(l.findings, f)
+}
+
+func (l *linter) value(node yaml.Node, path []string, scopes [][]string, template bool) {
+	if marker, ok := l.node(node, path, scopes, template).(dynaml.MarkerExpr); ok {
+		l.mark(marker, node, path, template || marker.Has(dynaml.TEMPLATE))
+	}
+}
+
+func (l *linter) node(node yaml.Node, path []string, scopes [][]string, template bool) dynaml.Expression {
+	if node == nil {
+		return nil
+	}
+	switch v := node.Value().(type) {
+	case map[string]yaml.Node:
+		scopes = append(scopes[:len(scopes):len(scopes)], path)
+		for _, k := range []string{"<<", yaml.MERGEKEY} {
+			if m := v[k]; m != nil {
+				if marker, ok := l.node(m, extend(path, k), scopes, template).(dynaml.MarkerExpr); ok {
+					if marker.Has(dynaml.TEMPLATE) {
+						template = true
+					}
+					l.mark(marker, node, path, template)
+				}
+			}
+		}
+		for _, k := range yaml.GetOrderedKeys(node) {
+			if k == "<<" || k == yaml.MERGEKEY {
+				continue
+			}
+			l.value(v[k], extend(path, k), scopes, template)
+		}
+	case []yaml.Node:
+		for i, e := range v {
+			l.value(e, extend(path, fmt.Sprintf("[%d]", i)), scopes, template)
+		}
+	default:
+		return l.expression(node, path, scopes, template)
+	}
+	return nil
+}
+
+func (l *linter) mark(marker dynaml.MarkerExpr, node yaml.Node, path []string, template bool) {
+	if l.graph != nil && marker.GetTag() != "" {
+		l.graph.tag(marker.GetTag(), l.names[0], path)
+	}
+	if template || len(path) == 0 || marker.GetTag() != "" {
+		return
+	}
+	flags := marker.GetFlags()
+	switch {
+	case flags.Temporary():
+		l.marked = append(l.marked, marked{node, path, "temporary"})
+	case flags.Local():
+		l.marked = append(l.marked, marked{node, path, "local"})
+	}
+}
+
+func (l *linter) expression(node yaml.Node, path []string, scopes [][]string, template bool) dynaml.Expression {
+	src := yaml.EmbeddedDynaml(node, l.features.InterpolationEnabled())
+	if src == nil {
+		return nil
+	}
+	e, err := dynaml.Parse(*src, path, path)
+	if err != nil {
+		l.report(RULE_SYNTAX_ERROR, node, path, strings.TrimSpace(*src), "%s", err)
+		return nil
+	}
+	ctx := &context{
+		node:     node,
+		path:     path,
+		scopes:   scopes,
+		template: template,
+		source:   strings.TrimSpace(*src),
+	}
+	if marker, ok := e.(dynaml.MarkerExpr); ok && marker.Has(dynaml.TEMPLATE) {
+		ctx.template = true
+	}
+	l.check(ctx, e, nil)
+	return e
+}
+
+func (l *linter) check(ctx *context, e dynaml.Expression, locals []string) {
+	dynaml.Walk(e, func(e dynaml.Expression) bool {
+		switch v := e.(type) {
+		case dynaml.CallExpr:
+			if name := functionName(v); name != "" {
+				if !dynaml.IsBuiltinFunction(name) && l.registry.LookupFunction(name) == nil {
+					l.report(RULE_UNKNOWN_FUNCTION, ctx.node, ctx.path, ctx.source, "unknown function '%s'", name)
+				}
+				if name == "read" && l.graph != nil {
+					l.read(ctx, v)
+				}
+			} else {
+				l.check(ctx, v.Function, locals)
+			}
+			for _, a := range v.Arguments {
+				l.check(ctx, a, locals)
+			}
+			return false
+		case dynaml.LambdaExpr:
+			names := []string{}
+			for _, p := range v.Parameters {
+				if contains(locals, p.Name) {
+					l.report(RULE_LAMBDA_SHADOWING, ctx.node, ctx.path, ctx.source, "lambda parameter '%s' shadows outer name", p.Name)
+				}
+				if p.Default != nil {
+					l.check(ctx, p.Default, locals)
+				}
+				names = append(names, p.Name)
+			}
+			l.check(ctx, v.E, append(names, locals...))
+			return false
+		case dynaml.ScopeExpr:
+			names := []string{}
+			for _, a := range v.Assignments {
+				if s, ok := a.Key.(dynaml.StringExpr); ok {
+					names = append(names, s.Value)
+				} else {
+					l.check(ctx, a.Key, locals)
+				}
+				l.check(ctx, a.Value, locals)
+			}
+			l.check(ctx, v.E, append(names, locals...))
+			return false
+		case dynaml.QualifiedExpr:
+			// the qualifying reference is relative to the value of the expression
+			l.check(ctx, v.Expression, locals)
+			return false
+		case dynaml.ReferenceExpr:
+			l.reference(ctx, v, locals)
+		case dynaml.MergeExpr:
+			if l.graph != nil {
+				l.merge(ctx, v)
+			}
+		}
+		return true
+	})
+}
+
+func functionName(e dynaml.CallExpr) string {
+	ref, ok := e.Function.(dynaml.ReferenceExpr)
+	if ok && ref.Tag == "" && len(ref.Path) == 1 && ref.Path[0] != "" && ref.Path[0] != yaml.SELF {
+		return ref.Path[0]
+	}
+	return ""
+}
+
+func (l *linter) reference(ctx *context, ref dynaml.ReferenceExpr, locals []string) {
+	path := ref.Path
+	if ref.Tag != "" && l.graph != nil {
+		l.graph.edge(l.from(ctx), l.graph.node(NODE_TAG, "", []string{normalizeTag(ref.Tag)}), EDGE_TAG, ref.String())
+	}
+	if ref.Tag != "" || len(path) == 0 {
+		return
+	}
+	switch path[0] {
+	case "":
+		path = path[1:]
+		if len(path) == 0 || contains(locals, path[0]) {
+			return
+		}
+		l.resolve(ctx, ref, []string{}, path)
+		return
+	case yaml.ROOT:
+		if len(path) > 1 {
+			if l.bindings != nil {
+				return
+			}
+			l.resolve(ctx, ref, []string{}, path[1:])
+		}
+		return
+	case yaml.SELF, yaml.DOCNODE:
+		// relative lookup skipping local scopes, _ may refer to a lambda or template
+		if len(path) > 1 {
+			l.resolveInScopes(ctx, ref, path[1:], path[0] == yaml.DOCNODE)
+		}
+		return
+	case "__ctx":
+		return
+	}
+	if contains(locals, path[0]) {
+		return
+	}
+	l.resolveInScopes(ctx, ref, path, true)
+}
+
+func (l *linter) resolveInScopes(ctx *context, ref dynaml.ReferenceExpr, path []string, report bool) {
+	for i := len(ctx.scopes) - 1; i >= 0; i-- {
+		scope := ctx.scopes[i]
+		switch st, _, provider := l.lookup(extend(scope, path[0])); st {
+		case unknown:
+			l.used = append(l.used, append(extend(scope), path...))
+			l.depend(ctx, ref, provider, append(extend(scope), path...))
+			return
+		case found:
+			l.resolve(ctx, ref, scope, path)
+			return
+		}
+	}
+	if l.bindings != nil {
+		if _, ok := yaml.FindR(true, l.bindings, l.features, path[0]); ok {
+			if l.graph != nil {
+				l.graph.edge(l.from(ctx), l.graph.node(NODE_BINDING, "", path), EDGE_REFERENCE, ref.String())
+			}
+			return
+		}
+	}
+	if report && !ctx.template {
+		l.report(RULE_UNRESOLVED_REFERENCE, ctx.node, ctx.path, ctx.source, "unresolved reference '%s'", ref)
+		l.depend(ctx, ref, -1, path)
+	}
+}
+
+func (l *linter) resolve(ctx *context, ref dynaml.ReferenceExpr, scope []string, path []string) {
+	st, canonical, provider := l.lookup(append(extend(scope), path...))
+	l.used = append(l.used, canonical)
+	if st == missing && !ctx.template {
+		l.report(RULE_UNRESOLVED_REFERENCE, ctx.node, ctx.path, ctx.source, "unresolved reference '%s'", ref)
+	}
+	if st != missing || !ctx.template {
+		l.depend(ctx, ref, provider, canonical)
+	}
+}
+
+// lookup checks whether a path is provided by the document or one of
+// its stubs. Additionally the canonical path (using list indices)
+// is returned as far as it could be determined together with the
+// index of the providing document (-1 if not found).
+func (l *linter) lookup(path []string) (status, []string, int) {
+	result := missing
+	provider := -1
+	var canonical []string
+	for i, p := range l.providers {
+		st, c := lookup(p, path, l.features)
+		if st > result || canonical == nil {
+			canonical = c
+		}
+		if st > result {
+			result = st
+			provider = i
+		}
+		if result == found {
+			break
+		}
+	}
+	return result, canonical, provider
+}
+
+func lookup(node yaml.Node, path []string, features features.FeatureFlags) (status, []string) {
+	canonical := []string{}
+	for _, step := range path {
+		if node == nil || node.Value() == nil {
+			return missing, append(canonical, step)
+		}
+		if yaml.EmbeddedDynaml(node, features.InterpolationEnabled()) != nil {
+			return unknown, append(canonical, step)
+		}
+		switch v := node.Value().(type) {
+		case map[string]yaml.Node:
+			next, ok := v[step]
+			if !ok {
+				if isOpen(v, features) {
+					return unknown, append(canonical, step)
+				}
+				return missing, append(canonical, step)
+			}
+			node = next
+			canonical = append(canonical, step)
+		case []yaml.Node:
+			index, st := lookupListEntry(node, v, step, features)
+			if index < 0 {
+				return st, append(canonical, step)
+			}
+			node = v[index]
+			canonical = append(canonical, fmt.Sprintf("[%d]", index))
+		default:
+			return missing, append(canonical, step)
+		}
+	}
+	return found, canonical
+}
+
+func lookupListEntry(node yaml.Node, list []yaml.Node, step string, features features.FeatureFlags) (int, status) {
+	if strings.HasPrefix(step, "[") && strings.HasSuffix(step, "]") {
+		index, err := strconv.Atoi(step[1 : len(step)-1])
+		if err == nil {
+			if index < 0 {
+				index = len(list) + index
+			}
+			if index >= 0 && index < len(list) {
+				return index, found
+			}
+			return -1, missing
+		}
+	}
+
+	key := node.KeyName()
+	if key == "" {
+		key = "name"
+	}
+	if split := strings.Index(step, ":"); split > 0 {
+		key = step[:split]
+		step = step[split+1:]
+	}
+	st := missing
+	for i, e := range list {
+		if e == nil {
+			continue
+		}
+		if yaml.EmbeddedDynaml(e, features.InterpolationEnabled()) != nil {
+			st = unknown
+			continue
+		}
+		m, ok := e.Value().(map[string]yaml.Node)
+		if !ok {
+			continue
+		}
+		name, ok := m[key]
+		if !ok {
+			if isOpen(m, features) {
+				st = unknown
+			}
+			continue
+		}
+		if yaml.EmbeddedDynaml(name, features.InterpolationEnabled()) != nil {
+			st = unknown
+			continue
+		}
+		if s, ok := name.Value().(string); ok && s == step {
+			return i, found
+		}
+	}
+	return -1, st
+}
+
+// isOpen checks whether a map may get additional fields by
+// a merge or a control expression. Pure markers do not add fields.
+func isOpen(m map[string]yaml.Node, features features.FeatureFlags) bool {
+	for k, v := range m {
+		if !strings.HasPrefix(k, "<<") {
+			continue
+		}
+		if k != "<<" && k != yaml.MERGEKEY {
+			return true
+		}
+		src := yaml.EmbeddedDynaml(v, features.InterpolationEnabled())
+		if src == nil {
+			return true
+		}
+		e, err := dynaml.Parse(*src, nil, nil)
+		if err != nil {
+			return true
+		}
+		if marker, ok := e.(dynaml.MarkerExpr); !ok || marker.MarkerExpression() != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *linter) isUsed(path []string) bool {
+	for _, u := range l.used {
+		if hasPrefix(u, path) || hasPrefix(path, u) {
+			return true
+		}
+	}
+	return false
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func extend(path []string, steps ...string) []string {
+	return append(path[:len(path):len(path)], steps...)
+}
+
+func hasPrefix(path, prefix []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i, s := range prefix {
+		if path[i] != s {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(list []string, name string) bool {
+	for _, s := range list {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
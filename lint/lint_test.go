@@ -0,0 +1,259 @@
+package lint
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/mandelsoft/spiff/dynaml"
+	"github.com/mandelsoft/spiff/yaml"
+)
+
+func lintTemplate(template string, stubs ...string) []Finding {
+	var nodes []yaml.Node
+	for _, s := range stubs {
+		nodes = append(nodes, parseYAML(s, "stub"))
+	}
+	return Lint([]yaml.Node{parseYAML(template, "template")}, nodes, nil)
+}
+
+func rules(findings []Finding) []string {
+	result := []string{}
+	for _, f := range findings {
+		result = append(result, f.Rule+":"+f.Path)
+	}
+	return result
+}
+
+var _ = Describe("Linting", func() {
+	It("accepts a valid template", func() {
+		findings := lintTemplate(`
+foo:
+  bar: 1
+list:
+  - name: alice
+    age: 25
+values:
+  a: (( foo.bar + .foo.bar ))
+  b: (( list.alice.age + list.[0].age ))
+  c: (( map[[1,2]|x|->x * 2] ))
+  d: (( upper("x") ))
+  e: (( semvermajor("1.2.3") ))
+  f: (( ($z=1) z ))
+  g: (( (foo).bar ))
+`)
+		Expect(findings).To(BeEmpty())
+	})
+
+	It("reports syntax errors with position", func() {
+		findings := lintTemplate(`
+foo: (( a + ))
+`)
+		Expect(findings).To(HaveLen(1))
+		Expect(findings[0].Rule).To(Equal(RULE_SYNTAX_ERROR))
+		Expect(findings[0].Severity).To(Equal(ERROR))
+		Expect(findings[0].File).To(Equal("template"))
+		Expect(findings[0].Line).To(Equal(2))
+		Expect(findings[0].Column).To(Equal(6))
+		Expect(findings[0].Expression).To(Equal("a +"))
+	})
+
+	It("reports unknown functions", func() {
+		findings := lintTemplate(`
+foo: (( join(",", list) + nofunc(1) ))
+list: []
+`)
+		Expect(rules(findings)).To(Equal([]string{RULE_UNKNOWN_FUNCTION + ":foo"}))
+		Expect(findings[0].Message).To(Equal("unknown function 'nofunc'"))
+	})
+
+	It("uses a given registry", func() {
+		functions := dynaml.NewFunctions()
+		functions.RegisterFunction("myfunc", func(arguments []interface{}, binding dynaml.Binding) (interface{}, dynaml.EvaluationInfo, bool) {
+			return nil, dynaml.DefaultInfo(), true
+		})
+		template := parseYAML(`
+foo: (( myfunc(1) ))
+`, "template")
+		Expect(Lint([]yaml.Node{template}, nil, nil)).To(HaveLen(1))
+		Expect(Lint([]yaml.Node{template}, nil, &Options{Registry: dynaml.DefaultRegistry().WithFunctions(functions)})).To(BeEmpty())
+	})
+
+	It("reports unresolved references", func() {
+		findings := lintTemplate(`
+foo:
+  bar: 1
+a: (( foo.bar ))
+b: (( foo.other ))
+c: (( list.bob ))
+d: (( .missing ))
+list:
+  - name: alice
+`)
+		Expect(rules(findings)).To(Equal([]string{
+			RULE_UNRESOLVED_REFERENCE + ":b",
+			RULE_UNRESOLVED_REFERENCE + ":c",
+			RULE_UNRESOLVED_REFERENCE + ":d",
+		}))
+		Expect(findings[0].Message).To(Equal("unresolved reference 'foo.other'"))
+	})
+
+	It("resolves references provided by stubs", func() {
+		findings := lintTemplate(`
+foo: ~
+a: (( foo.bar ))
+b: (( foo.other ))
+`, `
+foo:
+  bar: 1
+`)
+		Expect(rules(findings)).To(Equal([]string{RULE_UNRESOLVED_REFERENCE + ":b"}))
+	})
+
+	It("resolves references in enclosing scopes", func() {
+		findings := lintTemplate(`
+scope: root
+nested:
+  inner:
+    a: (( scope ))
+    b: (( sibling ))
+  sibling: 1
+`)
+		Expect(findings).To(BeEmpty())
+	})
+
+	It("accepts references into merged or computed content", func() {
+		findings := lintTemplate(`
+base:
+  <<: (( merge ))
+computed: (( { "a" = 1 } ))
+a: (( base.anything ))
+b: (( computed.a ))
+`)
+		Expect(findings).To(BeEmpty())
+	})
+
+	It("accepts references to bindings", func() {
+		template := parseYAML(`
+a: (( binding ))
+`, "template")
+		Expect(Lint([]yaml.Node{template}, nil, nil)).To(HaveLen(1))
+		opts := &Options{Bindings: map[string]yaml.Node{"binding": yaml.NewNode("value", "test")}}
+		Expect(Lint([]yaml.Node{template}, nil, opts)).To(BeEmpty())
+	})
+
+	It("ignores references in templates", func() {
+		findings := lintTemplate(`
+templ:
+  <<: (( &template ))
+  a: (( unknown ))
+expr: (( &template ( other ) ))
+`)
+		Expect(findings).To(BeEmpty())
+	})
+
+	It("checks stubs against their successors", func() {
+		findings := lintTemplate(`
+a: 1
+`, `
+b: (( c ))
+d: (( a ))
+`, `
+c: 2
+`)
+		Expect(rules(findings)).To(Equal([]string{RULE_UNRESOLVED_REFERENCE + ":d"}))
+		Expect(findings[0].File).To(Equal("stub"))
+	})
+
+	It("reports unused temporary and local nodes", func() {
+		findings := lintTemplate(`
+used:
+  <<: (( &temporary ))
+  a: 1
+unused:
+  <<: (( &temporary ))
+  b: 2
+local: (( &local ( 1 ) ))
+tagged:
+  <<: (( &tag:tag &temporary ))
+value: (( used.a ))
+`)
+		Expect(rules(findings)).To(Equal([]string{
+			RULE_UNUSED_NODE + ":unused",
+			RULE_UNUSED_NODE + ":local",
+		}))
+		Expect(findings[0].Severity).To(Equal(WARNING))
+		Expect(findings[1].Message).To(Equal(`local node "local" is never referenced`))
+	})
+
+	It("considers references to list entries by name", func() {
+		findings := lintTemplate(`
+list:
+  - name: alice
+    value: (( &temporary ( 1 ) ))
+value: (( list.alice.value ))
+`)
+		Expect(findings).To(BeEmpty())
+	})
+
+	It("reports shadowed lambda parameters", func() {
+		findings := lintTemplate(`
+a: (( |x|->map[[1]|x|->x] ))
+b: (( ($y=1) |y|->y ))
+c: (( |x|->map[[1]|v|->v + x] ))
+`)
+		Expect(rules(findings)).To(Equal([]string{
+			RULE_LAMBDA_SHADOWING + ":a",
+			RULE_LAMBDA_SHADOWING + ":b",
+		}))
+		Expect(findings[0].Message).To(Equal("lambda parameter 'x' shadows outer name"))
+	})
+
+	Context("output", func() {
+		findings := []Finding{
+			{
+				Rule:     RULE_UNKNOWN_FUNCTION,
+				Severity: ERROR,
+				Message:  "unknown function 'f'",
+				File:     "template",
+				Line:     2,
+				Column:   6,
+				Path:     "a.b",
+			},
+		}
+
+		It("renders json", func() {
+			data, err := JSON(nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("[]"))
+
+			data, err = JSON(findings)
+			Expect(err).NotTo(HaveOccurred())
+			var result []Finding
+			Expect(json.Unmarshal(data, &result)).To(Succeed())
+			Expect(result).To(Equal(findings))
+		})
+
+		It("renders sarif", func() {
+			data, err := SARIF("v1.0", findings)
+			Expect(err).NotTo(HaveOccurred())
+			var result map[string]interface{}
+			Expect(json.Unmarshal(data, &result)).To(Succeed())
+			Expect(result["version"]).To(Equal("2.1.0"))
+			run := result["runs"].([]interface{})[0].(map[string]interface{})
+			driver := run["tool"].(map[string]interface{})["driver"].(map[string]interface{})
+			Expect(driver["name"]).To(Equal("spiff"))
+			Expect(driver["rules"]).To(HaveLen(len(Rules)))
+			res := run["results"].([]interface{})[0].(map[string]interface{})
+			Expect(res["ruleId"]).To(Equal(RULE_UNKNOWN_FUNCTION))
+			Expect(res["ruleIndex"]).To(Equal(1.0))
+			Expect(res["level"]).To(Equal("error"))
+			loc := res["locations"].([]interface{})[0].(map[string]interface{})
+			Expect(loc["physicalLocation"]).To(Equal(map[string]interface{}{
+				"artifactLocation": map[string]interface{}{"uri": "template"},
+				"region":           map[string]interface{}{"startLine": 2.0, "startColumn": 6.0},
+			}))
+		})
+	})
+})
@@ -0,0 +1,155 @@
+package lint
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+const SARIF_VERSION = "2.1.0"
+const SARIF_SCHEMA = "https://json.schemastore.org/sarif-2.1.0.json"
+
+type SARIFLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []SARIFRun `json:"runs"`
+}
+
+type SARIFRun struct {
+	Tool    SARIFTool     `json:"tool"`
+	Results []SARIFResult `json:"results"`
+}
+
+type SARIFTool struct {
+	Driver SARIFDriver `json:"driver"`
+}
+
+type SARIFDriver struct {
+	Name           string      `json:"name"`
+	Version        string      `json:"version,omitempty"`
+	InformationURI string      `json:"informationUri,omitempty"`
+	Rules          []SARIFRule `json:"rules"`
+}
+
+type SARIFRule struct {
+	ID                   string             `json:"id"`
+	ShortDescription     SARIFMessage       `json:"shortDescription"`
+	DefaultConfiguration SARIFConfiguration `json:"defaultConfiguration"`
+}
+
+type SARIFConfiguration struct {
+	Level string `json:"level"`
+}
+
+type SARIFMessage struct {
+	Text string `json:"text"`
+}
+
+type SARIFResult struct {
+	RuleID    string          `json:"ruleId"`
+	RuleIndex int             `json:"ruleIndex"`
+	Level     string          `json:"level"`
+	Message   SARIFMessage    `json:"message"`
+	Locations []SARIFLocation `json:"locations,omitempty"`
+}
+
+type SARIFLocation struct {
+	PhysicalLocation *SARIFPhysicalLocation `json:"physicalLocation,omitempty"`
+	LogicalLocations []SARIFLogicalLocation `json:"logicalLocations,omitempty"`
+}
+
+type SARIFPhysicalLocation struct {
+	ArtifactLocation SARIFArtifactLocation `json:"artifactLocation"`
+	Region           *SARIFRegion          `json:"region,omitempty"`
+}
+
+type SARIFArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type SARIFRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
+
+type SARIFLogicalLocation struct {
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+}
+
+// NewSARIFLog creates a SARIF 2.1.0 log for a list of findings.
+func NewSARIFLog(version string, findings []Finding) *SARIFLog {
+	driver := SARIFDriver{
+		Name:           "spiff",
+		Version:        version,
+		InformationURI: "https://github.com/mandelsoft/spiff",
+		Rules:          []SARIFRule{},
+	}
+	for _, r := range Rules {
+		driver.Rules = append(driver.Rules, SARIFRule{
+			ID:                   r.ID,
+			ShortDescription:     SARIFMessage{r.Description},
+			DefaultConfiguration: SARIFConfiguration{string(r.Severity)},
+		})
+	}
+
+	results := []SARIFResult{}
+	for _, f := range findings {
+		index, _ := LookupRule(f.Rule)
+		result := SARIFResult{
+			RuleID:    f.Rule,
+			RuleIndex: index,
+			Level:     string(f.Severity),
+			Message:   SARIFMessage{f.Message},
+		}
+		loc := SARIFLocation{}
+		if f.File != "" {
+			loc.PhysicalLocation = &SARIFPhysicalLocation{
+				ArtifactLocation: SARIFArtifactLocation{f.File},
+			}
+			if f.Line > 0 {
+				loc.PhysicalLocation.Region = &SARIFRegion{f.Line, f.Column}
+			}
+		}
+		if f.Path != "" {
+			loc.LogicalLocations = []SARIFLogicalLocation{{f.Path}}
+		}
+		if loc.PhysicalLocation != nil || loc.LogicalLocations != nil {
+			result.Locations = []SARIFLocation{loc}
+		}
+		results = append(results, result)
+	}
+
+	return &SARIFLog{
+		Schema:  SARIF_SCHEMA,
+		Version: SARIF_VERSION,
+		Runs: []SARIFRun{
+			{
+				Tool:    SARIFTool{driver},
+				Results: results,
+			},
+		},
+	}
+}
+
+// SARIF renders a list of findings as SARIF document.
+func SARIF(version string, findings []Finding) ([]byte, error) {
+	return marshal(NewSARIFLog(version, findings))
+}
+
+// JSON renders a list of findings as JSON array.
+func JSON(findings []Finding) ([]byte, error) {
+	if findings == nil {
+		findings = []Finding{}
+	}
+	return marshal(findings)
+}
+
+func marshal(v interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
@@ -0,0 +1,477 @@
+package lsp
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/mandelsoft/spiff/dynaml"
+	"github.com/mandelsoft/spiff/features"
+	"github.com/mandelsoft/spiff/flow"
+	"github.com/mandelsoft/spiff/lint"
+	"github.com/mandelsoft/spiff/yaml"
+)
+
+// entry describes the source location of a document node.
+// For map entries the location of the key is used.
+type entry struct {
+	doc  int
+	path []string
+	line int
+	col  int
+	node yaml.Node
+}
+
+// tagdef describes a tag definition found in a document.
+type tagdef struct {
+	name string
+	doc  int
+	path []string
+}
+
+type document struct {
+	uri     string
+	version int
+	name    string
+	text    string
+	lines   []string
+
+	docs    []yaml.Node
+	results []yaml.Node
+	entries []entry
+	tags    []tagdef
+
+	diagnostics []Diagnostic
+}
+
+func newDocument(uri string, version int, text string, opts *Options) *document {
+	d := &document{
+		uri:     uri,
+		version: version,
+		name:    uriToPath(uri),
+		text:    text,
+		lines:   strings.Split(text, "\n"),
+	}
+	d.analyze(opts)
+	return d
+}
+
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return u.Path
+}
+
+func (d *document) analyze(opts *Options) {
+	d.diagnostics = []Diagnostic{}
+	docs, err := yaml.ParseMulti(d.name, []byte(d.text))
+	if err != nil {
+		pos := yaml.Position{}
+		if perr, ok := err.(yaml.ParseError); ok {
+			pos = perr.Position
+		}
+		d.addDiagnostic(pos, SeverityError, lint.RULE_SYNTAX_ERROR, err.Error())
+		return
+	}
+	d.docs = docs
+	for i, doc := range docs {
+		d.index(i, doc, []string{}, 1)
+	}
+
+	findings := lint.Lint(docs, nil, &lint.Options{
+		Registry: opts.Registry,
+		Features: opts.Features,
+		Bindings: opts.Bindings,
+	})
+	for _, f := range findings {
+		if f.File != d.name || f.Rule == lint.RULE_UNRESOLVED_REFERENCE {
+			// unresolved references are reported by the evaluation
+			continue
+		}
+		severity := SeverityWarning
+		if f.Severity == lint.ERROR {
+			severity = SeverityError
+		}
+		d.addDiagnostic(yaml.Position{Line: f.Line, Column: f.Column}, severity, f.Rule, f.Message)
+	}
+	d.evaluate(opts)
+}
+
+// evaluate processes the document with partial evaluation to provide
+// the evaluated values and the unresolved nodes.
+func (d *document) evaluate(opts *Options) {
+	d.results = make([]yaml.Node, len(d.docs))
+	defer func() {
+		if r := recover(); r != nil {
+			d.addDiagnostic(yaml.Position{}, SeverityError, "evaluation", fmt.Sprintf("evaluation failed: %v", r))
+		}
+	}()
+
+	state := flow.NewState(features.EncryptionKey(), opts.Mode).SetRegistry(opts.Registry)
+	if opts.Features != nil {
+		state.SetFeatures(opts.Features)
+	}
+	var binding dynaml.Binding = flow.NewEnvironment(nil, "context", state)
+	if opts.Bindings != nil {
+		binding = binding.WithLocalScope(opts.Bindings)
+	}
+	prepared, err := flow.PrepareStubs(binding, true, append([]yaml.Node{}, opts.Stubs...)...)
+	if err != nil {
+		d.addDiagnostic(yaml.Position{}, SeverityError, "evaluation", fmt.Sprintf("stubs: %s", err))
+		return
+	}
+
+	for i, doc := range d.docs {
+		if doc.Value() == nil {
+			continue
+		}
+		result, err := flow.Apply(binding, doc, prepared, flow.Options{Partial: true, PreserveTemporary: true})
+		d.results[i] = result
+		if err == nil {
+			continue
+		}
+		unresolved, ok := err.(dynaml.UnresolvedNodes)
+		if !ok {
+			d.addDiagnostic(doc.Position(), SeverityError, "evaluation", err.Error())
+			continue
+		}
+		for _, n := range unresolved.Nodes {
+			pos := n.Position()
+			if !pos.IsValid() || n.SourceName() != d.name {
+				if e := d.lookupEntry(i, n.Path); e != nil {
+					pos = yaml.Position{Line: e.line, Column: e.col}
+				}
+			}
+			severity := SeverityWarning
+			if n.HasError() {
+				severity = SeverityError
+			}
+			d.addDiagnostic(pos, severity, "evaluation", issueMessage(n))
+		}
+	}
+}
+
+func issueMessage(n dynaml.UnresolvedNode) string {
+	issue := n.Issue()
+	msg := issue.Issue
+	for _, nested := range issue.Nested {
+		if nested.Issue != "" {
+			msg += "\n" + nested.Issue
+		}
+	}
+	if msg != "" {
+		return msg
+	}
+	if n.Failed() {
+		return "depends on a node with an error"
+	}
+	return "unresolved: dependent of or involved in a cycle"
+}
+
+func (d *document) addDiagnostic(pos yaml.Position, severity int, code, msg string) {
+	d.diagnostics = append(d.diagnostics, Diagnostic{
+		Range:    d.lineRange(pos),
+		Severity: severity,
+		Code:     code,
+		Source:   "spiff",
+		Message:  msg,
+	})
+}
+
+// lineRange provides the range from the given position
+// to the end of its line.
+func (d *document) lineRange(pos yaml.Position) Range {
+	if !pos.IsValid() {
+		return Range{}
+	}
+	start := Position{pos.Line - 1, pos.Column - 1}
+	end := start
+	if start.Line < len(d.lines) {
+		end.Character = len([]rune(strings.TrimRight(d.lines[start.Line], " \t\r")))
+		if end.Character < start.Character {
+			end.Character = start.Character
+		}
+	}
+	return Range{start, end}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// source locations
+
+func (d *document) index(docno int, node yaml.Node, path []string, line int) {
+	if node == nil {
+		return
+	}
+	switch v := node.Value().(type) {
+	case map[string]yaml.Node:
+		for _, k := range yaml.GetOrderedKeys(node) {
+			child := v[k]
+			p := extend(path, k)
+			l, c := d.keyPosition(k, child, line)
+			if k == "<<" || k == yaml.MERGEKEY {
+				d.tag(docno, path, child)
+			} else {
+				d.entries = append(d.entries, entry{docno, p, l, c, child})
+				d.tag(docno, p, child)
+			}
+			d.index(docno, child, p, l)
+		}
+	case []yaml.Node:
+		for i, child := range v {
+			p := extend(path, fmt.Sprintf("[%d]", i))
+			l, c := line, 1
+			if child != nil && child.Position().IsValid() {
+				l, c = child.Position().Line, child.Position().Column
+			}
+			d.entries = append(d.entries, entry{docno, p, l, c, child})
+			d.tag(docno, p, child)
+			d.index(docno, child, p, l)
+		}
+	}
+}
+
+func (d *document) tag(docno int, path []string, node yaml.Node) {
+	if node == nil {
+		return
+	}
+	src := yaml.EmbeddedDynaml(node, false)
+	if src == nil {
+		return
+	}
+	e, err := dynaml.Parse(*src, path, path)
+	if err != nil {
+		return
+	}
+	if m, ok := e.(dynaml.MarkerExpr); ok && m.GetTag() != "" {
+		d.tags = append(d.tags, tagdef{strings.Replace(m.GetTag(), ":", ".", -1), docno, path})
+	}
+}
+
+// keyPosition determines the source location of the key of a map
+// entry. Because keys are no nodes, it is searched in the source
+// lines preceding the value (not before the given start line).
+func (d *document) keyPosition(key string, value yaml.Node, start int) (int, int) {
+	if value == nil || !value.Position().IsValid() {
+		return start, 1
+	}
+	pos := value.Position()
+	for l := pos.Line; l >= start && l >= 1; l-- {
+		if l > len(d.lines) {
+			continue
+		}
+		text := d.lines[l-1]
+		if l == pos.Line && pos.Column-1 <= len(text) {
+			text = text[:pos.Column-1]
+		}
+		if c := keyColumn(text, key); c > 0 {
+			return l, c
+		}
+	}
+	return pos.Line, pos.Column
+}
+
+func keyColumn(text, key string) int {
+	for i := 0; i+len(key) <= len(text); {
+		j := strings.Index(text[i:], key)
+		if j < 0 {
+			return 0
+		}
+		j += i
+		start := j
+		if j > 0 && (text[j-1] == '"' || text[j-1] == '\'') {
+			start = j - 1
+		}
+		rest := strings.TrimLeft(text[j+len(key):], "\"'")
+		if (start == 0 || strings.ContainsRune(" -{,", rune(text[start-1]))) &&
+			strings.HasPrefix(strings.TrimLeft(rest, " "), ":") {
+			return start + 1
+		}
+		i = j + 1
+	}
+	return 0
+}
+
+// locate provides the deepest entry defined on the given (1-based)
+// line or the closest preceding one.
+func (d *document) locate(line int) *entry {
+	var found *entry
+	for i := range d.entries {
+		e := &d.entries[i]
+		if e.line > line {
+			continue
+		}
+		if found == nil || e.line > found.line || (e.line == found.line && len(e.path) > len(found.path)) {
+			found = e
+		}
+	}
+	return found
+}
+
+func (d *document) lookupEntry(docno int, path []string) *entry {
+	for i := range d.entries {
+		e := &d.entries[i]
+		if e.doc == docno && equalPath(e.path, path) {
+			return e
+		}
+	}
+	return nil
+}
+
+func (d *document) location(e *entry) Location {
+	start := Position{e.line - 1, e.col - 1}
+	end := start
+	if len(e.path) > 0 {
+		end.Character += len(e.path[len(e.path)-1])
+	}
+	return Location{URI: d.uri, Range: Range{start, end}}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// references
+
+// referenceAt extracts the reference expression (up to the end of the
+// path step) at the given character of a line.
+func referenceAt(text string, char int) (dynaml.ReferenceExpr, bool) {
+	isRef := func(c byte) bool {
+		return c == '_' || c == '-' || c == '.' || c == ':' || c == '[' || c == ']' ||
+			(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+	}
+	if char < 0 || char > len(text) {
+		return dynaml.ReferenceExpr{}, false
+	}
+	start := char
+	for start > 0 && isRef(text[start-1]) {
+		start--
+	}
+	end := char
+	for end < len(text) && isRef(text[end]) {
+		end++
+	}
+	word := text[start:end]
+	cursor := char - start
+	if i := strings.Index(word, "::"); i < 0 || cursor > i+1 {
+		if j := strings.Index(word[cursor:], "."); j > 0 {
+			word = word[:cursor+j]
+		}
+	}
+	if word == "" {
+		return dynaml.ReferenceExpr{}, false
+	}
+	e, err := dynaml.Parse(word, nil, nil)
+	if err != nil {
+		return dynaml.ReferenceExpr{}, false
+	}
+	ref, ok := e.(dynaml.ReferenceExpr)
+	return ref, ok
+}
+
+// resolve determines the canonical document path of a reference
+// used in an expression at the given node path.
+func (d *document) resolve(docno int, path []string, ref dynaml.ReferenceExpr) []string {
+	if ref.Tag != "" || len(ref.Path) == 0 || docno >= len(d.docs) {
+		return nil
+	}
+	root := d.docs[docno]
+	steps := ref.Path
+	switch steps[0] {
+	case "", yaml.ROOT:
+		return canonicalPath(root, steps[1:])
+	case yaml.SELF, yaml.DOCNODE, "__ctx":
+		return nil
+	}
+	for i := len(path) - 1; i >= 0; i-- {
+		scope := path[:i]
+		node, ok := yaml.FindR(true, root, nil, scope...)
+		if !ok {
+			continue
+		}
+		if m, ok := node.Value().(map[string]yaml.Node); ok {
+			if _, ok := m[steps[0]]; ok {
+				return canonicalPath(root, append(extend(scope), steps...))
+			}
+		}
+	}
+	return nil
+}
+
+// canonicalPath provides the longest resolvable prefix of a path
+// using list indices for list entries.
+func canonicalPath(node yaml.Node, path []string) []string {
+	canonical := []string{}
+	for _, step := range path {
+		if node == nil {
+			break
+		}
+		switch v := node.Value().(type) {
+		case map[string]yaml.Node:
+			next, ok := v[step]
+			if !ok {
+				return canonical
+			}
+			node = next
+			canonical = append(canonical, step)
+		case []yaml.Node:
+			index := listIndex(node, v, step)
+			if index < 0 {
+				return canonical
+			}
+			node = v[index]
+			canonical = append(canonical, fmt.Sprintf("[%d]", index))
+		default:
+			return canonical
+		}
+	}
+	return canonical
+}
+
+func listIndex(node yaml.Node, list []yaml.Node, step string) int {
+	if strings.HasPrefix(step, "[") && strings.HasSuffix(step, "]") {
+		if index, err := strconv.Atoi(step[1 : len(step)-1]); err == nil {
+			if index < 0 {
+				index += len(list)
+			}
+			if index >= 0 && index < len(list) {
+				return index
+			}
+			return -1
+		}
+	}
+	key := node.KeyName()
+	if key == "" {
+		key = "name"
+	}
+	if i := strings.Index(step, ":"); i > 0 {
+		key = step[:i]
+		step = step[i+1:]
+	}
+	for i, e := range list {
+		if e == nil {
+			continue
+		}
+		if name, ok := yaml.FindStringR(true, e, nil, key); ok && name == step {
+			return i
+		}
+	}
+	return -1
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func extend(path []string, steps ...string) []string {
+	return append(path[:len(path):len(path)], steps...)
+}
+
+func equalPath(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
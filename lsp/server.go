@@ -0,0 +1,395 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mandelsoft/spiff/dynaml"
+	"github.com/mandelsoft/spiff/features"
+	"github.com/mandelsoft/spiff/flow"
+	"github.com/mandelsoft/spiff/yaml"
+)
+
+// Options describes the processing environment used to analyse
+// and evaluate the edited documents.
+type Options struct {
+	// Mode is the processing mode (see flow.MODE constants)
+	Mode     int
+	Registry dynaml.Registry
+	Features features.FeatureFlags
+	// Stubs are used to evaluate every edited document
+	Stubs    []yaml.Node
+	Bindings map[string]yaml.Node
+	// Version is reported as server version
+	Version string
+}
+
+// Server is a language server for spiff templates speaking
+// the language server protocol via a pair of streams.
+type Server struct {
+	opts     Options
+	in       *bufio.Reader
+	out      io.Writer
+	docs     map[string]*document
+	shutdown bool
+}
+
+func NewServer(in io.Reader, out io.Writer, opts *Options) *Server {
+	if opts == nil {
+		opts = &Options{Mode: flow.MODE_FILE_ACCESS}
+	}
+	return &Server{
+		opts: *opts,
+		in:   bufio.NewReader(in),
+		out:  out,
+		docs: map[string]*document{},
+	}
+}
+
+// Run handles incoming messages until an exit notification is received
+// or the input stream is closed. An error is returned, if the server is
+// exited without a preceding shutdown request.
+func (s *Server) Run() error {
+	for {
+		msg, err := ReadMessage(s.in)
+		if err != nil {
+			if err == io.EOF {
+				if s.shutdown {
+					return nil
+				}
+				return fmt.Errorf("input closed without shutdown")
+			}
+			if rerr, ok := err.(*ResponseError); ok {
+				if err := s.replyError(nil, rerr.Code, rerr.Message); err != nil {
+					return err
+				}
+				continue
+			}
+			return err
+		}
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return fmt.Errorf("exit without shutdown")
+			}
+			return nil
+		}
+		if err := s.handle(msg); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) handle(msg *Message) error {
+	if msg.Method == "" {
+		// responses to server requests are not used
+		return nil
+	}
+	result, rerr := s.dispatch(msg)
+	if !msg.IsRequest() {
+		return nil
+	}
+	if rerr != nil {
+		return s.replyError(msg.ID, rerr.Code, rerr.Message)
+	}
+	return WriteMessage(s.out, &Response{JSONRPC: "2.0", ID: msg.ID, Result: result})
+}
+
+func (s *Server) replyError(id *json.RawMessage, code int, msg string) error {
+	return WriteMessage(s.out, &ErrorResponse{JSONRPC: "2.0", ID: id, Error: &ResponseError{code, msg}})
+}
+
+func (s *Server) notify(method string, params interface{}) error {
+	return WriteMessage(s.out, &Notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func (s *Server) dispatch(msg *Message) (interface{}, *ResponseError) {
+	switch msg.Method {
+	case "initialize":
+		return s.initialize(), nil
+	case "initialized", "$/cancelRequest", "$/setTrace", "textDocument/didSave":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var params DidOpenTextDocumentParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &ResponseError{InvalidParams, err.Error()}
+		}
+		doc := params.TextDocument
+		return nil, s.update(doc.URI, doc.Version, doc.Text)
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &ResponseError{InvalidParams, err.Error()}
+		}
+		if len(params.ContentChanges) == 0 {
+			return nil, nil
+		}
+		text := params.ContentChanges[len(params.ContentChanges)-1].Text
+		return nil, s.update(params.TextDocument.URI, params.TextDocument.Version, text)
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &ResponseError{InvalidParams, err.Error()}
+		}
+		delete(s.docs, params.TextDocument.URI)
+		if err := s.notify("textDocument/publishDiagnostics", &PublishDiagnosticsParams{
+			URI:         params.TextDocument.URI,
+			Diagnostics: []Diagnostic{},
+		}); err != nil {
+			return nil, &ResponseError{InternalError, err.Error()}
+		}
+		return nil, nil
+
+	case "textDocument/hover":
+		d, params, rerr := s.position(msg)
+		if d == nil {
+			return nil, rerr
+		}
+		return s.hover(d, params.Position), nil
+	case "textDocument/definition":
+		d, params, rerr := s.position(msg)
+		if d == nil {
+			return nil, rerr
+		}
+		return s.definition(d, params.Position), nil
+	case "textDocument/completion":
+		d, params, rerr := s.position(msg)
+		if d == nil {
+			return nil, rerr
+		}
+		return s.completion(d, params.Position), nil
+	}
+	if msg.IsRequest() {
+		return nil, &ResponseError{MethodNotFound, fmt.Sprintf("method %q not supported", msg.Method)}
+	}
+	return nil, nil
+}
+
+func (s *Server) initialize() *InitializeResult {
+	return &InitializeResult{
+		Capabilities: ServerCapabilities{
+			TextDocumentSync: TextDocumentSyncOptions{
+				OpenClose: true,
+				Change:    TextDocumentSyncFull,
+			},
+			HoverProvider:      true,
+			DefinitionProvider: true,
+			CompletionProvider: &CompletionOptions{
+				TriggerCharacters: []string{"(", " "},
+			},
+		},
+		ServerInfo: &ServerInfo{Name: "spiff", Version: s.opts.Version},
+	}
+}
+
+func (s *Server) update(uri string, version int, text string) *ResponseError {
+	d := newDocument(uri, version, text, &s.opts)
+	s.docs[uri] = d
+	err := s.notify("textDocument/publishDiagnostics", &PublishDiagnosticsParams{
+		URI:         uri,
+		Version:     version,
+		Diagnostics: d.diagnostics,
+	})
+	if err != nil {
+		return &ResponseError{InternalError, err.Error()}
+	}
+	return nil
+}
+
+func (s *Server) position(msg *Message) (*document, *TextDocumentPositionParams, *ResponseError) {
+	var params TextDocumentPositionParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return nil, nil, &ResponseError{InvalidParams, err.Error()}
+	}
+	d := s.docs[params.TextDocument.URI]
+	if d == nil {
+		return nil, nil, nil
+	}
+	return d, &params, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func (d *document) lineText(pos Position) string {
+	if pos.Line < 0 || pos.Line >= len(d.lines) {
+		return ""
+	}
+	return d.lines[pos.Line]
+}
+
+// target determines the document and entry addressed by a reference
+// found at the given position.
+func (s *Server) target(d *document, pos Position) (*document, *entry) {
+	e := d.locate(pos.Line + 1)
+	if e == nil {
+		return nil, nil
+	}
+	text := d.lineText(pos)
+	if !inExpression(text, pos.Character) {
+		return nil, nil
+	}
+	ref, ok := referenceAt(text, pos.Character)
+	if !ok {
+		return nil, nil
+	}
+	if ref.Tag != "" {
+		return s.resolveTag(d, e.doc, ref)
+	}
+	path := d.resolve(e.doc, e.path, ref)
+	if len(path) == 0 {
+		return nil, nil
+	}
+	return d, d.lookupEntry(e.doc, path)
+}
+
+func (s *Server) resolveTag(d *document, docno int, ref dynaml.ReferenceExpr) (*document, *entry) {
+	name := strings.Replace(ref.Tag, ":", ".", -1)
+	steps := ref.Path
+	if len(steps) == 1 && steps[0] == "" {
+		steps = nil
+	}
+	if strings.HasPrefix(name, "doc.") {
+		n, err := strconv.Atoi(name[4:])
+		if err != nil {
+			return nil, nil
+		}
+		if n <= 0 {
+			n += docno + 1
+		}
+		if n <= 0 || n > len(d.docs) {
+			return nil, nil
+		}
+		return d, d.entryFor(n-1, canonicalPath(d.docs[n-1], steps))
+	}
+
+	docs := []*document{d}
+	uris := []string{}
+	for uri := range s.docs {
+		if uri != d.uri {
+			uris = append(uris, uri)
+		}
+	}
+	sort.Strings(uris)
+	for _, uri := range uris {
+		docs = append(docs, s.docs[uri])
+	}
+	var found *tagdef
+	var fdoc *document
+	for _, o := range docs {
+		for i := range o.tags {
+			t := &o.tags[i]
+			if t.name == name {
+				found, fdoc = t, o
+				break
+			}
+			if strings.HasPrefix(t.name, name+".") && (found == nil || strings.Count(t.name, ".") < strings.Count(found.name, ".")) {
+				found, fdoc = t, o
+			}
+		}
+		if found != nil && found.name == name {
+			break
+		}
+	}
+	if found == nil {
+		return nil, nil
+	}
+	base, ok := yaml.FindR(true, fdoc.docs[found.doc], nil, found.path...)
+	if !ok {
+		return nil, nil
+	}
+	return fdoc, fdoc.entryFor(found.doc, append(extend(found.path), canonicalPath(base, steps)...))
+}
+
+// entryFor provides the entry for a path, for the document
+// root the first entry is used.
+func (d *document) entryFor(docno int, path []string) *entry {
+	if len(path) == 0 {
+		for i := range d.entries {
+			if d.entries[i].doc == docno {
+				return &entry{docno, nil, d.entries[i].line, 1, d.docs[docno]}
+			}
+		}
+		return nil
+	}
+	return d.lookupEntry(docno, path)
+}
+
+func (s *Server) definition(d *document, pos Position) interface{} {
+	td, e := s.target(d, pos)
+	if e == nil {
+		return nil
+	}
+	return td.location(e)
+}
+
+func (s *Server) hover(d *document, pos Position) interface{} {
+	title := ""
+	td, e := s.target(d, pos)
+	if e != nil {
+		title = "reference"
+	} else {
+		td = d
+		e = d.locate(pos.Line + 1)
+	}
+	if e == nil || e.doc >= len(td.results) || td.results[e.doc] == nil {
+		return nil
+	}
+	path := e.path
+	node, ok := yaml.FindR(true, td.results[e.doc], nil, path...)
+	if !ok {
+		return nil
+	}
+
+	text := ""
+	if title != "" {
+		text = fmt.Sprintf("%s `%s`\n\n", title, strings.Join(path, "."))
+	} else if len(path) > 0 {
+		text = fmt.Sprintf("`%s`\n\n", strings.Join(path, "."))
+	}
+	if e, ok := node.Value().(dynaml.Expression); ok {
+		text += fmt.Sprintf("unresolved: `(( %s ))`", e)
+		if issue := node.Issue().Issue; issue != "" {
+			text += "\n\n" + issue
+		}
+	} else {
+		data, err := yaml.Marshal(node)
+		if err != nil {
+			return nil
+		}
+		text += "```yaml\n" + strings.TrimRight(string(data), "\n") + "\n```"
+	}
+	return &Hover{Contents: MarkupContent{Kind: "markdown", Value: text}}
+}
+
+func (s *Server) completion(d *document, pos Position) interface{} {
+	if !inExpression(d.lineText(pos), pos.Character) {
+		return &CompletionList{Items: []CompletionItem{}}
+	}
+	items := []CompletionItem{}
+	for _, n := range dynaml.BuiltinFunctionNames() {
+		items = append(items, CompletionItem{Label: n, Kind: CompletionKindFunction, Detail: "builtin function"})
+	}
+	for _, n := range dynaml.FunctionNames(s.opts.Registry) {
+		if !dynaml.IsBuiltinFunction(n) {
+			items = append(items, CompletionItem{Label: n, Kind: CompletionKindFunction, Detail: "function"})
+		}
+	}
+	return &CompletionList{Items: items}
+}
+
+// inExpression checks whether a character of a line is
+// part of a dynaml expression.
+func inExpression(text string, char int) bool {
+	if char < len(text) {
+		text = text[:char]
+	}
+	return strings.LastIndex(text, "((") > strings.LastIndex(text, "))")
+}
@@ -0,0 +1,275 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/mandelsoft/spiff/flow"
+)
+
+const uri = "file:///work/template.yml"
+
+type session struct {
+	input bytes.Buffer
+	id    int
+}
+
+func (s *session) request(method string, params interface{}) int {
+	s.id++
+	s.send(map[string]interface{}{"jsonrpc": "2.0", "id": s.id, "method": method, "params": params})
+	return s.id
+}
+
+func (s *session) notify(method string, params interface{}) {
+	s.send(map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params})
+}
+
+func (s *session) send(msg interface{}) {
+	Expect(WriteMessage(&s.input, msg)).To(Succeed())
+}
+
+func (s *session) position(method string, line, char int) int {
+	return s.request(method, map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri},
+		"position":     map[string]interface{}{"line": line, "character": char},
+	})
+}
+
+// run executes the server for the recorded messages and returns
+// the responses by request id and the published diagnostics.
+func (s *session) run() (map[int]json.RawMessage, [][]Diagnostic) {
+	s.request("shutdown", nil)
+	s.notify("exit", nil)
+
+	out := &bytes.Buffer{}
+	Expect(NewServer(&s.input, out, &Options{Mode: flow.MODE_FILE_ACCESS}).Run()).To(Succeed())
+
+	results := map[int]json.RawMessage{}
+	diagnostics := [][]Diagnostic{}
+	r := bufio.NewReader(out)
+	for r.Buffered() > 0 || out.Len() > 0 {
+		msg, err := ReadMessage(r)
+		Expect(err).NotTo(HaveOccurred())
+		if msg.Method == "textDocument/publishDiagnostics" {
+			var params PublishDiagnosticsParams
+			Expect(json.Unmarshal(msg.Params, &params)).To(Succeed())
+			diagnostics = append(diagnostics, params.Diagnostics)
+			continue
+		}
+		var id int
+		Expect(json.Unmarshal(*msg.ID, &id)).To(Succeed())
+		if msg.Error != nil {
+			results[id], _ = json.Marshal(msg.Error.Message)
+		} else {
+			results[id] = msg.Result
+		}
+	}
+	return results, diagnostics
+}
+
+func (s *session) open(text string) {
+	s.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri, "languageId": "yaml", "version": 1, "text": text},
+	})
+}
+
+const template = `
+values:
+  a: 1
+  b: (( a + 1 ))
+list:
+  - name: alice
+    age: (( values.b * 10 ))
+tagged:
+  <<: (( &tag:mytag ))
+  value: alice
+ref: (( mytag::value ))
+err: (( values.missing ))
+`
+
+var _ = Describe("language server", func() {
+	It("initializes", func() {
+		s := &session{}
+		id := s.request("initialize", map[string]interface{}{})
+		results, _ := s.run()
+		var result InitializeResult
+		Expect(json.Unmarshal(results[id], &result)).To(Succeed())
+		Expect(result.Capabilities.HoverProvider).To(BeTrue())
+		Expect(result.Capabilities.DefinitionProvider).To(BeTrue())
+		Expect(result.Capabilities.CompletionProvider).NotTo(BeNil())
+		Expect(result.Capabilities.TextDocumentSync.Change).To(Equal(TextDocumentSyncFull))
+	})
+
+	It("rejects unknown requests", func() {
+		s := &session{}
+		id := s.request("workspace/unknown", nil)
+		results, _ := s.run()
+		Expect(string(results[id])).To(MatchJSON(`"method \"workspace/unknown\" not supported"`))
+	})
+
+	It("fails on exit without shutdown", func() {
+		s := &session{}
+		s.notify("exit", nil)
+		Expect(NewServer(&s.input, &bytes.Buffer{}, nil).Run()).NotTo(Succeed())
+	})
+
+	Context("diagnostics", func() {
+		It("reports evaluation errors", func() {
+			s := &session{}
+			s.open(template)
+			_, diagnostics := s.run()
+			Expect(diagnostics).To(HaveLen(1))
+			Expect(diagnostics[0]).To(HaveLen(1))
+			d := diagnostics[0][0]
+			Expect(d.Severity).To(Equal(SeverityError))
+			Expect(d.Message).To(ContainSubstring("'values.missing' not found"))
+			Expect(d.Range.Start).To(Equal(Position{11, 5}))
+			Expect(d.Range.End).To(Equal(Position{11, 25}))
+		})
+
+		It("reports syntax errors and unknown functions", func() {
+			s := &session{}
+			s.open(`
+a: (( 1 + ))
+b: (( nofunc(1) ))
+`)
+			_, diagnostics := s.run()
+			Expect(diagnostics[0]).To(HaveLen(4))
+			codes := []string{}
+			for _, d := range diagnostics[0] {
+				codes = append(codes, d.Code)
+			}
+			Expect(codes[:2]).To(Equal([]string{"syntax-error", "unknown-function"}))
+			Expect(diagnostics[0][0].Range.Start).To(Equal(Position{1, 3}))
+		})
+
+		It("reports yaml errors", func() {
+			s := &session{}
+			s.open("a: [\n")
+			_, diagnostics := s.run()
+			Expect(diagnostics[0]).To(HaveLen(1))
+			Expect(diagnostics[0][0].Code).To(Equal("syntax-error"))
+		})
+
+		It("updates diagnostics on change and close", func() {
+			s := &session{}
+			s.open(template)
+			s.notify("textDocument/didChange", map[string]interface{}{
+				"textDocument":   map[string]interface{}{"uri": uri, "version": 2},
+				"contentChanges": []interface{}{map[string]interface{}{"text": "a: 1\n"}},
+			})
+			s.notify("textDocument/didClose", map[string]interface{}{
+				"textDocument": map[string]interface{}{"uri": uri},
+			})
+			_, diagnostics := s.run()
+			Expect(diagnostics).To(HaveLen(3))
+			Expect(diagnostics[0]).To(HaveLen(1))
+			Expect(diagnostics[1]).To(BeEmpty())
+			Expect(diagnostics[2]).To(BeEmpty())
+		})
+	})
+
+	Context("hover", func() {
+		It("shows the evaluated value of a node", func() {
+			s := &session{}
+			s.open(template)
+			id := s.position("textDocument/hover", 3, 3)
+			results, _ := s.run()
+			var hover Hover
+			Expect(json.Unmarshal(results[id], &hover)).To(Succeed())
+			Expect(hover.Contents.Value).To(Equal("`values.b`\n\n```yaml\n2\n```"))
+		})
+
+		It("shows the evaluated value of a list entry field", func() {
+			s := &session{}
+			s.open(template)
+			id := s.position("textDocument/hover", 6, 5)
+			results, _ := s.run()
+			var hover Hover
+			Expect(json.Unmarshal(results[id], &hover)).To(Succeed())
+			Expect(hover.Contents.Value).To(Equal("`list.[0].age`\n\n```yaml\n20\n```"))
+		})
+
+		It("shows the value of a referenced node", func() {
+			s := &session{}
+			s.open(template)
+			id := s.position("textDocument/hover", 6, 18)
+			results, _ := s.run()
+			var hover Hover
+			Expect(json.Unmarshal(results[id], &hover)).To(Succeed())
+			Expect(hover.Contents.Value).To(Equal("reference `values.b`\n\n```yaml\n2\n```"))
+		})
+
+		It("shows unresolved expressions", func() {
+			s := &session{}
+			s.open(template)
+			id := s.position("textDocument/hover", 11, 1)
+			results, _ := s.run()
+			var hover Hover
+			Expect(json.Unmarshal(results[id], &hover)).To(Succeed())
+			Expect(hover.Contents.Value).To(HavePrefix("`err`\n\nunresolved: `(( values.missing ))`"))
+		})
+	})
+
+	Context("definition", func() {
+		It("finds referenced nodes", func() {
+			s := &session{}
+			s.open(template)
+			id1 := s.position("textDocument/definition", 3, 9)
+			id2 := s.position("textDocument/definition", 6, 12)
+			id3 := s.position("textDocument/definition", 6, 18)
+			results, _ := s.run()
+			var loc Location
+			Expect(json.Unmarshal(results[id1], &loc)).To(Succeed())
+			Expect(loc).To(Equal(Location{uri, Range{Position{2, 2}, Position{2, 3}}}))
+			Expect(json.Unmarshal(results[id2], &loc)).To(Succeed())
+			Expect(loc).To(Equal(Location{uri, Range{Position{1, 0}, Position{1, 6}}}))
+			Expect(json.Unmarshal(results[id3], &loc)).To(Succeed())
+			Expect(loc).To(Equal(Location{uri, Range{Position{3, 2}, Position{3, 3}}}))
+		})
+
+		It("finds tag references", func() {
+			s := &session{}
+			s.open(template)
+			id := s.position("textDocument/definition", 10, 16)
+			results, _ := s.run()
+			var loc Location
+			Expect(json.Unmarshal(results[id], &loc)).To(Succeed())
+			Expect(loc).To(Equal(Location{uri, Range{Position{9, 2}, Position{9, 7}}}))
+		})
+
+		It("ignores text outside of expressions", func() {
+			s := &session{}
+			s.open(template)
+			id := s.position("textDocument/definition", 2, 2)
+			results, _ := s.run()
+			Expect(string(results[id])).To(Equal("null"))
+		})
+	})
+
+	Context("completion", func() {
+		It("provides function names in expressions", func() {
+			s := &session{}
+			s.open(template)
+			id1 := s.position("textDocument/completion", 3, 8)
+			id2 := s.position("textDocument/completion", 2, 4)
+			results, _ := s.run()
+			var list CompletionList
+			Expect(json.Unmarshal(results[id1], &list)).To(Succeed())
+			labels := map[string]string{}
+			for _, i := range list.Items {
+				labels[i.Label] = i.Detail
+			}
+			Expect(labels).To(HaveKeyWithValue("join", "builtin function"))
+			Expect(labels).To(HaveKeyWithValue("x509genkey", "function"))
+			Expect(labels).To(HaveKeyWithValue("semvermajor", "function"))
+
+			Expect(json.Unmarshal(results[id2], &list)).To(Succeed())
+			Expect(list.Items).To(BeEmpty())
+		})
+	})
+})
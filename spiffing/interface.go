@@ -5,6 +5,7 @@ import (
 
 	"github.com/mandelsoft/spiff/dynaml"
 	"github.com/mandelsoft/spiff/flow"
+	"github.com/mandelsoft/spiff/statestore"
 	"github.com/mandelsoft/spiff/yaml"
 )
 
@@ -34,6 +35,23 @@ type Functions = dynaml.Functions
 // the standard control set
 type Controls = dynaml.Controls
 
+// SecretProvider resolves the references of the secret function
+// for a provider name
+type SecretProvider = dynaml.SecretProvider
+
+// Clock provides the current time for the function now
+type Clock = dynaml.Clock
+
+// FixedClock is a clock always providing the same time
+type FixedClock = dynaml.FixedClock
+
+// StateStore provides locked access to a persisted state document
+type StateStore = statestore.StateStore
+
+// Formatter serializes documents in an output format. Formatters
+// are registered with yaml.RegisterFormatter
+type Formatter = yaml.Formatter
+
 // Spiff is a configuration and execution context for
 // executing spiff operations
 type Spiff interface {
@@ -55,6 +73,17 @@ type Spiff interface {
 	// additional function definitions
 	WithControls(controls Controls) Spiff
 
+	// WithSecretProvider creates a new context with the given
+	// secret provider used by the secret function for the given
+	// provider name. It replaces a built-in provider with the same
+	// name. A nil provider removes a previously set provider.
+	WithSecretProvider(name string, p SecretProvider) Spiff
+
+	// WithClock creates a new context with the given clock
+	// used by the function now. A FixedClock keeps the
+	// processing reproducible. A nil clock uses the system time.
+	WithClock(c Clock) Spiff
+
 	// WithFeatures creates a new context with the given
 	// additional features enabled
 	WithFeatures(features ...string) Spiff
@@ -65,6 +94,14 @@ type Spiff interface {
 	// feature enabled/disabled
 	WithControl(b bool) Spiff
 
+	// WithOptions creates a new context with the given
+	// processing options.
+	WithOptions(opts Options) Spiff
+	// WithOutputFormat creates a new context using the
+	// formatter registered for the given output format
+	// (for example toml or hcl-json) for Marshal.
+	WithOutputFormat(format string) (Spiff, error)
+
 	// WithValues creates a new context with the given
 	// additional structured values usable by path expressions
 	// during processing.
@@ -106,7 +143,9 @@ type Spiff interface {
 	// returns the list of documents in the internal representation
 	UnmarshalMultiSource(source Source) ([]Node, error)
 	// Marshal transform the internal node representation into a
-	// yaml representation
+	// yaml representation or the representation of the output format
+	// selected with WithOutputFormat. If the option KeepOrder is set,
+	// the original key order of maps is kept.
 	Marshal(node Node) ([]byte, error)
 	// DetermineState extracts the intended new state representation from
 	// a processing result.
@@ -69,6 +69,9 @@ type spiff struct {
 	registry dynaml.Registry
 	tags     map[string]*dynaml.Tag
 	features features.FeatureFlags
+	secrets  map[string]dynaml.SecretProvider
+	clock    dynaml.Clock
+	format   string
 
 	binding dynaml.Binding
 }
@@ -119,7 +122,11 @@ func (s *spiff) assureBinding() {
 	if s.binding == nil {
 		state := flow.NewState(s.key, s.mode, s.fs).
 			SetRegistry(s.registry).
-			SetFeatures(s.features)
+			SetFeatures(s.features).
+			SetClock(s.clock)
+		for n, p := range s.secrets {
+			state.SetSecretProvider(n, p)
+		}
 		if len(s.tags) > 0 {
 			var tags []*dynaml.Tag
 			for _, t := range s.tags {
@@ -201,6 +208,47 @@ func (s spiff) WithControls(controls Controls) Spiff {
 	return s.Reset()
 }
 
+// WithSecretProvider creates a new context with the given
+// secret provider used by the secret function
+func (s spiff) WithSecretProvider(name string, p SecretProvider) Spiff {
+	secrets := map[string]dynaml.SecretProvider{}
+	for n, e := range s.secrets {
+		secrets[n] = e
+	}
+	if p != nil {
+		secrets[name] = p
+	} else {
+		delete(secrets, name)
+	}
+	s.secrets = secrets
+	return s.Reset()
+}
+
+// WithClock creates a new context with the given
+// clock used by the function now
+func (s spiff) WithClock(c Clock) Spiff {
+	s.clock = c
+	return s.Reset()
+}
+
+// WithOptions creates a new context with the given
+// processing options.
+func (s spiff) WithOptions(opts Options) Spiff {
+	s.opts = opts
+	return s.Reset()
+}
+
+// WithOutputFormat creates a new context using the
+// formatter registered for the given output format
+// for Marshal.
+func (s spiff) WithOutputFormat(format string) (Spiff, error) {
+	if _, err := yaml.LookupFormatter(format); err != nil {
+		return nil, err
+	}
+	s.format = format
+	return s.Reset(), nil
+}
+
 // WithValues creates a new context with the given
 // additional structured values usable by path expressions
 // during processing.
@@ -259,7 +307,7 @@ func (s *spiff) Cascade(template Node, stubs []Node, states ...Node) (Node, erro
 func (s *spiff) PrepareStubs(stubs ...Node) ([]Node, error) {
 	s.Reset()
 	s.assureBinding()
-	return flow.PrepareStubs(s.binding, s.opts.Partial, stubs...)
+	return flow.PrepareStubsWithOptions(s.binding, s.opts, stubs...)
 }
 
 // ApplyStubs uses already prepared subs to process a template.
@@ -311,9 +359,18 @@ func (s *spiff) DetermineState(node Node) Node {
 }
 
 // Marshal transform the internal node representation into a
-// yaml representation
+// yaml representation or the representation of the selected
+// output format
 func (s *spiff) Marshal(node Node) ([]byte, error) {
-	return yaml.Marshal(node)
+	format := s.format
+	if format == "" {
+		format = yaml.FORMAT_YAML
+	}
+	f, err := yaml.LookupFormatter(format)
+	if err != nil {
+		return nil, err
+	}
+	return f.Marshal(node, yaml.MarshalOptions{KeepOrder: s.opts.KeepOrder})
 }
 
 // Normalize transform the node representation to a regular go value representation
@@ -1,8 +1,15 @@
 package spiffing
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+
+	"github.com/mandelsoft/spiff/dynaml/secrets"
+	"github.com/mandelsoft/spiff/statestore"
 )
 
 var _ = Describe("Spiffing", func() {
@@ -79,4 +86,179 @@ values: other
 `))
 		})
 	})
+
+	Context("Key order", func() {
+		ctx := New().WithOptions(Options{KeepOrder: true})
+
+		It("keeps the original key order", func() {
+			templ, err := ctx.Unmarshal("test", []byte(`
+zeta: 1
+alpha:
+  <<: (( merge ))
+  x: (( zeta ))
+  b: 2
+ref: (( alpha ))
+temp: (( &temporary(1) ))
+`))
+			Expect(err).To(Succeed())
+			stub, err := ctx.Unmarshal("stub", []byte(`
+alpha:
+  q: 1
+  c: 3
+`))
+			Expect(err).To(Succeed())
+			result, err := ctx.Cascade(templ, []Node{stub})
+			Expect(err).To(Succeed())
+			data, err := ctx.Marshal(result)
+			Expect(err).To(Succeed())
+			Expect(string(data)).To(Equal(
+				`zeta: 1
+alpha:
+  q: 1
+  c: 3
+  x: 1
+  b: 2
+ref:
+  q: 1
+  c: 3
+  x: 1
+  b: 2
+`))
+		})
+
+		It("sorts keys by default", func() {
+			templ, err := New().Unmarshal("test", []byte(`
+zeta: 1
+alpha: 2
+`))
+			Expect(err).To(Succeed())
+			data, err := New().Marshal(templ)
+			Expect(err).To(Succeed())
+			Expect(string(data)).To(Equal("alpha: 2\nzeta: 1\n"))
+		})
+	})
+
+	Context("Secrets", func() {
+		var server *httptest.Server
+
+		BeforeEach(func() {
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				switch {
+				case r.Header.Get("X-Vault-Token") != "token":
+					w.WriteHeader(http.StatusForbidden)
+					w.Write([]byte(`{"errors":["permission denied"]}`))
+				case r.URL.Path == "/v1/secret/data/db/prod":
+					w.Write([]byte(`{"data":{"data":{"user":"admin","password":"geheim"},"metadata":{"version":3}}}`))
+				case r.URL.Path == "/v1/kv/data/token":
+					w.Write([]byte(`{"data":{"data":{"value":"abc"},"metadata":{"version":1}}}`))
+				default:
+					w.WriteHeader(http.StatusNotFound)
+					w.Write([]byte(`{"errors":[]}`))
+				}
+			}))
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("resolves secrets from a vault server", func() {
+			ctx := Plain().WithSecretProvider("vault", secrets.NewVaultProvider(server.URL, "token"))
+			templ, err := ctx.Unmarshal("test", []byte(`
+user: (( secret("vault", "secret/db/prod#user") ))
+password: (( secret("vault", "secret/db/prod#password") ))
+token: (( secret("vault", "kv/token") ))
+`))
+			Expect(err).To(Succeed())
+			result, err := ctx.Cascade(templ, nil)
+			Expect(err).To(Succeed())
+			data, err := ctx.Marshal(result)
+			Expect(err).To(Succeed())
+			Expect(string(data)).To(Equal("password: geheim\ntoken: abc\nuser: admin\n"))
+		})
+
+		It("reports vault errors", func() {
+			ctx := Plain().WithSecretProvider("vault", secrets.NewVaultProvider(server.URL, "other"))
+			templ, err := ctx.Unmarshal("test", []byte(`
+user: (( secret("vault", "secret/db/prod#user") ))
+`))
+			Expect(err).To(Succeed())
+			_, err = ctx.Cascade(templ, nil)
+			Expect(err).NotTo(Succeed())
+			Expect(err.Error()).To(ContainSubstring("403 Forbidden: permission denied"))
+
+			ctx = Plain().WithSecretProvider("vault", secrets.NewVaultProvider(server.URL, "token"))
+			_, err = EvaluateDynamlExpression(ctx, `secret("vault", "secret/db/prod")`)
+			Expect(err).NotTo(Succeed())
+			Expect(err.Error()).To(ContainSubstring("key required to select one of [password, user]"))
+		})
+	})
+
+	Context("State stores", func() {
+		var server *httptest.Server
+		var fake *statestore.MemoryServer
+
+		BeforeEach(func() {
+			fake = statestore.NewMemoryServer()
+			server = httptest.NewServer(fake)
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("keeps the state in a state store", func() {
+			store, err := NewStateStore(server.URL + "/state.yaml")
+			Expect(err).To(Succeed())
+			template := NewSourceData("template", []byte(`
+seed: (( ~~ ))
+value: (( &state(seed) ))
+`))
+			ctx := Plain()
+			stub := NewSourceData("stub", []byte("seed: first\n"))
+			result, err := CascadeWithState(ctx, template, []Source{stub}, store)
+			Expect(err).To(Succeed())
+			Expect(string(result)).To(Equal("seed: first\nvalue: first\n"))
+
+			stub = NewSourceData("stub", []byte("seed: second\n"))
+			result, err = CascadeWithState(ctx, template, []Source{stub}, store)
+			Expect(err).To(Succeed())
+			Expect(string(result)).To(Equal("seed: second\nvalue: first\n"))
+
+			state, ok := fake.Object("/state.yaml")
+			Expect(ok).To(BeTrue())
+			Expect(string(state)).To(Equal("value: first\n"))
+			_, ok = fake.Object("/state.yaml.lock")
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Context("Clock", func() {
+		It("uses a fixed clock", func() {
+			ctx := Plain().WithClock(FixedClock(time.Date(2024, 2, 29, 10, 0, 0, 0, time.UTC)))
+			result, err := EvaluateDynamlExpression(ctx, `formattime(addduration(now(), "1d"), "DateOnly")`)
+			Expect(err).To(Succeed())
+			Expect(string(result)).To(Equal(`"2024-03-01"`))
+		})
+	})
+
+	Context("Output formats", func() {
+		It("marshals toml", func() {
+			ctx, err := Plain().WithOutputFormat("toml")
+			Expect(err).To(Succeed())
+			templ, err := ctx.Unmarshal("test", []byte("port: (( 8000 + 80 ))\ndb:\n  host: db\n"))
+			Expect(err).To(Succeed())
+			result, err := ctx.Cascade(templ, nil)
+			Expect(err).To(Succeed())
+			data, err := ctx.Marshal(result)
+			Expect(err).To(Succeed())
+			Expect(string(data)).To(Equal("port = 8080\n\n[db]\n  host = \"db\"\n"))
+		})
+
+		It("rejects unknown formats", func() {
+			_, err := Plain().WithOutputFormat("xml")
+			Expect(err).To(HaveOccurred())
+		})
+	})
 })
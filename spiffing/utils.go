@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/mandelsoft/spiff/statestore"
 	"github.com/mandelsoft/spiff/yaml"
 )
 
@@ -85,6 +86,41 @@ func Cascade(s Spiff, template Source, stubs []Source, optstate ...Source) ([]by
 	return rdata, nil, err
 }
 
+// NewStateStore provides a state store for a file path or URL.
+func NewStateStore(location string) (StateStore, error) {
+	return statestore.New(location)
+}
+
+// CascadeWithState processes a template source with a list of stub sources
+// and the state kept in a state store. The state store is locked during the
+// processing and the new state is written to the store. It delivers the
+// cascading result as yaml data.
+func CascadeWithState(s Spiff, template Source, stubs []Source, store StateStore) ([]byte, error) {
+	if err := store.Lock(statestore.DefaultLockTimeout); err != nil {
+		return nil, fmt.Errorf("cannot lock state [%s]: %s", store.Name(), err)
+	}
+	defer store.Unlock()
+
+	data, err := store.Read()
+	if err != nil {
+		return nil, err
+	}
+	var state []Source
+	if data != nil {
+		state = append(state, NewSourceData(store.Name(), data))
+	}
+	result, sdata, err := Cascade(s, template, stubs, state...)
+	if err != nil {
+		return nil, err
+	}
+	if sdata != nil {
+		if err := store.Write(sdata); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
 func ToNode(name string, data interface{}) (Node, error) {
 	return yaml.Sanitize(name, data)
 }
@@ -0,0 +1,137 @@
+package statestore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileStore keeps the state in a local file. The lock is an advisory
+// lock on the file <path>.lock (on Windows the exclusively created file
+// <path>.lock, which is considered stale after the lock expiry), the
+// state is replaced atomically by renaming a temporary file. The previous
+// state is kept in the file <path>.bak.
+type FileStore struct {
+	lock   sync.Mutex
+	path   string
+	file   *os.File
+	expiry time.Duration
+}
+
+var _ StateStore = &FileStore{}
+
+// NewFileStore provides a store for the given state file.
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+func (s *FileStore) Name() string {
+	return s.path
+}
+
+// WithLockExpiry provides a store considering locks older than the
+// given expiry as stale. The default is DefaultLockExpiry.
+func (s *FileStore) WithLockExpiry(expiry time.Duration) *FileStore {
+	return &FileStore{path: s.path, expiry: expiry}
+}
+
+// Path provides the path of the state file.
+func (s *FileStore) Path() string {
+	return s.path
+}
+
+func (s *FileStore) Lock(timeout time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.file != nil {
+		return fmt.Errorf("state %q already locked", s.path)
+	}
+	var f *os.File
+	err := waitFor(timeout, func() (err error) {
+		f, err = acquireLock(s.path+".lock", s.expiry)
+		return err
+	})
+	if err != nil {
+		if err == ErrLocked {
+			return err
+		}
+		return fmt.Errorf("cannot lock state %q: %s", s.path, err)
+	}
+	s.file = f
+	return nil
+}
+
+func (s *FileStore) Unlock() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := releaseLock(s.file)
+	s.file = nil
+	return err
+}
+
+// ForceUnlock removes the lock file. A processing still holding an
+// advisory lock keeps it for the removed file, so it must only be used
+// if no other processing is running.
+func (s *FileStore) ForceUnlock() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.file != nil {
+		releaseLock(s.file)
+		s.file = nil
+	}
+	err := os.Remove(s.path + ".lock")
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot unlock state %q: %s", s.path, err)
+	}
+	return nil
+}
+
+func (s *FileStore) Read() ([]byte, error) {
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (s *FileStore) Write(data []byte) error {
+	dir, base := filepath.Split(s.path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := ioutil.TempFile(dir, "."+base+".*")
+	if err != nil {
+		return fmt.Errorf("cannot write state file %q: %s", s.path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0664)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot write state file %q: %s", s.path, err)
+	}
+
+	if old, err := ioutil.ReadFile(s.path); err == nil {
+		if err := ioutil.WriteFile(s.path+".bak", old, 0664); err != nil {
+			return fmt.Errorf("cannot backup state file %q: %s", s.path, err)
+		}
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("cannot write state file %q: %s", s.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package statestore
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// HTTPStore keeps the state as object of an HTTP server supporting
+// entity tags and conditional requests, like S3 compatible object
+// stores (accessed with pre-signed or public URLs) or simple WebDAV
+// servers. The lock is the object <url>.lock containing the lock info,
+// which is created with If-None-Match: * and deleted by Unlock. A lock
+// older than the lock expiry is considered stale and deleted (with
+// If-Match for its entity tag) by the next Lock. The state is written with
+// If-Match for the entity tag of the last Read, therefore concurrent
+// modifications are detected even without locking.
+type HTTPStore struct {
+	lock   sync.Mutex
+	url    string
+	client *http.Client
+	header http.Header
+	expiry time.Duration
+	etag   string
+	read   bool
+	locked bool
+}
+
+var _ StateStore = &HTTPStore{}
+
+// NewHTTPStore provides a store for the given URL. If the environment
+// variable SPIFF_STATE_TOKEN is set, it is used as bearer token.
+func NewHTTPStore(url string) *HTTPStore {
+	header := http.Header{}
+	if token := os.Getenv("SPIFF_STATE_TOKEN"); token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	return &HTTPStore{
+		url:    url,
+		client: &http.Client{Timeout: 30 * time.Second},
+		header: header,
+	}
+}
+
+// WithClient provides a store using the given HTTP client.
+func (s *HTTPStore) WithClient(c *http.Client) *HTTPStore {
+	return &HTTPStore{url: s.url, client: c, header: s.header.Clone(), expiry: s.expiry}
+}
+
+// WithHeader provides a store sending an additional header with
+// every request.
+func (s *HTTPStore) WithHeader(name, value string) *HTTPStore {
+	n := &HTTPStore{url: s.url, client: s.client, header: s.header.Clone(), expiry: s.expiry}
+	n.header.Set(name, value)
+	return n
+}
+
+// WithLockExpiry provides a store considering locks older than the
+// given expiry as stale. The default is DefaultLockExpiry.
+func (s *HTTPStore) WithLockExpiry(expiry time.Duration) *HTTPStore {
+	return &HTTPStore{url: s.url, client: s.client, header: s.header.Clone(), expiry: expiry}
+}
+
+func (s *HTTPStore) Name() string {
+	return s.url
+}
+
+func (s *HTTPStore) Lock(timeout time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.locked {
+		return fmt.Errorf("state %q already locked", s.url)
+	}
+	err := waitFor(timeout, func() error {
+		err := s.createLock()
+		if err == ErrLocked && s.removeStaleLock() {
+			err = s.createLock()
+		}
+		return err
+	})
+	if err != nil {
+		if err == ErrLocked {
+			return err
+		}
+		return fmt.Errorf("cannot lock state %q: %s", s.url, err)
+	}
+	s.locked = true
+	return nil
+}
+
+func (s *HTTPStore) Unlock() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.locked {
+		return nil
+	}
+	s.locked = false
+	resp, err := s.do(http.MethodDelete, s.url+".lock", nil)
+	if err != nil {
+		return fmt.Errorf("cannot unlock state %q: %s", s.url, err)
+	}
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("cannot unlock state %q: %s", s.url, resp.Status)
+	}
+	return nil
+}
+
+func (s *HTTPStore) createLock() error {
+	resp, err := s.do(http.MethodPut, s.url+".lock", []byte(newLockInfo().String()), "If-None-Match", "*")
+	if err != nil {
+		return err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return nil
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		return ErrLocked
+	default:
+		return fmt.Errorf("%s", resp.Status)
+	}
+}
+
+// removeStaleLock deletes the lock object, if it is older than the
+// lock expiry. The deletion is conditional, so a lock replaced in the
+// meantime is kept.
+func (s *HTTPStore) removeStaleLock() bool {
+	resp, err := s.do(http.MethodGet, s.url+".lock", nil)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return false
+	}
+	info, err := ParseLockInfo(resp.data)
+	if err != nil || !info.Stale(s.expiry) {
+		return false
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		return false
+	}
+	resp, err = s.do(http.MethodDelete, s.url+".lock", nil, "If-Match", etag)
+	return err == nil && resp.StatusCode/100 == 2
+}
+
+func (s *HTTPStore) ForceUnlock() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.locked = false
+	resp, err := s.do(http.MethodDelete, s.url+".lock", nil)
+	if err != nil {
+		return fmt.Errorf("cannot unlock state %q: %s", s.url, err)
+	}
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("cannot unlock state %q: %s", s.url, resp.Status)
+	}
+	return nil
+}
+
+func (s *HTTPStore) Read() ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	resp, err := s.do(http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read state %q: %s", s.url, err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		s.etag = resp.Header.Get("ETag")
+		s.read = true
+		return resp.data, nil
+	case http.StatusNotFound:
+		s.etag = ""
+		s.read = true
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("cannot read state %q: %s", s.url, resp.Status)
+	}
+}
+
+func (s *HTTPStore) Write(data []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var cond []string
+	if s.read {
+		if s.etag != "" {
+			cond = []string{"If-Match", s.etag}
+		} else {
+			cond = []string{"If-None-Match", "*"}
+		}
+	}
+	resp, err := s.do(http.MethodPut, s.url, data, cond...)
+	if err != nil {
+		return fmt.Errorf("cannot write state %q: %s", s.url, err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		s.etag = resp.Header.Get("ETag")
+		s.read = s.etag != ""
+		return nil
+	case http.StatusPreconditionFailed:
+		return ErrConflict
+	default:
+		return fmt.Errorf("cannot write state %q: %s", s.url, resp.Status)
+	}
+}
+
+type response struct {
+	*http.Response
+	data []byte
+}
+
+// do executes a request with optional header name/value pairs and
+// reads the response body.
+func (s *HTTPStore) do(method, url string, body []byte, header ...string) (*response, error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range s.header {
+		req.Header[k] = v
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &response{resp, data}, nil
+}
@@ -0,0 +1,41 @@
+//go:build !windows
+// +build !windows
+
+package statestore
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// acquireLock acquires an advisory lock for the given lock file. The lock
+// is released by the operating system if the process terminates, so it
+// never gets stale. The lock info is written for diagnostic purposes.
+func acquireLock(path string, expiry time.Duration) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0664)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, ErrLocked
+		}
+		return nil, err
+	}
+	if err = f.Truncate(0); err == nil {
+		_, err = f.WriteAt([]byte(newLockInfo().String()), 0)
+	}
+	if err != nil {
+		releaseLock(f)
+		return nil, err
+	}
+	return f, nil
+}
+
+func releaseLock(f *os.File) error {
+	defer f.Close()
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,59 @@
+package statestore
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// acquireLock acquires a lock by exclusively creating the given lock file
+// containing the lock info. A lock file is left behind by a terminated
+// process, therefore it is removed if it is older than the given expiry.
+func acquireLock(path string, expiry time.Duration) (*os.File, error) {
+	f, err := createLock(path)
+	if err == ErrLocked && removeStaleLock(path, expiry) {
+		f, err = createLock(path)
+	}
+	return f, err
+}
+
+func createLock(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0664)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, ErrLocked
+		}
+		return nil, err
+	}
+	if _, err := f.Write([]byte(newLockInfo().String())); err != nil {
+		releaseLock(f)
+		return nil, err
+	}
+	return f, nil
+}
+
+// removeStaleLock removes a lock file older than the given expiry.
+// Lock files without valid lock info are aged by their modification time.
+func removeStaleLock(path string, expiry time.Duration) bool {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	info, err := ParseLockInfo(data)
+	if err != nil {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return false
+		}
+		info.Time = fi.ModTime()
+	}
+	if !info.Stale(expiry) {
+		return false
+	}
+	return os.Remove(path) == nil
+}
+
+func releaseLock(f *os.File) error {
+	f.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,91 @@
+package statestore
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// MemoryServer is an HTTP handler keeping objects in memory. It
+// supports entity tags and the conditional requests used by the
+// HTTPStore and can be used as local fake for an object store.
+type MemoryServer struct {
+	lock    sync.Mutex
+	objects map[string][]byte
+}
+
+var _ http.Handler = &MemoryServer{}
+
+func NewMemoryServer() *MemoryServer {
+	return &MemoryServer{objects: map[string][]byte{}}
+}
+
+// Object provides the content of the object with the given path.
+func (m *MemoryServer) Object(path string) ([]byte, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	data, ok := m.objects[path]
+	return data, ok
+}
+
+func (m *MemoryServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	path := r.URL.Path
+	data, exists := m.objects[path]
+	etag := ""
+	if exists {
+		etag = entityTag(data)
+	}
+	if match := r.Header.Get("If-Match"); match != "" && (!exists || (match != "*" && match != etag)) {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	if match := r.Header.Get("If-None-Match"); match != "" && exists && (match == "*" || match == etag) {
+		if r.Method == http.MethodGet {
+			w.WriteHeader(http.StatusNotModified)
+		} else {
+			w.WriteHeader(http.StatusPreconditionFailed)
+		}
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write(data)
+	case http.MethodPut:
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		m.objects[path] = body
+		w.Header().Set("ETag", entityTag(body))
+		if exists {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusCreated)
+		}
+	case http.MethodDelete:
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(m.objects, path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func entityTag(data []byte) string {
+	return fmt.Sprintf("%q", fmt.Sprintf("%x", sha256.Sum256(data)))
+}
@@ -0,0 +1,143 @@
+// Package statestore provides storage backends for the state documents
+// maintained by spiff (option --state). A store serializes concurrent
+// processings of the same state by a lock and replaces the state
+// atomically.
+package statestore
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultLockTimeout is the default time to wait for the lock of a state.
+const DefaultLockTimeout = time.Minute
+
+// DefaultLockExpiry is the default age after which a lock is considered
+// stale. Such locks are left behind by processings terminated without
+// releasing their lock and are removed by the next processing.
+const DefaultLockExpiry = time.Hour
+
+// ErrLocked is returned if a state is locked by another processing.
+var ErrLocked = errors.New("state is locked by another processing")
+
+// ErrConflict is returned if a state has been modified since it has been read.
+var ErrConflict = errors.New("state has been modified concurrently")
+
+// StateStore provides access to a persisted state document.
+type StateStore interface {
+	// Name provides a printable name of the store.
+	Name() string
+	// Lock acquires exclusive access to the state. It waits for the lock
+	// up to the given timeout and returns ErrLocked if it cannot be
+	// acquired.
+	Lock(timeout time.Duration) error
+	// Unlock releases a previously acquired lock.
+	Unlock() error
+	// ForceUnlock removes the lock of the state regardless of the
+	// processing holding it.
+	ForceUnlock() error
+	// Read provides the actual state. It is nil if there is no state, yet.
+	Read() ([]byte, error)
+	// Write atomically replaces the state. Stores detecting modifications
+	// since the last Read return ErrConflict.
+	Write(data []byte) error
+}
+
+// New provides a store for a state location. http and https URLs
+// are handled by an HTTPStore, file URLs and plain paths by a FileStore.
+func New(location string) (StateStore, error) {
+	if !strings.Contains(location, "://") {
+		return NewFileStore(location), nil
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid state location %q: %s", location, err)
+	}
+	switch u.Scheme {
+	case "file":
+		if u.Host != "" && u.Host != "localhost" {
+			return nil, fmt.Errorf("invalid state location %q: remote file host not supported", location)
+		}
+		return NewFileStore(u.Path), nil
+	case "http", "https":
+		return NewHTTPStore(location), nil
+	default:
+		return nil, fmt.Errorf("invalid state location %q: unsupported scheme %q", location, u.Scheme)
+	}
+}
+
+// waitFor calls try until it succeeds, fails with an error other than
+// ErrLocked or the timeout is reached.
+func waitFor(timeout time.Duration, try func() error) error {
+	deadline := time.Now().Add(timeout)
+	delay := 50 * time.Millisecond
+	for {
+		err := try()
+		if err != ErrLocked || !time.Now().Before(deadline) {
+			return err
+		}
+		time.Sleep(delay)
+		if delay < time.Second {
+			delay *= 2
+		}
+	}
+}
+
+// LockInfo describes the processing holding a lock. It is stored as
+// content of the lock.
+type LockInfo struct {
+	Host string
+	PID  int
+	Time time.Time
+}
+
+func newLockInfo() LockInfo {
+	host, _ := os.Hostname()
+	return LockInfo{Host: host, PID: os.Getpid(), Time: time.Now().UTC().Truncate(time.Second)}
+}
+
+// ParseLockInfo parses the content of a lock.
+func ParseLockInfo(data []byte) (LockInfo, error) {
+	info := LockInfo{}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.SplitN(line, ":", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(fields[1])
+		var err error
+		switch strings.TrimSpace(fields[0]) {
+		case "host":
+			info.Host = value
+		case "pid":
+			info.PID, err = strconv.Atoi(value)
+		case "time":
+			info.Time, err = time.Parse(time.RFC3339, value)
+		}
+		if err != nil {
+			return info, fmt.Errorf("invalid lock info: %s", err)
+		}
+	}
+	if info.Time.IsZero() {
+		return info, fmt.Errorf("invalid lock info: time missing")
+	}
+	return info, nil
+}
+
+func (i LockInfo) String() string {
+	return fmt.Sprintf("host: %s\npid: %d\ntime: %s\n", i.Host, i.PID, i.Time.Format(time.RFC3339))
+}
+
+// Stale checks whether a lock is older than the given expiry.
+// A zero expiry uses DefaultLockExpiry.
+func (i LockInfo) Stale(expiry time.Duration) bool {
+	if expiry <= 0 {
+		expiry = DefaultLockExpiry
+	}
+	return time.Since(i.Time) > expiry
+}
@@ -0,0 +1,206 @@
+package statestore
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("state stores", func() {
+	Context("locations", func() {
+		It("uses file stores for paths", func() {
+			s, err := New("dir/state.yaml")
+			Expect(err).To(BeNil())
+			Expect(s).To(Equal(NewFileStore("dir/state.yaml")))
+			s, err = New("file:///dir/state.yaml")
+			Expect(err).To(BeNil())
+			Expect(s).To(Equal(NewFileStore("/dir/state.yaml")))
+		})
+		It("uses http stores for http urls", func() {
+			s, err := New("https://example.com/bucket/state.yaml")
+			Expect(err).To(BeNil())
+			Expect(s.Name()).To(Equal("https://example.com/bucket/state.yaml"))
+			Expect(s).To(BeAssignableToTypeOf(&HTTPStore{}))
+		})
+		It("rejects unknown schemes", func() {
+			_, err := New("ftp://example.com/state.yaml")
+			Expect(err).To(MatchError(`invalid state location "ftp://example.com/state.yaml": unsupported scheme "ftp"`))
+		})
+	})
+
+	Context("lock info", func() {
+		It("parses the lock info", func() {
+			info := newLockInfo()
+			parsed, err := ParseLockInfo([]byte(info.String()))
+			Expect(err).To(BeNil())
+			Expect(parsed).To(Equal(info))
+			Expect(parsed.Stale(0)).To(BeFalse())
+			Expect(parsed.Stale(-time.Second)).To(BeFalse())
+		})
+		It("detects stale locks", func() {
+			info := LockInfo{Host: "host", PID: 1, Time: time.Now().Add(-2 * time.Hour)}
+			Expect(info.Stale(0)).To(BeTrue())
+			Expect(info.Stale(3 * time.Hour)).To(BeFalse())
+		})
+	})
+
+	Context("file store", func() {
+		var dir string
+		var path string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "spiff-state-")
+			Expect(err).To(BeNil())
+			path = filepath.Join(dir, "state.yaml")
+		})
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("reads no state for a missing file", func() {
+			data, err := NewFileStore(path).Read()
+			Expect(err).To(BeNil())
+			Expect(data).To(BeNil())
+		})
+
+		It("replaces the state and keeps a backup", func() {
+			s := NewFileStore(path)
+			Expect(s.Write([]byte("first: 1\n"))).To(Succeed())
+			Expect(s.Write([]byte("second: 2\n"))).To(Succeed())
+			Expect(s.Read()).To(Equal([]byte("second: 2\n")))
+			Expect(ioutil.ReadFile(path + ".bak")).To(Equal([]byte("first: 1\n")))
+
+			files, err := ioutil.ReadDir(dir)
+			Expect(err).To(BeNil())
+			names := []string{}
+			for _, f := range files {
+				names = append(names, f.Name())
+			}
+			Expect(names).To(ConsistOf("state.yaml", "state.yaml.bak"))
+		})
+
+		It("serializes access by a lock", func() {
+			s1 := NewFileStore(path)
+			s2 := NewFileStore(path)
+			Expect(s1.Lock(0)).To(Succeed())
+			Expect(s2.Lock(100 * time.Millisecond)).To(Equal(ErrLocked))
+			Expect(s1.Unlock()).To(Succeed())
+			Expect(s2.Lock(0)).To(Succeed())
+			Expect(s2.Unlock()).To(Succeed())
+		})
+
+		It("records the lock info", func() {
+			s := NewFileStore(path)
+			Expect(s.Lock(0)).To(Succeed())
+			defer s.Unlock()
+			data, err := ioutil.ReadFile(path + ".lock")
+			Expect(err).To(BeNil())
+			info, err := ParseLockInfo(data)
+			Expect(err).To(BeNil())
+			Expect(info.PID).To(Equal(os.Getpid()))
+		})
+
+		It("removes locks forcibly", func() {
+			s := NewFileStore(path)
+			Expect(s.Lock(0)).To(Succeed())
+			Expect(NewFileStore(path).ForceUnlock()).To(Succeed())
+			Expect(path + ".lock").NotTo(BeAnExistingFile())
+			Expect(s.Unlock()).To(Succeed())
+		})
+	})
+
+	Context("http store", func() {
+		var fake *MemoryServer
+		var server *httptest.Server
+		var url string
+
+		BeforeEach(func() {
+			fake = NewMemoryServer()
+			server = httptest.NewServer(fake)
+			url = server.URL + "/bucket/state.yaml"
+		})
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("reads no state for a missing object", func() {
+			data, err := NewHTTPStore(url).Read()
+			Expect(err).To(BeNil())
+			Expect(data).To(BeNil())
+		})
+
+		It("replaces the state", func() {
+			s := NewHTTPStore(url)
+			Expect(s.Read()).To(BeNil())
+			Expect(s.Write([]byte("first: 1\n"))).To(Succeed())
+			Expect(s.Write([]byte("second: 2\n"))).To(Succeed())
+			Expect(NewHTTPStore(url).Read()).To(Equal([]byte("second: 2\n")))
+		})
+
+		It("detects concurrent modifications", func() {
+			s1 := NewHTTPStore(url)
+			s2 := NewHTTPStore(url)
+			Expect(s1.Read()).To(BeNil())
+			Expect(s2.Read()).To(BeNil())
+			Expect(s1.Write([]byte("first: 1\n"))).To(Succeed())
+			Expect(s2.Write([]byte("second: 2\n"))).To(Equal(ErrConflict))
+
+			Expect(s2.Read()).To(Equal([]byte("first: 1\n")))
+			Expect(s2.Write([]byte("second: 2\n"))).To(Succeed())
+			Expect(s1.Write([]byte("third: 3\n"))).To(Equal(ErrConflict))
+		})
+
+		It("serializes access by a lock", func() {
+			s1 := NewHTTPStore(url)
+			s2 := NewHTTPStore(url)
+			Expect(s1.Lock(0)).To(Succeed())
+			_, ok := fake.Object("/bucket/state.yaml.lock")
+			Expect(ok).To(BeTrue())
+			Expect(s2.Lock(100 * time.Millisecond)).To(Equal(ErrLocked))
+			Expect(s1.Unlock()).To(Succeed())
+			_, ok = fake.Object("/bucket/state.yaml.lock")
+			Expect(ok).To(BeFalse())
+			Expect(s2.Lock(0)).To(Succeed())
+			Expect(s2.Unlock()).To(Succeed())
+		})
+
+		It("removes stale locks", func() {
+			stale := LockInfo{Host: "host", PID: 1, Time: time.Now().UTC().Add(-2 * time.Hour)}
+			Expect(NewHTTPStore(url + ".lock").Write([]byte(stale.String()))).To(Succeed())
+			Expect(NewHTTPStore(url).WithLockExpiry(3 * time.Hour).Lock(0)).To(Equal(ErrLocked))
+			s := NewHTTPStore(url)
+			Expect(s.Lock(0)).To(Succeed())
+			data, _ := fake.Object("/bucket/state.yaml.lock")
+			info, err := ParseLockInfo(data)
+			Expect(err).To(BeNil())
+			Expect(info.PID).To(Equal(os.Getpid()))
+			Expect(s.Unlock()).To(Succeed())
+		})
+
+		It("removes locks forcibly", func() {
+			Expect(NewHTTPStore(url).Lock(0)).To(Succeed())
+			Expect(NewHTTPStore(url).ForceUnlock()).To(Succeed())
+			_, ok := fake.Object("/bucket/state.yaml.lock")
+			Expect(ok).To(BeFalse())
+		})
+
+		It("sends configured headers", func() {
+			os.Setenv("SPIFF_STATE_TOKEN", "token")
+			defer os.Unsetenv("SPIFF_STATE_TOKEN")
+			var auth string
+			server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				auth = r.Header.Get("Authorization")
+				fake.ServeHTTP(w, r)
+			})
+			Expect(NewHTTPStore(url).Write([]byte("state: 1\n"))).To(Succeed())
+			Expect(auth).To(Equal("Bearer token"))
+		})
+	})
+})
@@ -33,7 +33,7 @@ foo:
 			It("accepts string keys to index maps", func() {
 				val, found := Find(tree, nil, "foo", "bar", "baz")
 				Expect(found).To(BeTrue())
-				Expect(val).To(Equal(node("found")))
+				Expect(val.EquivalentToNode(node("found"))).To(BeTrue())
 			})
 		})
 
@@ -49,7 +49,7 @@ foo:
 			It("accepts [x] to index lists", func() {
 				val, found := Find(tree, nil, "foo", "bar", "[1]", "fizz")
 				Expect(found).To(BeTrue())
-				Expect(val).To(Equal(node("right")))
+				Expect(val.EquivalentToNode(node("right"))).To(BeTrue())
 			})
 		})
 
@@ -0,0 +1,449 @@
+package yaml
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/magiconair/properties"
+	"github.com/pelletier/go-toml"
+	"gopkg.in/ini.v1"
+)
+
+// This file provides parsers and serializers for the non-YAML data
+// formats TOML, INI, Java properties, dotenv files and CSV. Values of
+// formats without types (all besides TOML) are parsed as strings.
+
+////////////////////////////////////////////////////////////////////////////////
+// TOML
+
+// ParseTOML parses a TOML document. Date and time values are provided
+// as strings.
+func ParseTOML(sourceName string, source []byte) (Node, error) {
+	tree, err := toml.LoadBytes(source)
+	if err != nil {
+		return nil, err
+	}
+	return Sanitize(sourceName, tomlValue(tree.ToMap()))
+}
+
+func tomlValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = tomlValue(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = tomlValue(e)
+		}
+	case []map[string]interface{}:
+		list := make([]interface{}, len(v))
+		for i, e := range v {
+			list[i] = tomlValue(e)
+		}
+		return list
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return v.String()
+	}
+	return value
+}
+
+// ToTOML serializes a map node as TOML document.
+func ToTOML(root Node) ([]byte, error) {
+	m, err := normalizedMap(root, "toml")
+	if err != nil {
+		return nil, err
+	}
+	tree, err := toml.TreeFromMap(m)
+	if err != nil {
+		return nil, err
+	}
+	s, err := tree.ToTomlString()
+	return []byte(s), err
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// INI
+
+// ParseINI parses an INI file. The keys of the default section are
+// provided as top level fields, the other sections as maps.
+func ParseINI(sourceName string, source []byte) (Node, error) {
+	file, err := ini.Load(source)
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]interface{}{}
+	for _, section := range file.Sections() {
+		fields := result
+		if section.Name() != ini.DefaultSection {
+			fields = map[string]interface{}{}
+			result[section.Name()] = fields
+		}
+		for _, key := range section.Keys() {
+			fields[key.Name()] = key.Value()
+		}
+	}
+	return Sanitize(sourceName, result)
+}
+
+// ToINI serializes a map node as INI file. Simple top level fields are
+// written to the default section, map fields are written as sections.
+func ToINI(root Node) ([]byte, error) {
+	m, err := normalizedMap(root, "ini")
+	if err != nil {
+		return nil, err
+	}
+	file := ini.Empty()
+	for _, k := range sortedKeys(m) {
+		if _, ok := m[k].(map[string]interface{}); ok {
+			continue
+		}
+		s, err := simpleValue(m[k], k)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := file.Section("").NewKey(k, s); err != nil {
+			return nil, err
+		}
+	}
+	for _, k := range sortedKeys(m) {
+		fields, ok := m[k].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		section, err := file.NewSection(k)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range sortedKeys(fields) {
+			s, err := simpleValue(fields[f], k+"."+f)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := section.NewKey(f, s); err != nil {
+				return nil, err
+			}
+		}
+	}
+	buf := &bytes.Buffer{}
+	_, err = file.WriteTo(buf)
+	return buf.Bytes(), err
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Properties
+
+// ParseProperties parses a Java properties file into a flat map.
+// Property references (${name}) are not expanded.
+func ParseProperties(sourceName string, source []byte) (Node, error) {
+	loader := &properties.Loader{Encoding: properties.UTF8, DisableExpansion: true}
+	p, err := loader.LoadBytes(source)
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]interface{}{}
+	for k, v := range p.Map() {
+		result[k] = v
+	}
+	return Sanitize(sourceName, result)
+}
+
+// ToProperties serializes a map node as Java properties file. Nested
+// maps and lists are flattened to keys separated by dots.
+func ToProperties(root Node) ([]byte, error) {
+	m, err := normalizedMap(root, "properties")
+	if err != nil {
+		return nil, err
+	}
+	flat := map[string]interface{}{}
+	if err := flatten("", ".", m, flat); err != nil {
+		return nil, err
+	}
+	p := properties.NewProperties()
+	p.DisableExpansion = true
+	for _, k := range sortedKeys(flat) {
+		s, err := simpleValue(flat[k], k)
+		if err != nil {
+			return nil, err
+		}
+		if _, _, err := p.Set(k, s); err != nil {
+			return nil, err
+		}
+	}
+	buf := &bytes.Buffer{}
+	_, err = p.Write(buf, properties.UTF8)
+	return buf.Bytes(), err
+}
+
+// flatten maps nested maps and lists to keys joining the path steps
+// with a separator. Paths mapped to the same key are rejected.
+func flatten(prefix, sep string, value interface{}, result map[string]interface{}) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			if err := flatten(prefix+k+sep, sep, e, result); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for i, e := range v {
+			if err := flatten(prefix+strconv.Itoa(i)+sep, sep, e, result); err != nil {
+				return err
+			}
+		}
+	default:
+		key := strings.TrimSuffix(prefix, sep)
+		if _, ok := result[key]; ok {
+			return fmt.Errorf("ambiguous key %q", key)
+		}
+		result[key] = value
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// dotenv
+
+// ParseDotEnv parses a .env file consisting of lines of the form
+// [export] KEY=VALUE. Values may be quoted, double quoted values support
+// the usual escape sequences.
+func ParseDotEnv(sourceName string, source []byte) (Node, error) {
+	result := map[string]interface{}{}
+	scanner := bufio.NewScanner(bytes.NewReader(source))
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		text = strings.TrimSpace(strings.TrimPrefix(text, "export "))
+		i := strings.Index(text, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("line %d: KEY=VALUE expected", line)
+		}
+		key := strings.TrimSpace(text[:i])
+		value := strings.TrimSpace(text[i+1:])
+		switch {
+		case strings.HasPrefix(value, `"`):
+			end := closingQuote(value)
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated string", line)
+			}
+			s, err := strconv.Unquote(value[:end+1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", line, err)
+			}
+			value = s
+		case strings.HasPrefix(value, "'"):
+			end := strings.Index(value[1:], "'")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated string", line)
+			}
+			value = value[1 : end+1]
+		default:
+			if c := strings.Index(value, " #"); c >= 0 {
+				value = strings.TrimSpace(value[:c])
+			}
+		}
+		result[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return Sanitize(sourceName, result)
+}
+
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// ToDotEnv serializes a map node as .env file. Nested maps and lists
+// are flattened to upper case keys separated by underscores.
+func ToDotEnv(root Node) ([]byte, error) {
+	m, err := normalizedMap(root, "dotenv")
+	if err != nil {
+		return nil, err
+	}
+	flat := map[string]interface{}{}
+	if err := flatten("", "_", m, flat); err != nil {
+		return nil, err
+	}
+	env := map[string]interface{}{}
+	for k, v := range flat {
+		key := strings.ToUpper(k)
+		if _, ok := env[key]; ok {
+			return nil, fmt.Errorf("ambiguous key %q", key)
+		}
+		env[key] = v
+	}
+	buf := &bytes.Buffer{}
+	for _, k := range sortedKeys(env) {
+		s, err := simpleValue(env[k], k)
+		if err != nil {
+			return nil, err
+		}
+		if strings.ContainsAny(s, " \t\n\r\"'#$\\") {
+			s = strconv.Quote(s)
+		}
+		fmt.Fprintf(buf, "%s=%s\n", k, s)
+	}
+	return buf.Bytes(), nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// CSV
+
+// ParseCSV parses a CSV file with a header line into a list of maps.
+func ParseCSV(sourceName string, source []byte) (Node, error) {
+	records, err := csv.NewReader(bytes.NewReader(source)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	result := []interface{}{}
+	if len(records) == 0 {
+		return Sanitize(sourceName, result)
+	}
+	header := records[0]
+	for _, r := range records[1:] {
+		entry := map[string]interface{}{}
+		for i, h := range header {
+			entry[h] = r[i]
+		}
+		result = append(result, entry)
+	}
+	return Sanitize(sourceName, result)
+}
+
+// ToCSV serializes a list of maps as CSV file with a header line
+// containing the keys of all entries or a list of lists as plain
+// records.
+func ToCSV(root Node) ([]byte, error) {
+	var list []Node
+	if root != nil {
+		l, ok := root.Value().([]Node)
+		if !ok {
+			return nil, fmt.Errorf("csv requires a list")
+		}
+		list = l
+	}
+
+	records := [][]string{}
+	header := []string{}
+	if len(list) > 0 {
+		if _, ok := list[0].Value().(map[string]Node); ok {
+			found := map[string]bool{}
+			for _, e := range list {
+				if _, ok := e.Value().(map[string]Node); !ok {
+					return nil, fmt.Errorf("csv requires a list of maps or a list of lists")
+				}
+				for _, k := range GetOrderedKeys(e) {
+					if !found[k] {
+						found[k] = true
+						header = append(header, k)
+					}
+				}
+			}
+			records = append(records, header)
+		}
+	}
+	for i, e := range list {
+		v, err := Normalize(e)
+		if err != nil {
+			return nil, err
+		}
+		var record []string
+		switch r := v.(type) {
+		case map[string]interface{}:
+			for _, h := range header {
+				s, err := simpleValue(r[h], fmt.Sprintf("[%d].%s", i, h))
+				if err != nil {
+					return nil, err
+				}
+				record = append(record, s)
+			}
+		case []interface{}:
+			if len(header) > 0 {
+				return nil, fmt.Errorf("csv requires a list of maps or a list of lists")
+			}
+			for j, f := range r {
+				s, err := simpleValue(f, fmt.Sprintf("[%d][%d]", i, j))
+				if err != nil {
+					return nil, err
+				}
+				record = append(record, s)
+			}
+		default:
+			return nil, fmt.Errorf("csv requires a list of maps or a list of lists")
+		}
+		records = append(records, record)
+	}
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+	if err := w.WriteAll(records); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func normalizedMap(root Node, format string) (map[string]interface{}, error) {
+	if root == nil || root.Value() == nil {
+		return map[string]interface{}{}, nil
+	}
+	v, err := Normalize(root)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s requires a map", format)
+	}
+	return m, nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// simpleValue provides the string representation of a simple value.
+func simpleValue(value interface{}, key string) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	default:
+		return "", fmt.Errorf("simple value required for %q", key)
+	}
+}
@@ -0,0 +1,160 @@
+package yaml
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("data formats", func() {
+	normalized := func(n Node, err error) interface{} {
+		Expect(err).NotTo(HaveOccurred())
+		v, err := Normalize(n)
+		Expect(err).NotTo(HaveOccurred())
+		return v
+	}
+
+	Context("toml", func() {
+		It("parses documents", func() {
+			v := normalized(ParseTOML("test", []byte(`
+title = "demo"
+port = 8080
+created = 2020-10-01T10:00:00Z
+
+[[servers]]
+name = "a"
+`)))
+			Expect(v).To(Equal(map[string]interface{}{
+				"title":   "demo",
+				"port":    int64(8080),
+				"created": "2020-10-01T10:00:00Z",
+				"servers": []interface{}{map[string]interface{}{"name": "a"}},
+			}))
+		})
+
+		It("round trips maps", func() {
+			tree := parseYAML(`
+---
+title: demo
+ratio: 0.5
+db:
+  ports: [ 1, 2 ]
+`)
+			data, err := ToTOML(tree)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(normalized(ParseTOML("test", data))).To(Equal(normalized(tree, nil)))
+		})
+
+		It("requires a map", func() {
+			_, err := ToTOML(parseYAML(`[ a ]`))
+			Expect(err).To(MatchError("toml requires a map"))
+		})
+	})
+
+	Context("ini", func() {
+		It("round trips sections", func() {
+			tree := parseYAML(`
+---
+name: x
+server:
+  host: localhost
+  port: "80"
+`)
+			data, err := ToINI(tree)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("name = x\n\n[server]\nhost = localhost\nport = 80\n\n"))
+			Expect(normalized(ParseINI("test", data))).To(Equal(normalized(tree, nil)))
+		})
+
+		It("rejects nested sections", func() {
+			_, err := ToINI(parseYAML(`{ a: { b: { c: d } } }`))
+			Expect(err).To(MatchError(`simple value required for "a.b"`))
+		})
+	})
+
+	Context("properties", func() {
+		It("flattens nested values", func() {
+			data, err := ToProperties(parseYAML(`
+---
+db:
+  host: db
+  ports: [ 1, 2 ]
+`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("db.host = db\ndb.ports.0 = 1\ndb.ports.1 = 2\n"))
+		})
+
+		It("rejects ambiguous keys", func() {
+			_, err := ToProperties(parseYAML(`{ db.host: a, db: { host: b } }`))
+			Expect(err).To(MatchError(`ambiguous key "db.host"`))
+		})
+
+		It("does not expand references", func() {
+			v := normalized(ParseProperties("test", []byte("a = ${b}\nb: c\n")))
+			Expect(v).To(Equal(map[string]interface{}{"a": "${b}", "b": "c"}))
+		})
+	})
+
+	Context("dotenv", func() {
+		It("parses files", func() {
+			v := normalized(ParseDotEnv("test", []byte(`
+# comment
+export HOST=localhost
+MSG="hello\nworld" # comment
+RAW='a "b"'
+PLAIN=x # comment
+`)))
+			Expect(v).To(Equal(map[string]interface{}{
+				"HOST":  "localhost",
+				"MSG":   "hello\nworld",
+				"RAW":   `a "b"`,
+				"PLAIN": "x",
+			}))
+		})
+
+		It("quotes values", func() {
+			data, err := ToDotEnv(parseYAML(`{ A: 1, B: "x y" }`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("A=1\nB=\"x y\"\n"))
+		})
+
+		It("flattens nested values", func() {
+			data, err := ToDotEnv(parseYAML(`{ port: 8080, db: { host: db, ports: [ 1, 2 ] } }`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("DB_HOST=db\nDB_PORTS_0=1\nDB_PORTS_1=2\nPORT=8080\n"))
+		})
+
+		It("rejects ambiguous keys", func() {
+			_, err := ToDotEnv(parseYAML(`{ db_host: a, db: { host: b } }`))
+			Expect(err).To(MatchError(`ambiguous key "db_host"`))
+			_, err = ToDotEnv(parseYAML(`{ host: a, HOST: b }`))
+			Expect(err).To(MatchError(`ambiguous key "HOST"`))
+		})
+
+		It("reports invalid lines", func() {
+			_, err := ParseDotEnv("test", []byte("A=1\nB\n"))
+			Expect(err).To(MatchError("line 2: KEY=VALUE expected"))
+		})
+	})
+
+	Context("csv", func() {
+		It("round trips lists of maps", func() {
+			tree := parseYAML(`
+---
+- name: a
+  value: "1"
+- name: b
+  value: x,y
+`)
+			data, err := ToCSV(tree)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("name,value\na,1\nb,\"x,y\"\n"))
+			Expect(normalized(ParseCSV("test", data))).To(Equal(normalized(tree, nil)))
+		})
+
+		It("writes lists of lists", func() {
+			data, err := ToCSV(parseYAML(`[ [ a, 1 ], [ b, 2 ] ]`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("a,1\nb,2\n"))
+		})
+	})
+})
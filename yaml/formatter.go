@@ -0,0 +1,211 @@
+package yaml
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Formatter serializes documents in an output format.
+type Formatter interface {
+	// Marshal serializes a single document.
+	Marshal(node Node, opts MarshalOptions) ([]byte, error)
+	// Join composes the output for a sequence of serialized documents.
+	// Empty documents are given as nil.
+	Join(docs [][]byte) ([]byte, error)
+}
+
+const (
+	FORMAT_YAML = "yaml"
+	FORMAT_JSON = "json"
+)
+
+var formatters = struct {
+	lock       sync.RWMutex
+	formatters map[string]Formatter
+}{formatters: map[string]Formatter{}}
+
+// RegisterFormatter registers a formatter for an output format name.
+func RegisterFormatter(name string, f Formatter) {
+	formatters.lock.Lock()
+	defer formatters.lock.Unlock()
+	formatters.formatters[name] = f
+}
+
+// GetFormatter provides the formatter registered for an output format
+// name or nil.
+func GetFormatter(name string) Formatter {
+	formatters.lock.RLock()
+	defer formatters.lock.RUnlock()
+	return formatters.formatters[name]
+}
+
+// FormatterNames provides the sorted list of registered output formats.
+func FormatterNames() []string {
+	formatters.lock.RLock()
+	defer formatters.lock.RUnlock()
+	names := []string{}
+	for n := range formatters.formatters {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupFormatter provides the formatter for an output format name or
+// an error, if it is unknown.
+func LookupFormatter(name string) (Formatter, error) {
+	f := GetFormatter(name)
+	if f == nil {
+		return nil, fmt.Errorf("unknown output format %q (supported: %v)", name, FormatterNames())
+	}
+	return f, nil
+}
+
+func init() {
+	RegisterFormatter(FORMAT_YAML, yamlFormatter{})
+	RegisterFormatter(FORMAT_JSON, jsonFormatter{})
+	RegisterFormatter("ndjson", ndjsonFormatter{})
+	RegisterFormatter("hcl-json", hclJSONFormatter{})
+	RegisterFormatter("toml", SimpleFormatter("toml", ToTOML))
+	RegisterFormatter("ini", SimpleFormatter("ini", ToINI))
+	RegisterFormatter("properties", SimpleFormatter("properties", ToProperties))
+	RegisterFormatter("dotenv", SimpleFormatter("dotenv", ToDotEnv))
+	RegisterFormatter("csv", SimpleFormatter("csv", ToCSV))
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// yamlFormatter writes yaml documents separated by ---.
+type yamlFormatter struct{}
+
+func (yamlFormatter) Marshal(node Node, opts MarshalOptions) ([]byte, error) {
+	return MarshalWithOptions(node, opts)
+}
+
+func (yamlFormatter) Join(docs [][]byte) ([]byte, error) {
+	var out bytes.Buffer
+	for _, d := range docs {
+		if len(docs) > 1 || len(d) == 0 {
+			out.WriteString("---\n")
+		}
+		out.Write(d)
+	}
+	return out.Bytes(), nil
+}
+
+// jsonFormatter writes one json document per line.
+type jsonFormatter struct{}
+
+func (jsonFormatter) Marshal(node Node, opts MarshalOptions) ([]byte, error) {
+	if opts.KeepOrder {
+		return ToOrderedJSON(node)
+	}
+	return ToJSON(node)
+}
+
+func (jsonFormatter) Join(docs [][]byte) ([]byte, error) {
+	var out bytes.Buffer
+	for _, d := range docs {
+		if d != nil {
+			out.Write(d)
+			out.WriteString("\n")
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// ndjsonFormatter writes newline delimited json. The entries of a list
+// document are written as separate lines.
+type ndjsonFormatter struct {
+	jsonFormatter
+}
+
+func (f ndjsonFormatter) Marshal(node Node, opts MarshalOptions) ([]byte, error) {
+	list, ok := node.Value().([]Node)
+	if !ok {
+		return f.jsonFormatter.Marshal(node, opts)
+	}
+	lines := [][]byte{}
+	for _, e := range list {
+		data, err := f.jsonFormatter.Marshal(e, opts)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, data)
+	}
+	return bytes.Join(lines, []byte("\n")), nil
+}
+
+func (ndjsonFormatter) Join(docs [][]byte) ([]byte, error) {
+	var out bytes.Buffer
+	for _, d := range docs {
+		if len(d) > 0 {
+			out.Write(d)
+			out.WriteString("\n")
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// hclJSONFormatter writes the JSON syntax of HCL (for example .tf.json
+// files), an indented json object.
+type hclJSONFormatter struct {
+	jsonFormatter
+}
+
+func (f hclJSONFormatter) Marshal(node Node, opts MarshalOptions) ([]byte, error) {
+	if _, ok := node.Value().(map[string]Node); !ok {
+		return nil, fmt.Errorf("hcl-json requires a map")
+	}
+	data, err := f.jsonFormatter.Marshal(node, opts)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, data, "", "  "); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func (hclJSONFormatter) Join(docs [][]byte) ([]byte, error) {
+	return singleDocument("hcl-json", docs, []byte("\n"))
+}
+
+// SimpleFormatter provides a formatter for formats supporting only a
+// single document per output.
+func SimpleFormatter(name string, marshal func(Node) ([]byte, error)) Formatter {
+	return simpleFormatter{name, marshal}
+}
+
+type simpleFormatter struct {
+	name    string
+	marshal func(Node) ([]byte, error)
+}
+
+func (f simpleFormatter) Marshal(node Node, opts MarshalOptions) ([]byte, error) {
+	return f.marshal(node)
+}
+
+func (f simpleFormatter) Join(docs [][]byte) ([]byte, error) {
+	return singleDocument(f.name, docs, nil)
+}
+
+func singleDocument(name string, docs [][]byte, suffix []byte) ([]byte, error) {
+	var result []byte
+	found := false
+	for _, d := range docs {
+		if d == nil {
+			continue
+		}
+		if found {
+			return nil, fmt.Errorf("output format %s does not support multiple documents", name)
+		}
+		found = true
+		result = append(d, suffix...)
+	}
+	return result, nil
+}
@@ -0,0 +1,75 @@
+package yaml
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("formatters", func() {
+	format := func(name string, docs ...Node) (string, error) {
+		f, err := LookupFormatter(name)
+		Expect(err).NotTo(HaveOccurred())
+		data := [][]byte{}
+		for _, d := range docs {
+			if d == nil {
+				data = append(data, nil)
+				continue
+			}
+			b, err := f.Marshal(d, MarshalOptions{})
+			if err != nil {
+				return "", err
+			}
+			data = append(data, b)
+		}
+		out, err := f.Join(data)
+		return string(out), err
+	}
+
+	doc := parseYAML(`
+---
+port: 8080
+db:
+  host: db
+`)
+
+	It("writes yaml documents", func() {
+		out, err := format(FORMAT_YAML, doc, parseYAML(`[ a ]`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(out).To(Equal("---\ndb:\n  host: db\nport: 8080\n---\n- a\n"))
+	})
+
+	It("writes json documents", func() {
+		out, err := format(FORMAT_JSON, doc, nil, parseYAML(`[ a ]`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(out).To(Equal("{\"db\":{\"host\":\"db\"},\"port\":8080}\n[\"a\"]\n"))
+	})
+
+	It("writes list entries as ndjson lines", func() {
+		out, err := format("ndjson", parseYAML(`[ { a: 1 }, { b: 2 } ]`), doc)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(out).To(Equal("{\"a\":1}\n{\"b\":2}\n{\"db\":{\"host\":\"db\"},\"port\":8080}\n"))
+	})
+
+	It("writes hcl json", func() {
+		out, err := format("hcl-json", doc)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(out).To(Equal("{\n  \"db\": {\n    \"host\": \"db\"\n  },\n  \"port\": 8080\n}\n"))
+
+		_, err = format("hcl-json", parseYAML(`[ a ]`))
+		Expect(err).To(MatchError("hcl-json requires a map"))
+	})
+
+	It("writes single document formats", func() {
+		out, err := format("toml", nil, doc)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(out).To(Equal("port = 8080\n\n[db]\n  host = \"db\"\n"))
+
+		_, err = format("properties", doc, doc)
+		Expect(err).To(MatchError("output format properties does not support multiple documents"))
+	})
+
+	It("rejects unknown formats", func() {
+		_, err := LookupFormatter("xml")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -1,10 +1,12 @@
 package yaml
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
+	"strings"
 
 	"github.com/mandelsoft/spiff/legacy/candiedyaml"
 )
@@ -13,6 +15,204 @@ func Marshal(node Node) ([]byte, error) {
 	return candiedyaml.Marshal(node)
 }
 
+// MarshalOptions describes optional features for marshalling
+// a node.
+type MarshalOptions struct {
+	// Comments emits the comments attached to the nodes (see ParseWithComments).
+	Comments bool
+	// KeepOrder emits map entries according to their original key order
+	// (see KeyOrder) instead of sorting them.
+	KeepOrder bool
+}
+
+// MarshalWithOptions marshals a node according to the given options.
+func MarshalWithOptions(node Node, opts MarshalOptions) ([]byte, error) {
+	if opts.Comments {
+		buf := &bytes.Buffer{}
+		c := node.Comments()
+		if c != nil && c.Head != "" {
+			// the document comment is separated from the content
+			writeComment(buf, "", c.Head)
+			buf.WriteString("\n")
+		}
+		if err := marshalCommented(buf, "", node, opts.KeepOrder); err != nil {
+			return nil, err
+		}
+		if c != nil {
+			writeComment(buf, "", c.Foot)
+		}
+		return buf.Bytes(), nil
+	}
+	if opts.KeepOrder {
+		return candiedyaml.Marshal(orderedNode{node})
+	}
+	return Marshal(node)
+}
+
+// MarshalWithComments marshals a node like Marshal, but additionally
+// emits the comments attached to the nodes (see ParseWithComments)
+// next to the corresponding map entries and list items.
+func MarshalWithComments(node Node) ([]byte, error) {
+	return MarshalWithOptions(node, MarshalOptions{Comments: true})
+}
+
+// MarshalOrdered marshals a node like Marshal, but keeps the
+// original key order of maps.
+func MarshalOrdered(node Node) ([]byte, error) {
+	return MarshalWithOptions(node, MarshalOptions{KeepOrder: true})
+}
+
+// orderedNode marshals maps as candiedyaml.MapSlice in the
+// original key order.
+type orderedNode struct {
+	Node
+}
+
+func (n orderedNode) MarshalYAML() (string, interface{}, error) {
+	switch v := n.Value().(type) {
+	case map[string]Node:
+		m := candiedyaml.MapSlice{}
+		for _, k := range GetOrderedKeys(n.Node) {
+			m = append(m, candiedyaml.MapItem{Key: k, Value: orderedNode{v[k]}})
+		}
+		return "", m, nil
+	case []Node:
+		l := make([]interface{}, len(v))
+		for i, e := range v {
+			l[i] = orderedNode{e}
+		}
+		return "", l, nil
+	}
+	return n.Node.MarshalYAML()
+}
+
+func marshalCommented(buf *bytes.Buffer, indent string, node Node, keepOrder bool) error {
+	switch v := node.Value().(type) {
+	case map[string]Node:
+		if len(v) > 0 {
+			keys := GetSortedKeys(v)
+			if keepOrder {
+				keys = GetOrderedKeys(node)
+			}
+			for _, k := range keys {
+				key, err := marshalScalar(k)
+				if err != nil {
+					return err
+				}
+				if err := marshalEntry(buf, indent, key+":", v[k], true, keepOrder); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case []Node:
+		if len(v) > 0 {
+			for _, e := range v {
+				if err := marshalEntry(buf, indent, "-", e, false, keepOrder); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	data, err := candiedyaml.Marshal(node)
+	if err != nil {
+		return err
+	}
+	buf.WriteString(indentLines(strings.TrimSuffix(string(data), "\n"), indent))
+	buf.WriteString("\n")
+	return nil
+}
+
+// marshalEntry emits a map entry or list item with the given prefix
+// (the key or the item indicator) preceded by its head comment and
+// followed by its line comment.
+func marshalEntry(buf *bytes.Buffer, indent string, prefix string, node Node, mapEntry bool, keepOrder bool) error {
+	var head, line string
+	if c := node.Comments(); c != nil {
+		head, line = c.Head, c.Line
+	}
+	writeComment(buf, indent, head)
+	if line != "" {
+		line = " " + line
+	}
+
+	if isCollection(node) {
+		if mapEntry {
+			// nested map entries are indented, nested lists are not
+			nested := indent
+			if _, ok := node.Value().(map[string]Node); ok {
+				nested += "  "
+			}
+			buf.WriteString(indent + prefix + line + "\n")
+			return marshalCommented(buf, nested, node, keepOrder)
+		}
+		if line != "" {
+			buf.WriteString(indent + prefix + line + "\n")
+			return marshalCommented(buf, indent+"  ", node, keepOrder)
+		}
+		sub := &bytes.Buffer{}
+		if err := marshalCommented(sub, indent+"  ", node, keepOrder); err != nil {
+			return err
+		}
+		buf.WriteString(indent + prefix + " ")
+		buf.Write(sub.Bytes()[len(indent)+2:])
+		return nil
+	}
+
+	data, err := candiedyaml.Marshal(node)
+	if err != nil {
+		return err
+	}
+	// the line comment must not become part of a multi-line scalar
+	value := strings.SplitN(strings.TrimSuffix(string(data), "\n"), "\n", 2)
+	buf.WriteString(indent + prefix + " " + value[0] + line + "\n")
+	if len(value) > 1 {
+		buf.WriteString(indentLines(value[1], indent) + "\n")
+	}
+	return nil
+}
+
+func isCollection(node Node) bool {
+	switch v := node.Value().(type) {
+	case map[string]Node:
+		return len(v) > 0
+	case []Node:
+		return len(v) > 0
+	}
+	return false
+}
+
+func marshalScalar(value interface{}) (string, error) {
+	data, err := candiedyaml.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(data), "\n"), nil
+}
+
+func indentLines(text string, indent string) string {
+	if indent == "" {
+		return text
+	}
+	lines := strings.Split(text, "\n")
+	for i, l := range lines {
+		if l != "" {
+			lines[i] = indent + l
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func writeComment(buf *bytes.Buffer, indent string, comment string) {
+	if comment == "" {
+		return
+	}
+	for _, l := range strings.Split(comment, "\n") {
+		buf.WriteString(indent + l + "\n")
+	}
+}
+
 func ToJSON(root Node) ([]byte, error) {
 	if root == nil {
 		return ValueToJSON(nil)
@@ -20,6 +220,77 @@ func ToJSON(root Node) ([]byte, error) {
 	return ValueToJSON(root.Value())
 }
 
+// ToOrderedJSON converts a node to json keeping the original key
+// order of maps (see KeyOrder).
+func ToOrderedJSON(root Node) ([]byte, error) {
+	if root == nil {
+		return ValueToJSON(nil)
+	}
+	n, err := normalizeOrdered(root)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(n)
+}
+
+// jsonMap is a json object marshalled in the given key order.
+type jsonMap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (m jsonMap) MarshalJSON() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteString("{")
+	for i, k := range m.keys {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		key, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(m.values[k])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteString(":")
+		buf.Write(value)
+	}
+	buf.WriteString("}")
+	return buf.Bytes(), nil
+}
+
+func normalizeOrdered(node Node) (interface{}, error) {
+	if node == nil || node.Value() == nil {
+		return nil, nil
+	}
+	switch v := node.Value().(type) {
+	case map[string]Node:
+		m := jsonMap{GetOrderedKeys(node), map[string]interface{}{}}
+		for k, e := range v {
+			sub, err := normalizeOrdered(e)
+			if err != nil {
+				return nil, err
+			}
+			m.values[k] = sub
+		}
+		return m, nil
+	case []Node:
+		l := []interface{}{}
+		for _, e := range v {
+			sub, err := normalizeOrdered(e)
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, sub)
+		}
+		return l, nil
+	}
+	return normalizeValue(node.Value())
+}
+
 func ValueToJSON(root interface{}) ([]byte, error) {
 	n, err := normalizeValue(root)
 	if err != nil {
@@ -23,11 +23,15 @@ type Node interface {
 	Value() interface{}
 	Template() interface{}
 	SourceName() string
+	Position() Position
+	Comments() *Comments
+	KeyOrder() []string
 	RedirectPath() []string
 	Flags() NodeFlags
 	Temporary() bool
 	Local() bool
 	State() bool
+	Sensitive() bool
 	ReplaceFlag() bool
 	Preferred() bool
 	Merged() bool
@@ -49,9 +53,57 @@ type AnnotatedNode struct {
 	template   interface{}
 	resolver   RefResolver
 	sourceName string
+	position   Position
+	comments   *Comments
+	order      []string
 	Annotation
 }
 
+// Position describes the location of a node in its source document.
+// Line and column are 1-based, the zero value describes an unknown position.
+type Position struct {
+	Line   int
+	Column int
+}
+
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Position) String() string {
+	if !p.IsValid() {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// SourceLocation returns the source name of a node, extended by
+// its line and column (file:line:col), if known.
+func SourceLocation(node Node) string {
+	if node == nil {
+		return ""
+	}
+	if pos := node.Position(); pos.IsValid() {
+		return node.SourceName() + ":" + pos.String()
+	}
+	return node.SourceName()
+}
+
+// Comments describes the comments attached to a node in its source
+// document: the comment lines preceding it (Head), the comment on the
+// same line (Line) and, for a document root, the trailing comment
+// lines of the document (Foot). The comment texts include the
+// comment indicator, multiple lines are separated by newlines.
+type Comments struct {
+	Head string
+	Line string
+	Foot string
+}
+
+func (c *Comments) IsEmpty() bool {
+	return c == nil || (c.Head == "" && c.Line == "" && c.Foot == "")
+}
+
 type Issue struct {
 	Issue    string
 	OrigPath []string
@@ -77,6 +129,8 @@ const (
 
 	FLAG_INJECTED = 0x040
 	FLAG_IMPLIED  = 0x080
+
+	FLAG_SENSITIVE = 0x100 // value must not be shown in diagnostic output
 )
 
 type NodeFlags int
@@ -160,6 +214,14 @@ func (f *NodeFlags) SetInjected() *NodeFlags {
 	return f
 }
 
+func (f NodeFlags) Sensitive() bool {
+	return (f & FLAG_SENSITIVE) != 0
+}
+func (f *NodeFlags) SetSensitive() *NodeFlags {
+	*f |= FLAG_SENSITIVE
+	return f
+}
+
 type Annotation struct {
 	redirectPath []string
 	replace      bool
@@ -175,18 +237,22 @@ type Annotation struct {
 }
 
 func copyNode(node Node) AnnotatedNode {
-	return AnnotatedNode{node.Value(), node.Template(), node.Resolver(), node.SourceName(), node.GetAnnotation()}
+	return AnnotatedNode{node.Value(), node.Template(), node.Resolver(), node.SourceName(), node.Position(), node.Comments(), node.KeyOrder(), node.GetAnnotation()}
 }
 func copyNodeAnnotated(node Node, anno Annotation) AnnotatedNode {
-	return AnnotatedNode{node.Value(), node.Template(), node.Resolver(), node.SourceName(), anno}
+	return AnnotatedNode{node.Value(), node.Template(), node.Resolver(), node.SourceName(), node.Position(), node.Comments(), node.KeyOrder(), anno}
 }
 
 func NewNode(value interface{}, sourcePath string) Node {
-	return AnnotatedNode{MassageType(value), nil, nil, sourcePath, EmptyAnnotation()}
+	return AnnotatedNode{MassageType(value), nil, nil, sourcePath, Position{}, nil, nil, EmptyAnnotation()}
+}
+
+func NewPositionedNode(value interface{}, sourcePath string, pos Position) Node {
+	return AnnotatedNode{MassageType(value), nil, nil, sourcePath, pos, nil, nil, EmptyAnnotation()}
 }
 
 func NewDynamicNode(value, template interface{}, sourcePath string) Node {
-	return AnnotatedNode{MassageType(value), template, nil, sourcePath, EmptyAnnotation().SetInjected().SetDynamic()}
+	return AnnotatedNode{MassageType(value), template, nil, sourcePath, Position{}, nil, nil, EmptyAnnotation().SetInjected().SetDynamic()}
 }
 
 func ResolverNode(node Node, resolver RefResolver) Node {
@@ -195,6 +261,28 @@ func ResolverNode(node Node, resolver RefResolver) Node {
 	return n
 }
 
+func PositionNode(node Node, pos Position) Node {
+	n := copyNode(node)
+	n.position = pos
+	return n
+}
+
+func CommentedNode(node Node, comments *Comments) Node {
+	n := copyNode(node)
+	if comments.IsEmpty() {
+		comments = nil
+	}
+	n.comments = comments
+	return n
+}
+
+// OrderedNode sets the original key order for a map node.
+func OrderedNode(node Node, keys []string) Node {
+	n := copyNode(node)
+	n.order = keys
+	return n
+}
+
 func ReplaceValue(value interface{}, node Node) Node {
 	n := copyNode(node)
 	n.value = value
@@ -234,6 +322,41 @@ func KeyNameNode(node Node, keyName string) Node {
 	return copyNodeAnnotated(node, node.GetAnnotation().AddKeyName(keyName))
 }
 
+// ProcessKeyTag removes the key tag from the field of a list entry
+// marked as key field with "key:<field>". It provides the
+// substituted entry and the name of the key field, if found.
+func ProcessKeyTag(val Node) (Node, string) {
+	keyName := ""
+
+	m, ok := val.Value().(map[string]Node)
+	if ok {
+		found := false
+		for key, _ := range m {
+			split := strings.Index(key, ":")
+			if split > 0 {
+				if key[:split] == "key" {
+					keyName = key[split+1:]
+					found = true
+				}
+			}
+		}
+		if found {
+			newMap := make(map[string]Node)
+			for key, v := range m {
+				split := strings.Index(key, ":")
+				if split > 0 {
+					if key[:split] == "key" {
+						key = key[split+1:]
+					}
+				}
+				newMap[key] = v
+			}
+			return SubstituteNode(newMap, val), keyName
+		}
+	}
+	return val, keyName
+}
+
 func IssueNode(node Node, error bool, failed bool, issue Issue) Node {
 	return copyNodeAnnotated(node, node.GetAnnotation().AddIssue(error, failed, issue))
 }
@@ -428,6 +551,21 @@ func (n AnnotatedNode) SourceName() string {
 	return n.sourceName
 }
 
+func (n AnnotatedNode) Position() Position {
+	return n.position
+}
+
+func (n AnnotatedNode) Comments() *Comments {
+	return n.comments
+}
+
+// KeyOrder returns the original key order of a map node, if known.
+// The list may contain keys not present in the map anymore and
+// may miss keys added during processing.
+func (n AnnotatedNode) KeyOrder() []string {
+	return n.order
+}
+
 func (n AnnotatedNode) Template() interface{} {
 	return n.template
 }
@@ -539,14 +677,14 @@ func UnescapeDynaml(root Node, interpol bool) Node {
 			strings.HasSuffix(value, "))") {
 			sub := value[2 : len(value)-2]
 			if strings.HasPrefix(sub, "!") {
-				return NewNode("(("+sub[1:]+"))", root.SourceName())
+				return CommentedNode(NewPositionedNode("(("+sub[1:]+"))", root.SourceName(), root.Position()), root.Comments())
 			}
 			return root
 		}
 		if interpol {
 			str, _ := convertToExpression(value, true)
 			if str != nil && *str != value {
-				return NewNode(*str, root.SourceName())
+				return CommentedNode(NewPositionedNode(*str, root.SourceName(), root.Position()), root.Comments())
 			}
 		}
 	case map[string]Node:
@@ -565,8 +703,26 @@ func UnescapeDynaml(root Node, interpol bool) Node {
 			}
 		}
 		if found {
-			return NewNode(new, root.SourceName())
+			n := CommentedNode(NewPositionedNode(new, root.SourceName(), root.Position()), root.Comments())
+			return OrderedNode(n, unescapeKeys(root.KeyOrder()))
 		}
 	}
 	return root
 }
+
+func unescapeKeys(keys []string) []string {
+	if keys == nil {
+		return nil
+	}
+	result := make([]string, len(keys))
+	for i, k := range keys {
+		switch {
+		case strings.HasPrefix(k, "<<!"):
+			k = "<<" + k[3:]
+		case strings.HasPrefix(k, MERGEKEY+"!"):
+			k = MERGEKEY + k[len(MERGEKEY)+1:]
+		}
+		result[i] = k
+	}
+	return result
+}
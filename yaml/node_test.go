@@ -16,6 +16,30 @@ var _ = Describe("Node", func() {
 		Expect(ok).To(BeTrue())
 	})
 
+	Describe("ProcessKeyTag", func() {
+		It("removes the key tag", func() {
+			subject := NewNode(map[string]Node{
+				"key:id": NewNode("a", "test"),
+				"value":  NewNode(1, "test"),
+			}, "test")
+
+			node, key := ProcessKeyTag(subject)
+			Expect(key).To(Equal("id"))
+			Expect(node.Value()).To(Equal(map[string]Node{
+				"id":    NewNode("a", "test"),
+				"value": NewNode(1, "test"),
+			}))
+		})
+
+		It("keeps entries without key tag", func() {
+			subject := NewNode(map[string]Node{"id": NewNode("a", "test")}, "test")
+
+			node, key := ProcessKeyTag(subject)
+			Expect(key).To(Equal(""))
+			Expect(node).To(Equal(subject))
+		})
+	})
+
 	Describe("ComparableValue", func() {
 		It("returns the node value", func() {
 			subjectValue := "hello world"
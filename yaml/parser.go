@@ -13,13 +13,48 @@ import (
 //	"github.com/cloudfoundry-incubator/candiedyaml"
 
 type NonStringKeyError struct {
-	Key interface{}
+	Key      interface{}
+	Source   string
+	Position Position
 }
 
 func (e NonStringKeyError) Error() string {
+	if e.Position.IsValid() {
+		return fmt.Sprintf("%s:%s: map key must be a string: %#v", e.Source, e.Position, e.Key)
+	}
 	return fmt.Sprintf("map key must be a string: %#v", e.Key)
 }
 
+// ParseError is a yaml syntax error found in a source document.
+type ParseError struct {
+	Source   string
+	Position Position
+	Err      error
+}
+
+func (e ParseError) Error() string {
+	if e.Position.IsValid() {
+		return fmt.Sprintf("%s:%s: %s", e.Source, e.Position, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", e.Source, e.Err)
+}
+
+func newParseError(sourceName string, err error) error {
+	var pos Position
+	switch e := err.(type) {
+	case *candiedyaml.ParserError:
+		pos = MarkPosition(e.ProblemMark)
+	case *candiedyaml.UnexpectedEventError:
+		pos = MarkPosition(e.At)
+	}
+	return ParseError{sourceName, pos, err}
+}
+
+// MarkPosition converts a parser mark into a node position.
+func MarkPosition(mark candiedyaml.YAML_mark_t) Position {
+	return Position{mark.Line(), mark.Column()}
+}
+
 func Unmarshal(sourceName string, source []byte) (Node, error) {
 	return Parse(sourceName, source)
 }
@@ -40,6 +75,29 @@ func UnmarshalMulti(sourceName string, source []byte) ([]Node, error) {
 }
 
 func ParseMulti(sourceName string, source []byte) ([]Node, error) {
+	return parseMulti(sourceName, source, false)
+}
+
+// ParseWithComments parses a single document like Parse, but keeps
+// the comments of the source document attached to the nodes
+// (see Comments). They are re-emitted by MarshalWithComments.
+func ParseWithComments(sourceName string, source []byte) (Node, error) {
+	docs, err := ParseMultiWithComments(sourceName, source)
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) > 1 {
+		return nil, fmt.Errorf("multi document not possible")
+	}
+	return docs[0], err
+}
+
+// ParseMultiWithComments is the comment preserving variant of ParseMulti.
+func ParseMultiWithComments(sourceName string, source []byte) ([]Node, error) {
+	return parseMulti(sourceName, source, true)
+}
+
+func parseMulti(sourceName string, source []byte, comments bool) ([]Node, error) {
 	docs := []Node{}
 
 	if len(bytes.Trim(source, " \t\n\r")) == 0 {
@@ -47,12 +105,17 @@ func ParseMulti(sourceName string, source []byte) ([]Node, error) {
 	}
 	r := bytes.NewBuffer(source)
 	d := candiedyaml.NewDecoder(r)
+	if comments {
+		d.UseComments()
+	} else {
+		d.UseMarks()
+	}
 
 	for d.HasNext() {
 		var parsed interface{}
 		err := d.Decode(&parsed)
 		if err != nil {
-			return nil, err
+			return nil, newParseError(sourceName, err)
 		}
 		n, err := Sanitize(sourceName, parsed)
 		if err != nil {
@@ -68,6 +131,35 @@ var arrayType = reflect.TypeOf([]interface{}{})
 
 func Sanitize(sourceName string, root interface{}) (Node, error) {
 	switch rootVal := root.(type) {
+	case candiedyaml.Marked:
+		pos := MarkPosition(rootVal.Mark)
+		n, err := Sanitize(sourceName, rootVal.Value)
+		if err != nil {
+			if e, ok := err.(NonStringKeyError); ok && !e.Position.IsValid() {
+				e.Source = sourceName
+				e.Position = pos
+				err = e
+			}
+			return nil, err
+		}
+		n = PositionNode(n, pos)
+		if rootVal.Keys != nil {
+			keys := make([]string, 0, len(rootVal.Keys))
+			for _, k := range rootVal.Keys {
+				if str, ok := k.(string); ok {
+					keys = append(keys, str)
+				}
+			}
+			n = OrderedNode(n, keys)
+		}
+		if rootVal.HeadComment != "" || rootVal.LineComment != "" || rootVal.FootComment != "" {
+			n = CommentedNode(n, &Comments{
+				Head: rootVal.HeadComment,
+				Line: rootVal.LineComment,
+				Foot: rootVal.FootComment,
+			})
+		}
+		return n, nil
 	case time.Time:
 		return NewNode(rootVal.Format("2019-01-08T10:06:26Z"), sourceName), nil
 	case map[interface{}]interface{}:
@@ -76,7 +168,7 @@ func Sanitize(sourceName string, root interface{}) (Node, error) {
 		for key, val := range rootVal {
 			str, ok := key.(string)
 			if !ok {
-				return nil, NonStringKeyError{key}
+				return nil, NonStringKeyError{Key: key}
 			}
 
 			sub, err := Sanitize(sourceName, val)
@@ -11,7 +11,7 @@ var _ = Describe("YAML Parser", func() {
 		It("parses maps as strings mapping to Nodes", func() {
 			parsed, err := Parse("test", []byte(`foo: "fizz \"buzz\""`))
 			Expect(err).NotTo(HaveOccurred())
-			Expect(parsed).To(Equal(node(map[string]Node{"foo": node(`fizz "buzz"`)})))
+			Expect(withoutSourceInfo(parsed)).To(Equal(node(map[string]Node{"foo": node(`fizz "buzz"`)})))
 		})
 
 		It("parses maps with block string values", func() {
@@ -79,10 +79,176 @@ doc2:
 			Expect(len(docs)).To(Equal(2))
 		})
 	})
+
+	Context("source positions", func() {
+		It("records line and column of nodes", func() {
+			parsed, err := Parse("test", []byte(`
+---
+foo:
+  bar: (( alice ))
+  list:
+    - a
+    - b
+`))
+			Expect(err).NotTo(HaveOccurred())
+			foo := parsed.Value().(map[string]Node)["foo"]
+			Expect(foo.Position()).To(Equal(Position{4, 3}))
+			bar := foo.Value().(map[string]Node)["bar"]
+			Expect(bar.Position()).To(Equal(Position{4, 8}))
+			Expect(SourceLocation(bar)).To(Equal("test:4:8"))
+			list := foo.Value().(map[string]Node)["list"].Value().([]Node)
+			Expect(list[1].Position()).To(Equal(Position{7, 7}))
+		})
+
+		It("reports parse errors with source location", func() {
+			_, err := Parse("test", []byte("foo: [ a\nbar: b"))
+			Expect(err).To(BeAssignableToTypeOf(ParseError{}))
+			Expect(err.Error()).To(HavePrefix("test:2:"))
+		})
+
+		It("reports non string keys with source location", func() {
+			_, err := Parse("test", []byte("foo:\n  1: bar"))
+			Expect(err.Error()).To(HavePrefix("test:2:3: map key must be a string"))
+		})
+	})
+
+	Context("key order", func() {
+		source := `
+zeta: 1
+alpha:
+  list:
+  - b: 1
+    a: 2
+  c: 3
+`
+		It("records the key order of maps", func() {
+			parsed, err := Parse("test", []byte(source))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(parsed.KeyOrder()).To(Equal([]string{"zeta", "alpha"}))
+			Expect(GetOrderedKeys(parsed)).To(Equal([]string{"zeta", "alpha"}))
+		})
+
+		It("marshals maps in original order", func() {
+			parsed, err := Parse("test", []byte(source))
+			Expect(err).NotTo(HaveOccurred())
+			data, err := MarshalOrdered(parsed)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal(source[1:]))
+			data, err = ToOrderedJSON(parsed)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal(`{"zeta":1,"alpha":{"list":[{"b":1,"a":2}],"c":3}}`))
+		})
+
+		It("appends keys without known order sorted", func() {
+			parsed, err := Parse("test", []byte("b: 1\na: 2\n"))
+			Expect(err).NotTo(HaveOccurred())
+			m := parsed.Value().(map[string]Node)
+			m["d"] = NewNode(3, "test")
+			m["c"] = NewNode(4, "test")
+			delete(m, "a")
+			data, err := MarshalOrdered(parsed)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("b: 1\nc: 4\nd: 3\n"))
+		})
+	})
+
+	Context("comments", func() {
+		source := `# head
+foo: # line foo
+  # head bar
+  bar: alice # line bar
+list:
+# head item
+- a # line a
+- b
+# foot
+`
+		It("ignores comments by default", func() {
+			parsed, err := Parse("test", []byte(source))
+			Expect(err).NotTo(HaveOccurred())
+			foo := parsed.Value().(map[string]Node)["foo"]
+			Expect(foo.Comments()).To(BeNil())
+		})
+
+		It("attaches head, line and foot comments", func() {
+			parsed, err := ParseWithComments("test", []byte(source))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(parsed.Comments()).To(Equal(&Comments{Foot: "# foot"}))
+			foo := parsed.Value().(map[string]Node)["foo"]
+			Expect(foo.Comments()).To(Equal(&Comments{Head: "# head", Line: "# line foo"}))
+			bar := foo.Value().(map[string]Node)["bar"]
+			Expect(bar.Comments()).To(Equal(&Comments{Head: "# head bar", Line: "# line bar"}))
+			list := parsed.Value().(map[string]Node)["list"].Value().([]Node)
+			Expect(list[0].Comments()).To(Equal(&Comments{Head: "# head item", Line: "# line a"}))
+			Expect(list[1].Comments()).To(BeNil())
+		})
+
+		It("re-emits comments", func() {
+			parsed, err := ParseWithComments("test", []byte(source))
+			Expect(err).NotTo(HaveOccurred())
+			data, err := MarshalWithComments(parsed)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal(source))
+		})
+
+		It("keeps line comments out of multi-line scalars", func() {
+			parsed, err := ParseWithComments("test", []byte("text: | # literal\n  a\n  b\n"))
+			Expect(err).NotTo(HaveOccurred())
+			data, err := MarshalWithComments(parsed)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("text: |+ # literal\n  a\n  b\n"))
+		})
+
+		It("combines comments and key order", func() {
+			parsed, err := ParseWithComments("test", []byte("b: 1 # b\na: 2 # a\n"))
+			Expect(err).NotTo(HaveOccurred())
+			data, err := MarshalWithOptions(parsed, MarshalOptions{Comments: true, KeepOrder: true})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("b: 1 # b\na: 2 # a\n"))
+		})
+
+		It("anchors separated head comments to the document", func() {
+			parsed, err := ParseWithComments("test", []byte("# document\n\n# zeta\nzeta: 1\nalpha: 2\n"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(parsed.Comments()).To(Equal(&Comments{Head: "# document"}))
+			data, err := MarshalWithComments(parsed)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("# document\n\nalpha: 2\n# zeta\nzeta: 1\n"))
+		})
+
+		It("marshals like Marshal without comments", func() {
+			parsed, err := Parse("test", []byte(source))
+			Expect(err).NotTo(HaveOccurred())
+			data, err := MarshalWithComments(parsed)
+			Expect(err).NotTo(HaveOccurred())
+			expected, err := Marshal(parsed)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal(string(expected)))
+		})
+	})
 })
 
 func parsesAs(source string, expr interface{}) {
 	parsed, err := Parse("test", []byte(source))
 	Expect(err).NotTo(HaveOccurred())
-	Expect(parsed).To(Equal(node(expr)))
+	Expect(withoutSourceInfo(parsed)).To(Equal(node(expr)))
+}
+
+// withoutSourceInfo removes the source positions and key orders recorded
+// by the parser, to compare parsed nodes with constructed ones.
+func withoutSourceInfo(node Node) Node {
+	if node == nil {
+		return nil
+	}
+	switch v := node.Value().(type) {
+	case map[string]Node:
+		for k, e := range v {
+			v[k] = withoutSourceInfo(e)
+		}
+	case []Node:
+		for i, e := range v {
+			v[i] = withoutSourceInfo(e)
+		}
+	}
+	return OrderedNode(PositionNode(node, Position{}), nil)
 }
@@ -0,0 +1,769 @@
+package yaml
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mandelsoft/spiff/features"
+)
+
+// JSONPath is a compiled JSONPath expression. Child steps follow the
+// semantics of Find, therefore lists of maps can be stepped through by
+// the value of the name field (or the key field of the list), for
+// example $.jobs.web or $.jobs[name:web].
+//
+// Supported are the root $, child steps (.name, ['name']), wildcards
+// (.* and [*]), recursive descent (..), indices, unions and slices
+// ([0], [-1], [0,2], [1:3], [::2]) and filter expressions
+// ([?(@.name == 'web' && @.instances > 1)]). Filters support the
+// operators ==, !=, <, <=, >, >=, =~ (regular expression), &&, ||
+// and !. A path alone selects entries for which it exists and is not
+// false.
+type JSONPath struct {
+	path  string
+	steps []queryStep
+}
+
+type queryStep struct {
+	recursive bool
+	selector  querySelector
+}
+
+type querySelector func(node Node, q *queryEvaluation) []Node
+
+type queryEvaluation struct {
+	root     Node
+	features features.FeatureFlags
+}
+
+// Query evaluates a JSONPath expression on a node tree and provides the
+// list of matching nodes.
+func Query(root Node, features features.FeatureFlags, path string) ([]Node, error) {
+	q, err := ParseJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return q.Evaluate(root, features), nil
+}
+
+// ParseJSONPath compiles a JSONPath expression. The leading $ may be
+// omitted.
+func ParseJSONPath(path string) (*JSONPath, error) {
+	steps, err := parseQuery(strings.TrimSpace(path), '$')
+	if err != nil {
+		return nil, fmt.Errorf("invalid path %q: %s", path, err)
+	}
+	return &JSONPath{path: path, steps: steps}, nil
+}
+
+func (p *JSONPath) String() string {
+	return p.path
+}
+
+// Evaluate provides the list of nodes matched by the path.
+func (p *JSONPath) Evaluate(root Node, features features.FeatureFlags) []Node {
+	q := &queryEvaluation{root: root, features: features}
+	return q.evaluate(root, p.steps)
+}
+
+func (q *queryEvaluation) evaluate(node Node, steps []queryStep) []Node {
+	current := []Node{node}
+	for _, step := range steps {
+		next := []Node{}
+		for _, n := range current {
+			if n == nil {
+				continue
+			}
+			if step.recursive {
+				for _, d := range descendants(n, nil) {
+					next = append(next, step.selector(d, q)...)
+				}
+			} else {
+				next = append(next, step.selector(n, q)...)
+			}
+		}
+		current = next
+	}
+	return current
+}
+
+func children(node Node) []Node {
+	switch v := node.Value().(type) {
+	case map[string]Node:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		result := make([]Node, 0, len(keys))
+		for _, k := range keys {
+			result = append(result, v[k])
+		}
+		return result
+	case []Node:
+		return v
+	default:
+		return nil
+	}
+}
+
+func descendants(node Node, result []Node) []Node {
+	result = append(result, node)
+	for _, c := range children(node) {
+		if c != nil {
+			result = descendants(c, result)
+		}
+	}
+	return result
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// selectors
+
+func childSelector(name string) querySelector {
+	return func(node Node, q *queryEvaluation) []Node {
+		if next, ok := nextStep(false, name, node, q.features); ok && next != nil {
+			return []Node{next}
+		}
+		return nil
+	}
+}
+
+func wildcardSelector(node Node, q *queryEvaluation) []Node {
+	return children(node)
+}
+
+func indexSelector(index int) querySelector {
+	return func(node Node, q *queryEvaluation) []Node {
+		list, ok := node.Value().([]Node)
+		if !ok {
+			return nil
+		}
+		i := index
+		if i < 0 {
+			i += len(list)
+		}
+		if i < 0 || i >= len(list) {
+			return nil
+		}
+		return []Node{list[i]}
+	}
+}
+
+func sliceSelector(start, end *int, step int) querySelector {
+	return func(node Node, q *queryEvaluation) []Node {
+		list, ok := node.Value().([]Node)
+		if !ok {
+			return nil
+		}
+		n := len(list)
+		bound := func(p *int, def int) int {
+			if p == nil {
+				return def
+			}
+			i := *p
+			if i < 0 {
+				i += n
+			}
+			if i < -1 {
+				i = -1
+			}
+			if i > n {
+				i = n
+			}
+			return i
+		}
+		result := []Node{}
+		if step > 0 {
+			s, e := bound(start, 0), bound(end, n)
+			if s < 0 {
+				s = 0
+			}
+			for i := s; i < e; i += step {
+				result = append(result, list[i])
+			}
+		} else {
+			s, e := bound(start, n-1), bound(end, -1)
+			if s >= n {
+				s = n - 1
+			}
+			for i := s; i > e; i += step {
+				result = append(result, list[i])
+			}
+		}
+		return result
+	}
+}
+
+func unionSelector(selectors []querySelector) querySelector {
+	return func(node Node, q *queryEvaluation) []Node {
+		result := []Node{}
+		for _, s := range selectors {
+			result = append(result, s(node, q)...)
+		}
+		return result
+	}
+}
+
+func filterSelector(expr filterExpr) querySelector {
+	return func(node Node, q *queryEvaluation) []Node {
+		result := []Node{}
+		for _, c := range children(node) {
+			if c != nil && truthy(expr(c, q)) {
+				result = append(result, c)
+			}
+		}
+		return result
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// path parser
+
+var (
+	queryIndex = regexp.MustCompile(`^-?[0-9]+$`)
+	querySlice = regexp.MustCompile(`^(-?[0-9]*):(-?[0-9]*)(?::(-?[0-9]*))?$`)
+)
+
+func parseQuery(path string, root byte) ([]queryStep, error) {
+	if path == "" {
+		return nil, fmt.Errorf("empty path")
+	}
+	switch {
+	case path[0] == root:
+		path = path[1:]
+	case path[0] != '.' && path[0] != '[':
+		path = "." + path
+	}
+
+	steps := []queryStep{}
+	for len(path) > 0 {
+		recursive := false
+		switch {
+		case strings.HasPrefix(path, ".."):
+			recursive = true
+			path = path[2:]
+			if strings.HasPrefix(path, "[") {
+				break
+			}
+			fallthrough
+		case path[0] == '.':
+			if !recursive {
+				path = path[1:]
+			}
+			name := path
+			if i := strings.IndexAny(path, ".["); i >= 0 {
+				name = path[:i]
+			}
+			path = path[len(name):]
+			name = strings.TrimSpace(name)
+			switch name {
+			case "":
+				return nil, fmt.Errorf("missing name")
+			case "*":
+				steps = append(steps, queryStep{recursive, wildcardSelector})
+			default:
+				steps = append(steps, queryStep{recursive, childSelector(name)})
+			}
+			continue
+		case path[0] != '[':
+			return nil, fmt.Errorf("unexpected %q", path)
+		}
+
+		end, err := closingBracket(path)
+		if err != nil {
+			return nil, err
+		}
+		sel, err := parseBracket(strings.TrimSpace(path[1:end]))
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, queryStep{recursive, sel})
+		path = path[end+1:]
+	}
+	return steps, nil
+}
+
+// closingBracket provides the index of the bracket closing the one
+// at the beginning of s, respecting quotes and nested brackets.
+func closingBracket(s string) (int, error) {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '[' || c == '(':
+			depth++
+		case c == ']' || c == ')':
+			depth--
+			if depth == 0 {
+				if c != ']' {
+					return 0, fmt.Errorf("unbalanced parenthesis")
+				}
+				return i, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("missing ]")
+}
+
+func parseBracket(content string) (querySelector, error) {
+	switch {
+	case content == "":
+		return nil, fmt.Errorf("empty []")
+	case content == "*":
+		return wildcardSelector, nil
+	case strings.HasPrefix(content, "?"):
+		expr, err := parseFilter(strings.TrimSpace(content[1:]))
+		if err != nil {
+			return nil, err
+		}
+		return filterSelector(expr), nil
+	}
+
+	items, err := splitUnion(content)
+	if err != nil {
+		return nil, err
+	}
+	selectors := []querySelector{}
+	for _, item := range items {
+		switch {
+		case item == "":
+			return nil, fmt.Errorf("empty union entry in [%s]", content)
+		case item[0] == '\'' || item[0] == '"':
+			name, err := unquote(item)
+			if err != nil {
+				return nil, err
+			}
+			selectors = append(selectors, childSelector(name))
+		case queryIndex.MatchString(item):
+			i, err := strconv.Atoi(item)
+			if err != nil {
+				return nil, err
+			}
+			selectors = append(selectors, indexSelector(i))
+		case querySlice.MatchString(item):
+			sel, err := parseSlice(querySlice.FindStringSubmatch(item))
+			if err != nil {
+				return nil, err
+			}
+			selectors = append(selectors, sel)
+		default:
+			selectors = append(selectors, childSelector(item))
+		}
+	}
+	if len(selectors) == 1 {
+		return selectors[0], nil
+	}
+	return unionSelector(selectors), nil
+}
+
+func splitUnion(content string) ([]string, error) {
+	items := []string{}
+	var quote byte
+	last := 0
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ',':
+			items = append(items, strings.TrimSpace(content[last:i]))
+			last = i + 1
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated string")
+	}
+	return append(items, strings.TrimSpace(content[last:])), nil
+}
+
+func parseSlice(match []string) (querySelector, error) {
+	var bounds [2]*int
+	for i := range bounds {
+		if match[i+1] != "" {
+			v, err := strconv.Atoi(match[i+1])
+			if err != nil {
+				return nil, err
+			}
+			bounds[i] = &v
+		}
+	}
+	step := 1
+	if match[3] != "" {
+		v, err := strconv.Atoi(match[3])
+		if err != nil {
+			return nil, err
+		}
+		if v == 0 {
+			return nil, fmt.Errorf("slice step must not be zero")
+		}
+		step = v
+	}
+	return sliceSelector(bounds[0], bounds[1], step), nil
+}
+
+func unquote(s string) (string, error) {
+	if len(s) < 2 || s[len(s)-1] != s[0] {
+		return "", fmt.Errorf("unterminated string %s", s)
+	}
+	var b strings.Builder
+	for i := 1; i < len(s)-1; i++ {
+		if s[i] == '\\' && i+1 < len(s)-1 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String(), nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// filter expressions
+
+// filterExpr evaluates to a node, nil means undefined.
+type filterExpr func(node Node, q *queryEvaluation) Node
+
+var (
+	trueNode  = NewNode(true, "query")
+	falseNode = NewNode(false, "query")
+)
+
+func boolNode(b bool) Node {
+	if b {
+		return trueNode
+	}
+	return falseNode
+}
+
+func truthy(n Node) bool {
+	return n != nil && n.Value() != false
+}
+
+type filterParser struct {
+	src string
+	pos int
+}
+
+func parseFilter(src string) (filterExpr, error) {
+	p := &filterParser{src: src}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return nil, fmt.Errorf("unexpected %q in filter", p.src[p.pos:])
+	}
+	return expr, nil
+}
+
+func (p *filterParser) skipSpace() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *filterParser) consume(token string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.src[p.pos:], token) {
+		p.pos += len(token)
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) parseOr() (filterExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.consume("||") {
+		l := left
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = func(node Node, q *queryEvaluation) Node {
+			return boolNode(truthy(l(node, q)) || truthy(r(node, q)))
+		}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.consume("&&") {
+		l := left
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = func(node Node, q *queryEvaluation) Node {
+			return boolNode(truthy(l(node, q)) && truthy(r(node, q)))
+		}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filterExpr, error) {
+	if p.consume("!") {
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(node Node, q *queryEvaluation) Node {
+			return boolNode(!truthy(e(node, q)))
+		}, nil
+	}
+	if p.consume("(") {
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume(")") {
+			return nil, fmt.Errorf("missing ) in filter")
+		}
+		return e, nil
+	}
+	return p.parseComparison()
+}
+
+var filterOperators = []string{"==", "!=", "<=", ">=", "=~", "<", ">"}
+
+func (p *filterParser) parseComparison() (filterExpr, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range filterOperators {
+		if !p.consume(op) {
+			continue
+		}
+		if op == "=~" {
+			return p.parseRegexpMatch(left)
+		}
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return compareExpr(op, left, right), nil
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseOperand() (filterExpr, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return nil, fmt.Errorf("operand expected in filter")
+	}
+	rest := p.src[p.pos:]
+	switch c := rest[0]; {
+	case c == '@' || c == '$':
+		end := pathEnd(rest)
+		steps, err := parseQuery(rest[:end], c)
+		if err != nil {
+			return nil, err
+		}
+		p.pos += end
+		relative := c == '@'
+		return func(node Node, q *queryEvaluation) Node {
+			if !relative {
+				node = q.root
+			}
+			result := q.evaluate(node, steps)
+			if len(result) == 0 {
+				return nil
+			}
+			return result[0]
+		}, nil
+	case c == '\'' || c == '"' || c == '/':
+		end := 1
+		for end < len(rest) && rest[end] != c {
+			if rest[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(rest) {
+			return nil, fmt.Errorf("unterminated string in filter")
+		}
+		s, err := unquote(rest[:end+1])
+		if c == '/' {
+			s = rest[1:end]
+		}
+		if err != nil {
+			return nil, err
+		}
+		p.pos += end + 1
+		return literal(s), nil
+	default:
+		end := strings.IndexAny(rest, " \t)=!<>&|")
+		if end < 0 {
+			end = len(rest)
+		}
+		word := rest[:end]
+		p.pos += end
+		switch word {
+		case "true":
+			return literal(true), nil
+		case "false":
+			return literal(false), nil
+		case "null":
+			n := NewNode(nil, "query")
+			return func(Node, *queryEvaluation) Node { return n }, nil
+		}
+		if i, err := strconv.ParseInt(word, 10, 64); err == nil {
+			return literal(i), nil
+		}
+		if f, err := strconv.ParseFloat(word, 64); err == nil {
+			return literal(f), nil
+		}
+		return nil, fmt.Errorf("invalid operand %q in filter", word)
+	}
+}
+
+func literal(v interface{}) filterExpr {
+	n := NewNode(v, "query")
+	return func(Node, *queryEvaluation) Node { return n }
+}
+
+// pathEnd provides the end of a path operand in a filter expression.
+func pathEnd(s string) int {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case depth == 0 && strings.IndexByte(" \t)=!<>&|", c) >= 0:
+			return i
+		}
+	}
+	return len(s)
+}
+
+// parseRegexpMatch parses the regular expression literal of the
+// operator =~.
+func (p *filterParser) parseRegexpMatch(left filterExpr) (filterExpr, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) || strings.IndexByte("'\"/", p.src[p.pos]) < 0 {
+		return nil, fmt.Errorf("=~ requires a regular expression literal")
+	}
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(right(nil, nil).Value().(string))
+	if err != nil {
+		return nil, err
+	}
+	return func(node Node, q *queryEvaluation) Node {
+		l := left(node, q)
+		if l == nil {
+			return falseNode
+		}
+		v, ok := l.Value().(string)
+		return boolNode(ok && re.MatchString(v))
+	}, nil
+}
+
+func compareExpr(op string, left, right filterExpr) filterExpr {
+	return func(node Node, q *queryEvaluation) Node {
+		l, r := left(node, q), right(node, q)
+		if l == nil || r == nil {
+			return falseNode
+		}
+		switch op {
+		case "==":
+			return boolNode(equalValues(l, r))
+		case "!=":
+			return boolNode(!equalValues(l, r))
+		}
+		c, ok := compareValues(l.Value(), r.Value())
+		if !ok {
+			return falseNode
+		}
+		switch op {
+		case "<":
+			return boolNode(c < 0)
+		case "<=":
+			return boolNode(c <= 0)
+		case ">":
+			return boolNode(c > 0)
+		default:
+			return boolNode(c >= 0)
+		}
+	}
+}
+
+func equalValues(a, b Node) bool {
+	if c, ok := compareValues(a.Value(), b.Value()); ok {
+		return c == 0
+	}
+	return a.EquivalentToNode(b)
+}
+
+func numberValue(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	default:
+		return 0, false
+	}
+}
+
+// compareValues compares numbers and strings.
+func compareValues(a, b interface{}) (int, bool) {
+	if x, ok := numberValue(a); ok {
+		if y, ok := numberValue(b); ok {
+			switch {
+			case x < y:
+				return -1, true
+			case x > y:
+				return 1, true
+			default:
+				return 0, true
+			}
+		}
+		return 0, false
+	}
+	if x, ok := a.(string); ok {
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), true
+		}
+	}
+	return 0, false
+}
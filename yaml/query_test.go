@@ -0,0 +1,77 @@
+package yaml
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("querying paths", func() {
+	tree := parseYAML(`
+---
+deployments:
+  - name: frontend
+    replicas: 3
+    containers:
+      - name: web
+        image: nginx:1.19
+      - name: sidecar
+        image: envoy:1.16
+  - name: backend
+    replicas: 1
+    containers:
+      - name: app
+        image: backend:2.0
+labels:
+  tier: web
+`)
+
+	values := func(path string) []interface{} {
+		nodes, err := Query(tree, nil, path)
+		Expect(err).NotTo(HaveOccurred())
+		result := []interface{}{}
+		for _, n := range nodes {
+			result = append(result, n.Value())
+		}
+		return result
+	}
+
+	It("selects children", func() {
+		Expect(values("$.labels.tier")).To(Equal([]interface{}{"web"}))
+		Expect(values("$['labels']['tier']")).To(Equal([]interface{}{"web"}))
+		Expect(values("labels.tier")).To(Equal([]interface{}{"web"}))
+		Expect(values("$.labels.missing")).To(BeEmpty())
+	})
+
+	It("steps through lists by name", func() {
+		Expect(values("$.deployments.backend.replicas")).To(Equal([]interface{}{int64(1)}))
+		Expect(values("$.deployments[name:frontend].containers[web].image")).To(Equal([]interface{}{"nginx:1.19"}))
+	})
+
+	It("handles wildcards, indices and slices", func() {
+		Expect(values("$.deployments[*].name")).To(Equal([]interface{}{"frontend", "backend"}))
+		Expect(values("$.deployments[-1].name")).To(Equal([]interface{}{"backend"}))
+		Expect(values("$.deployments[0].containers[0,1].name")).To(Equal([]interface{}{"web", "sidecar"}))
+		Expect(values("$.deployments[0].containers[1:].name")).To(Equal([]interface{}{"sidecar"}))
+		Expect(values("$.deployments[::-1].name")).To(Equal([]interface{}{"backend", "frontend"}))
+	})
+
+	It("descends recursively", func() {
+		Expect(values("$..containers[*].image")).To(Equal([]interface{}{"nginx:1.19", "envoy:1.16", "backend:2.0"}))
+		Expect(values("$..image")).To(Equal([]interface{}{"nginx:1.19", "envoy:1.16", "backend:2.0"}))
+	})
+
+	It("filters", func() {
+		Expect(values("$.deployments[?(@.replicas > 1)].name")).To(Equal([]interface{}{"frontend"}))
+		Expect(values("$..containers[?(@.image =~ '^nginx')].name")).To(Equal([]interface{}{"web"}))
+		Expect(values("$..containers[?(@.name != 'web' && !(@.name == 'app'))].name")).To(Equal([]interface{}{"sidecar"}))
+		Expect(values("$.deployments[?(@.containers[?(@.name == $.labels.tier)])].name")).To(Equal([]interface{}{"frontend"}))
+		Expect(values("$.deployments[?(@.missing || @.replicas == 1.0)].name")).To(Equal([]interface{}{"backend"}))
+	})
+
+	It("reports invalid paths", func() {
+		for _, p := range []string{"", "$.", "$[", "$[?(@.a ==)]", "$[::0]", "$[?(@.a =~ @.b)]"} {
+			_, err := Query(tree, nil, p)
+			Expect(err).To(HaveOccurred(), p)
+		}
+	})
+})
@@ -8,6 +8,30 @@ type ComparableValue interface {
 	EquivalentTo(interface{}) bool
 }
 
+// GetOrderedKeys returns the keys of a map node according to its
+// original key order (see KeyOrder). Keys without known order
+// follow in sorted order.
+func GetOrderedKeys(node Node) []string {
+	m := node.Value().(map[string]Node)
+	keys := make([]string, 0, len(m))
+	found := map[string]bool{}
+	for _, k := range node.KeyOrder() {
+		if _, ok := m[k]; ok && !found[k] {
+			found[k] = true
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == len(m) {
+		return keys
+	}
+	for _, k := range GetSortedKeys(m) {
+		if !found[k] {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
 func GetSortedKeys(unsortedMap map[string]Node) []string {
 	keys := make([]string, len(unsortedMap))
 	i := 0